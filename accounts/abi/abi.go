@@ -19,8 +19,12 @@ package abi
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 // The ABI holds information about a contract's context and available
@@ -165,3 +169,54 @@ func (abi *ABI) MethodById(sigdata []byte) (*Method, error) {
 	}
 	return nil, fmt.Errorf("no method with id: %#x", sigdata[:4])
 }
+
+// revertSelector is the function selector of the revert reasons emitted by
+// Solidity, which are encoded as if they were calls to a function `Error(string)`.
+var revertSelector = crypto.Keccak256([]byte("Error(string)"))[:4]
+
+// UnpackRevert resolves the ABI encoded revert reason from the given return data
+// of a reverted call. According to the Solidity spec, the reason is ABI encoded
+// as if it were a call to a function `Error(string)`.
+func UnpackRevert(data []byte) (string, error) {
+	if len(data) < 4 || !bytes.Equal(data[:4], revertSelector) {
+		return "", errors.New("invalid data for unpacking")
+	}
+	typ, _ := NewType("string", nil)
+	unpacked, err := (Arguments{{Type: typ}}).UnpackValues(data[4:])
+	if err != nil {
+		return "", err
+	}
+	return unpacked[0].(string), nil
+}
+
+// RevertError is an error returned for calls aborted by the REVERT opcode. It
+// carries the decoded revert reason in its message, and the JSON-RPC error code
+// and data a node reports for a revertal.
+type RevertError struct {
+	error
+	reason string // revert data hex encoded
+}
+
+// NewRevertError creates a RevertError from the return data of a reverted call,
+// decoding the Error(string) reason into the message if the data contains one.
+func NewRevertError(data []byte) *RevertError {
+	err := errors.New("execution reverted")
+	if reason, errUnpack := UnpackRevert(data); errUnpack == nil {
+		err = fmt.Errorf("execution reverted: %v", reason)
+	}
+	return &RevertError{
+		error:  err,
+		reason: hexutil.Encode(data),
+	}
+}
+
+// ErrorCode returns the JSON error code for a revertal.
+// See: https://github.com/ethereum/wiki/wiki/JSON-RPC-Error-Codes-Improvement-Proposal
+func (e *RevertError) ErrorCode() int {
+	return 3
+}
+
+// ErrorData returns the hex encoded revert data.
+func (e *RevertError) ErrorData() interface{} {
+	return e.reason
+}
@@ -19,6 +19,7 @@ package abi
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
@@ -931,3 +932,62 @@ func TestABI_MethodById(t *testing.T) {
 		t.Errorf("Expected error, nil is short to decode data")
 	}
 }
+
+func TestUnpackRevert(t *testing.T) {
+	t.Parallel()
+
+	var cases = []struct {
+		input     string
+		expect    string
+		expectErr error
+	}{
+		{"", "", errors.New("invalid data for unpacking")},
+		{"08c379a1", "", errors.New("invalid data for unpacking")},
+		{"08c379a00000000000000000000000000000000000000000000000000000000000000020000000000000000000000000000000000000000000000000000000000000000d72657665727420726561736f6e00000000000000000000000000000000000000", "revert reason", nil},
+		{"08c379a00000000000000000000000000000000000000000000000000000000000000020", "", errors.New("abi: cannot marshal in to go slice: offset 64 would go over slice boundary (len=32)")},
+	}
+	for index, c := range cases {
+		t.Run(fmt.Sprintf("case %d", index), func(t *testing.T) {
+			got, err := UnpackRevert(common.Hex2Bytes(c.input))
+			if c.expectErr != nil {
+				if err == nil {
+					t.Fatalf("Expected non-nil error")
+				}
+				if err.Error() != c.expectErr.Error() {
+					t.Fatalf("Expected error mismatch, want %v, got %v", c.expectErr, err)
+				}
+				return
+			}
+			if c.expect != got {
+				t.Fatalf("Output mismatch, want %v, got %v", c.expect, got)
+			}
+		})
+	}
+}
+
+func TestNewRevertError(t *testing.T) {
+	t.Parallel()
+
+	var cases = []struct {
+		input  string
+		expect string
+	}{
+		{"", "execution reverted"},
+		{"08c379a1", "execution reverted"},
+		{"08c379a00000000000000000000000000000000000000000000000000000000000000020000000000000000000000000000000000000000000000000000000000000000d72657665727420726561736f6e00000000000000000000000000000000000000", "execution reverted: revert reason"},
+	}
+	for index, c := range cases {
+		t.Run(fmt.Sprintf("case %d", index), func(t *testing.T) {
+			err := NewRevertError(common.Hex2Bytes(c.input))
+			if err.Error() != c.expect {
+				t.Fatalf("Message mismatch, want %v, got %v", c.expect, err.Error())
+			}
+			if err.ErrorCode() != 3 {
+				t.Fatalf("Error code mismatch, want 3, got %d", err.ErrorCode())
+			}
+			if data := err.ErrorData(); data != "0x"+c.input {
+				t.Fatalf("Error data mismatch, want 0x%v, got %v", c.input, data)
+			}
+		})
+	}
+}
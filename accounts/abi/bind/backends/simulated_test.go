@@ -19,15 +19,19 @@ package backends_test
 import (
 	"context"
 	"math/big"
+	"strings"
 	"testing"
 
 	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
 func TestSimulatedBackend(t *testing.T) {
@@ -80,3 +84,47 @@ func TestSimulatedBackend(t *testing.T) {
 	}
 
 }
+
+func TestSimulatedBackendRevertReason(t *testing.T) {
+	var (
+		contract = common.Address{0xcc}
+		// Contract reverting with Error("boom"), copied from the end of its code
+		code = common.FromHex("0x6064600c60003960646000fd" +
+			"08c379a0" +
+			"0000000000000000000000000000000000000000000000000000000000000020" +
+			"0000000000000000000000000000000000000000000000000000000000000004" +
+			"626f6f6d00000000000000000000000000000000000000000000000000000000")
+		// ABI of a method the above contract can be called through
+		definition = `[{"constant":true,"inputs":[],"name":"boom","outputs":[{"name":"","type":"uint256"}],"type":"function"}]`
+	)
+	sim := backends.NewSimulatedBackend(core.GenesisAlloc{contract: {Balance: new(big.Int), Code: code}}, 8000000)
+
+	check := func(method string, err error) {
+		if err == nil {
+			t.Fatalf("%s: expected revert error", method)
+		}
+		if have, want := err.Error(), "execution reverted: boom"; have != want {
+			t.Errorf("%s: error message mismatch: have %q, want %q", method, have, want)
+		}
+		if e, ok := err.(rpc.DataError); !ok || e.ErrorData() != hexutil.Encode(code[12:]) {
+			t.Errorf("%s: error data mismatch: have %v", method, err)
+		}
+	}
+	call := ethereum.CallMsg{To: &contract}
+
+	_, err := sim.CallContract(context.Background(), call, nil)
+	check("CallContract", err)
+	_, err = sim.PendingCallContract(context.Background(), call)
+	check("PendingCallContract", err)
+	_, err = sim.EstimateGas(context.Background(), call)
+	check("EstimateGas", err)
+
+	// Contract bindings must surface the same revert reason
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		t.Fatalf("failed to parse ABI: %v", err)
+	}
+	var result *big.Int
+	err = bind.NewBoundContract(contract, parsed, sim, sim, sim).Call(nil, &result, "boom")
+	check("BoundContract.Call", err)
+}
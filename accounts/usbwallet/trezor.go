@@ -182,6 +182,10 @@ func (w *trezorDriver) SignTx(path accounts.DerivationPath, tx *types.Transactio
 	if w.device == nil {
 		return common.Address{}, nil, accounts.ErrWalletClosed
 	}
+	// Ensure the wallet is capable of signing the given transaction
+	if tx.Type() != types.LegacyTxType {
+		return common.Address{}, nil, types.ErrTxTypeNotSupported
+	}
 	return w.trezorSign(path, tx, chainID)
 }
 
@@ -0,0 +1,222 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package t8ntool
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/tests"
+	"golang.org/x/crypto/sha3"
+)
+
+// Prestate is the state and environment the transactions are applied on top of.
+type Prestate struct {
+	Env stEnv             `json:"env"`
+	Pre core.GenesisAlloc `json:"pre"`
+}
+
+// ExecutionResult contains the roots and receipts of the block assembled from the
+// applied transactions, along with the transactions which had to be rejected.
+type ExecutionResult struct {
+	StateRoot   common.Hash    `json:"stateRoot"`
+	TxRoot      common.Hash    `json:"txRoot"`
+	ReceiptRoot common.Hash    `json:"receiptRoot"`
+	LogsHash    common.Hash    `json:"logsHash"`
+	Bloom       types.Bloom    `json:"logsBloom"`
+	Receipts    types.Receipts `json:"receipts"`
+	Rejected    []*rejectedTx  `json:"rejected,omitempty"`
+}
+
+// rejectedTx is a transaction which could not be included in the block, along
+// with the reason why.
+type rejectedTx struct {
+	Index int    `json:"index"`
+	Err   string `json:"error"`
+}
+
+type ommer struct {
+	Delta   uint64         `json:"delta"`
+	Address common.Address `json:"address"`
+}
+
+//go:generate gencodec -type stEnv -field-override stEnvMarshaling -out gen_stenv.go
+
+type stEnv struct {
+	Coinbase    common.Address                      `json:"currentCoinbase"   gencodec:"required"`
+	Difficulty  *big.Int                            `json:"currentDifficulty" gencodec:"required"`
+	GasLimit    uint64                              `json:"currentGasLimit"   gencodec:"required"`
+	Number      uint64                              `json:"currentNumber"     gencodec:"required"`
+	Timestamp   uint64                              `json:"currentTimestamp"  gencodec:"required"`
+	BaseFee     *big.Int                            `json:"currentBaseFee,omitempty"`
+	BlockHashes map[math.HexOrDecimal64]common.Hash `json:"blockHashes,omitempty"`
+	Ommers      []ommer                             `json:"ommers,omitempty"`
+}
+
+type stEnvMarshaling struct {
+	Coinbase   common.UnprefixedAddress
+	Difficulty *math.HexOrDecimal256
+	GasLimit   math.HexOrDecimal64
+	Number     math.HexOrDecimal64
+	Timestamp  math.HexOrDecimal64
+	BaseFee    *math.HexOrDecimal256
+}
+
+// chainContext is a core.ChainContext serving the ancestor headers of the block
+// being assembled from the block hashes given in the environment. The headers
+// only carry their number and parent hash, which is all the BLOCKHASH opcode
+// needs to walk the chain.
+type chainContext struct {
+	number uint64
+	hashes map[math.HexOrDecimal64]common.Hash
+}
+
+// Engine implements core.ChainContext. The coinbase is always given explicitly,
+// so no consensus engine is needed to resolve it.
+func (c *chainContext) Engine() consensus.Engine {
+	return nil
+}
+
+// GetHeader implements core.ChainContext, returning a stub header for any
+// ancestor of the current block. Missing block hashes are reported as zero.
+func (c *chainContext) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if number == 0 || number >= c.number {
+		return nil
+	}
+	return &types.Header{
+		Number:     new(big.Int).SetUint64(number),
+		ParentHash: c.hashes[math.HexOrDecimal64(number-1)],
+	}
+}
+
+// Apply applies a set of transactions to a pre-state, returning the post-state
+// along with the execution results. Transactions which fail to apply are skipped
+// and reported as rejected, leaving the state unchanged.
+func (pre *Prestate) Apply(vmConfig vm.Config, chainConfig *params.ChainConfig,
+	txs types.Transactions, miningReward int64,
+	getTracerFn func(txIndex int, txHash common.Hash) (tracer vm.Tracer, err error)) (*state.StateDB, *ExecutionResult, error) {
+
+	var (
+		statedb = tests.MakePreState(rawdb.NewMemoryDatabase(), pre.Pre)
+		chain   = &chainContext{number: pre.Env.Number, hashes: pre.Env.BlockHashes}
+		gaspool = new(core.GasPool).AddGas(pre.Env.GasLimit)
+		usedGas uint64
+
+		receipts = make(types.Receipts, 0)
+		included = make(types.Transactions, 0)
+		logs     = make([]*types.Log, 0)
+		rejected []*rejectedTx
+	)
+	header := &types.Header{
+		ParentHash: pre.Env.BlockHashes[math.HexOrDecimal64(pre.Env.Number-1)],
+		Coinbase:   pre.Env.Coinbase,
+		Difficulty: pre.Env.Difficulty,
+		Number:     new(big.Int).SetUint64(pre.Env.Number),
+		GasLimit:   pre.Env.GasLimit,
+		Time:       pre.Env.Timestamp,
+	}
+	if pre.Env.Number == 0 {
+		header.ParentHash = common.Hash{}
+	}
+	if chainConfig.IsEIP1559(header.Number) {
+		header.BaseFee = pre.Env.BaseFee
+	}
+	// If DAO is supported/enabled, we need to handle it here. In geth 'proper', it's
+	// done in StateProcessor.Process(block, ...), right before transactions are applied.
+	if chainConfig.DAOForkSupport && chainConfig.DAOForkBlock != nil && chainConfig.DAOForkBlock.Cmp(header.Number) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+	}
+	for i, tx := range txs {
+		tracer, err := getTracerFn(i, tx.Hash())
+		if err != nil {
+			return nil, nil, err
+		}
+		vmConfig.Tracer = tracer
+		vmConfig.Debug = (tracer != nil)
+		statedb.Prepare(tx.Hash(), common.Hash{}, len(included))
+
+		var (
+			snapshot = statedb.Snapshot()
+			prevGas  = *gaspool
+		)
+		receipt, _, err := core.ApplyTransaction(chainConfig, chain, &pre.Env.Coinbase, gaspool, statedb, header, tx, &usedGas, vmConfig)
+		if err != nil {
+			statedb.RevertToSnapshot(snapshot)
+			*gaspool = prevGas
+
+			log.Info("Rejected transaction", "index", i, "hash", tx.Hash(), "error", err)
+			rejected = append(rejected, &rejectedTx{i, err.Error()})
+			continue
+		}
+		included = append(included, tx)
+		receipts = append(receipts, receipt)
+		logs = append(logs, receipt.Logs...)
+	}
+	// Add mining reward, if enabled
+	if miningReward >= 0 {
+		var (
+			blockReward = big.NewInt(miningReward)
+			minerReward = new(big.Int).Set(blockReward)
+			perOmmer    = new(big.Int).Div(blockReward, big.NewInt(32))
+		)
+		for _, ommer := range pre.Env.Ommers {
+			// Add 1/32th for each ommer included
+			minerReward.Add(minerReward, perOmmer)
+			// Add (8-delta)/8
+			reward := big.NewInt(8)
+			reward.Sub(reward, new(big.Int).SetUint64(ommer.Delta))
+			reward.Mul(reward, blockReward)
+			reward.Div(reward, big.NewInt(8))
+			statedb.AddBalance(ommer.Address, reward)
+		}
+		statedb.AddBalance(pre.Env.Coinbase, minerReward)
+	}
+	// Commit block
+	root, err := statedb.Commit(chainConfig.IsEIP158(header.Number))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not commit state: %v", err)
+	}
+	execRs := &ExecutionResult{
+		StateRoot:   root,
+		TxRoot:      types.DeriveSha(included),
+		ReceiptRoot: types.DeriveSha(receipts),
+		Bloom:       types.CreateBloom(receipts),
+		LogsHash:    rlpHash(logs),
+		Receipts:    receipts,
+		Rejected:    rejected,
+	}
+	return statedb, execRs, nil
+}
+
+func rlpHash(x interface{}) (h common.Hash) {
+	hw := sha3.NewLegacyKeccak256()
+	rlp.Encode(hw, x)
+	hw.Sum(h[:0])
+	return h
+}
@@ -0,0 +1,347 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package t8ntool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/tests"
+	"github.com/ethereum/go-ethereum/trie"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// Exit codes of the tool, see NumberedError.
+const (
+	ErrorEVM      = 2
+	ErrorVMConfig = 3
+
+	ErrorJson = 10
+	ErrorIO   = 11
+)
+
+// stdinSelector is the input name making the tool read that input from stdin.
+const stdinSelector = "stdin"
+
+// emptyCodeHash is the code hash of accounts without code.
+var emptyCodeHash = crypto.Keccak256Hash(nil)
+
+// NumberedError is an error carrying the exit code the tool should terminate
+// with, so that callers can tell the kind of failure apart.
+type NumberedError struct {
+	errorCode int
+	err       error
+}
+
+// NewError creates an error which causes the tool to exit with the given code.
+func NewError(errorCode int, err error) *NumberedError {
+	return &NumberedError{errorCode, err}
+}
+
+func (n *NumberedError) Error() string {
+	return fmt.Sprintf("ERROR(%d): %v", n.errorCode, n.err.Error())
+}
+
+// Code returns the exit code of the error.
+func (n *NumberedError) Code() int {
+	return n.errorCode
+}
+
+// input is the combined format of all inputs when read from stdin.
+type input struct {
+	Alloc core.GenesisAlloc  `json:"alloc,omitempty"`
+	Env   *stEnv             `json:"env,omitempty"`
+	Txs   types.Transactions `json:"txs,omitempty"`
+}
+
+// Main is the entry point of the transition tool. It reads the pre-state alloc,
+// the block environment and the transactions, applies the transactions on top
+// of the pre-state and writes out the execution result and the post-state alloc.
+func Main(ctx *cli.Context) error {
+	// Configure the go-ethereum logger
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
+	glogger.Verbosity(log.Lvl(ctx.Int(VerbosityFlag.Name)))
+	log.Root().SetHandler(glogger)
+
+	var (
+		baseDir   = ""
+		getTracer func(txIndex int, txHash common.Hash) (vm.Tracer, error)
+	)
+	// If user specified a basedir, make sure it exists
+	if ctx.IsSet(OutputBasedir.Name) {
+		if base := ctx.String(OutputBasedir.Name); len(base) > 0 {
+			if err := os.MkdirAll(base, 0755); err != nil {
+				return NewError(ErrorIO, fmt.Errorf("failed creating output basedir: %v", err))
+			}
+			baseDir = base
+		}
+	}
+	if ctx.Bool(TraceFlag.Name) {
+		// Configure the EVM logger
+		logConfig := &vm.LogConfig{
+			DisableStack:  ctx.Bool(TraceDisableStackFlag.Name),
+			DisableMemory: ctx.Bool(TraceDisableMemoryFlag.Name),
+			Debug:         true,
+		}
+		var prevFile *os.File
+		// This one closes the last file
+		defer func() {
+			if prevFile != nil {
+				prevFile.Close()
+			}
+		}()
+		getTracer = func(txIndex int, txHash common.Hash) (vm.Tracer, error) {
+			if prevFile != nil {
+				prevFile.Close()
+			}
+			traceFile, err := os.Create(filepath.Join(baseDir, fmt.Sprintf("trace-%d-%v.jsonl", txIndex, txHash.String())))
+			if err != nil {
+				return nil, NewError(ErrorIO, fmt.Errorf("failed creating trace-file: %v", err))
+			}
+			prevFile = traceFile
+			return vm.NewJSONLogger(logConfig, traceFile), nil
+		}
+	} else {
+		getTracer = func(txIndex int, txHash common.Hash) (tracer vm.Tracer, err error) {
+			return nil, nil
+		}
+	}
+	// We need to load three things: alloc, env and transactions. May be either in
+	// stdin input or in files. Check if anything needs to be read from stdin
+	var (
+		prestate  Prestate
+		allocStr  = ctx.String(InputAllocFlag.Name)
+		envStr    = ctx.String(InputEnvFlag.Name)
+		txStr     = ctx.String(InputTxsFlag.Name)
+		inputData = &input{}
+	)
+	if allocStr == stdinSelector || envStr == stdinSelector || txStr == stdinSelector {
+		decoder := json.NewDecoder(os.Stdin)
+		if err := decoder.Decode(inputData); err != nil {
+			return NewError(ErrorJson, fmt.Errorf("failed unmarshaling stdin: %v", err))
+		}
+	}
+	if allocStr != stdinSelector {
+		inFile, err := os.Open(allocStr)
+		if err != nil {
+			return NewError(ErrorIO, fmt.Errorf("failed reading alloc file: %v", err))
+		}
+		defer inFile.Close()
+		decoder := json.NewDecoder(inFile)
+		if err := decoder.Decode(&inputData.Alloc); err != nil {
+			return NewError(ErrorJson, fmt.Errorf("failed unmarshaling alloc-file: %v", err))
+		}
+	}
+	prestate.Pre = inputData.Alloc
+
+	// Set the block environment
+	if envStr != stdinSelector {
+		inFile, err := os.Open(envStr)
+		if err != nil {
+			return NewError(ErrorIO, fmt.Errorf("failed reading env file: %v", err))
+		}
+		defer inFile.Close()
+		decoder := json.NewDecoder(inFile)
+		var env stEnv
+		if err := decoder.Decode(&env); err != nil {
+			return NewError(ErrorJson, fmt.Errorf("failed unmarshaling env-file: %v", err))
+		}
+		inputData.Env = &env
+	}
+	if inputData.Env == nil {
+		return NewError(ErrorJson, fmt.Errorf("missing block environment"))
+	}
+	prestate.Env = *inputData.Env
+
+	// Construct the chain config, overriding the chain id of the fork rules
+	fork, ok := tests.Forks[ctx.String(ForknameFlag.Name)]
+	if !ok {
+		return NewError(ErrorVMConfig, tests.UnsupportedForkError{Name: ctx.String(ForknameFlag.Name)})
+	}
+	chainConfig := *fork
+	chainConfig.ChainID = big.NewInt(ctx.Int64(ChainIDFlag.Name))
+
+	// Blocks after EIP-1559 can't be assembled without the base fee
+	if chainConfig.IsEIP1559(new(big.Int).SetUint64(prestate.Env.Number)) && prestate.Env.BaseFee == nil {
+		return NewError(ErrorVMConfig, errors.New("EIP-1559 config but missing 'currentBaseFee' in env section"))
+	}
+
+	// Set the transactions
+	if txStr != stdinSelector {
+		inFile, err := os.Open(txStr)
+		if err != nil {
+			return NewError(ErrorIO, fmt.Errorf("failed reading txs file: %v", err))
+		}
+		defer inFile.Close()
+		decoder := json.NewDecoder(inFile)
+		var txs types.Transactions
+		if err := decoder.Decode(&txs); err != nil {
+			return NewError(ErrorJson, fmt.Errorf("failed unmarshaling txs-file: %v", err))
+		}
+		inputData.Txs = txs
+	}
+	// Apply the transactions and dump the post-state and the execution result
+	statedb, result, err := prestate.Apply(vm.Config{}, &chainConfig, inputData.Txs, ctx.Int64(RewardFlag.Name), getTracer)
+	if err != nil {
+		if _, ok := err.(*NumberedError); ok {
+			return err
+		}
+		return NewError(ErrorEVM, err)
+	}
+	alloc, err := makeAlloc(statedb.Database(), result.StateRoot)
+	if err != nil {
+		return NewError(ErrorEVM, err)
+	}
+	return dispatchOutput(ctx, baseDir, result, alloc)
+}
+
+// makeAlloc converts the state with the given root into the genesis alloc format
+// the tool reads its pre-state in, so the output of one run can be fed into the
+// next one. Accounts and storage slots are keyed by the preimages of their trie
+// keys, which are recorded for all state the tool has touched.
+func makeAlloc(db state.Database, root common.Hash) (core.GenesisAlloc, error) {
+	tr, err := db.OpenTrie(root)
+	if err != nil {
+		return nil, err
+	}
+	alloc := make(core.GenesisAlloc)
+	it := trie.NewIterator(tr.NodeIterator(nil))
+	for it.Next() {
+		preimage := tr.GetKey(it.Key)
+		if preimage == nil {
+			return nil, fmt.Errorf("missing preimage of account %x", it.Key)
+		}
+		var account state.Account
+		if err := rlp.DecodeBytes(it.Value, &account); err != nil {
+			return nil, fmt.Errorf("invalid account %x: %v", preimage, err)
+		}
+		genesisAccount := core.GenesisAccount{
+			Nonce:   account.Nonce,
+			Balance: account.Balance,
+		}
+		if codeHash := common.BytesToHash(account.CodeHash); codeHash != emptyCodeHash {
+			code, err := db.ContractCode(common.BytesToHash(it.Key), codeHash)
+			if err != nil {
+				return nil, fmt.Errorf("missing code of account %x: %v", preimage, err)
+			}
+			genesisAccount.Code = code
+		}
+		if account.Root != types.EmptyRootHash {
+			storage, err := makeStorage(db, common.BytesToHash(it.Key), account.Root)
+			if err != nil {
+				return nil, fmt.Errorf("invalid storage of account %x: %v", preimage, err)
+			}
+			genesisAccount.Storage = storage
+		}
+		alloc[common.BytesToAddress(preimage)] = genesisAccount
+	}
+	if it.Err != nil {
+		return nil, it.Err
+	}
+	return alloc, nil
+}
+
+// makeStorage collects the storage slots of an account by iterating its storage
+// trie directly.
+func makeStorage(db state.Database, addrHash, root common.Hash) (map[common.Hash]common.Hash, error) {
+	tr, err := db.OpenStorageTrie(addrHash, root)
+	if err != nil {
+		return nil, err
+	}
+	storage := make(map[common.Hash]common.Hash)
+	it := trie.NewIterator(tr.NodeIterator(nil))
+	for it.Next() {
+		preimage := tr.GetKey(it.Key)
+		if preimage == nil {
+			return nil, fmt.Errorf("missing preimage of slot %x", it.Key)
+		}
+		_, value, _, err := rlp.Split(it.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slot %x: %v", preimage, err)
+		}
+		storage[common.BytesToHash(preimage)] = common.BytesToHash(value)
+	}
+	return storage, it.Err
+}
+
+// saveFile marshals the object to the given file
+func saveFile(baseDir, filename string, data interface{}) error {
+	b, err := json.MarshalIndent(data, "", " ")
+	if err != nil {
+		return NewError(ErrorJson, fmt.Errorf("failed marshalling output: %v", err))
+	}
+	location := filepath.Join(baseDir, filename)
+	if err = ioutil.WriteFile(location, b, 0644); err != nil {
+		return NewError(ErrorIO, fmt.Errorf("failed writing output: %v", err))
+	}
+	log.Info("Wrote file", "file", location)
+	return nil
+}
+
+// dispatchOutput writes the output data to either stderr or stdout, or to the specified
+// files
+func dispatchOutput(ctx *cli.Context, baseDir string, result *ExecutionResult, alloc core.GenesisAlloc) error {
+	stdOutObject := make(map[string]interface{})
+	stdErrObject := make(map[string]interface{})
+	dispatch := func(baseDir, fName, name string, obj interface{}) error {
+		switch fName {
+		case "stdout":
+			stdOutObject[name] = obj
+		case "stderr":
+			stdErrObject[name] = obj
+		default: // save to file
+			if err := saveFile(baseDir, fName, obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := dispatch(baseDir, ctx.String(OutputAllocFlag.Name), "alloc", alloc); err != nil {
+		return err
+	}
+	if err := dispatch(baseDir, ctx.String(OutputResultFlag.Name), "result", result); err != nil {
+		return err
+	}
+	if len(stdOutObject) > 0 {
+		b, err := json.MarshalIndent(stdOutObject, "", " ")
+		if err != nil {
+			return NewError(ErrorJson, fmt.Errorf("failed marshalling output: %v", err))
+		}
+		os.Stdout.Write(b)
+	}
+	if len(stdErrObject) > 0 {
+		b, err := json.MarshalIndent(stdErrObject, "", " ")
+		if err != nil {
+			return NewError(ErrorJson, fmt.Errorf("failed marshalling output: %v", err))
+		}
+		os.Stderr.Write(b)
+	}
+	return nil
+}
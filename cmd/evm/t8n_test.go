@@ -0,0 +1,148 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/pkg/reexec"
+	"github.com/ethereum/go-ethereum/cmd/evm/internal/t8ntool"
+	"github.com/ethereum/go-ethereum/internal/cmdtest"
+)
+
+type testT8n struct {
+	*cmdtest.TestCmd
+}
+
+func TestMain(m *testing.M) {
+	// Run the app if we've been exec'd as "evm-test" in runT8n.
+	reexec.Register("evm-test", func() {
+		if err := app.Run(os.Args); err != nil {
+			code := 1
+			if ec, ok := err.(*t8ntool.NumberedError); ok {
+				code = ec.Code()
+			}
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(code)
+		}
+		os.Exit(0)
+	})
+	// check if we have been reexec'd
+	if reexec.Init() {
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// t8nInput is the set of input files and rules of a state transition test.
+type t8nInput struct {
+	inAlloc  string
+	inTxs    string
+	inEnv    string
+	stFork   string
+	stReward string
+}
+
+func (args *t8nInput) get(base string) []string {
+	return []string{
+		"--input.alloc", filepath.Join(base, args.inAlloc),
+		"--input.txs", filepath.Join(base, args.inTxs),
+		"--input.env", filepath.Join(base, args.inEnv),
+		"--state.fork", args.stFork,
+		"--state.reward", args.stReward,
+	}
+}
+
+// Tests that the state transition tool produces the expected post-state alloc
+// and execution result for the inputs in the testdata folder.
+func TestT8n(t *testing.T) {
+	tests := []struct {
+		base        string
+		input       t8nInput
+		expExitCode int
+		expOut      string
+	}{
+		{ // Nonce reuse, coinbase payment and blockhash access
+			base:   "./testdata/1",
+			input:  t8nInput{"alloc.json", "txs.json", "env.json", "ConstantinopleFix", "-1"},
+			expOut: "exp.json",
+		},
+		{ // Missing base fee after EIP-1559
+			base:        "./testdata/1",
+			input:       t8nInput{"alloc.json", "txs.json", "env.json", "EIP1559", "-1"},
+			expExitCode: t8ntool.ErrorVMConfig,
+		},
+		{ // Legacy and dynamic fee transactions paying a base fee
+			base:   "./testdata/2",
+			input:  t8nInput{"alloc.json", "txs.json", "env.json", "EIP1559", "-1"},
+			expOut: "exp.json",
+		},
+	}
+	for i, tc := range tests {
+		dir, err := ioutil.TempDir("", "evm-t8n-")
+		if err != nil {
+			t.Fatalf("test %d: failed to create temporary directory: %v", i, err)
+		}
+		defer os.RemoveAll(dir)
+
+		args := append(tc.input.get(tc.base), "--output.basedir", dir, "--output.alloc", "alloc.json", "--output.result", "result.json")
+		tt := &testT8n{TestCmd: cmdtest.NewTestCmd(t, nil)}
+		tt.Run("evm-test", append([]string{"t8n"}, args...)...)
+		tt.WaitExit()
+		if status := tt.ExitStatus(); status != tc.expExitCode {
+			t.Fatalf("test %d: exit status mismatch: have %d, want %d: %s", i, status, tc.expExitCode, tt.StderrText())
+		}
+		if tc.expExitCode != 0 {
+			continue
+		}
+		// Compare the produced output against the expected one
+		have := make(map[string]interface{})
+		for _, name := range []string{"alloc", "result"} {
+			var out interface{}
+			if err := readJSON(filepath.Join(dir, name+".json"), &out); err != nil {
+				t.Fatalf("test %d: failed to read %s output: %v", i, name, err)
+			}
+			have[name] = out
+		}
+		var want map[string]interface{}
+		if err := readJSON(filepath.Join(tc.base, tc.expOut), &want); err != nil {
+			t.Fatalf("test %d: failed to read expected output: %v", i, err)
+		}
+		for _, name := range []string{"alloc", "result"} {
+			if !reflect.DeepEqual(have[name], want[name]) {
+				haveJSON, _ := json.MarshalIndent(have[name], "", " ")
+				wantJSON, _ := json.MarshalIndent(want[name], "", " ")
+				t.Errorf("test %d: %s mismatch:\nhave: %s\nwant: %s", i, name, haveJSON, wantJSON)
+			}
+		}
+	}
+}
+
+// readJSON decodes the JSON content of the given file into v.
+func readJSON(path string, v interface{}) error {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(blob, v)
+}
@@ -0,0 +1,442 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/console"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	// dbFlags are the flags needed by all database subcommands to open the chain
+	// database.
+	dbFlags = []cli.Flag{
+		utils.DataDirFlag,
+		utils.DBEngineFlag,
+		utils.AncientFlag,
+		utils.CacheFlag,
+		utils.TestnetFlag,
+		utils.RinkebyFlag,
+		utils.GoerliFlag,
+	}
+	dbLimitFlag = cli.Uint64Flag{
+		Name:  "limit",
+		Usage: "Maximum number of entries to print (0 = unlimited)",
+		Value: 100,
+	}
+
+	dbCommand = cli.Command{
+		Name:      "db",
+		Usage:     "Low level database operations",
+		ArgsUsage: "",
+		Category:  "DATABASE COMMANDS",
+		Subcommands: []cli.Command{
+			dbGetCmd,
+			dbPutCmd,
+			dbDeleteCmd,
+			dbIterateCmd,
+			dbCompactCmd,
+			dbRemoveTableCmd,
+			dbVerifyAncientsCmd,
+		},
+	}
+	dbGetCmd = cli.Command{
+		Action:    utils.MigrateFlags(dbGet),
+		Name:      "get",
+		Usage:     "Show the value of a database key",
+		ArgsUsage: "<hex-key>",
+		Flags:     dbFlags,
+		Description: `
+The get command prints the raw value stored under the given hex encoded key,
+along with its decoded form if the key belongs to a known schema table.`,
+	}
+	dbPutCmd = cli.Command{
+		Action:    utils.MigrateFlags(dbPut),
+		Name:      "put",
+		Usage:     "Set the value of a database key (WARNING: may corrupt your database)",
+		ArgsUsage: "<hex-key> <hex-value>",
+		Flags:     dbFlags,
+		Description: `
+The put command stores the given hex encoded value under the given hex encoded
+key, printing the previous value if there was one.`,
+	}
+	dbDeleteCmd = cli.Command{
+		Action:    utils.MigrateFlags(dbDelete),
+		Name:      "delete",
+		Usage:     "Delete a database key (WARNING: may corrupt your database)",
+		ArgsUsage: "<hex-key>",
+		Flags:     dbFlags,
+		Description: `
+The delete command removes the given hex encoded key from the database, printing
+the deleted value if there was one.`,
+	}
+	dbIterateCmd = cli.Command{
+		Action:    utils.MigrateFlags(dbIterate),
+		Name:      "iterate",
+		Usage:     "Iterate over the entries of a schema table",
+		ArgsUsage: "<table> [<hex-start>]",
+		Flags:     append(dbFlags, dbLimitFlag),
+		Description: `
+The iterate command prints the entries of the given schema table in key order,
+optionally starting at the given hex encoded key, in decoded form. The available
+tables are: ` + strings.Join(rawdb.DatabaseTables(), ", ") + `.`,
+	}
+	dbCompactCmd = cli.Command{
+		Action:    utils.MigrateFlags(dbCompact),
+		Name:      "compact",
+		Usage:     "Compact a key range of the database",
+		ArgsUsage: "[<hex-start> <hex-limit>]",
+		Flags:     dbFlags,
+		Description: `
+The compact command compacts the underlying storage of the given hex encoded
+key range [start, limit), or the entire database if no range is given.`,
+	}
+	dbRemoveTableCmd = cli.Command{
+		Action:    utils.MigrateFlags(dbRemoveTable),
+		Name:      "remove-table",
+		Usage:     "Remove all entries of a schema table (WARNING: may corrupt your database)",
+		ArgsUsage: "<table>",
+		Flags:     dbFlags,
+		Description: `
+The remove-table command deletes all the entries of the given schema table from
+the key-value database, after asking for confirmation. The available tables are:
+` + strings.Join(rawdb.DatabaseTables(), ", ") + `.`,
+	}
+	dbVerifyAncientsCmd = cli.Command{
+		Action:    utils.MigrateFlags(dbVerifyAncients),
+		Name:      "verify-ancients",
+		Usage:     "Verify the integrity of the ancient store",
+		ArgsUsage: "[<blockNumFirst> <blockNumLast>]",
+		Flags:     dbFlags,
+		Description: `
+The verify-ancients command checks the integrity of the blocks in the ancient
+store: every item needs to pass its checksum, the headers need to hash to the
+stored hashes, the bodies and receipts need to match the roots in the headers
+and the total difficulties need to be consistent. The ranges of corrupted blocks
+are reported at the end. The optional arguments limit the verification to the
+given block range.`,
+	}
+)
+
+// parseHexBytes parses a hex string, with or without the 0x prefix.
+func parseHexBytes(input string) ([]byte, error) {
+	if strings.HasPrefix(input, "0x") || strings.HasPrefix(input, "0X") {
+		input = input[2:]
+	}
+	return hex.DecodeString(input)
+}
+
+// printEntry prints a database entry, decoded if the key belongs to a known
+// schema table.
+func printEntry(key, value []byte) {
+	fmt.Printf("key:   %#x\n", key)
+	fmt.Printf("value: %#x\n", value)
+
+	if table := rawdb.ClassifyKey(key); table != nil {
+		decoded, err := table.Decode(key, value)
+		if err != nil {
+			decoded = fmt.Sprintf("failed to decode: %v", err)
+		}
+		fmt.Printf("%s: %s\n", table.Name, decoded)
+	}
+}
+
+func dbGet(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return errors.New("invalid number of arguments")
+	}
+	key, err := parseHexBytes(ctx.Args().Get(0))
+	if err != nil {
+		return fmt.Errorf("invalid key: %v", err)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack, true)
+	defer db.Close()
+
+	value, err := db.Get(key)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve key %#x: %v", key, err)
+	}
+	printEntry(key, value)
+	return nil
+}
+
+func dbPut(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		return errors.New("invalid number of arguments")
+	}
+	key, err := parseHexBytes(ctx.Args().Get(0))
+	if err != nil {
+		return fmt.Errorf("invalid key: %v", err)
+	}
+	value, err := parseHexBytes(ctx.Args().Get(1))
+	if err != nil {
+		return fmt.Errorf("invalid value: %v", err)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack, true)
+	defer db.Close()
+
+	if prev, err := db.Get(key); err == nil {
+		fmt.Printf("Previous value: %#x\n", prev)
+	}
+	return db.Put(key, value)
+}
+
+func dbDelete(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return errors.New("invalid number of arguments")
+	}
+	key, err := parseHexBytes(ctx.Args().Get(0))
+	if err != nil {
+		return fmt.Errorf("invalid key: %v", err)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack, true)
+	defer db.Close()
+
+	if prev, err := db.Get(key); err == nil {
+		fmt.Printf("Previous value: %#x\n", prev)
+	}
+	return db.Delete(key)
+}
+
+func dbIterate(ctx *cli.Context) error {
+	if ctx.NArg() < 1 || ctx.NArg() > 2 {
+		return errors.New("invalid number of arguments")
+	}
+	table, err := rawdb.FindDatabaseTable(ctx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	var start []byte
+	if ctx.NArg() == 2 {
+		if start, err = parseHexBytes(ctx.Args().Get(1)); err != nil {
+			return fmt.Errorf("invalid start key: %v", err)
+		}
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack, true)
+	defer db.Close()
+
+	it := newTableIterator(db, table, start)
+	defer it.Release()
+
+	var (
+		limit = ctx.Uint64(dbLimitFlag.Name)
+		count uint64
+	)
+	for it.Next() {
+		if !table.Match(it.Key()) {
+			continue
+		}
+		if limit > 0 && count >= limit {
+			fmt.Printf("Limit of %d entries reached, continue from key %#x\n", limit, it.Key())
+			break
+		}
+		decoded, err := table.Decode(it.Key(), it.Value())
+		if err != nil {
+			decoded = fmt.Sprintf("failed to decode: %v", err)
+		}
+		fmt.Printf("%#x: %s\n", it.Key(), decoded)
+		count++
+	}
+	return it.Error()
+}
+
+// newTableIterator creates an iterator over the keys sharing the prefix of the
+// table, optionally starting at the given key. Keys need to be matched against
+// the table by the caller, as some tables share a prefix.
+func newTableIterator(db ethdb.Iteratee, table *rawdb.DatabaseTable, start []byte) ethdb.Iterator {
+	if start == nil {
+		return db.NewIteratorWithPrefix(table.Prefix)
+	}
+	if bytes.Compare(start, table.Prefix) < 0 {
+		start = table.Prefix
+	}
+	return &prefixIterator{Iterator: db.NewIteratorWithStart(start), prefix: table.Prefix}
+}
+
+// prefixIterator wraps an iterator, stopping it at the first key not having the
+// given prefix.
+type prefixIterator struct {
+	ethdb.Iterator
+	prefix []byte
+}
+
+// Next moves the iterator to the next key/value pair, as long as it has the
+// configured prefix.
+func (it *prefixIterator) Next() bool {
+	return it.Iterator.Next() && bytes.HasPrefix(it.Key(), it.prefix)
+}
+
+func dbCompact(ctx *cli.Context) error {
+	if ctx.NArg() != 0 && ctx.NArg() != 2 {
+		return errors.New("invalid number of arguments")
+	}
+	var start, limit []byte
+	if ctx.NArg() == 2 {
+		var err error
+		if start, err = parseHexBytes(ctx.Args().Get(0)); err != nil {
+			return fmt.Errorf("invalid start key: %v", err)
+		}
+		if limit, err = parseHexBytes(ctx.Args().Get(1)); err != nil {
+			return fmt.Errorf("invalid limit key: %v", err)
+		}
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack, true)
+	defer db.Close()
+
+	log.Info("Compacting database", "start", fmt.Sprintf("%#x", start), "limit", fmt.Sprintf("%#x", limit))
+	begin := time.Now()
+	if err := db.Compact(start, limit); err != nil {
+		return err
+	}
+	log.Info("Database compaction finished", "elapsed", common.PrettyDuration(time.Since(begin)))
+	return nil
+}
+
+func dbRemoveTable(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return errors.New("invalid number of arguments")
+	}
+	table, err := rawdb.FindDatabaseTable(ctx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack, true)
+	defer db.Close()
+
+	confirm, err := console.Stdin.PromptConfirm(fmt.Sprintf("Remove all %s entries from the database?", table.Name))
+	switch {
+	case err != nil:
+		return err
+	case !confirm:
+		log.Info("Table removal skipped", "table", table.Name)
+		return nil
+	}
+	var (
+		it     = newTableIterator(db, table, nil)
+		batch  = db.NewBatch()
+		count  int
+		start  = time.Now()
+		logged = time.Now()
+	)
+	defer func() { it.Release() }()
+
+	for it.Next() {
+		key := it.Key()
+		if !table.Match(key) {
+			continue
+		}
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+		count++
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+
+			// Recreate the iterator after every batch commit, an open one
+			// might hold back the database from applying the deletions.
+			it.Release()
+			it = newTableIterator(db, table, common.CopyBytes(key))
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Removing table entries", "table", table.Name, "count", count, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	log.Info("Removed table entries", "table", table.Name, "count", count, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+func dbVerifyAncients(ctx *cli.Context) error {
+	if ctx.NArg() != 0 && ctx.NArg() != 2 {
+		return errors.New("invalid number of arguments")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack, true)
+	defer db.Close()
+
+	frozen, err := db.Ancients()
+	if err != nil {
+		return err
+	}
+	if frozen == 0 {
+		fmt.Println("Ancient store is empty")
+		return nil
+	}
+	first, last := uint64(0), frozen-1
+	if ctx.NArg() == 2 {
+		var ferr, lerr error
+		first, ferr = strconv.ParseUint(ctx.Args().Get(0), 10, 64)
+		last, lerr = strconv.ParseUint(ctx.Args().Get(1), 10, 64)
+		if ferr != nil || lerr != nil {
+			return errors.New("block number not an integer")
+		}
+		if first > last || last >= frozen {
+			return fmt.Errorf("invalid block range, ancient store contains blocks #0-#%d", frozen-1)
+		}
+	}
+	start := time.Now()
+	corrupted := rawdb.VerifyAncients(db, first, last, func(number uint64, err error) {
+		log.Error("Corrupted ancient block", "number", number, "err", err)
+	})
+	if len(corrupted) > 0 {
+		return fmt.Errorf("corrupted ancient blocks: %v", corrupted)
+	}
+	log.Info("Verified ancient blocks", "first", first, "last", last, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
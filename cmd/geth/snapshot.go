@@ -0,0 +1,124 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state/pruner"
+	"github.com/ethereum/go-ethereum/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	snapshotCommand = cli.Command{
+		Name:        "snapshot",
+		Usage:       "A set of commands for maintaining the state data",
+		Category:    "MISCELLANEOUS COMMANDS",
+		Description: "",
+		Subcommands: []cli.Command{
+			{
+				Name:      "prune-state",
+				Usage:     "Prune stale ethereum state data",
+				ArgsUsage: "<root>",
+				Action:    utils.MigrateFlags(pruneState),
+				Category:  "MISCELLANEOUS COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.DBEngineFlag,
+					utils.AncientFlag,
+					utils.TestnetFlag,
+					utils.RinkebyFlag,
+					utils.GoerliFlag,
+					utils.CacheFlag,
+					utils.CacheDatabaseFlag,
+					utils.CacheTrieJournalFlag,
+					utils.BloomFilterSizeFlag,
+				},
+				Description: `
+geth snapshot prune-state <state-root>
+will prune historical state data with the help of a state bloom filter. All
+trie nodes and contract codes that do not belong to the specified version of
+the state (and the genesis state) will be deleted from the database.
+
+If the state root is not specified, the state of HEAD-127 is retained, which is
+the state persisted to disk when geth shuts down. Since the head state will be
+pruned away, the chain is rewound to that block on the next start and the
+missing blocks are re-processed.
+
+The clean trie cache journal is deleted as well, since it may contain trie nodes
+which are pruned away.
+
+The bloom filter is persisted into the data directory before deleting anything.
+If the pruning is interrupted, it is resumed automatically on the next start
+(or by running this command again).
+
+WARNING: the node must not be running while pruning.
+`,
+			},
+		},
+	}
+)
+
+func pruneState(ctx *cli.Context) error {
+	if ctx.NArg() > 1 {
+		log.Error("Too many arguments given")
+		return errors.New("too many arguments")
+	}
+	var (
+		targetRoot common.Hash
+		err        error
+	)
+	if ctx.NArg() == 1 {
+		targetRoot, err = parseRoot(ctx.Args()[0])
+		if err != nil {
+			log.Error("Failed to resolve state root", "error", err)
+			return err
+		}
+	}
+	stack, config := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chainDb := utils.MakeChainDatabase(ctx, stack, false)
+	defer chainDb.Close()
+
+	var trieCachePath string
+	if config.Eth.TrieCleanCacheJournal != "" {
+		trieCachePath = stack.ResolvePath(config.Eth.TrieCleanCacheJournal)
+	}
+	pruner, err := pruner.NewPruner(chainDb, stack.ResolvePath(""), trieCachePath, ctx.Uint64(utils.BloomFilterSizeFlag.Name))
+	if err != nil {
+		log.Error("Failed to create state pruner", "error", err)
+		return err
+	}
+	if err = pruner.Prune(targetRoot); err != nil {
+		log.Error("Failed to prune state", "error", err)
+		return err
+	}
+	return nil
+}
+
+// parseRoot parses a state root given in hex format.
+func parseRoot(input string) (common.Hash, error) {
+	var h common.Hash
+	if err := h.UnmarshalText([]byte(input)); err != nil {
+		return h, err
+	}
+	return h, nil
+}
@@ -0,0 +1,329 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/internal/era"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// historyChecksums is the name of the file listing the sha256 checksums of all
+// the archive files in an exported history directory.
+const historyChecksums = "checksums.txt"
+
+// ExportHistory exports the canonical blocks in the range [first, last] into
+// archive files in the given directory, one file per epoch. The block data is
+// read raw from the database, covering both the ancient store and the active
+// key-value store. A checksum file listing the sha256 hash of every exported
+// archive is written alongside them, replacing any previous one.
+func ExportHistory(db ethdb.Database, dir string, first, last uint64, network string) error {
+	log.Info("Exporting blockchain history", "dir", dir)
+	if first > last {
+		return fmt.Errorf("invalid block range: first %d > last %d", first, last)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	var (
+		start     = time.Now()
+		logged    = time.Now()
+		checksums []string
+	)
+	for epoch := first / era.MaxEraSize; epoch <= last/era.MaxEraSize; epoch++ {
+		from, to := epoch*era.MaxEraSize, (epoch+1)*era.MaxEraSize-1
+		if from < first {
+			from = first
+		}
+		if to > last {
+			to = last
+		}
+		name, checksum, err := exportHistoryEpoch(db, dir, int(epoch), from, to, network)
+		if err != nil {
+			return err
+		}
+		checksums = append(checksums, checksum)
+
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Exporting blockchain history", "file", name, "block", to, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, historyChecksums), []byte(strings.Join(checksums, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	log.Info("Exported blockchain history", "dir", dir, "files", len(checksums), "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// exportHistoryEpoch writes the blocks [from, to] into a single archive file,
+// returning its name and sha256 checksum.
+func exportHistoryEpoch(db ethdb.Database, dir string, epoch int, from, to uint64, network string) (string, string, error) {
+	// Write into a temporary file first, the final name depends on the contents
+	tmp := filepath.Join(dir, fmt.Sprintf("%s-%05d.era1.tmp", network, epoch))
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	var (
+		buf     = bufio.NewWriter(f)
+		builder = era.NewBuilder(buf)
+	)
+	for number := from; number <= to; number++ {
+		hash := rawdb.ReadCanonicalHash(db, number)
+		if hash == (common.Hash{}) {
+			return "", "", fmt.Errorf("canonical hash for block %d missing", number)
+		}
+		var (
+			header   = rawdb.ReadHeaderRLP(db, hash, number)
+			body     = rawdb.ReadBodyRLP(db, hash, number)
+			receipts = rawdb.ReadReceiptsRLP(db, hash, number)
+			td       = rawdb.ReadTd(db, hash, number)
+		)
+		if len(header) == 0 || len(body) == 0 || len(receipts) == 0 || td == nil {
+			return "", "", fmt.Errorf("data for block %d [%x…] missing", number, hash[:4])
+		}
+		if err := builder.AddRLP(number, hash, header, body, receipts, td); err != nil {
+			return "", "", err
+		}
+	}
+	root, err := builder.Finalize()
+	if err != nil {
+		return "", "", err
+	}
+	if err := buf.Flush(); err != nil {
+		return "", "", err
+	}
+	if err := f.Sync(); err != nil {
+		return "", "", err
+	}
+	name := era.Filename(network, epoch, root)
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return "", "", err
+	}
+	checksum, err := fileChecksum(filepath.Join(dir, name))
+	if err != nil {
+		return "", "", err
+	}
+	return name, checksum, nil
+}
+
+// ImportHistory imports the archive files of the given network from the
+// directory straight into the ancient store of the database. The archives are
+// checked against the checksum file, verified for internal consistency and
+// linked to the chain already present in the database. Only blocks past the
+// current ancient store head are imported, so the database must not contain
+// any non-ancient chain segment beyond the genesis block.
+func ImportHistory(db ethdb.Database, dir string, network string) error {
+	log.Info("Importing blockchain history", "dir", dir)
+
+	files, err := era.ReadDir(dir, network)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no %s archives found in %s", network, dir)
+	}
+	checksums, err := readHistoryChecksums(filepath.Join(dir, historyChecksums))
+	if err != nil {
+		return err
+	}
+	if len(checksums) != len(files) {
+		return fmt.Errorf("checksum count mismatch: have %d, want %d", len(checksums), len(files))
+	}
+	// Ensure the database can be extended by the archived history
+	frozen, err := db.Ancients()
+	if err != nil {
+		return fmt.Errorf("ancient store unavailable: %v", err)
+	}
+	genesis := rawdb.ReadCanonicalHash(db, 0)
+	if genesis == (common.Hash{}) {
+		return errors.New("genesis block missing, database not initialized")
+	}
+	if head := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadHeaderHash(db)); head != nil && *head > 0 && *head >= frozen {
+		return fmt.Errorf("database contains non-ancient blocks up to #%d, history can only be imported into a fresh database", *head)
+	}
+	var (
+		parent   common.Hash
+		parentTd *big.Int
+	)
+	if frozen > 0 {
+		parent = rawdb.ReadCanonicalHash(db, frozen-1)
+		parentTd = rawdb.ReadTd(db, parent, frozen-1)
+	}
+	var (
+		start    = time.Now()
+		logged   = time.Now()
+		imported int
+	)
+	for i, file := range files {
+		checksum, err := fileChecksum(filepath.Join(dir, file))
+		if err != nil {
+			return err
+		}
+		if checksum != checksums[i] {
+			return fmt.Errorf("checksum mismatch for %s: have %s, want %s", file, checksum, checksums[i])
+		}
+		e, err := era.Open(filepath.Join(dir, file))
+		if err != nil {
+			return err
+		}
+		if e.Start()+e.Count() <= frozen {
+			log.Debug("Skipping already imported history", "file", file)
+			e.Close()
+			continue
+		}
+		if e.Start() > frozen {
+			e.Close()
+			return fmt.Errorf("history gap: %s starts at block %d, ancient store ends at %d", file, e.Start(), frozen)
+		}
+		if err := e.Verify(); err != nil {
+			e.Close()
+			return fmt.Errorf("failed to verify %s: %v", file, err)
+		}
+		n, err := importHistoryFile(db, e, frozen, genesis, &parent, &parentTd)
+		e.Close()
+		if err != nil {
+			return fmt.Errorf("failed to import %s: %v", file, err)
+		}
+		frozen += uint64(n)
+		imported += n
+
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Importing blockchain history", "file", file, "blocks", imported, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	log.Info("Imported blockchain history", "blocks", imported, "head", frozen-1, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// importHistoryFile appends the blocks of an already verified archive from the
+// given number onwards to the ancient store, updating the parent hash and total
+// difficulty as it goes. The header and fast block head markers are moved to
+// the last imported block.
+func importHistoryFile(db ethdb.Database, e *era.Era, from uint64, genesis common.Hash, parent *common.Hash, parentTd **big.Int) (int, error) {
+	batch := db.NewBatch()
+	for number := from; number < e.Start()+e.Count(); number++ {
+		header, body, receipts, td, err := e.GetRawBlockByNumber(number)
+		if err != nil {
+			return 0, err
+		}
+		var (
+			h types.Header
+			b types.Body
+		)
+		if err := rlp.DecodeBytes(header, &h); err != nil {
+			return 0, err
+		}
+		if err := rlp.DecodeBytes(body, &b); err != nil {
+			return 0, err
+		}
+		block := types.NewBlockWithHeader(&h).WithBody(b.Transactions, b.Uncles)
+		hash := block.Hash()
+
+		// Link the block to the chain already in the database
+		if number == 0 {
+			if hash != genesis {
+				return 0, fmt.Errorf("genesis mismatch: have %x, want %x", hash, genesis)
+			}
+		} else {
+			if block.ParentHash() != *parent {
+				return 0, fmt.Errorf("block %d: parent hash mismatch: have %x, want %x", number, block.ParentHash(), *parent)
+			}
+			if want := new(big.Int).Add(*parentTd, block.Difficulty()); td.Cmp(want) != 0 {
+				return 0, fmt.Errorf("block %d: total difficulty mismatch: have %v, want %v", number, td, want)
+			}
+		}
+		tdBlob, err := rlp.EncodeToBytes(td)
+		if err != nil {
+			return 0, err
+		}
+		if err := db.AppendAncient(number, hash.Bytes(), header, body, receipts, tdBlob); err != nil {
+			return 0, err
+		}
+		rawdb.WriteHeaderNumber(batch, hash, number)
+		rawdb.WriteTxLookupEntries(batch, block)
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return 0, err
+			}
+			batch.Reset()
+		}
+		*parent, *parentTd = hash, td
+	}
+	// Flush the ancient data before pointing the head markers to it
+	if err := db.Sync(); err != nil {
+		return 0, err
+	}
+	rawdb.WriteHeadHeaderHash(batch, *parent)
+	rawdb.WriteHeadFastBlockHash(batch, *parent)
+	if err := batch.Write(); err != nil {
+		return 0, err
+	}
+	return int(e.Start() + e.Count() - from), nil
+}
+
+// readHistoryChecksums reads the list of archive checksums from a history
+// checksum file.
+func readHistoryChecksums(fn string) ([]string, error) {
+	blob, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checksums: %v", err)
+	}
+	var checksums []string
+	for _, line := range strings.Split(string(blob), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			checksums = append(checksums, line)
+		}
+	}
+	return checksums, nil
+}
+
+// fileChecksum calculates the hex encoded sha256 checksum of a file.
+func fileChecksum(fn string) (string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
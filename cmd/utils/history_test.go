@@ -0,0 +1,145 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// newFreezerDB creates a leveldb database with a freezer in a subfolder of the
+// given directory.
+func newFreezerDB(t *testing.T, dir string) ethdb.Database {
+	db, err := rawdb.NewLevelDBDatabaseWithFreezer(filepath.Join(dir, "chaindata"), 16, 16, filepath.Join(dir, "ancient"), "", false)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	return db
+}
+
+// Tests that history exported into archives can be imported into the ancient
+// store of a fresh database.
+func TestHistoryExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  core.GenesisAlloc{address: {Balance: big.NewInt(1000000000000000000)}},
+		}
+		signer = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	// Generate and import a chain into the source database
+	srcdb := newFreezerDB(t, filepath.Join(dir, "src"))
+	defer srcdb.Close()
+
+	genesis := gspec.MustCommit(srcdb)
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), srcdb, 128, func(i int, gen *core.BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(gen.TxNonce(address), common.Address{0xaa}, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			t.Fatalf("failed to sign transaction: %v", err)
+		}
+		gen.AddTx(tx)
+	})
+	chain, err := core.NewBlockChain(srcdb, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	chain.Stop()
+
+	// Export the history and import it into a fresh database
+	archive := filepath.Join(dir, "archive")
+	if err := ExportHistory(srcdb, archive, 0, uint64(len(blocks)), "mainnet"); err != nil {
+		t.Fatalf("failed to export history: %v", err)
+	}
+	dstdb := newFreezerDB(t, filepath.Join(dir, "dst"))
+	defer dstdb.Close()
+
+	gspec.MustCommit(dstdb)
+	if err := ImportHistory(dstdb, archive, "mainnet"); err != nil {
+		t.Fatalf("failed to import history: %v", err)
+	}
+	if frozen, _ := dstdb.Ancients(); frozen != uint64(len(blocks)+1) {
+		t.Fatalf("ancient count mismatch: have %d, want %d", frozen, len(blocks)+1)
+	}
+	head := blocks[len(blocks)-1]
+	if hash := rawdb.ReadHeadHeaderHash(dstdb); hash != head.Hash() {
+		t.Errorf("head header mismatch: have %x, want %x", hash, head.Hash())
+	}
+	if hash := rawdb.ReadHeadFastBlockHash(dstdb); hash != head.Hash() {
+		t.Errorf("head fast block mismatch: have %x, want %x", hash, head.Hash())
+	}
+	for _, block := range blocks {
+		number := block.NumberU64()
+		if hash := rawdb.ReadCanonicalHash(dstdb, number); hash != block.Hash() {
+			t.Fatalf("block %d: canonical hash mismatch: have %x, want %x", number, hash, block.Hash())
+		}
+		if n := rawdb.ReadHeaderNumber(dstdb, block.Hash()); n == nil || *n != number {
+			t.Fatalf("block %d: header number mismatch: have %v", number, n)
+		}
+		if imported := rawdb.ReadBlock(dstdb, block.Hash(), number); imported == nil || imported.Hash() != block.Hash() {
+			t.Fatalf("block %d: imported block missing", number)
+		}
+		if want, have := rawdb.ReadTd(srcdb, block.Hash(), number), rawdb.ReadTd(dstdb, block.Hash(), number); have == nil || want.Cmp(have) != 0 {
+			t.Fatalf("block %d: total difficulty mismatch: have %v, want %v", number, have, want)
+		}
+		if want, have := rawdb.ReadReceiptsRLP(srcdb, block.Hash(), number), rawdb.ReadReceiptsRLP(dstdb, block.Hash(), number); string(want) != string(have) {
+			t.Fatalf("block %d: receipts mismatch", number)
+		}
+		for _, tx := range block.Transactions() {
+			if _, _, n, _ := rawdb.ReadTransaction(dstdb, tx.Hash()); n != number {
+				t.Fatalf("block %d: transaction lookup mismatch: have %d", number, n)
+			}
+		}
+	}
+	// Importing again should be a noop, importing tampered archives should fail
+	if err := ImportHistory(dstdb, archive, "mainnet"); err != nil {
+		t.Fatalf("failed to reimport history: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(archive, historyChecksums), []byte("00\n"), 0644); err != nil {
+		t.Fatalf("failed to overwrite checksums: %v", err)
+	}
+	baddb := newFreezerDB(t, filepath.Join(dir, "bad"))
+	defer baddb.Close()
+
+	gspec.MustCommit(baddb)
+	if err := ImportHistory(baddb, archive, "mainnet"); err == nil {
+		t.Fatalf("archive with bad checksum imported")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package misc
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// VerifyEip1559Header verifies the fields of a header that are altered by the
+// EIP-1559 fee market: the gas limit, which may move within the usual bounds of
+// its parent (doubled at the fork block to keep the gas target unchanged), and
+// the base fee, which is fully determined by the parent.
+func VerifyEip1559Header(config *params.ChainConfig, parent, header *types.Header) error {
+	// Verify that the gas limit remains within allowed bounds
+	parentGasLimit := parent.GasLimit
+	if !config.IsEIP1559(parent.Number) {
+		parentGasLimit = parent.GasLimit * params.ElasticityMultiplier
+	}
+	if err := VerifyGaslimit(parentGasLimit, header.GasLimit); err != nil {
+		return err
+	}
+	// Verify the header is not malformed and the base fee is correct
+	if header.BaseFee == nil {
+		return fmt.Errorf("header is missing baseFee")
+	}
+	if expected := CalcBaseFee(config, parent); header.BaseFee.Cmp(expected) != 0 {
+		return fmt.Errorf("invalid baseFee: have %v, want %v, parentBaseFee %v, parentGasUsed %d",
+			header.BaseFee, expected, parent.BaseFee, parent.GasUsed)
+	}
+	return nil
+}
+
+// VerifyGaslimit verifies that the header's gas limit remains within the allowed
+// bounds of the parent's gas limit.
+func VerifyGaslimit(parentGasLimit, headerGasLimit uint64) error {
+	diff := int64(parentGasLimit) - int64(headerGasLimit)
+	if diff < 0 {
+		diff *= -1
+	}
+	limit := parentGasLimit / params.GasLimitBoundDivisor
+
+	if uint64(diff) >= limit || headerGasLimit < params.MinGasLimit {
+		return fmt.Errorf("invalid gas limit: have %d, want %d += %d", headerGasLimit, parentGasLimit, limit)
+	}
+	return nil
+}
+
+// CalcBaseFee calculates the base fee of the header following the given parent.
+// The base fee moves by at most 1/BaseFeeChangeDenominator per block, rising
+// when the parent used more gas than its target (half of its gas limit) and
+// falling when it used less.
+func CalcBaseFee(config *params.ChainConfig, parent *types.Header) *big.Int {
+	// The first fee market block starts off with the initial base fee
+	if !config.IsEIP1559(parent.Number) {
+		return new(big.Int).SetUint64(params.InitialBaseFee)
+	}
+	var (
+		parentGasTarget          = parent.GasLimit / params.ElasticityMultiplier
+		parentGasTargetBig       = new(big.Int).SetUint64(parentGasTarget)
+		baseFeeChangeDenominator = new(big.Int).SetUint64(params.BaseFeeChangeDenominator)
+	)
+	// If the parent gas used is exactly the target, the base fee stays the same
+	if parent.GasUsed == parentGasTarget {
+		return new(big.Int).Set(parent.BaseFee)
+	}
+	if parent.GasUsed > parentGasTarget {
+		// Over target, increase the base fee by at least 1 wei
+		gasUsedDelta := new(big.Int).SetUint64(parent.GasUsed - parentGasTarget)
+		baseFeeDelta := new(big.Int).Mul(parent.BaseFee, gasUsedDelta)
+		baseFeeDelta.Div(baseFeeDelta, parentGasTargetBig)
+		baseFeeDelta.Div(baseFeeDelta, baseFeeChangeDenominator)
+
+		return new(big.Int).Add(parent.BaseFee, math.BigMax(baseFeeDelta, common.Big1))
+	}
+	// Under target, decrease the base fee without going below zero
+	gasUsedDelta := new(big.Int).SetUint64(parentGasTarget - parent.GasUsed)
+	baseFeeDelta := new(big.Int).Mul(parent.BaseFee, gasUsedDelta)
+	baseFeeDelta.Div(baseFeeDelta, parentGasTargetBig)
+	baseFeeDelta.Div(baseFeeDelta, baseFeeChangeDenominator)
+
+	baseFee := new(big.Int).Sub(parent.BaseFee, baseFeeDelta)
+	if baseFee.Sign() < 0 {
+		baseFee.SetUint64(0)
+	}
+	return baseFee
+}
@@ -17,11 +17,13 @@
 package core
 
 import (
+	"crypto/ecdsa"
 	"fmt"
 	"io/ioutil"
 	"math/big"
 	"math/rand"
 	"os"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -143,7 +145,7 @@ func testBlockChainImport(chain types.Blocks, blockchain *BlockChain) error {
 			}
 			return err
 		}
-		statedb, err := state.New(blockchain.GetBlockByHash(block.ParentHash()).Root(), blockchain.stateCache)
+		statedb, err := state.New(blockchain.GetBlockByHash(block.ParentHash()).Root(), blockchain.stateCache, nil)
 		if err != nil {
 			return err
 		}
@@ -649,7 +651,7 @@ func TestFastVsFullChains(t *testing.T) {
 		t.Fatalf("failed to create temp freezer dir: %v", err)
 	}
 	defer os.Remove(frdir)
-	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "")
+	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "", false)
 	if err != nil {
 		t.Fatalf("failed to create temp freezer db: %v", err)
 	}
@@ -723,7 +725,7 @@ func TestLightVsFastVsFullChainHeads(t *testing.T) {
 			t.Fatalf("failed to create temp freezer dir: %v", err)
 		}
 		defer os.Remove(dir)
-		db, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), dir, "")
+		db, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), dir, "", false)
 		if err != nil {
 			t.Fatalf("failed to create temp freezer db: %v", err)
 		}
@@ -1412,6 +1414,180 @@ func TestEIP155Transition(t *testing.T) {
 	}
 }
 
+// Tests that access list transactions are accepted only after the EIP-2930 fork
+// and that state accesses are charged according to their warm/cold status.
+func TestEIP2930Transition(t *testing.T) {
+	// Configure a chain with a contract loading the same storage slot twice
+	var (
+		db       = rawdb.NewMemoryDatabase()
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address  = crypto.PubkeyToAddress(key.PublicKey)
+		funds    = big.NewInt(1000000000)
+		contract = common.Address{0xaa}
+		gspec    = &Genesis{
+			Config: &params.ChainConfig{
+				ChainID:             big.NewInt(1),
+				HomesteadBlock:      big.NewInt(0),
+				EIP150Block:         big.NewInt(0),
+				EIP155Block:         big.NewInt(0),
+				EIP158Block:         big.NewInt(0),
+				ByzantiumBlock:      big.NewInt(0),
+				ConstantinopleBlock: big.NewInt(0),
+				PetersburgBlock:     big.NewInt(0),
+				EIP2930Block:        big.NewInt(2),
+			},
+			Alloc: GenesisAlloc{
+				address: {Balance: funds},
+				// PUSH1 0, SLOAD, POP, PUSH1 0, SLOAD, POP, STOP
+				contract: {Code: common.FromHex("0x600054506000545000"), Balance: new(big.Int)},
+			},
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP2930Signer(gspec.Config.ChainID)
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	defer blockchain.Stop()
+
+	accesses := types.AccessList{{Address: contract, StorageKeys: []common.Hash{{}}}}
+	blocks, receipts := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 2, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), contract, new(big.Int), 50000, new(big.Int), nil), signer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.AddTx(tx)
+
+		if i == 1 {
+			tx, err = types.SignTx(types.NewAccessListTransaction(gspec.Config.ChainID, block.TxNonce(address), &contract, new(big.Int), 50000, new(big.Int), nil, accesses), signer, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			block.AddTx(tx)
+		}
+	})
+	// Check the gas used by the transactions before and after the fork
+	for i, want := range []uint64{
+		21000 + 3 + 200 + 2 + 3 + 200 + 2,               // 2x SLOAD, pre-fork
+		21000 + 3 + 2100 + 2 + 3 + 100 + 2,              // cold, then warm SLOAD
+		21000 + 2400 + 1900 + 3 + 100 + 2 + 3 + 100 + 2, // warm SLOADs thanks to the access list
+	} {
+		var receipt *types.Receipt
+		if i == 0 {
+			receipt = receipts[0][0]
+		} else {
+			receipt = receipts[1][i-1]
+		}
+		if receipt.GasUsed != want {
+			t.Errorf("transaction %d: gas used mismatch: have %d, want %d", i, receipt.GasUsed, want)
+		}
+	}
+	if _, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	stored := blockchain.GetReceiptsByHash(blocks[1].Hash())
+	if len(stored) != 2 || stored[0].Type != types.LegacyTxType || stored[1].Type != types.AccessListTxType {
+		t.Fatalf("stored receipts type mismatch: %v", stored)
+	}
+	// Access list transactions are invalid before the fork
+	blocks, _ = GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 1, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewAccessListTransaction(gspec.Config.ChainID, block.TxNonce(address), &contract, new(big.Int), 50000, new(big.Int), nil, accesses), signer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.txs = append(block.txs, tx)
+	})
+	if _, err := blockchain.InsertChain(blocks); err != types.ErrTxTypeNotSupported {
+		t.Errorf("pre-fork insertion error mismatch: have %v, want %v", err, types.ErrTxTypeNotSupported)
+	}
+}
+
+// Tests that the fee market activates with the EIP-1559 fork: the gas limit is
+// doubled, the base fee is burned and only the tip is paid to the miner.
+func TestEIP1559Transition(t *testing.T) {
+	var (
+		db       = rawdb.NewMemoryDatabase()
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address  = crypto.PubkeyToAddress(key.PublicKey)
+		funds    = new(big.Int).Mul(big.NewInt(params.Ether), big.NewInt(1000))
+		coinbase = common.Address{0xbb}
+		gspec    = &Genesis{
+			Config: &params.ChainConfig{
+				ChainID:             big.NewInt(1),
+				HomesteadBlock:      big.NewInt(0),
+				EIP150Block:         big.NewInt(0),
+				EIP155Block:         big.NewInt(0),
+				EIP158Block:         big.NewInt(0),
+				ByzantiumBlock:      big.NewInt(0),
+				ConstantinopleBlock: big.NewInt(0),
+				PetersburgBlock:     big.NewInt(0),
+				EIP2930Block:        big.NewInt(0),
+				EIP1559Block:        big.NewInt(1),
+			},
+			Alloc: GenesisAlloc{address: {Balance: funds}},
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP1559Signer(gspec.Config.ChainID)
+		tip     = big.NewInt(2 * params.GWei)
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	defer blockchain.Stop()
+
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 2, func(i int, block *BlockGen) {
+		block.SetCoinbase(coinbase)
+
+		feeCap := new(big.Int).Add(block.header.BaseFee, tip)
+		tx, err := types.SignTx(types.NewDynamicFeeTransaction(gspec.Config.ChainID, block.TxNonce(address), &common.Address{0xaa}, big.NewInt(1), params.TxGas, tip, feeCap, nil, nil), signer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.AddTx(tx)
+	})
+	if _, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	// The fork block doubles the gas limit and starts at the initial base fee
+	if have, want := blocks[0].GasLimit(), genesis.GasLimit()*params.ElasticityMultiplier; have != want {
+		t.Errorf("fork block gas limit mismatch: have %d, want %d", have, want)
+	}
+	if have := blocks[0].BaseFee(); have == nil || have.Uint64() != params.InitialBaseFee {
+		t.Errorf("fork block base fee mismatch: have %v, want %d", have, params.InitialBaseFee)
+	}
+	// Both blocks are way below the gas target, the base fee must drop
+	if blocks[1].BaseFee().Cmp(blocks[0].BaseFee()) >= 0 {
+		t.Errorf("base fee didn't drop: have %v, parent %v", blocks[1].BaseFee(), blocks[0].BaseFee())
+	}
+	// The sender pays base fee and tip, the miner only receives the tip
+	statedb, _ := blockchain.State()
+
+	paid, tips := new(big.Int), new(big.Int)
+	for _, block := range blocks {
+		gasUsed := new(big.Int).SetUint64(block.GasUsed())
+		paid.Add(paid, new(big.Int).Mul(gasUsed, new(big.Int).Add(block.BaseFee(), tip)))
+		tips.Add(tips, new(big.Int).Mul(gasUsed, tip))
+	}
+	want := new(big.Int).Sub(funds, paid)
+	want.Sub(want, big.NewInt(2)) // value transfers
+	if have := statedb.GetBalance(address); have.Cmp(want) != 0 {
+		t.Errorf("sender balance mismatch: have %v, want %v", have, want)
+	}
+	want = new(big.Int).Mul(ethash.ConstantinopleBlockReward, big.NewInt(2))
+	want.Add(want, tips)
+	if have := statedb.GetBalance(coinbase); have.Cmp(want) != 0 {
+		t.Errorf("miner balance mismatch: have %v, want %v", have, want)
+	}
+	// Transactions unable to pay the base fee are invalid
+	blocks, _ = GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 1, func(i int, block *BlockGen) {
+		feeCap := new(big.Int).Sub(block.header.BaseFee, common.Big1)
+		tx, err := types.SignTx(types.NewDynamicFeeTransaction(gspec.Config.ChainID, block.TxNonce(address), &common.Address{0xaa}, big.NewInt(1), params.TxGas, common.Big0, feeCap, nil, nil), signer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.txs = append(block.txs, tx)
+	})
+	if _, err := blockchain.InsertChain(blocks); err != ErrFeeCapTooLow {
+		t.Errorf("low fee cap insertion error mismatch: have %v, want %v", err, ErrFeeCapTooLow)
+	}
+}
+
 func TestEIP161AccountRemoval(t *testing.T) {
 	// Configure and generate a sample block chain
 	var (
@@ -1644,7 +1820,7 @@ func TestBlockchainRecovery(t *testing.T) {
 		t.Fatalf("failed to create temp freezer dir: %v", err)
 	}
 	defer os.Remove(frdir)
-	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "")
+	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "", false)
 	if err != nil {
 		t.Fatalf("failed to create temp freezer db: %v", err)
 	}
@@ -1700,7 +1876,7 @@ func TestIncompleteAncientReceiptChainInsertion(t *testing.T) {
 		t.Fatalf("failed to create temp freezer dir: %v", err)
 	}
 	defer os.Remove(frdir)
-	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "")
+	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "", false)
 	if err != nil {
 		t.Fatalf("failed to create temp freezer db: %v", err)
 	}
@@ -1899,7 +2075,7 @@ func testInsertKnownChainData(t *testing.T, typ string) {
 		t.Fatalf("failed to create temp freezer dir: %v", err)
 	}
 	defer os.Remove(dir)
-	chaindb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), dir, "")
+	chaindb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), dir, "", false)
 	if err != nil {
 		t.Fatalf("failed to create temp freezer db: %v", err)
 	}
@@ -2241,3 +2417,68 @@ func BenchmarkBlockChain_1x1000Executions(b *testing.B) {
 	}
 	benchmarkLargeNumberOfValueToNonexisting(b, numTxs, numBlocks, recipientFn, dataFn)
 }
+
+// Tests that the state prefetcher doesn't feed the EVM profiler, so the profiled
+// opcodes of a chain match a single execution of its blocks.
+func TestProfilerIgnoresPrefetch(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0xc0de")
+		gspec    = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				// Contract looping for a while, then incrementing storage slot 0
+				contract: {Balance: new(big.Int), Code: common.FromHex("0x6140005b600190038060035750600054600101600055")},
+			},
+		}
+		signer = types.NewEIP155Signer(gspec.Config.ChainID)
+		keys   = make([]*ecdsa.PrivateKey, 8)
+	)
+	// The followup block is prefetched on top of the parent of the current one,
+	// so every block is sent from a fresh account to keep the nonces valid. The
+	// looping ensures the prefetching isn't interrupted before it starts.
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		gspec.Alloc[crypto.PubkeyToAddress(keys[i].PublicKey)] = GenesisAccount{Balance: big.NewInt(params.Ether)}
+	}
+	gendb := rawdb.NewMemoryDatabase()
+	blocks, _ := GenerateChain(gspec.Config, gspec.MustCommit(gendb), ethash.NewFaker(), gendb, len(keys), func(i int, block *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(0, contract, new(big.Int), 1000000, big.NewInt(1), nil), signer, keys[i])
+		block.AddTx(tx)
+	})
+	// Import the chain with and without prefetching and compare the profiles
+	counts := make([]map[string]uint64, 2)
+	for i, noPrefetch := range []bool{true, false} {
+		db := rawdb.NewMemoryDatabase()
+		gspec.MustCommit(db)
+
+		profiler := vm.NewProfiler()
+		profiler.Start()
+
+		cacheConfig := &CacheConfig{
+			TrieCleanLimit:      256,
+			TrieCleanNoPrefetch: noPrefetch,
+			TrieDirtyLimit:      256,
+			TrieTimeLimit:       5 * time.Minute,
+		}
+		chain, err := NewBlockChain(db, cacheConfig, gspec.Config, ethash.NewFaker(), vm.Config{Profiler: profiler}, nil)
+		if err != nil {
+			t.Fatalf("failed to create chain: %v", err)
+		}
+		if _, err := chain.InsertChain(blocks); err != nil {
+			t.Fatalf("failed to import chain: %v", err)
+		}
+		chain.Stop()
+		profiler.Stop()
+
+		counts[i] = make(map[string]uint64)
+		for op, stats := range profiler.Report().Ops {
+			counts[i][op] = stats.Count
+		}
+	}
+	if counts[0]["SSTORE"] != uint64(len(blocks)) {
+		t.Fatalf("profiled SSTORE count mismatch: have %d, want %d", counts[0]["SSTORE"], len(blocks))
+	}
+	if !reflect.DeepEqual(counts[0], counts[1]) {
+		t.Errorf("prefetching changed the profile: have %v, want %v", counts[1], counts[0])
+	}
+}
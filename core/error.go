@@ -33,6 +33,14 @@ var (
 	// next one expected based on the local chain.
 	ErrNonceTooHigh = errors.New("nonce too high")
 
+	// ErrTipAboveFeeCap is returned if the tip of a transaction is higher than
+	// its total fee cap.
+	ErrTipAboveFeeCap = errors.New("max priority fee per gas higher than max fee per gas")
+
+	// ErrFeeCapTooLow is returned if the fee cap of a transaction is lower than
+	// the base fee of the block it is executed in.
+	ErrFeeCapTooLow = errors.New("max fee per gas less than block base fee")
+
 	// ErrNoGenesis is returned when there is no Genesis Block.
 	ErrNoGenesis = errors.New("genesis not found in chain")
 )
@@ -339,6 +339,44 @@ func TestBlockReceiptStorage(t *testing.T) {
 	}
 }
 
+// Tests that the receipts of typed transactions retain their type in the database,
+// so that the receipt root can be derived from the raw receipts alone.
+func TestTypedReceiptStorage(t *testing.T) {
+	db := NewMemoryDatabase()
+
+	var (
+		to  = common.HexToAddress("0x1")
+		tx1 = types.NewTransaction(1, to, big.NewInt(1), 1, big.NewInt(1), nil)
+		tx2 = types.NewAccessListTransaction(big.NewInt(1), 2, &to, big.NewInt(2), 2, big.NewInt(2), nil, nil)
+		tx3 = types.NewDynamicFeeTransaction(big.NewInt(1), 3, &to, big.NewInt(3), 3, big.NewInt(1), big.NewInt(3), nil, nil)
+	)
+	receipts := make(types.Receipts, 3)
+	for i, tx := range []*types.Transaction{tx1, tx2, tx3} {
+		receipts[i] = &types.Receipt{
+			Type:              tx.Type(),
+			Status:            types.ReceiptStatusSuccessful,
+			CumulativeGasUsed: uint64(i + 1),
+			Logs:              []*types.Log{{Address: common.BytesToAddress([]byte{byte(i)})}},
+		}
+		receipts[i].Bloom = types.CreateBloom(types.Receipts{receipts[i]})
+	}
+	block := types.NewBlock(&types.Header{Number: big.NewInt(1)}, types.Transactions{tx1, tx2, tx3}, nil, receipts)
+
+	WriteReceipts(db, block.Hash(), block.NumberU64(), receipts)
+	raw := ReadRawReceipts(db, block.Hash(), block.NumberU64())
+	if len(raw) != len(receipts) {
+		t.Fatalf("receipt count mismatch: have %d, want %d", len(raw), len(receipts))
+	}
+	for i, receipt := range raw {
+		if receipt.Type != receipts[i].Type {
+			t.Errorf("receipt %d: type mismatch: have %d, want %d", i, receipt.Type, receipts[i].Type)
+		}
+	}
+	if have := types.DeriveSha(raw); have != block.ReceiptHash() {
+		t.Fatalf("receipt root mismatch: have %x, want %x", have, block.ReceiptHash())
+	}
+}
+
 func checkReceiptsRLP(have, want types.Receipts) error {
 	if len(have) != len(want) {
 		return fmt.Errorf("receipts sizes mismatch: have %d, want %d", len(have), len(want))
@@ -0,0 +1,164 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+)
+
+// Tests that a database opened with a read-only freezer serves the ancient data
+// but rejects any modification of it.
+func TestReadonlyFreezerDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer-readonly-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := newFreezer(dir, "", false)
+	if err != nil {
+		t.Fatalf("failed to create freezer: %v", err)
+	}
+	writeTestAncients(f, 3)
+	f.Close()
+
+	db, err := NewDatabaseWithFreezer(memorydb.New(), dir, "", true)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if frozen, _ := db.Ancients(); frozen != 3 {
+		t.Fatalf("ancient count mismatch: have %d, want %d", frozen, 3)
+	}
+	if _, err := db.Ancient(freezerHashTable, 2); err != nil {
+		t.Fatalf("failed to retrieve ancient hash: %v", err)
+	}
+	if err := db.AppendAncient(3, nil, nil, nil, nil, nil); err != errReadOnly {
+		t.Fatalf("append error mismatch: have %v, want %v", err, errReadOnly)
+	}
+	if err := db.TruncateAncients(1); err != errReadOnly {
+		t.Fatalf("truncate error mismatch: have %v, want %v", err, errReadOnly)
+	}
+	if frozen, _ := db.Ancients(); frozen != 3 {
+		t.Fatalf("ancient count mismatch after rejected writes: have %d, want %d", frozen, 3)
+	}
+}
+
+// readFreezerFiles returns the contents of all the files in a freezer directory.
+func readFreezerFiles(t *testing.T, dir string) map[string][]byte {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to list freezer files: %v", err)
+	}
+	files := make(map[string][]byte)
+	for _, info := range infos {
+		blob, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			t.Fatalf("failed to read freezer file %s: %v", info.Name(), err)
+		}
+		files[info.Name()] = blob
+	}
+	return files
+}
+
+// Tests that opening an inconsistent freezer read-only fails without repairing
+// any of its files.
+func TestReadonlyFreezerInconsistent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer-readonly-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := newFreezer(dir, "", false)
+	if err != nil {
+		t.Fatalf("failed to create freezer: %v", err)
+	}
+	writeTestAncients(f, 3)
+	if err := f.tables[freezerHashTable].truncate(2); err != nil {
+		t.Fatalf("failed to truncate hash table: %v", err)
+	}
+	f.Close()
+
+	// Drop a dangling byte onto the body index too
+	index, err := os.OpenFile(filepath.Join(dir, freezerBodiesTable+".cidx"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("failed to open body index: %v", err)
+	}
+	index.Write([]byte{0x01})
+	index.Close()
+
+	before := readFreezerFiles(t, dir)
+	if _, err := NewDatabaseWithFreezer(memorydb.New(), dir, "", true); err == nil {
+		t.Fatalf("opened inconsistent freezer read-only")
+	}
+	after := readFreezerFiles(t, dir)
+	if len(before) != len(after) {
+		t.Fatalf("freezer file count changed: have %d, want %d", len(after), len(before))
+	}
+	for name, blob := range before {
+		if !bytes.Equal(after[name], blob) {
+			t.Errorf("freezer file %s modified", name)
+		}
+	}
+}
+
+// Tests that a legacy freezer without checksum files can be opened read-only,
+// serving its items unverified and without creating any checksum files.
+func TestReadonlyFreezerLegacy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer-readonly-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := newFreezer(dir, "", false)
+	if err != nil {
+		t.Fatalf("failed to create freezer: %v", err)
+	}
+	writeTestAncients(f, 3)
+	f.Close()
+
+	for name := range freezerNoSnappy {
+		os.Remove(filepath.Join(dir, name+".rcrc"))
+		os.Remove(filepath.Join(dir, name+".ccrc"))
+	}
+	f, err = newFreezer(dir, "", true)
+	if err != nil {
+		t.Fatalf("failed to open legacy freezer read-only: %v", err)
+	}
+	for name := range freezerNoSnappy {
+		if _, err := f.Ancient(name, 2); err != nil {
+			t.Errorf("failed to retrieve legacy %s item: %v", name, err)
+		}
+	}
+	f.Close()
+
+	for name := range readFreezerFiles(t, dir) {
+		if strings.HasSuffix(name, "crc") {
+			t.Errorf("read-only freezer created checksum file %s", name)
+		}
+	}
+}
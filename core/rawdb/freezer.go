@@ -20,8 +20,10 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"math/big"
 	"os"
 	"path/filepath"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -30,6 +32,7 @@ import (
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/prometheus/tsdb/fileutil"
 )
 
@@ -45,6 +48,10 @@ var (
 	// errSymlinkDatadir is returned if the ancient directory specified by user
 	// is a symbolic link.
 	errSymlinkDatadir = errors.New("symbolic link datadir is not supported")
+
+	// errReadOnly is returned if the user attempts to modify a freezer opened
+	// in read-only mode.
+	errReadOnly = errors.New("read only")
 )
 
 const (
@@ -56,6 +63,14 @@ const (
 	// freezerBatchLimit is the maximum number of blocks to freeze in one batch
 	// before doing an fsync and deleting it from the key-value store.
 	freezerBatchLimit = 30000
+
+	// freezerScrubInterval is the time to wait between verifying two batches of
+	// ancient blocks in the background scrubber.
+	freezerScrubInterval = 10 * time.Second
+
+	// freezerScrubBatch is the number of ancient blocks to verify in one batch
+	// in the background scrubber.
+	freezerScrubBatch = 1024
 )
 
 // freezer is an memory mapped append-only database to store immutable chain data
@@ -71,13 +86,19 @@ type freezer struct {
 	// so take advantage of that (https://golang.org/pkg/sync/atomic/#pkg-note-BUG).
 	frozen uint64 // Number of blocks already frozen
 
+	readonly     bool                     // Flag whether modifications are rejected
 	tables       map[string]*freezerTable // Data tables for storing everything
 	instanceLock fileutil.Releaser        // File-system lock to prevent double opens
+
+	migrated  chan struct{} // Closed once all frozen items have a checksum
+	quit      chan struct{} // Quit channel to stop background maintenance threads
+	closeOnce sync.Once
 }
 
 // newFreezer creates a chain freezer that moves ancient chain data into
-// append-only flat file containers.
-func newFreezer(datadir string, namespace string) (*freezer, error) {
+// append-only flat file containers. A read-only freezer never modifies the
+// files on disk, failing instead if they would need to be repaired.
+func newFreezer(datadir string, namespace string, readonly bool) (*freezer, error) {
 	// Create the initial freezer object
 	var (
 		readMeter  = metrics.NewRegisteredMeter(namespace+"ancient/read", nil)
@@ -98,11 +119,14 @@ func newFreezer(datadir string, namespace string) (*freezer, error) {
 	}
 	// Open all the supported data tables
 	freezer := &freezer{
+		readonly:     readonly,
 		tables:       make(map[string]*freezerTable),
 		instanceLock: lock,
+		migrated:     make(chan struct{}),
+		quit:         make(chan struct{}),
 	}
 	for name, disableSnappy := range freezerNoSnappy {
-		table, err := newTable(datadir, name, readMeter, writeMeter, disableSnappy)
+		table, err := newTable(datadir, name, readMeter, writeMeter, disableSnappy, readonly)
 		if err != nil {
 			for _, table := range freezer.tables {
 				table.Close()
@@ -119,12 +143,19 @@ func newFreezer(datadir string, namespace string) (*freezer, error) {
 		lock.Release()
 		return nil, err
 	}
+	// Items lacking checksums are migrated by a background thread, see
+	// migrateChecksums
+	if freezer.checksummed() >= atomic.LoadUint64(&freezer.frozen) {
+		close(freezer.migrated)
+	}
 	log.Info("Opened ancient database", "database", datadir)
 	return freezer, nil
 }
 
 // Close terminates the chain freezer, unmapping all the data files.
 func (f *freezer) Close() error {
+	f.closeOnce.Do(func() { close(f.quit) })
+
 	var errs []error
 	for _, table := range f.tables {
 		if err := table.Close(); err != nil {
@@ -177,6 +208,15 @@ func (f *freezer) AncientSize(kind string) (uint64, error) {
 // injection will be rejected. But if two injections with same number happen at
 // the same time, we can get into the trouble.
 func (f *freezer) AppendAncient(number uint64, hash, header, body, receipts, td []byte) (err error) {
+	if f.readonly {
+		return errReadOnly
+	}
+	// New items can only be checksummed after the old ones are
+	select {
+	case <-f.migrated:
+	case <-f.quit:
+		return errClosed
+	}
 	// Ensure the binary blobs we are appending is continuous with freezer.
 	if atomic.LoadUint64(&f.frozen) != number {
 		return errOutOrderInsertion
@@ -219,6 +259,9 @@ func (f *freezer) AppendAncient(number uint64, hash, header, body, receipts, td
 
 // Truncate discards any recent data above the provided threshold number.
 func (f *freezer) TruncateAncients(items uint64) error {
+	if f.readonly {
+		return errReadOnly
+	}
 	if atomic.LoadUint64(&f.frozen) <= items {
 		return nil
 	}
@@ -367,14 +410,72 @@ func (f *freezer) freeze(db ethdb.KeyValueStore) {
 	}
 }
 
-// repair truncates all data tables to the same length.
+// scrub is a background thread that continuously cycles through the ancient
+// store in small batches, verifying the integrity of the frozen blocks and
+// reporting any corrupted ranges found.
+func (f *freezer) scrub() {
+	var (
+		next      uint64        // Next block number to verify
+		corrupted AncientRanges // Corrupted ranges found in the current pass
+		start     = time.Now()
+	)
+	for {
+		select {
+		case <-f.quit:
+			return
+		case <-time.After(freezerScrubInterval):
+		}
+		frozen := atomic.LoadUint64(&f.frozen)
+		if next >= frozen {
+			// Pass finished (or nothing frozen yet), report and start over
+			if next > 0 {
+				if len(corrupted) > 0 {
+					log.Error("Corrupted ancient blocks found", "ranges", corrupted, "elapsed", common.PrettyDuration(time.Since(start)))
+				} else {
+					log.Debug("Verified ancient blocks", "count", next, "elapsed", common.PrettyDuration(time.Since(start)))
+				}
+			}
+			next, corrupted, start = 0, nil, time.Now()
+			continue
+		}
+		last := next + freezerScrubBatch - 1
+		if last >= frozen {
+			last = frozen - 1
+		}
+		ranges := VerifyAncients(f, next, last, func(number uint64, err error) {
+			// Items might have been truncated away concurrently, ignore those
+			if number < atomic.LoadUint64(&f.frozen) {
+				log.Error("Corrupted ancient block", "number", number, "err", err)
+			}
+		})
+		for _, r := range ranges {
+			for number := r.First; number <= r.Last && number < atomic.LoadUint64(&f.frozen); number++ {
+				corrupted = corrupted.add(number)
+			}
+		}
+		next = last + 1
+	}
+}
+
+// repair truncates all data tables to the same length. A read-only freezer is
+// only checked for consistency.
 func (f *freezer) repair() error {
-	min := uint64(math.MaxUint64)
+	min, max := uint64(math.MaxUint64), uint64(0)
 	for _, table := range f.tables {
 		items := atomic.LoadUint64(&table.items)
 		if min > items {
 			min = items
 		}
+		if max < items {
+			max = items
+		}
+	}
+	if f.readonly {
+		if min != max {
+			return fmt.Errorf("inconsistent freezer tables, can't repair them read-only: %d to %d items", min, max)
+		}
+		atomic.StoreUint64(&f.frozen, min)
+		return nil
 	}
 	for _, table := range f.tables {
 		if err := table.truncate(min); err != nil {
@@ -384,3 +485,120 @@ func (f *freezer) repair() error {
 	atomic.StoreUint64(&f.frozen, min)
 	return nil
 }
+
+// migrateChecksums computes the checksums of the frozen items which don't have
+// one yet, e.g. in tables created before checksums were introduced. Computing a
+// checksum from the stored data would make an already corrupted item look valid,
+// so every block is verified against its header first. Blocks failing that are
+// reported, and their items get a checksum which never matches.
+//
+// The migration runs in the background in batches, so it doesn't hold up the
+// startup. The checksum files double as its progress marker: they are synced
+// after every batch, and an interrupted migration resumes from the first item
+// lacking a checksum. Appending new items waits until the migration is done.
+func (f *freezer) migrateChecksums() {
+	select {
+	case <-f.migrated:
+		return
+	default:
+	}
+	var (
+		reader    = uncheckedFreezer{f}
+		first     = f.checksummed()
+		corrupted AncientRanges
+		parentTd  *big.Int
+		start     = time.Now()
+		logged    = time.Now()
+	)
+	if frozen := atomic.LoadUint64(&f.frozen); first < frozen {
+		log.Info("Checksumming ancient blocks", "first", first, "last", frozen-1)
+	}
+	if first > 0 {
+		if blob, err := reader.Ancient(freezerDifficultyTable, first-1); err == nil {
+			td := new(big.Int)
+			if err := rlp.DecodeBytes(blob, td); err == nil {
+				parentTd = td
+			}
+		}
+	}
+	for number := first; ; {
+		select {
+		case <-f.quit:
+			return
+		default:
+		}
+		// Blocks can only be truncated away concurrently, never appended
+		frozen := atomic.LoadUint64(&f.frozen)
+		if number >= frozen {
+			break
+		}
+		last := number + freezerScrubBatch
+		if last > frozen {
+			last = frozen
+		}
+		for ; number < last; number++ {
+			td, err := VerifyAncient(reader, number, parentTd)
+			if err != nil && number < atomic.LoadUint64(&f.frozen) {
+				log.Error("Corrupted ancient block, not checksumming it", "number", number, "err", err)
+				corrupted = corrupted.add(number)
+			}
+			for _, table := range f.tables {
+				if atomic.LoadUint64(&table.checksummed) > number {
+					continue
+				}
+				if cerr := table.appendChecksum(err == nil); cerr != nil && cerr != errOutOfBounds {
+					select {
+					case <-f.quit:
+					default:
+						log.Error("Failed to checksum ancient block", "number", number, "err", cerr)
+					}
+					return
+				}
+			}
+			parentTd = td
+		}
+		for _, table := range f.tables {
+			if err := table.Sync(); err != nil {
+				log.Error("Failed to sync ancient checksums", "err", err)
+				return
+			}
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Checksumming ancient blocks", "number", number, "last", frozen-1, "corrupted", len(corrupted), "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if len(corrupted) > 0 {
+		log.Error("Corrupted ancient blocks found", "ranges", corrupted)
+	}
+	if count := f.checksummed() - first; count > 0 {
+		log.Info("Checksummed ancient blocks", "count", count, "corrupted", len(corrupted), "elapsed", common.PrettyDuration(time.Since(start)))
+	}
+	close(f.migrated)
+}
+
+// checksummed returns the number of leading items having a checksum in all of
+// the data tables.
+func (f *freezer) checksummed() uint64 {
+	checksummed := uint64(math.MaxUint64)
+	for _, table := range f.tables {
+		if n := atomic.LoadUint64(&table.checksummed); n < checksummed {
+			checksummed = n
+		}
+	}
+	return checksummed
+}
+
+// uncheckedFreezer is a freezer reader which doesn't verify the item checksums,
+// allowing the verification of items which don't have one yet.
+type uncheckedFreezer struct {
+	*freezer
+}
+
+// Ancient retrieves an ancient binary blob without verifying its checksum.
+func (f uncheckedFreezer) Ancient(kind string, number uint64) ([]byte, error) {
+	if table := f.tables[kind]; table != nil {
+		return table.retrieveUnchecked(number)
+	}
+	return nil, errUnknownTable
+}
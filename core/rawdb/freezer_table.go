@@ -20,6 +20,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"os"
 	"path/filepath"
 	"sync"
@@ -42,8 +43,23 @@ var (
 
 	// errNotSupported is returned if the database doesn't support the required operation.
 	errNotSupported = errors.New("this operation is not supported")
+
+	// errChecksumMismatch is returned if the data of an item read from the freezer
+	// table doesn't match the checksum stored for it.
+	errChecksumMismatch = errors.New("checksum mismatch")
+
+	// errMissingChecksums is returned if an item is appended to a freezer table
+	// which has items without a checksum.
+	errMissingChecksums = errors.New("missing checksums")
 )
 
+// checksumSize is the size of the per-item CRC32 checksums stored in the checksum
+// file of a freezer table.
+const checksumSize = 4
+
+// checksumTable is the CRC32 polynomial table used to checksum freezer items.
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
 // indexEntry contains the number/id of the file that the data resides in, aswell as the
 // offset within the file to the end of the data
 // In serialized form, the filenum is stored as uint16.
@@ -70,15 +86,22 @@ func (i *indexEntry) marshallBinary() []byte {
 }
 
 // freezerTable represents a single chained data table within the freezer (e.g. blocks).
-// It consists of a data file (snappy encoded arbitrary data blobs) and an indexEntry
-// file (uncompressed 64 bit indices into the data file).
+// It consists of a data file (snappy encoded arbitrary data blobs), an indexEntry
+// file (uncompressed 64 bit indices into the data file) and a checksum file (CRC32
+// of every stored data blob).
+//
+// Tables created before checksums were introduced lack the checksum file. The
+// missing checksums are filled in by the freezer on open, after verifying the
+// items against the block headers.
 type freezerTable struct {
-	// WARNING: The `items` field is accessed atomically. On 32 bit platforms, only
-	// 64-bit aligned fields can be atomic. The struct is guaranteed to be so aligned,
-	// so take advantage of that (https://golang.org/pkg/sync/atomic/#pkg-note-BUG).
-	items uint64 // Number of items stored in the table (including items removed from tail)
+	// WARNING: The `items` and `checksummed` fields are accessed atomically. On 32 bit
+	// platforms, only 64-bit aligned fields can be atomic. The struct is guaranteed to
+	// be so aligned, so take advantage of that (https://golang.org/pkg/sync/atomic/#pkg-note-BUG).
+	items       uint64 // Number of items stored in the table (including items removed from tail)
+	checksummed uint64 // Number of items with a checksum (including items removed from tail)
 
 	noCompression bool   // if true, disables snappy compression. Note: does not work retroactively
+	readonly      bool   // if true, the files are neither repaired nor modified
 	maxFileSize   uint32 // Max file size for data-files
 	name          string
 	path          string
@@ -88,6 +111,7 @@ type freezerTable struct {
 	headId uint32              // number of the currently active head file
 	tailId uint32              // number of the earliest file
 	index  *os.File            // File descriptor for the indexEntry file of the table
+	crcs   *os.File            // File descriptor for the checksum file of the table
 
 	// In the case that old items are deleted (from the tail), we use itemOffset
 	// to count how many historic items have gone missing.
@@ -102,33 +126,52 @@ type freezerTable struct {
 }
 
 // newTable opens a freezer table with default settings - 2G files
-func newTable(path string, name string, readMeter metrics.Meter, writeMeter metrics.Meter, disableSnappy bool) (*freezerTable, error) {
-	return newCustomTable(path, name, readMeter, writeMeter, 2*1000*1000*1000, disableSnappy)
+func newTable(path string, name string, readMeter metrics.Meter, writeMeter metrics.Meter, disableSnappy bool, readonly bool) (*freezerTable, error) {
+	return openTable(path, name, readMeter, writeMeter, 2*1000*1000*1000, disableSnappy, readonly)
 }
 
 // newCustomTable opens a freezer table, creating the data and index files if they are
 // non existent. Both files are truncated to the shortest common length to ensure
 // they don't go out of sync.
 func newCustomTable(path string, name string, readMeter metrics.Meter, writeMeter metrics.Meter, maxFilesize uint32, noCompression bool) (*freezerTable, error) {
+	return openTable(path, name, readMeter, writeMeter, maxFilesize, noCompression, false)
+}
+
+// openTable opens a freezer table like newCustomTable does. In read-only mode the
+// files are opened as they are: missing data and index files and inconsistencies
+// between them are reported as errors instead of being repaired. A missing checksum
+// file is treated as one without any checksums.
+func openTable(path string, name string, readMeter metrics.Meter, writeMeter metrics.Meter, maxFilesize uint32, noCompression bool, readonly bool) (*freezerTable, error) {
 	// Ensure the containing directory exists and open the indexEntry file
-	if err := os.MkdirAll(path, 0755); err != nil {
+	flag := os.O_RDWR | os.O_CREATE | os.O_APPEND
+	if readonly {
+		flag = os.O_RDONLY
+	} else if err := os.MkdirAll(path, 0755); err != nil {
 		return nil, err
 	}
-	var idxName string
+	var idxName, crcName string
 	if noCompression {
 		// raw idx
 		idxName = fmt.Sprintf("%s.ridx", name)
+		crcName = fmt.Sprintf("%s.rcrc", name)
 	} else {
 		// compressed idx
 		idxName = fmt.Sprintf("%s.cidx", name)
+		crcName = fmt.Sprintf("%s.ccrc", name)
 	}
-	offsets, err := os.OpenFile(filepath.Join(path, idxName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	offsets, err := os.OpenFile(filepath.Join(path, idxName), flag, 0644)
 	if err != nil {
 		return nil, err
 	}
+	crcs, err := os.OpenFile(filepath.Join(path, crcName), flag, 0644)
+	if err != nil && !(readonly && os.IsNotExist(err)) {
+		offsets.Close()
+		return nil, err
+	}
 	// Create the table and repair any past inconsistency
 	tab := &freezerTable{
 		index:         offsets,
+		crcs:          crcs,
 		files:         make(map[uint32]*os.File),
 		readMeter:     readMeter,
 		writeMeter:    writeMeter,
@@ -136,6 +179,7 @@ func newCustomTable(path string, name string, readMeter metrics.Meter, writeMete
 		path:          path,
 		logger:        log.New("database", path, "table", name),
 		noCompression: noCompression,
+		readonly:      readonly,
 		maxFileSize:   maxFilesize,
 	}
 	if err := tab.repair(); err != nil {
@@ -157,12 +201,18 @@ func (t *freezerTable) repair() error {
 		return err
 	}
 	if stat.Size() == 0 {
+		if t.readonly {
+			return t.errInconsistent("empty index")
+		}
 		if _, err := t.index.Write(buffer); err != nil {
 			return err
 		}
 	}
 	// Ensure the index is a multiple of indexEntrySize bytes
 	if overflow := stat.Size() % indexEntrySize; overflow != 0 {
+		if t.readonly {
+			return t.errInconsistent("partial index entry")
+		}
 		t.index.Truncate(stat.Size() - overflow) // New file can't trigger this path
 	}
 	// Retrieve the file sizes and prepare for truncation
@@ -188,7 +238,7 @@ func (t *freezerTable) repair() error {
 
 	t.index.ReadAt(buffer, offsetsSize-indexEntrySize)
 	lastIndex.unmarshalBinary(buffer)
-	t.head, err = t.openFile(lastIndex.filenum, os.O_RDWR|os.O_CREATE|os.O_APPEND)
+	t.head, err = t.openFile(lastIndex.filenum, t.headFlag())
 	if err != nil {
 		return err
 	}
@@ -199,6 +249,9 @@ func (t *freezerTable) repair() error {
 
 	// Keep truncating both files until they come in sync
 	contentExp = int64(lastIndex.offset)
+	if t.readonly && contentExp != contentSize {
+		return t.errInconsistent(fmt.Sprintf("indexed %d bytes, stored %d", contentExp, contentSize))
+	}
 
 	for contentExp != contentSize {
 		// Truncate the head file to the last offset pointer
@@ -236,11 +289,13 @@ func (t *freezerTable) repair() error {
 		}
 	}
 	// Ensure all reparation changes have been written to disk
-	if err := t.index.Sync(); err != nil {
-		return err
-	}
-	if err := t.head.Sync(); err != nil {
-		return err
+	if !t.readonly {
+		if err := t.index.Sync(); err != nil {
+			return err
+		}
+		if err := t.head.Sync(); err != nil {
+			return err
+		}
 	}
 	// Update the item and byte counters and return
 	t.items = uint64(t.itemOffset) + uint64(offsetsSize/indexEntrySize-1) // last indexEntry points to the end of the data file
@@ -251,10 +306,78 @@ func (t *freezerTable) repair() error {
 	if err := t.preopen(); err != nil {
 		return err
 	}
+	// Bring the checksums in sync with the now consistent data
+	if err := t.repairChecksums(); err != nil {
+		return err
+	}
 	t.logger.Debug("Chain freezer table opened", "items", t.items, "size", common.StorageSize(t.headBytes))
 	return nil
 }
 
+// repairChecksums cross checks the checksum file against the index, dropping any
+// dangling checksums. Missing checksums are not computed here, since the items
+// might be corrupted already; see freezer.migrateChecksums.
+func (t *freezerTable) repairChecksums() error {
+	if t.crcs == nil {
+		// Missing checksum file of a read-only legacy table
+		t.checksummed = uint64(t.itemOffset)
+		return nil
+	}
+	stat, err := t.crcs.Stat()
+	if err != nil {
+		return err
+	}
+	var (
+		stored = uint64(stat.Size()) / checksumSize
+		items  = t.items - uint64(t.itemOffset)
+	)
+	if stored > items || uint64(stat.Size())%checksumSize != 0 {
+		if t.readonly {
+			return t.errInconsistent(fmt.Sprintf("%d items, %d bytes of checksums", items, stat.Size()))
+		}
+		if stored > items {
+			stored = items
+		}
+		t.logger.Warn("Truncating dangling checksums", "indexed", items, "stored", stored)
+		if err := t.crcs.Truncate(int64(stored * checksumSize)); err != nil {
+			return err
+		}
+	}
+	t.checksummed = uint64(t.itemOffset) + stored
+	return nil
+}
+
+// appendChecksum stores the checksum of the first item lacking one. If the item
+// is known to be corrupted, an inverted checksum is stored instead, so that the
+// corruption keeps being reported rather than hidden behind a fresh checksum.
+func (t *freezerTable) appendChecksum(valid bool) error {
+	if t.readonly {
+		return errReadOnly
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	item := atomic.LoadUint64(&t.checksummed)
+	if item >= atomic.LoadUint64(&t.items) {
+		return errOutOfBounds
+	}
+	blob, err := t.retrieve(item - uint64(t.itemOffset))
+	if err != nil {
+		return err
+	}
+	crc := crc32.Checksum(blob, checksumTable)
+	if !valid {
+		crc = ^crc
+	}
+	buf := make([]byte, checksumSize)
+	binary.BigEndian.PutUint32(buf, crc)
+	if _, err := t.crcs.Write(buf); err != nil {
+		return err
+	}
+	atomic.AddUint64(&t.checksummed, 1)
+	return nil
+}
+
 // preopen opens all files that the freezer will need. This method should be called from an init-context,
 // since it assumes that it doesn't have to bother with locking
 // The rationale for doing preopen is to not have to do it from within Retrieve, thus not needing to ever
@@ -269,10 +392,24 @@ func (t *freezerTable) preopen() (err error) {
 		}
 	}
 	// Open head in read/write
-	t.head, err = t.openFile(t.headId, os.O_RDWR|os.O_CREATE|os.O_APPEND)
+	t.head, err = t.openFile(t.headId, t.headFlag())
 	return err
 }
 
+// headFlag returns the flags to open the head data file with.
+func (t *freezerTable) headFlag() int {
+	if t.readonly {
+		return os.O_RDONLY
+	}
+	return os.O_RDWR | os.O_CREATE | os.O_APPEND
+}
+
+// errInconsistent returns the error reported for an inconsistency of the table
+// files, which would have been repaired if the table wasn't opened read-only.
+func (t *freezerTable) errInconsistent(reason string) error {
+	return fmt.Errorf("inconsistent freezer table %s, can't repair it read-only: %s", t.name, reason)
+}
+
 // truncate discards any recent data above the provided threashold number.
 func (t *freezerTable) truncate(items uint64) error {
 	t.lock.Lock()
@@ -282,11 +419,20 @@ func (t *freezerTable) truncate(items uint64) error {
 	if atomic.LoadUint64(&t.items) <= items {
 		return nil
 	}
-	// Something's out of sync, truncate the table's offset index
+	if t.readonly {
+		return errReadOnly
+	}
+	// Something's out of sync, truncate the table's offset index and checksums
 	t.logger.Warn("Truncating freezer table", "items", t.items, "limit", items)
 	if err := t.index.Truncate(int64(items+1) * indexEntrySize); err != nil {
 		return err
 	}
+	if err := t.crcs.Truncate(int64(items) * checksumSize); err != nil {
+		return err
+	}
+	if atomic.LoadUint64(&t.checksummed) > items {
+		atomic.StoreUint64(&t.checksummed, items)
+	}
 	// Calculate the new expected size of the data file and truncate it
 	buffer := make([]byte, indexEntrySize)
 	if _, err := t.index.ReadAt(buffer, int64(items*indexEntrySize)); err != nil {
@@ -330,6 +476,13 @@ func (t *freezerTable) Close() error {
 	}
 	t.index = nil
 
+	if t.crcs != nil {
+		if err := t.crcs.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		t.crcs = nil
+	}
+
 	for _, f := range t.files {
 		if err := f.Close(); err != nil {
 			errs = append(errs, err)
@@ -391,6 +544,9 @@ func (t *freezerTable) releaseFilesAfter(num uint32, remove bool) {
 // Note, this method will *not* flush any data to disk so be sure to explicitly
 // fsync before irreversibly deleting data from the database.
 func (t *freezerTable) Append(item uint64, blob []byte) error {
+	if t.readonly {
+		return errReadOnly
+	}
 	// Read lock prevents competition with truncate
 	t.lock.RLock()
 	// Ensure the table is still accessible
@@ -403,6 +559,11 @@ func (t *freezerTable) Append(item uint64, blob []byte) error {
 		t.lock.RUnlock()
 		return fmt.Errorf("appending unexpected item: want %d, have %d", t.items, item)
 	}
+	// Ensure the checksum is written at the right position
+	if atomic.LoadUint64(&t.checksummed) != item {
+		t.lock.RUnlock()
+		return errMissingChecksums
+	}
 	// Encode the blob and write it into the data file
 	if !t.noCompression {
 		blob = snappy.Encode(nil, blob)
@@ -443,9 +604,16 @@ func (t *freezerTable) Append(item uint64, blob []byte) error {
 		filenum: atomic.LoadUint32(&t.headId),
 		offset:  newOffset,
 	}
+	// Write the checksum before the indexEntry, dangling ones are dropped on repair
+	crc := make([]byte, checksumSize)
+	binary.BigEndian.PutUint32(crc, crc32.Checksum(blob, checksumTable))
+	if _, err := t.crcs.Write(crc); err != nil {
+		return err
+	}
 	// Write indexEntry
 	t.index.Write(idx.marshallBinary())
-	t.writeMeter.Mark(int64(bLen + indexEntrySize))
+	t.writeMeter.Mark(int64(bLen + indexEntrySize + checksumSize))
+	atomic.AddUint64(&t.checksummed, 1)
 	atomic.AddUint64(&t.items, 1)
 	return nil
 }
@@ -488,29 +656,72 @@ func (t *freezerTable) Retrieve(item uint64) ([]byte, error) {
 		return nil, errOutOfBounds
 	}
 	t.lock.RLock()
-	startOffset, endOffset, filenum, err := t.getBounds(item - uint64(offset))
+	blob, err := t.retrieve(item - uint64(offset))
 	if err != nil {
 		t.lock.RUnlock()
 		return nil, err
 	}
+	// Legacy items without a checksum yet are returned unverified
+	var crc []byte
+	if item < atomic.LoadUint64(&t.checksummed) {
+		crc = make([]byte, checksumSize)
+		if _, err := t.crcs.ReadAt(crc, int64(item-uint64(offset))*checksumSize); err != nil {
+			t.lock.RUnlock()
+			return nil, err
+		}
+	}
+	t.lock.RUnlock()
+	t.readMeter.Mark(int64(len(blob) + 2*indexEntrySize + len(crc)))
+
+	// Ensure the data is intact, decompress and return
+	if crc != nil && crc32.Checksum(blob, checksumTable) != binary.BigEndian.Uint32(crc) {
+		return nil, errChecksumMismatch
+	}
+	if t.noCompression {
+		return blob, nil
+	}
+	return snappy.Decode(nil, blob)
+}
+
+// retrieveUnchecked looks up an item like Retrieve does, but without verifying
+// it against its checksum. It's used to verify items which don't have one yet.
+func (t *freezerTable) retrieveUnchecked(item uint64) ([]byte, error) {
+	if t.index == nil || t.head == nil {
+		return nil, errClosed
+	}
+	offset := atomic.LoadUint32(&t.itemOffset)
+	if atomic.LoadUint64(&t.items) <= item || uint64(offset) > item {
+		return nil, errOutOfBounds
+	}
+	t.lock.RLock()
+	blob, err := t.retrieve(item - uint64(offset))
+	t.lock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if t.noCompression {
+		return blob, nil
+	}
+	return snappy.Decode(nil, blob)
+}
+
+// retrieve reads the raw stored binary blob of an item from the data file. The
+// item number is relative to the first one not deleted from the tail. This method
+// assumes that the caller holds the read lock.
+func (t *freezerTable) retrieve(item uint64) ([]byte, error) {
+	startOffset, endOffset, filenum, err := t.getBounds(item)
+	if err != nil {
+		return nil, err
+	}
 	dataFile, exist := t.files[filenum]
 	if !exist {
-		t.lock.RUnlock()
 		return nil, fmt.Errorf("missing data file %d", filenum)
 	}
-	// Retrieve the data itself, decompress and return
 	blob := make([]byte, endOffset-startOffset)
 	if _, err := dataFile.ReadAt(blob, int64(startOffset)); err != nil {
-		t.lock.RUnlock()
 		return nil, err
 	}
-	t.lock.RUnlock()
-	t.readMeter.Mark(int64(len(blob) + 2*indexEntrySize))
-
-	if t.noCompression {
-		return blob, nil
-	}
-	return snappy.Decode(nil, blob)
+	return blob, nil
 }
 
 // has returns an indicator whether the specified number data
@@ -529,15 +740,28 @@ func (t *freezerTable) size() (uint64, error) {
 		return 0, err
 	}
 	total := uint64(t.maxFileSize)*uint64(t.headId-t.tailId) + uint64(t.headBytes) + uint64(stat.Size())
+	if t.crcs != nil {
+		crcs, err := t.crcs.Stat()
+		if err != nil {
+			return 0, err
+		}
+		total += uint64(crcs.Size())
+	}
 	return total, nil
 }
 
 // Sync pushes any pending data from memory out to disk. This is an expensive
 // operation, so use it with care.
 func (t *freezerTable) Sync() error {
+	if t.readonly {
+		return nil
+	}
 	if err := t.index.Sync(); err != nil {
 		return err
 	}
+	if err := t.crcs.Sync(); err != nil {
+		return err
+	}
 	return t.head.Sync()
 }
 
@@ -19,6 +19,7 @@ package rawdb
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -560,6 +561,15 @@ func TestOffset(t *testing.T) {
 		indexFile.Truncate(indexEntrySize * (1 + 2))
 		indexFile.Close()
 
+		// Drop the checksums of the four removed items too
+		p = filepath.Join(os.TempDir(), fmt.Sprintf("%v.rcrc", fname))
+		crcs, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, crcs[4*checksumSize:], 0644); err != nil {
+			t.Fatal(err)
+		}
 	}
 	// Now open again
 	{
@@ -597,6 +607,147 @@ func TestOffset(t *testing.T) {
 	}
 }
 
+// TestFreezerChecksumMismatch tests that corruptions of the data files are
+// detected by the item checksums.
+func TestFreezerChecksumMismatch(t *testing.T) {
+	t.Parallel()
+	wm, rm := metrics.NewMeter(), metrics.NewMeter()
+	fname := fmt.Sprintf("checksum-%d", rand.Uint64())
+
+	for _, noCompression := range []bool{true, false} {
+		name := fmt.Sprintf("%s-%v", fname, noCompression)
+		f, err := newCustomTable(os.TempDir(), name, rm, wm, 50, noCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Write 3 x 15 bytes into the first file and flip a bit in the middle item
+		for x := 0; x < 3; x++ {
+			f.Append(uint64(x), getChunk(15, x))
+		}
+		f.Close()
+
+		ext := "rdat"
+		if !noCompression {
+			ext = "cdat"
+		}
+		p := filepath.Join(os.TempDir(), fmt.Sprintf("%s.0000.%s", name, ext))
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[len(data)/2] ^= 0x01
+		if err := ioutil.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		// Reopen the table and ensure only the middle item is rejected
+		f, err = newCustomTable(os.TempDir(), name, rm, wm, 50, noCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for x := 0; x < 3; x++ {
+			got, err := f.Retrieve(uint64(x))
+			if x == 1 {
+				if err != errChecksumMismatch {
+					t.Fatalf("item %d: error mismatch: have %v, want %v", x, err, errChecksumMismatch)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("item %d: failed to retrieve: %v", x, err)
+			}
+			if exp := getChunk(15, x); !bytes.Equal(got, exp) {
+				t.Fatalf("item %d: expected %x got %x", x, exp, got)
+			}
+		}
+		f.Close()
+	}
+}
+
+// TestFreezerChecksumRepair tests that dangling checksums are dropped when a table
+// is opened, while missing ones are left for the freezer to fill in.
+func TestFreezerChecksumRepair(t *testing.T) {
+	t.Parallel()
+	wm, rm := metrics.NewMeter(), metrics.NewMeter()
+	fname := fmt.Sprintf("checksumrepair-%d", rand.Uint64())
+	crcfile := filepath.Join(os.TempDir(), fmt.Sprintf("%s.rcrc", fname))
+
+	{ // Fill table across multiple files
+		f, err := newCustomTable(os.TempDir(), fname, rm, wm, 50, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for x := 0; x < 10; x++ {
+			f.Append(uint64(x), getChunk(15, x))
+		}
+		f.Close()
+	}
+	// Drop some checksums and leave a partial one around
+	if err := os.Truncate(crcfile, 3*checksumSize+1); err != nil {
+		t.Fatal(err)
+	}
+	f, err := newCustomTable(os.TempDir(), fname, rm, wm, 50, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.checksummed != 3 {
+		t.Fatalf("checksummed items mismatch: have %d, want %d", f.checksummed, 3)
+	}
+	if err := assertFileSize(crcfile, 3*checksumSize); err != nil {
+		t.Fatal(err)
+	}
+	if blob, err := f.Retrieve(3); err != nil || !bytes.Equal(blob, getChunk(15, 3)) {
+		t.Fatalf("item without checksum mismatch: have %x, %v, want %x", blob, err, getChunk(15, 3))
+	}
+	if err := f.Append(10, getChunk(15, 10)); err != errMissingChecksums {
+		t.Fatalf("append error mismatch: have %v, want %v", err, errMissingChecksums)
+	}
+	// Fill in the missing checksums, marking one of the items corrupted
+	for x := 3; x < 10; x++ {
+		if err := f.appendChecksum(x != 5); err != nil {
+			t.Fatalf("item %d: failed to add checksum: %v", x, err)
+		}
+	}
+	for x := 0; x < 10; x++ {
+		got, err := f.Retrieve(uint64(x))
+		if x == 5 {
+			if err != errChecksumMismatch {
+				t.Fatalf("item %d: error mismatch: have %v, want %v", x, err, errChecksumMismatch)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("item %d: failed to retrieve: %v", x, err)
+		}
+		if exp := getChunk(15, x); !bytes.Equal(got, exp) {
+			t.Fatalf("item %d: expected %x got %x", x, exp, got)
+		}
+	}
+	if err := f.Append(10, getChunk(15, 10)); err != nil {
+		t.Fatalf("failed to append after repair: %v", err)
+	}
+	f.Close()
+
+	// Add garbage checksums and ensure they are dropped
+	crcs, err := os.OpenFile(crcfile, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crcs.Write(make([]byte, 2*checksumSize))
+	crcs.Close()
+
+	if f, err = newCustomTable(os.TempDir(), fname, rm, wm, 50, true); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if f.checksummed != 11 {
+		t.Fatalf("checksummed items mismatch: have %d, want %d", f.checksummed, 11)
+	}
+	if err := assertFileSize(crcfile, 11*checksumSize); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // TODO (?)
 // - test that if we remove several head-files, aswell as data last data-file,
 //   the index is truncated accordingly
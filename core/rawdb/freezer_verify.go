@@ -0,0 +1,181 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// AncientRange is an inclusive range of ancient block numbers.
+type AncientRange struct {
+	First uint64
+	Last  uint64
+}
+
+// String implements fmt.Stringer.
+func (r AncientRange) String() string {
+	if r.First == r.Last {
+		return fmt.Sprintf("#%d", r.First)
+	}
+	return fmt.Sprintf("#%d-#%d", r.First, r.Last)
+}
+
+// AncientRanges is a list of ancient block ranges.
+type AncientRanges []AncientRange
+
+// String implements fmt.Stringer.
+func (rs AncientRanges) String() string {
+	strs := make([]string, len(rs))
+	for i, r := range rs {
+		strs[i] = r.String()
+	}
+	return strings.Join(strs, ", ")
+}
+
+// add appends a single block number to the list, extending the last range if
+// the number is adjacent to it.
+func (rs AncientRanges) add(number uint64) AncientRanges {
+	if n := len(rs); n > 0 && rs[n-1].Last+1 == number {
+		rs[n-1].Last = number
+		return rs
+	}
+	return append(rs, AncientRange{First: number, Last: number})
+}
+
+// VerifyAncient checks the integrity of a single frozen block. All its items need
+// to be retrievable (passing their checksums), the header needs to hash to the
+// stored hash and the body and receipts need to match the roots in the header.
+// If the total difficulty of the parent is known, the total difficulty of the
+// block is checked against it too. The block's total difficulty is returned to
+// allow chaining the checks.
+func VerifyAncient(db ethdb.AncientReader, number uint64, parentTd *big.Int) (*big.Int, error) {
+	hash, err := db.Ancient(freezerHashTable, number)
+	if err != nil {
+		return nil, fmt.Errorf("hash unavailable: %v", err)
+	}
+	headerRLP, err := db.Ancient(freezerHeaderTable, number)
+	if err != nil {
+		return nil, fmt.Errorf("header unavailable: %v", err)
+	}
+	if have := crypto.Keccak256Hash(headerRLP); have != common.BytesToHash(hash) {
+		return nil, fmt.Errorf("header hash mismatch: have %x, want %x", have, hash)
+	}
+	header := new(types.Header)
+	if err := rlp.DecodeBytes(headerRLP, header); err != nil {
+		return nil, fmt.Errorf("invalid header: %v", err)
+	}
+	if header.Number.Uint64() != number {
+		return nil, fmt.Errorf("header number mismatch: have %d", header.Number)
+	}
+	// Verify the block body against the header
+	bodyRLP, err := db.Ancient(freezerBodiesTable, number)
+	if err != nil {
+		return nil, fmt.Errorf("body unavailable: %v", err)
+	}
+	body := new(types.Body)
+	if err := rlp.DecodeBytes(bodyRLP, body); err != nil {
+		return nil, fmt.Errorf("invalid body: %v", err)
+	}
+	if have := types.DeriveSha(types.Transactions(body.Transactions)); have != header.TxHash {
+		return nil, fmt.Errorf("transaction root mismatch: have %x, want %x", have, header.TxHash)
+	}
+	if have := types.CalcUncleHash(body.Uncles); have != header.UncleHash {
+		return nil, fmt.Errorf("uncle hash mismatch: have %x, want %x", have, header.UncleHash)
+	}
+	// Verify the receipts against the header
+	receiptsRLP, err := db.Ancient(freezerReceiptTable, number)
+	if err != nil {
+		return nil, fmt.Errorf("receipts unavailable: %v", err)
+	}
+	var storageReceipts []*types.ReceiptForStorage
+	if err := rlp.DecodeBytes(receiptsRLP, &storageReceipts); err != nil {
+		return nil, fmt.Errorf("invalid receipts: %v", err)
+	}
+	receipts := make(types.Receipts, len(storageReceipts))
+	for i, receipt := range storageReceipts {
+		receipts[i] = (*types.Receipt)(receipt)
+	}
+	if have := types.DeriveSha(receipts); have != header.ReceiptHash {
+		return nil, fmt.Errorf("receipt root mismatch: have %x, want %x", have, header.ReceiptHash)
+	}
+	// Verify the total difficulty if the parent's is known
+	tdRLP, err := db.Ancient(freezerDifficultyTable, number)
+	if err != nil {
+		return nil, fmt.Errorf("total difficulty unavailable: %v", err)
+	}
+	td := new(big.Int)
+	if err := rlp.DecodeBytes(tdRLP, td); err != nil {
+		return nil, fmt.Errorf("invalid total difficulty: %v", err)
+	}
+	if parentTd != nil {
+		if want := new(big.Int).Add(parentTd, header.Difficulty); td.Cmp(want) != 0 {
+			return nil, fmt.Errorf("total difficulty mismatch: have %v, want %v", td, want)
+		}
+	}
+	return td, nil
+}
+
+// VerifyAncients checks the integrity of all the frozen blocks in the range
+// [first, last] and returns the ranges of the corrupted ones. The optional
+// callback is invoked with the failure reason for every corrupted block.
+func VerifyAncients(db ethdb.AncientReader, first, last uint64, onCorrupt func(number uint64, err error)) AncientRanges {
+	var (
+		corrupted AncientRanges
+		parentTd  *big.Int
+
+		start  = time.Now()
+		logged = time.Now()
+	)
+	// Retrieve the parent total difficulty to verify the first block with too
+	if first > 0 {
+		if blob, err := db.Ancient(freezerDifficultyTable, first-1); err == nil {
+			td := new(big.Int)
+			if err := rlp.DecodeBytes(blob, td); err == nil {
+				parentTd = td
+			}
+		}
+	}
+	for number := first; number <= last; number++ {
+		td, err := VerifyAncient(db, number, parentTd)
+		if err != nil {
+			if onCorrupt != nil {
+				onCorrupt(number, err)
+			}
+			corrupted = corrupted.add(number)
+		}
+		parentTd = td
+
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Verifying ancient blocks", "number", number, "last", last, "corrupted", len(corrupted), "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+		if number == last {
+			break // Avoid overflowing on math.MaxUint64
+		}
+	}
+	return corrupted
+}
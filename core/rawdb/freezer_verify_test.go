@@ -0,0 +1,200 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// writeTestAncients freezes a chain of blocks, each with a transaction and a receipt.
+func writeTestAncients(f *freezer, n int) {
+	var (
+		parent = common.Hash{}
+		td     = new(big.Int)
+	)
+	for i := 0; i < n; i++ {
+		header := &types.Header{
+			ParentHash: parent,
+			Number:     big.NewInt(int64(i)),
+			Difficulty: big.NewInt(int64(1000 + i)),
+		}
+		tx := types.NewTransaction(uint64(i), common.Address{0xaa}, big.NewInt(1), 21000, big.NewInt(1), nil)
+		receipt := types.NewReceipt(nil, false, 21000)
+		receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+		block := types.NewBlock(header, []*types.Transaction{tx}, nil, []*types.Receipt{receipt})
+
+		td.Add(td, block.Difficulty())
+		WriteAncientBlock(f, block, types.Receipts{receipt}, td)
+		parent = block.Hash()
+	}
+}
+
+// Tests that the ancient verifier detects corrupted blocks and reports them in
+// merged ranges.
+func TestVerifyAncients(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer-verify-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := newFreezer(dir, "", false)
+	if err != nil {
+		t.Fatalf("failed to create freezer: %v", err)
+	}
+	writeTestAncients(f, 10)
+
+	if ranges := VerifyAncients(f, 0, 9, nil); len(ranges) != 0 {
+		t.Fatalf("intact ancients reported corrupted: %v", ranges)
+	}
+	f.Close()
+
+	// Flip bits in the stored hashes of a few blocks and ensure they are detected
+	path := filepath.Join(dir, "hashes.0000.rdat")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read hashes: %v", err)
+	}
+	for _, number := range []int{3, 4, 7} {
+		data[number*common.HashLength] ^= 0x01
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("failed to write hashes: %v", err)
+	}
+	if f, err = newFreezer(dir, "", false); err != nil {
+		t.Fatalf("failed to reopen freezer: %v", err)
+	}
+	defer f.Close()
+
+	var reported []uint64
+	ranges := VerifyAncients(f, 0, 9, func(number uint64, err error) {
+		reported = append(reported, number)
+	})
+	if want := (AncientRanges{{3, 4}, {7, 7}}); !reflect.DeepEqual(ranges, want) {
+		t.Fatalf("corrupted range mismatch: have %v, want %v", ranges, want)
+	}
+	if want := []uint64{3, 4, 7}; !reflect.DeepEqual(reported, want) {
+		t.Fatalf("reported block mismatch: have %v, want %v", reported, want)
+	}
+	if ranges := VerifyAncients(f, 5, 6, nil); len(ranges) != 0 {
+		t.Fatalf("intact subrange reported corrupted: %v", ranges)
+	}
+}
+
+// Tests that missing checksums are only derived from blocks which verify against
+// their headers, while corrupted blocks keep being reported.
+func TestFreezerChecksumUpgrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer-upgrade-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := newFreezer(dir, "", false)
+	if err != nil {
+		t.Fatalf("failed to create freezer: %v", err)
+	}
+	writeTestAncients(f, 10)
+	f.Close()
+
+	// Turn the tables into legacy ones without checksums and corrupt a block
+	crcs, _ := filepath.Glob(filepath.Join(dir, "*crc"))
+	if len(crcs) != len(freezerNoSnappy) {
+		t.Fatalf("checksum file count mismatch: have %d, want %d", len(crcs), len(freezerNoSnappy))
+	}
+	for _, crc := range crcs {
+		if err := os.Remove(crc); err != nil {
+			t.Fatalf("failed to remove checksums: %v", err)
+		}
+	}
+	path := filepath.Join(dir, "hashes.0000.rdat")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read hashes: %v", err)
+	}
+	data[4*common.HashLength] ^= 0x01
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("failed to write hashes: %v", err)
+	}
+	// Reopen the freezer, the items must be served unverified until migrated
+	if f, err = newFreezer(dir, "", false); err != nil {
+		t.Fatalf("failed to reopen freezer: %v", err)
+	}
+	if f.checksummed() != 0 {
+		t.Fatalf("checksummed items mismatch: have %d, want %d", f.checksummed(), 0)
+	}
+	if _, err := f.Ancient(freezerHashTable, 4); err != nil {
+		t.Fatalf("failed to retrieve unchecksummed item: %v", err)
+	}
+	errc := make(chan error)
+	go func() { errc <- f.AppendAncient(10, nil, nil, nil, nil, nil) }()
+	select {
+	case err := <-errc:
+		t.Fatalf("append not waiting for checksum migration: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	f.Close()
+	if err := <-errc; err != errClosed {
+		t.Fatalf("append error mismatch: have %v, want %v", err, errClosed)
+	}
+	// Migrate the checksums, but drop some of them to simulate an interrupted
+	// migration which must be resumed on the next run
+	if f, err = newFreezer(dir, "", false); err != nil {
+		t.Fatalf("failed to reopen freezer: %v", err)
+	}
+	f.migrateChecksums()
+	f.Close()
+	for _, crc := range crcs {
+		if err := os.Truncate(crc, 6*checksumSize); err != nil {
+			t.Fatalf("failed to truncate checksums: %v", err)
+		}
+	}
+	// Reopen the freezer twice, the corruption must not be healed by the upgrade
+	for i := 0; i < 2; i++ {
+		if f, err = newFreezer(dir, "", false); err != nil {
+			t.Fatalf("failed to reopen freezer: %v", err)
+		}
+		if want := uint64(6 + 4*i); f.checksummed() != want {
+			t.Fatalf("resumed checksums mismatch: have %d, want %d", f.checksummed(), want)
+		}
+		f.migrateChecksums()
+		select {
+		case <-f.migrated:
+		default:
+			t.Fatalf("checksum migration not finished")
+		}
+		if f.checksummed() != 10 {
+			t.Fatalf("checksummed items mismatch: have %d, want %d", f.checksummed(), 10)
+		}
+		if _, err := f.Ancient(freezerHashTable, 4); err != errChecksumMismatch {
+			t.Fatalf("corrupted item error mismatch: have %v, want %v", err, errChecksumMismatch)
+		}
+		if ranges := VerifyAncients(f, 0, 9, nil); !reflect.DeepEqual(ranges, AncientRanges{{4, 4}}) {
+			t.Fatalf("corrupted range mismatch: have %v, want %v", ranges, AncientRanges{{4, 4}})
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// DatabaseTable is a category of data stored in the key-value database, as laid
+// out by the schema. It allows matching keys belonging to the category and
+// rendering their values in a human readable form.
+type DatabaseTable struct {
+	Name   string // Unique name of the table
+	Prefix []byte // Common prefix of all the keys in the table (may be empty)
+
+	match  func(key []byte) bool                   // Whether a key belongs to the table
+	decode func(key, value []byte) (string, error) // Human readable rendering of an entry
+}
+
+// Match returns whether the key belongs to the table.
+func (t *DatabaseTable) Match(key []byte) bool {
+	return bytes.HasPrefix(key, t.Prefix) && t.match(key)
+}
+
+// Decode renders a database entry of the table in a human readable form.
+func (t *DatabaseTable) Decode(key, value []byte) (string, error) {
+	if t.decode == nil {
+		return fmt.Sprintf("%#x", value), nil
+	}
+	return t.decode(key, value)
+}
+
+// keyLength creates a key matcher accepting keys of the given length.
+func keyLength(length int) func([]byte) bool {
+	return func(key []byte) bool { return len(key) == length }
+}
+
+// metadataKeys are the singleton keys tracking database metadata.
+var metadataKeys = [][]byte{
+	databaseVerisionKey, headHeaderKey, headBlockKey, headFastBlockKey, fastTrieProgressKey,
+	snapshotRootKey, snapshotJournalKey, snapshotGeneratorKey,
+}
+
+// databaseTables is the list of all known key-value data categories. The order
+// is relevant, keys are classified by the first table matching them.
+var databaseTables = []*DatabaseTable{
+	{
+		Name: "metadata",
+		match: func(key []byte) bool {
+			for _, meta := range metadataKeys {
+				if bytes.Equal(key, meta) {
+					return true
+				}
+			}
+			return false
+		},
+		decode: decodeMetadata,
+	},
+	{
+		Name:   "trie-nodes",
+		match:  keyLength(common.HashLength),
+		decode: decodeTrieNode,
+	},
+	{
+		Name:   "headers",
+		Prefix: headerPrefix,
+		match:  keyLength(len(headerPrefix) + 8 + common.HashLength),
+		decode: func(key, value []byte) (string, error) {
+			return decodeJSON(value, new(types.Header))
+		},
+	},
+	{
+		Name:   "tds",
+		Prefix: headerPrefix,
+		match: func(key []byte) bool {
+			return len(key) == len(headerPrefix)+8+common.HashLength+len(headerTDSuffix) && bytes.HasSuffix(key, headerTDSuffix)
+		},
+		decode: func(key, value []byte) (string, error) {
+			td := new(big.Int)
+			if err := rlp.DecodeBytes(value, td); err != nil {
+				return "", err
+			}
+			return td.String(), nil
+		},
+	},
+	{
+		Name:   "canonical-hashes",
+		Prefix: headerPrefix,
+		match: func(key []byte) bool {
+			return len(key) == len(headerPrefix)+8+len(headerHashSuffix) && bytes.HasSuffix(key, headerHashSuffix)
+		},
+		decode: func(key, value []byte) (string, error) {
+			number := binary.BigEndian.Uint64(key[len(headerPrefix):])
+			return fmt.Sprintf("#%d: %#x", number, value), nil
+		},
+	},
+	{
+		Name:   "header-numbers",
+		Prefix: headerNumberPrefix,
+		match:  keyLength(len(headerNumberPrefix) + common.HashLength),
+		decode: func(key, value []byte) (string, error) {
+			if len(value) != 8 {
+				return "", fmt.Errorf("invalid number length %d", len(value))
+			}
+			return fmt.Sprintf("#%d", binary.BigEndian.Uint64(value)), nil
+		},
+	},
+	{
+		Name:   "bodies",
+		Prefix: blockBodyPrefix,
+		match:  keyLength(len(blockBodyPrefix) + 8 + common.HashLength),
+		decode: func(key, value []byte) (string, error) {
+			return decodeJSON(value, new(types.Body))
+		},
+	},
+	{
+		Name:   "receipts",
+		Prefix: blockReceiptsPrefix,
+		match:  keyLength(len(blockReceiptsPrefix) + 8 + common.HashLength),
+		decode: func(key, value []byte) (string, error) {
+			var storageReceipts []*types.ReceiptForStorage
+			if err := rlp.DecodeBytes(value, &storageReceipts); err != nil {
+				return "", err
+			}
+			receipts := make([]*types.Receipt, len(storageReceipts))
+			for i, receipt := range storageReceipts {
+				receipts[i] = (*types.Receipt)(receipt)
+			}
+			return marshalJSON(receipts)
+		},
+	},
+	{
+		Name:   "tx-lookups",
+		Prefix: txLookupPrefix,
+		match:  keyLength(len(txLookupPrefix) + common.HashLength),
+		decode: decodeTxLookup,
+	},
+	{
+		Name:   "bloombits",
+		Prefix: bloomBitsPrefix,
+		match:  keyLength(len(bloomBitsPrefix) + 2 + 8 + common.HashLength),
+		decode: func(key, value []byte) (string, error) {
+			var (
+				bit     = binary.BigEndian.Uint16(key[len(bloomBitsPrefix):])
+				section = binary.BigEndian.Uint64(key[len(bloomBitsPrefix)+2:])
+			)
+			return fmt.Sprintf("bit %d, section %d: %#x", bit, section, value), nil
+		},
+	},
+	{
+		Name:   "snapshot-accounts",
+		Prefix: SnapshotAccountPrefix,
+		match:  keyLength(len(SnapshotAccountPrefix) + common.HashLength),
+		decode: func(key, value []byte) (string, error) {
+			var account struct {
+				Nonce    uint64
+				Balance  *big.Int
+				Root     hexBytes
+				CodeHash hexBytes
+			}
+			return decodeJSON(value, &account)
+		},
+	},
+	{
+		Name:   "snapshot-storage",
+		Prefix: SnapshotStoragePrefix,
+		match:  keyLength(len(SnapshotStoragePrefix) + 2*common.HashLength),
+	},
+	{
+		Name:   "preimages",
+		Prefix: preimagePrefix,
+		match:  keyLength(len(preimagePrefix) + common.HashLength),
+	},
+	{
+		Name:   "configs",
+		Prefix: configPrefix,
+		match:  keyLength(len(configPrefix) + common.HashLength),
+		decode: func(key, value []byte) (string, error) {
+			return string(value), nil
+		},
+	},
+	{
+		Name:   "chain-indexes",
+		Prefix: []byte("i"),
+		match:  func(key []byte) bool { return true },
+	},
+}
+
+// DatabaseTables returns the names of all the known key-value data categories.
+func DatabaseTables() []string {
+	names := make([]string, 0, len(databaseTables))
+	for _, table := range databaseTables {
+		names = append(names, table.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FindDatabaseTable retrieves a key-value data category by name.
+func FindDatabaseTable(name string) (*DatabaseTable, error) {
+	for _, table := range databaseTables {
+		if table.Name == name {
+			return table, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown table %q, available: %s", name, strings.Join(DatabaseTables(), ", "))
+}
+
+// ClassifyKey returns the key-value data category a key belongs to, or nil if
+// the key is unknown to the schema.
+func ClassifyKey(key []byte) *DatabaseTable {
+	for _, table := range databaseTables {
+		if table.Match(key) {
+			return table
+		}
+	}
+	return nil
+}
+
+// hexBytes is a byte slice marshalled into JSON as a 0x prefixed hex string.
+type hexBytes []byte
+
+// MarshalText implements encoding.TextMarshaler.
+func (b hexBytes) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%#x", []byte(b))), nil
+}
+
+// decodeJSON RLP decodes a value into the given object and renders it as JSON.
+func decodeJSON(value []byte, obj interface{}) (string, error) {
+	if err := rlp.DecodeBytes(value, obj); err != nil {
+		return "", err
+	}
+	return marshalJSON(obj)
+}
+
+// marshalJSON renders an object as indented JSON.
+func marshalJSON(obj interface{}) (string, error) {
+	out, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// decodeMetadata renders the value of a database metadata entry.
+func decodeMetadata(key, value []byte) (string, error) {
+	switch {
+	case bytes.Equal(key, databaseVerisionKey):
+		var version uint64
+		if err := rlp.DecodeBytes(value, &version); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("version %d", version), nil
+
+	case bytes.Equal(key, fastTrieProgressKey):
+		return fmt.Sprintf("%d trie entries", new(big.Int).SetBytes(value)), nil
+
+	case len(value) == common.HashLength:
+		return fmt.Sprintf("%#x", value), nil
+
+	default:
+		return fmt.Sprintf("%d bytes: %#x", len(value), value), nil
+	}
+}
+
+// decodeTxLookup renders a transaction lookup entry in any of its historical
+// formats.
+func decodeTxLookup(key, value []byte) (string, error) {
+	switch {
+	case len(value) < common.HashLength:
+		return fmt.Sprintf("block #%d", new(big.Int).SetBytes(value)), nil
+
+	case len(value) == common.HashLength:
+		return fmt.Sprintf("block %#x", value), nil
+
+	default:
+		var entry LegacyTxLookupEntry
+		if err := rlp.DecodeBytes(value, &entry); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("block #%d (%#x), index %d", entry.BlockIndex, entry.BlockHash, entry.Index), nil
+	}
+}
+
+// decodeTrieNode renders a trie node stored under its hash. Since contract code
+// is stored under the same key scheme, anything not decoding as a node is shown
+// as raw data.
+func decodeTrieNode(key, value []byte) (string, error) {
+	elems, rest, err := rlp.SplitList(value)
+	if err != nil || len(rest) != 0 {
+		return fmt.Sprintf("code or unknown data: %#x", value), nil
+	}
+	count, err := rlp.CountValues(elems)
+	if err != nil {
+		return fmt.Sprintf("code or unknown data: %#x", value), nil
+	}
+	var items []string
+	for len(elems) > 0 {
+		kind, content, rest, err := rlp.Split(elems)
+		if err != nil {
+			return fmt.Sprintf("code or unknown data: %#x", value), nil
+		}
+		switch {
+		case kind == rlp.List:
+			items = append(items, fmt.Sprintf("embedded(%#x)", elems[:len(elems)-len(rest)]))
+		case len(content) == 0:
+			items = append(items, "nil")
+		default:
+			items = append(items, fmt.Sprintf("%#x", content))
+		}
+		elems = rest
+	}
+	switch count {
+	case 17:
+		return fmt.Sprintf("full node: children [%s], value %s", strings.Join(items[:16], " "), items[16]), nil
+	case 2:
+		return fmt.Sprintf("short node: key %s, value %s", items[0], items[1]), nil
+	default:
+		return fmt.Sprintf("code or unknown data: %#x", value), nil
+	}
+}
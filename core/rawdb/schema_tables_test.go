@@ -0,0 +1,93 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Tests that database keys are classified into the correct schema tables.
+func TestClassifyKey(t *testing.T) {
+	hash := common.HexToHash("0xdeadbeef")
+	tests := []struct {
+		key   []byte
+		table string
+	}{
+		{headHeaderKey, "metadata"},
+		{hash.Bytes(), "trie-nodes"},
+		{headerKey(1, hash), "headers"},
+		{headerTDKey(1, hash), "tds"},
+		{headerHashKey(1), "canonical-hashes"},
+		{headerNumberKey(hash), "header-numbers"},
+		{blockBodyKey(1, hash), "bodies"},
+		{blockReceiptsKey(1, hash), "receipts"},
+		{txLookupKey(hash), "tx-lookups"},
+		{bloomBitsKey(1, 2, hash), "bloombits"},
+		{accountSnapshotKey(hash), "snapshot-accounts"},
+		{storageSnapshotKey(hash, hash), "snapshot-storage"},
+		{preimageKey(hash), "preimages"},
+		{configKey(hash), "configs"},
+		{append(BloomBitsIndexPrefix, []byte("count")...), "chain-indexes"},
+		{[]byte("unknown-key"), ""},
+	}
+	for i, tt := range tests {
+		table := ClassifyKey(tt.key)
+		switch {
+		case table == nil && tt.table != "":
+			t.Errorf("test %d: key %x unclassified, want %s", i, tt.key, tt.table)
+		case table != nil && table.Name != tt.table:
+			t.Errorf("test %d: key %x classified as %s, want %q", i, tt.key, table.Name, tt.table)
+		}
+	}
+}
+
+// Tests that database entries are decoded into human readable forms.
+func TestDecodeDatabaseTable(t *testing.T) {
+	header := &types.Header{Number: big.NewInt(314), Difficulty: big.NewInt(1), Extra: []byte("test")}
+	blob, _ := rlp.EncodeToBytes(header)
+
+	table, err := FindDatabaseTable("headers")
+	if err != nil {
+		t.Fatalf("failed to find table: %v", err)
+	}
+	out, err := table.Decode(headerKey(314, header.Hash()), blob)
+	if err != nil {
+		t.Fatalf("failed to decode header: %v", err)
+	}
+	if !strings.Contains(out, `"number": "0x13a"`) {
+		t.Errorf("decoded header missing number: %s", out)
+	}
+	// Trie nodes should be recognized, anything else rendered raw
+	table, _ = FindDatabaseTable("trie-nodes")
+
+	node, _ := rlp.EncodeToBytes([][]byte{{0x20, 0x01}, {0x02}})
+	if out, _ := table.Decode(common.Hash{}.Bytes(), node); !strings.HasPrefix(out, "short node") {
+		t.Errorf("short node not recognized: %s", out)
+	}
+	if out, _ := table.Decode(common.Hash{}.Bytes(), []byte{0x60, 0x80}); !strings.HasPrefix(out, "code") {
+		t.Errorf("code not recognized: %s", out)
+	}
+	if _, err := FindDatabaseTable("nonexistent"); err == nil {
+		t.Errorf("unknown table found")
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// accessList tracks the accounts and storage slots accessed during the execution
+// of a transaction, as defined by EIP-2929. Accesses to items not yet in the list
+// are charged the cold access costs.
+type accessList struct {
+	addresses map[common.Address]int     // Accessed addresses, mapped to the index of their slot set, or -1
+	slots     []map[common.Hash]struct{} // Accessed storage slots, per address
+}
+
+// newAccessList creates an empty access list.
+func newAccessList() *accessList {
+	return &accessList{
+		addresses: make(map[common.Address]int),
+	}
+}
+
+// ContainsAddress returns true if the address is in the access list.
+func (al *accessList) ContainsAddress(address common.Address) bool {
+	_, ok := al.addresses[address]
+	return ok
+}
+
+// Contains checks if a slot within an account is present in the access list,
+// returning separate flags for the presence of the account and the slot.
+func (al *accessList) Contains(address common.Address, slot common.Hash) (addressPresent bool, slotPresent bool) {
+	idx, ok := al.addresses[address]
+	if !ok {
+		return false, false
+	}
+	if idx == -1 {
+		return true, false
+	}
+	_, slotPresent = al.slots[idx][slot]
+	return true, slotPresent
+}
+
+// Copy creates an independent copy of the access list.
+func (al *accessList) Copy() *accessList {
+	cpy := &accessList{
+		addresses: make(map[common.Address]int, len(al.addresses)),
+		slots:     make([]map[common.Hash]struct{}, len(al.slots)),
+	}
+	for addr, idx := range al.addresses {
+		cpy.addresses[addr] = idx
+	}
+	for i, slots := range al.slots {
+		cpy.slots[i] = make(map[common.Hash]struct{}, len(slots))
+		for slot := range slots {
+			cpy.slots[i][slot] = struct{}{}
+		}
+	}
+	return cpy
+}
+
+// AddAddress adds an address to the access list, returning true if the address
+// was not present before.
+func (al *accessList) AddAddress(address common.Address) bool {
+	if _, present := al.addresses[address]; present {
+		return false
+	}
+	al.addresses[address] = -1
+	return true
+}
+
+// AddSlot adds the specified (address, slot) combination to the access list,
+// returning whether the address and the slot were added respectively.
+func (al *accessList) AddSlot(address common.Address, slot common.Hash) (addrChange bool, slotChange bool) {
+	idx, addrPresent := al.addresses[address]
+	if !addrPresent || idx == -1 {
+		// Address not present, or address present but no slots there
+		al.addresses[address] = len(al.slots)
+		al.slots = append(al.slots, map[common.Hash]struct{}{slot: {}})
+		return !addrPresent, true
+	}
+	// There is already an (address, slot) mapping
+	if _, ok := al.slots[idx][slot]; ok {
+		return false, false
+	}
+	al.slots[idx][slot] = struct{}{}
+	return false, true
+}
+
+// DeleteSlot removes an (address, slot) tuple from the access list. This
+// operation needs to be performed in the same order as the addition happened,
+// it is only meant to be used by the journal.
+func (al *accessList) DeleteSlot(address common.Address, slot common.Hash) {
+	idx, addrOk := al.addresses[address]
+	if !addrOk {
+		panic("reverting slot change, address not present in list")
+	}
+	slots := al.slots[idx]
+	delete(slots, slot)
+	// If that was the last (first) slot, remove it. Since additions and
+	// removals happen in order, we can be sure it's the last one.
+	if len(slots) == 0 {
+		al.slots = al.slots[:idx]
+		al.addresses[address] = -1
+	}
+}
+
+// DeleteAddress removes an address from the access list. This operation needs
+// to be performed in the same order as the addition happened, it is only meant
+// to be used by the journal.
+func (al *accessList) DeleteAddress(address common.Address) {
+	delete(al.addresses, address)
+}
@@ -21,10 +21,23 @@ import (
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/trie"
 )
 
+// DumpConfig is a set of options to control what portions of the state will be
+// iterated and collected.
+type DumpConfig struct {
+	SkipCode          bool
+	SkipStorage       bool
+	OnlyWithAddresses bool
+	Start             []byte
+	Max               uint64
+}
+
+// DumpAccount represents an account in the state, as collected by RawDump.
 type DumpAccount struct {
 	Balance  string            `json:"balance"`
 	Nonce    uint64            `json:"nonce"`
@@ -34,48 +47,241 @@ type DumpAccount struct {
 	Storage  map[string]string `json:"storage"`
 }
 
+// Dump represents the full dump in a collected format, as one large map.
 type Dump struct {
 	Root     string                 `json:"root"`
 	Accounts map[string]DumpAccount `json:"accounts"`
 }
 
-func (self *StateDB) RawDump() Dump {
-	dump := Dump{
-		Root:     fmt.Sprintf("%x", self.trie.Hash()),
-		Accounts: make(map[string]DumpAccount),
+// DumpEntry represents an account in the state, as produced by the iterative
+// dumps. Unlike DumpAccount, keys are 0x-prefixed, storage values are the slot
+// contents without RLP encoding and empty code and storage are omitted.
+type DumpEntry struct {
+	Balance   string            `json:"balance"`
+	Nonce     uint64            `json:"nonce"`
+	Root      string            `json:"root"`
+	CodeHash  string            `json:"codeHash"`
+	Code      string            `json:"code,omitempty"`
+	Storage   map[string]string `json:"storage,omitempty"`
+	Address   *common.Address   `json:"address,omitempty"` // Address only present in iterative (line-by-line) mode
+	SecureKey hexutil.Bytes     `json:"key,omitempty"`     // If we don't have address, we can output the key
+}
+
+// iterativeDump is a 'collector'-implementation which dump output line-by-line iteratively.
+type iterativeDump struct {
+	*json.Encoder
+}
+
+// IteratorDump is an implementation for iterating over data.
+type IteratorDump struct {
+	Root     string               `json:"root"`
+	Accounts map[string]DumpEntry `json:"accounts"`
+	Next     hexutil.Bytes        `json:"next,omitempty"` // nil if no more accounts
+}
+
+// dumpAccount is an account visited during state iteration, before it is
+// converted into one of the output formats.
+type dumpAccount struct {
+	addr      *common.Address // nil if the address preimage is missing
+	secureKey []byte
+	data      Account
+	code      []byte
+	storage   []dumpSlot // nil if storage is skipped
+}
+
+// dumpSlot is a storage slot visited during state iteration.
+type dumpSlot struct {
+	key       *common.Hash // nil if the slot preimage is missing
+	secureKey []byte
+	value     []byte // RLP encoded slot content
+}
+
+// collector interface which the state trie calls during iteration
+type collector interface {
+	onRoot(common.Hash)
+	onAccount(*dumpAccount)
+}
+
+func (d *Dump) onRoot(root common.Hash) {
+	d.Root = fmt.Sprintf("%x", root)
+}
+
+// onAccount adds an account in the legacy format. Entries without a preimage
+// are keyed by their hashed key, so they don't collide with each other or with
+// the zero address.
+func (d *Dump) onAccount(acc *dumpAccount) {
+	account := DumpAccount{
+		Balance:  acc.data.Balance.String(),
+		Nonce:    acc.data.Nonce,
+		Root:     common.Bytes2Hex(acc.data.Root[:]),
+		CodeHash: common.Bytes2Hex(acc.data.CodeHash),
+		Code:     common.Bytes2Hex(acc.code),
+		Storage:  make(map[string]string, len(acc.storage)),
+	}
+	for _, slot := range acc.storage {
+		key := fmt.Sprintf("pre(%x)", slot.secureKey)
+		if slot.key != nil {
+			key = common.Bytes2Hex(slot.key[:])
+		}
+		account.Storage[key] = common.Bytes2Hex(slot.value)
+	}
+	key := fmt.Sprintf("pre(%x)", acc.secureKey)
+	if acc.addr != nil {
+		key = common.Bytes2Hex(acc.addr[:])
+	}
+	d.Accounts[key] = account
+}
+
+func (d *IteratorDump) onRoot(root common.Hash) {
+	d.Root = fmt.Sprintf("%x", root)
+}
+
+func (d *IteratorDump) onAccount(acc *dumpAccount) {
+	key := fmt.Sprintf("pre(%#x)", acc.secureKey)
+	if acc.addr != nil {
+		key = hexutil.Encode(acc.addr[:])
+	}
+	d.Accounts[key] = newDumpEntry(acc)
+}
+
+func (d iterativeDump) onAccount(acc *dumpAccount) {
+	entry := newDumpEntry(acc)
+	entry.Address = acc.addr
+	d.Encode(entry)
+}
+
+func (d iterativeDump) onRoot(root common.Hash) {
+	d.Encode(struct {
+		Root common.Hash `json:"root"`
+	}{root})
+}
+
+// newDumpEntry converts a visited account into the format of the iterative dumps.
+func newDumpEntry(acc *dumpAccount) DumpEntry {
+	entry := DumpEntry{
+		Balance:  acc.data.Balance.String(),
+		Nonce:    acc.data.Nonce,
+		Root:     common.Bytes2Hex(acc.data.Root[:]),
+		CodeHash: common.Bytes2Hex(acc.data.CodeHash),
+		Code:     common.Bytes2Hex(acc.code),
+	}
+	if acc.addr == nil {
+		entry.SecureKey = acc.secureKey
 	}
+	if acc.storage != nil {
+		entry.Storage = make(map[string]string, len(acc.storage))
+		for _, slot := range acc.storage {
+			_, content, _, err := rlp.Split(slot.value)
+			if err != nil {
+				log.Error("Failed to decode the value returned by iterator", "error", err)
+				continue
+			}
+			key := fmt.Sprintf("pre(%#x)", slot.secureKey)
+			if slot.key != nil {
+				key = slot.key.Hex()
+			}
+			entry.Storage[key] = common.Bytes2Hex(content)
+		}
+	}
+	return entry
+}
 
-	it := trie.NewIterator(self.trie.NodeIterator(nil))
+// dump iterates over the account trie starting at the configured key, feeding
+// every account into the collector. If a maximum is configured, the iteration
+// stops after that many accounts and the key of the next account is returned,
+// which may be used to resume the dump.
+func (self *StateDB) dump(c collector, conf *DumpConfig) (nextKey []byte) {
+	if conf == nil {
+		conf = new(DumpConfig)
+	}
+	var (
+		missingPreimages int
+		accounts         uint64
+	)
+	c.onRoot(self.trie.Hash())
+
+	it := trie.NewIterator(self.trie.NodeIterator(conf.Start))
 	for it.Next() {
-		addr := self.trie.GetKey(it.Key)
 		var data Account
 		if err := rlp.DecodeBytes(it.Value, &data); err != nil {
 			panic(err)
 		}
-
-		obj := newObject(nil, common.BytesToAddress(addr), data)
-		account := DumpAccount{
-			Balance:  data.Balance.String(),
-			Nonce:    data.Nonce,
-			Root:     common.Bytes2Hex(data.Root[:]),
-			CodeHash: common.Bytes2Hex(data.CodeHash),
-			Code:     common.Bytes2Hex(obj.Code(self.db)),
-			Storage:  make(map[string]string),
+		account := &dumpAccount{secureKey: it.Key, data: data}
+		addrBytes := self.trie.GetKey(it.Key)
+		if addrBytes == nil {
+			// Preimage missing
+			missingPreimages++
+			if conf.OnlyWithAddresses {
+				continue
+			}
+		} else {
+			addr := common.BytesToAddress(addrBytes)
+			account.addr = &addr
 		}
-		storageIt := trie.NewIterator(obj.getTrie(self.db).NodeIterator(nil))
-		for storageIt.Next() {
-			account.Storage[common.Bytes2Hex(self.trie.GetKey(storageIt.Key))] = common.Bytes2Hex(storageIt.Value)
+		obj := newObject(nil, common.BytesToAddress(addrBytes), data)
+		if !conf.SkipCode {
+			account.code = obj.Code(self.db)
 		}
-		dump.Accounts[common.Bytes2Hex(addr)] = account
+		if !conf.SkipStorage {
+			account.storage = []dumpSlot{}
+			storageIt := trie.NewIterator(obj.getTrie(self.db).NodeIterator(nil))
+			for storageIt.Next() {
+				slot := dumpSlot{secureKey: storageIt.Key, value: storageIt.Value}
+				if preimage := self.trie.GetKey(storageIt.Key); preimage != nil {
+					key := common.BytesToHash(preimage)
+					slot.key = &key
+				} else {
+					missingPreimages++
+				}
+				account.storage = append(account.storage, slot)
+			}
+		}
+		c.onAccount(account)
+		accounts++
+		if conf.Max > 0 && accounts >= conf.Max {
+			if it.Next() {
+				nextKey = it.Key
+			}
+			break
+		}
+	}
+	if missingPreimages > 0 {
+		log.Warn("Dump incomplete due to missing preimages", "missing", missingPreimages)
+	}
+	return nextKey
+}
+
+// RawDump returns the entire state an a single large object.
+func (self *StateDB) RawDump(opts *DumpConfig) Dump {
+	dump := &Dump{
+		Accounts: make(map[string]DumpAccount),
 	}
-	return dump
+	self.dump(dump, opts)
+	return *dump
 }
 
-func (self *StateDB) Dump() []byte {
-	json, err := json.MarshalIndent(self.RawDump(), "", "    ")
+// Dump returns a JSON string representing the entire state as a single json-object.
+func (self *StateDB) Dump(opts *DumpConfig) []byte {
+	dump := self.RawDump(opts)
+	json, err := json.MarshalIndent(dump, "", "    ")
 	if err != nil {
 		fmt.Println("dump err", err)
 	}
-
 	return json
 }
+
+// IterativeDump streams out accounts as json-objects, delimited by linebreaks,
+// into the given encoder. The returned key, if non-nil, can be used as the start
+// of a subsequent dump to resume where this one stopped.
+func (self *StateDB) IterativeDump(opts *DumpConfig, output *json.Encoder) []byte {
+	return self.dump(iterativeDump{output}, opts)
+}
+
+// IteratorDump dumps out a batch of accounts starting with the configured key.
+func (self *StateDB) IteratorDump(opts *DumpConfig) IteratorDump {
+	iterator := &IteratorDump{
+		Accounts: make(map[string]DumpEntry),
+	}
+	iterator.Next = self.dump(iterator, opts)
+	return *iterator
+}
@@ -0,0 +1,440 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	// stateBloomFilePrefix is the filename prefix of state bloom filter.
+	stateBloomFilePrefix = "statebloom"
+
+	// stateBloomFileSuffix is the filename suffix of state bloom filter.
+	stateBloomFileSuffix = "bf.gz"
+
+	// stateBloomFileTempSuffix is the filename suffix of state bloom filter
+	// while it is being written out to detect write aborts.
+	stateBloomFileTempSuffix = ".tmp"
+
+	// rangeCompactionThreshold is the minimal deleted entry number for
+	// triggering range compaction. It's a quite arbitrary number but just
+	// to avoid triggering range compaction because of small deletion.
+	rangeCompactionThreshold = 100000
+
+	// retainedBlocks is the distance from the chain head of the default pruning
+	// target. The chain keeps the state of the last 128 blocks in memory, from
+	// which it flushes the HEAD-127 one to disk on shutdown.
+	retainedBlocks = 127
+)
+
+var (
+	// errMissingHead is returned if the database doesn't contain a head block
+	// which would be needed to pick the default pruning target.
+	errMissingHead = errors.New("missing head block")
+
+	// errMissingGenesis is returned if the genesis block cannot be found in
+	// the database.
+	errMissingGenesis = errors.New("missing genesis block")
+
+	// errPruningAborted is returned if a state traversal is interrupted because
+	// the pruner is being shut down.
+	errPruningAborted = errors.New("pruning aborted")
+)
+
+// Pruner is an offline tool to prune the stale state with the help of a state
+// bloom filter. The state bloom is populated with all the trie nodes and code
+// hashes reachable from the target state root (and the genesis state), after
+// which every other trie node or contract code is deleted from the database.
+//
+// The pruning procedure is crash safe: the bloom filter is persisted to disk
+// before anything is deleted, and it's only removed once the pruning finishes.
+// If the process is interrupted, RecoverPruning can pick up where it left off.
+type Pruner struct {
+	db            ethdb.Database
+	stateBloom    *stateBloom
+	datadir       string
+	trieCachePath string
+}
+
+// NewPruner creates the pruner instance. The bloom filter size is specified in
+// megabytes; the larger the filter, the fewer stale entries are retained due to
+// false positives. The clean trie cache journal at trieCachePath (if any) is
+// deleted, since it may contain nodes which are pruned away.
+func NewPruner(db ethdb.Database, datadir, trieCachePath string, bloomSize uint64) (*Pruner, error) {
+	// Sanitize the bloom filter size if it's too small.
+	if bloomSize < 256 {
+		log.Warn("Sanitizing bloomfilter size", "provided(MB)", bloomSize, "updated(MB)", 256)
+		bloomSize = 256
+	}
+	stateBloom, err := newStateBloomWithSize(bloomSize)
+	if err != nil {
+		return nil, err
+	}
+	return &Pruner{
+		db:            db,
+		stateBloom:    stateBloom,
+		datadir:       datadir,
+		trieCachePath: trieCachePath,
+	}, nil
+}
+
+// Prune deletes all historical state nodes except the nodes belong to the
+// specified state version. If the user doesn't specify the state version,
+// the state of HEAD-127 is used, which is the one persisted on shutdown.
+//
+// Note, after pruning the chain head state is gone, so on the next start the
+// chain will be rewound to the pruning target and re-execute the blocks above.
+func (p *Pruner) Prune(root common.Hash) error {
+	// If the target state root is not specified, use the HEAD-127 as the
+	// target. The reason for picking it is it's the state persisted to disk
+	// during the last shutdown.
+	if root == (common.Hash{}) {
+		target, err := defaultTarget(p.db)
+		if err != nil {
+			return err
+		}
+		root = target
+	}
+	// Ensure the target state is actually available before doing anything,
+	// otherwise we'd wipe the entire state.
+	if blob, err := p.db.Get(root.Bytes()); err != nil || len(blob) == 0 {
+		return fmt.Errorf("associated state[%x] is not present", root)
+	}
+	// If the state bloom filter is already committed previously, reuse it for
+	// pruning instead of generating a new one. It's mandatory because a part of
+	// state may already be deleted, the recovery procedure is necessary.
+	if _, stateBloomRoot, err := findBloomFilter(p.datadir); err != nil {
+		return err
+	} else if stateBloomRoot != (common.Hash{}) {
+		return RecoverPruning(p.datadir, p.db, p.trieCachePath)
+	}
+	log.Info("Selecting state to retain", "root", root)
+
+	// Traverse the target state, re-construct the whole state trie and commit
+	// all the nodes and code hashes into the state bloom. The genesis state is
+	// retained too, since it's needed during database initialization.
+	start := time.Now()
+	if err := commitState(p.db, root, p.stateBloom, nil); err != nil {
+		return err
+	}
+	genesis, err := genesisRoot(p.db)
+	if err != nil {
+		return err
+	}
+	if genesis != root {
+		if err := commitState(p.db, genesis, p.stateBloom, nil); err != nil {
+			return err
+		}
+	}
+	filterName := bloomFilterName(p.datadir, root)
+
+	log.Info("Writing state bloom to disk", "name", filterName)
+	if err := p.stateBloom.Commit(filterName, filterName+stateBloomFileTempSuffix); err != nil {
+		return err
+	}
+	log.Info("State bloom filter committed", "name", filterName)
+	return prune(p.db, p.stateBloom, filterName, p.trieCachePath, start)
+}
+
+// RecoverPruning will resume the pruning procedure during the system restart.
+// This function is used in this case: user tries to prune state data, but the
+// system was interrupted midway because of crash or manual-kill. In this case
+// if the bloom filter for filtering active state is already constructed, the
+// pruning can be resumed. What's more if the bloom filter is constructed, the
+// pruning **has to be resumed**. Otherwise a lot of dangling nodes may be left
+// in the disk.
+//
+// If no committed state bloom is found, no pruning was interrupted and the
+// function returns without touching the database.
+func RecoverPruning(datadir string, db ethdb.Database, trieCachePath string) error {
+	stateBloomPath, stateBloomRoot, err := findBloomFilter(datadir)
+	if err != nil {
+		return err
+	}
+	if stateBloomPath == "" {
+		return nil // nothing to recover
+	}
+	stateBloom, err := newStateBloomFromDisk(stateBloomPath)
+	if err != nil {
+		return err
+	}
+	log.Info("Loaded state bloom filter", "path", stateBloomPath, "root", stateBloomRoot)
+	return prune(db, stateBloom, stateBloomPath, trieCachePath, time.Now())
+}
+
+// prune iterates over the entire database and deletes all the trie nodes and
+// contract codes not contained in the state bloom. Once done, the bloom filter
+// file is removed to mark the pruning finished and the database is compacted.
+func prune(db ethdb.Database, stateBloom *stateBloom, bloomPath, trieCachePath string, start time.Time) error {
+	// The clean trie cache journal might contain nodes which are about to be
+	// deleted. Loading it on the next start would mask the missing state, so
+	// get rid of it before touching the database.
+	if err := deleteCleanTrieCache(trieCachePath); err != nil {
+		return err
+	}
+	// Delete all stale trie nodes in the disk. With the help of state bloom
+	// the trie nodes(and codes) belong to the active state will be filtered
+	// out. A very small part of stale tries will also be filtered because of
+	// the false-positive rate of bloom filter. But the assumption is held here
+	// that the false-positive is low enough(~0.05%). The probablity of the
+	// dangling node is the state root is super low. So the dangling nodes in
+	// disk don't matter.
+	var (
+		count  int
+		size   common.StorageSize
+		pstart = time.Now()
+		logged = time.Now()
+		batch  = db.NewBatch()
+		iter   = db.NewIterator()
+	)
+	for iter.Next() {
+		key := iter.Key()
+
+		// All state entries are keyed by their 32 byte hashes: trie nodes as
+		// well as contract codes. Anything else is left untouched.
+		if len(key) != common.HashLength || stateBloom.Contain(key) {
+			continue
+		}
+		count++
+		size += common.StorageSize(len(key) + len(iter.Value()))
+		batch.Delete(key)
+
+		if time.Since(logged) > 8*time.Second {
+			var eta time.Duration // Realistically will never remain uninited
+			if done := binary.BigEndian.Uint64(key[:8]); done > 0 {
+				var (
+					left  = math.MaxUint64 - done
+					speed = done/uint64(time.Since(pstart)/time.Millisecond+1) + 1
+				)
+				eta = time.Duration(left/speed) * time.Millisecond
+			}
+			log.Info("Pruning state data", "nodes", count, "size", size,
+				"elapsed", common.PrettyDuration(time.Since(pstart)), "eta", common.PrettyDuration(eta))
+			logged = time.Now()
+		}
+		// Recreate the iterator after every batch commit in order
+		// to allow the underlying compactor to delete the entries.
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				iter.Release()
+				return err
+			}
+			batch.Reset()
+
+			iter.Release()
+			iter = db.NewIteratorWithStart(key)
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if batch.ValueSize() > 0 {
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		batch.Reset()
+	}
+	log.Info("Pruned state data", "nodes", count, "size", size, "elapsed", common.PrettyDuration(time.Since(pstart)))
+
+	// Delete the state bloom, it marks the entire pruning procedure is
+	// finished. If any crashes or manual exit happens before this,
+	// `RecoverPruning` will pick it up in the next restarts to redo all
+	// the things.
+	if err := os.RemoveAll(bloomPath); err != nil {
+		return err
+	}
+	// Start compactions, will remove the deleted data from the disk immediately.
+	// Note for small pruning, the compaction is skipped.
+	if count >= rangeCompactionThreshold {
+		cstart := time.Now()
+		for b := 0x00; b <= 0xf0; b += 0x10 {
+			var (
+				start = []byte{byte(b)}
+				end   = []byte{byte(b + 0x10)}
+			)
+			if b == 0xf0 {
+				end = nil
+			}
+			log.Info("Compacting database", "range", fmt.Sprintf("%#x-%#x", start, end), "elapsed", common.PrettyDuration(time.Since(cstart)))
+			if err := db.Compact(start, end); err != nil {
+				log.Error("Database compaction failed", "error", err)
+				return err
+			}
+		}
+		log.Info("Database compaction finished", "elapsed", common.PrettyDuration(time.Since(cstart)))
+	}
+	log.Info("State pruning successful", "pruned", size, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// deleteCleanTrieCache deletes the clean trie cache journal, if it exists.
+func deleteCleanTrieCache(path string) error {
+	if path == "" {
+		return nil
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	log.Info("Deleted clean trie cache journal", "path", path)
+	return nil
+}
+
+// commitState iterates over the entire state identified by the given root and
+// inserts every trie node and contract code hash into the state bloom. The
+// traversal can be interrupted by closing the optional abort channel.
+func commitState(db ethdb.Database, root common.Hash, stateBloom *stateBloom, abort <-chan struct{}) error {
+	statedb, err := state.New(root, state.NewDatabase(db), nil)
+	if err != nil {
+		return err
+	}
+	var (
+		nodes  int
+		start  = time.Now()
+		logged = time.Now()
+		it     = state.NewNodeIterator(statedb)
+	)
+	for it.Next() {
+		// Embedded nodes don't have a hash of their own, they are stored as
+		// part of their parent and need no marking.
+		if it.Hash == (common.Hash{}) {
+			continue
+		}
+		stateBloom.Put(it.Hash.Bytes())
+		nodes++
+
+		if time.Since(logged) > 8*time.Second {
+			select {
+			case <-abort:
+				return errPruningAborted
+			default:
+			}
+			log.Info("Marking state entries to retain", "root", root, "entries", nodes, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if it.Error != nil {
+		return it.Error
+	}
+	log.Info("Marked state entries to retain", "root", root, "entries", nodes, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// defaultTarget returns the state root of the HEAD-127 block, which is the state
+// persisted to disk during the last shutdown.
+func defaultTarget(db ethdb.Database) (common.Hash, error) {
+	head := rawdb.ReadHeadBlockHash(db)
+	if head == (common.Hash{}) {
+		return common.Hash{}, errMissingHead
+	}
+	number := rawdb.ReadHeaderNumber(db, head)
+	if number == nil {
+		return common.Hash{}, errMissingHead
+	}
+	target := *number
+	if target > retainedBlocks {
+		target -= retainedBlocks
+	} else {
+		target = 0
+	}
+	header := rawdb.ReadHeader(db, rawdb.ReadCanonicalHash(db, target), target)
+	if header == nil {
+		return common.Hash{}, fmt.Errorf("missing header #%d", target)
+	}
+	return header.Root, nil
+}
+
+// genesisRoot returns the state root of the genesis block.
+func genesisRoot(db ethdb.Database) (common.Hash, error) {
+	header := rawdb.ReadHeader(db, rawdb.ReadCanonicalHash(db, 0), 0)
+	if header == nil {
+		return common.Hash{}, errMissingGenesis
+	}
+	return header.Root, nil
+}
+
+// bloomFilterName returns the path of the state bloom filter used to prune the
+// database down to the given state root.
+func bloomFilterName(datadir string, hash common.Hash) string {
+	return filepath.Join(datadir, fmt.Sprintf("%s.%s.%s", stateBloomFilePrefix, hash.Hex(), stateBloomFileSuffix))
+}
+
+// isBloomFilter checks whether the file is a state bloom filter and returns the
+// state root it was generated for.
+func isBloomFilter(filename string) (bool, common.Hash) {
+	filename = filepath.Base(filename)
+	if strings.HasPrefix(filename, stateBloomFilePrefix+".") && strings.HasSuffix(filename, "."+stateBloomFileSuffix) {
+		return true, common.HexToHash(filename[len(stateBloomFilePrefix)+1 : len(filename)-len(stateBloomFileSuffix)-1])
+	}
+	return false, common.Hash{}
+}
+
+// findBloomFilter searches the data directory for a committed state bloom
+// filter, cleaning up any partially written one along the way. Only the file
+// names in the directory are looked at.
+func findBloomFilter(datadir string) (string, common.Hash, error) {
+	files, err := ioutil.ReadDir(datadir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", common.Hash{}, nil
+		}
+		return "", common.Hash{}, err
+	}
+	var (
+		stateBloomPath string
+		stateBloomRoot common.Hash
+	)
+	for _, info := range files {
+		if info.IsDir() || !strings.HasPrefix(info.Name(), stateBloomFilePrefix+".") {
+			continue
+		}
+		path := filepath.Join(datadir, info.Name())
+
+		// Remove any leftover temporary filter, it was never completed
+		if strings.HasSuffix(path, stateBloomFileTempSuffix) {
+			if ok, _ := isBloomFilter(strings.TrimSuffix(path, stateBloomFileTempSuffix)); ok {
+				log.Warn("Deleting incomplete state bloom", "path", path)
+				if err := os.Remove(path); err != nil {
+					return "", common.Hash{}, err
+				}
+			}
+			continue
+		}
+		if ok, root := isBloomFilter(path); ok {
+			stateBloomPath, stateBloomRoot = path, root
+		}
+	}
+	return stateBloomPath, stateBloomRoot, nil
+}
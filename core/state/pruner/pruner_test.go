@@ -0,0 +1,163 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// makeTestStates creates a genesis state and two consecutive states on top of
+// it, all of them persisted into the database. The roots are returned in order.
+func makeTestStates(t *testing.T, db ethdb.Database) []common.Hash {
+	var (
+		sdb   = state.NewDatabase(db)
+		roots []common.Hash
+		root  common.Hash
+	)
+	for i := 0; i < 3; i++ {
+		statedb, _ := state.New(root, sdb, nil)
+		for j := 0; j < 10; j++ {
+			addr := common.BytesToAddress([]byte{byte(i), byte(j)})
+			statedb.SetBalance(addr, big.NewInt(int64(i*100+j)))
+			statedb.SetState(addr, common.Hash{byte(i)}, common.Hash{byte(j + 1)})
+			statedb.SetCode(addr, []byte{byte(i), byte(j), 0xff})
+		}
+		root, _ = statedb.Commit(false)
+		if err := sdb.TrieDB().Commit(root, false, nil); err != nil {
+			t.Fatalf("failed to commit state %d: %v", i, err)
+		}
+		roots = append(roots, root)
+	}
+	// Mark the first state as the genesis one
+	genesis := &types.Header{Number: big.NewInt(0), Root: roots[0]}
+	rawdb.WriteHeader(db, genesis)
+	rawdb.WriteCanonicalHash(db, genesis.Hash(), 0)
+
+	return roots
+}
+
+// checkState ensures the entire state identified by the root is available.
+func checkState(db ethdb.Database, root common.Hash) error {
+	statedb, err := state.New(root, state.NewDatabase(db), nil)
+	if err != nil {
+		return err
+	}
+	it := state.NewNodeIterator(statedb)
+	for it.Next() {
+	}
+	return it.Error
+}
+
+// Tests that pruning retains the target and the genesis states, while deleting
+// every other stale state entry.
+func TestPrune(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "pruner-")
+	if err != nil {
+		t.Fatalf("failed to create temporary datadir: %v", err)
+	}
+	defer os.RemoveAll(datadir)
+
+	db := rawdb.NewMemoryDatabase()
+	roots := makeTestStates(t, db)
+
+	// Leave a clean trie cache journal around, it must not survive pruning
+	journal := filepath.Join(datadir, "triecache")
+	if err := ioutil.WriteFile(journal, []byte{0x01}, 0644); err != nil {
+		t.Fatalf("failed to write trie cache journal: %v", err)
+	}
+	bloom, _ := newStateBloomWithSize(1)
+	pruner := &Pruner{db: db, stateBloom: bloom, datadir: datadir, trieCachePath: journal}
+	if err := pruner.Prune(roots[2]); err != nil {
+		t.Fatalf("failed to prune state: %v", err)
+	}
+	for i, root := range []common.Hash{roots[0], roots[2]} {
+		if err := checkState(db, root); err != nil {
+			t.Errorf("retained state %d incomplete: %v", i, err)
+		}
+	}
+	if ok, _ := db.Has(roots[1].Bytes()); ok {
+		t.Errorf("stale state root retained")
+	}
+	if path, _, _ := findBloomFilter(datadir); path != "" {
+		t.Errorf("state bloom not deleted after pruning: %s", path)
+	}
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Errorf("trie cache journal not deleted after pruning: %v", err)
+	}
+}
+
+// Tests that an interrupted pruning is resumed from the persisted state bloom.
+func TestRecoverPruning(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "pruner-")
+	if err != nil {
+		t.Fatalf("failed to create temporary datadir: %v", err)
+	}
+	defer os.RemoveAll(datadir)
+
+	db := rawdb.NewMemoryDatabase()
+	roots := makeTestStates(t, db)
+
+	// Nothing should happen if there's no pruning to resume, the database must
+	// not even be accessed (any access on the empty wrapper would panic)
+	if err := RecoverPruning(datadir, struct{ ethdb.Database }{}, ""); err != nil {
+		t.Fatalf("failed to recover without state bloom: %v", err)
+	}
+	// Simulate a crash right after the state bloom was committed
+	bloom, _ := newStateBloomWithSize(1)
+	if err := commitState(db, roots[2], bloom, nil); err != nil {
+		t.Fatalf("failed to mark state: %v", err)
+	}
+	if err := commitState(db, roots[0], bloom, nil); err != nil {
+		t.Fatalf("failed to mark state: %v", err)
+	}
+	name := bloomFilterName(datadir, roots[2])
+	if err := bloom.Commit(name, name+stateBloomFileTempSuffix); err != nil {
+		t.Fatalf("failed to commit state bloom: %v", err)
+	}
+	// Leave a partially written filter around too, it must be discarded
+	partial := bloomFilterName(datadir, roots[1]) + stateBloomFileTempSuffix
+	if err := ioutil.WriteFile(partial, []byte{0x01}, 0644); err != nil {
+		t.Fatalf("failed to write partial bloom: %v", err)
+	}
+	if err := RecoverPruning(datadir, db, ""); err != nil {
+		t.Fatalf("failed to recover pruning: %v", err)
+	}
+	for i, root := range []common.Hash{roots[0], roots[2]} {
+		if err := checkState(db, root); err != nil {
+			t.Errorf("retained state %d incomplete: %v", i, err)
+		}
+	}
+	if ok, _ := db.Has(roots[1].Bytes()); ok {
+		t.Errorf("stale state root retained")
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("state bloom not deleted after recovery: %v", err)
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("partial state bloom not deleted: %v", err)
+	}
+}
@@ -0,0 +1,437 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+const (
+	// retentionGenerations is the number of generations the retention window is
+	// split into. Stale state is pruned once every generation, so the state kept
+	// on disk spans between 1 and 1+1/retentionGenerations retention windows.
+	retentionGenerations = 4
+
+	// retentionBloomSize is the size in megabytes of the bloom filter used to mark
+	// the oldest retained state during a pruning cycle.
+	retentionBloomSize = 1024
+
+	// retentionNodesPerBlock is the estimated number of trie nodes written per
+	// block, used to size the bloom filters of the generations.
+	retentionNodesPerBlock = 4096
+
+	// retentionDeleteBatch is the number of stale entries collected before they
+	// are checked against the live generations and deleted.
+	retentionDeleteBatch = 10000
+
+	// retentionPruneRanges is the number of key ranges the state entries are
+	// split into. Every pruning cycle only iterates over one of them, so a full
+	// sweep of the database is spread across this many cycles.
+	retentionPruneRanges = 16
+)
+
+// generation tracks all the trie nodes and contract codes written to disk while
+// committing a range of blocks.
+//
+// Completed generations are persisted into the journal directory, the current
+// one only when the retainer is stopped. Its file doubles as a marker of a clean
+// shutdown: it's deleted once loaded, and if it's missing on startup, the entries
+// written since the last completed generation are unknown, so all the persisted
+// generations are discarded and a new retention window is recorded.
+type generation struct {
+	first uint64      // Number of the first block committed in this generation
+	bloom *stateBloom // Filter containing all the written state entries
+}
+
+// Retainer is an online pruner keeping the historical state of a configurable
+// number of recent blocks on disk, deleting the older state in the background.
+//
+// The state of every block is persisted, and all the written state entries are
+// recorded into generations of bloom filters. Any state entry not present in a
+// parent state is written when the child state is committed, so the state of
+// all the blocks in the retention window is made up of the state of its oldest
+// block and the entries written since. Once a full window has been recorded,
+// the oldest retained state is marked and every other state entry not present
+// in a live generation is deleted from the database.
+type Retainer struct {
+	db          ethdb.Database
+	journal     string // Directory to persist the generations into (empty = memory only)
+	retention   uint64 // Number of recent blocks to retain the state of
+	genBlocks   uint64 // Number of blocks covered by a single generation
+	bloomSize   uint64 // Size in megabytes of the bloom filter marking the oldest state
+	pruneRanges uint64 // Number of key ranges a full sweep of the database is split into
+
+	current *generation   // Generation recording the currently written entries
+	gens    []*generation // Completed generations, oldest first
+	pruning bool          // Whether a pruning cycle is currently running
+	lock    sync.Mutex    // Lock serializing state commits and deletions
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewRetainer creates an online state pruner retaining the historical state of
+// the given number of recent blocks. If a journal directory is given, the
+// generations persisted by a previous run are loaded from it.
+func NewRetainer(db ethdb.Database, retention uint64, journal string) *Retainer {
+	genBlocks := (retention + retentionGenerations - 1) / retentionGenerations
+	if genBlocks == 0 {
+		genBlocks = 1
+	}
+	r := &Retainer{
+		db:          db,
+		journal:     journal,
+		retention:   retention,
+		genBlocks:   genBlocks,
+		bloomSize:   retentionBloomSize,
+		pruneRanges: retentionPruneRanges,
+		quit:        make(chan struct{}),
+	}
+	if journal != "" {
+		if err := r.loadGenerations(); err != nil {
+			log.Warn("Discarded state retention journal", "path", journal, "err", err)
+			r.gens, r.current = nil, nil
+			r.wipeJournal()
+		}
+	}
+	return r
+}
+
+// Stop interrupts any running pruning cycle and waits for it to terminate, then
+// persists the current generation.
+func (r *Retainer) Stop() {
+	close(r.quit)
+	r.wg.Wait()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.journal != "" && r.current != nil {
+		if err := r.saveGeneration(r.current, true); err != nil {
+			log.Error("Failed to persist state retention generation", "err", err)
+		}
+	}
+}
+
+// Commit flushes the state trie of the given block to disk, recording every
+// written entry. If a new generation is started, a pruning cycle is scheduled
+// to delete the state falling out of the retention window.
+func (r *Retainer) Commit(triedb *trie.Database, root common.Hash, number uint64) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.current == nil {
+		gen, err := r.newGeneration(number)
+		if err != nil {
+			return err
+		}
+		r.current = gen
+	}
+	if err := triedb.Commit(root, false, func(hash common.Hash) { r.current.bloom.Put(hash.Bytes()) }); err != nil {
+		return err
+	}
+	if number < r.current.first+r.genBlocks {
+		return nil
+	}
+	// The current generation is full, persist it and start a new one
+	if r.journal != "" {
+		if err := r.saveGeneration(r.current, false); err != nil {
+			return err
+		}
+	}
+	gen, err := r.newGeneration(number + 1)
+	if err != nil {
+		return err
+	}
+	r.gens, r.current = append(r.gens, r.current), gen
+
+	// Schedule a pruning cycle if the entire window above the oldest retained
+	// state has been recorded. Generations fully below it are no longer needed.
+	if r.pruning || number <= r.retention {
+		return nil
+	}
+	target := number - r.retention
+	if r.gens[0].first > target {
+		return nil
+	}
+	for len(r.gens) > 1 && r.gens[1].first <= target {
+		if r.journal != "" {
+			os.Remove(generationFile(r.journal, r.gens[0].first, false))
+		}
+		r.gens = r.gens[1:]
+	}
+	r.pruning = true
+
+	r.wg.Add(1)
+	go r.prune(target)
+	return nil
+}
+
+// newGeneration creates an empty generation starting at the given block.
+func (r *Retainer) newGeneration(first uint64) (*generation, error) {
+	size := r.genBlocks * retentionNodesPerBlock / (1024 * 1024)
+	if size == 0 {
+		size = 1
+	}
+	bloom, err := newStateBloomWithSize(size)
+	if err != nil {
+		return nil, err
+	}
+	return &generation{first: first, bloom: bloom}, nil
+}
+
+// generationFile returns the path of the file a generation is persisted into.
+func generationFile(journal string, first uint64, current bool) string {
+	if current {
+		return filepath.Join(journal, fmt.Sprintf("%d.current", first))
+	}
+	return filepath.Join(journal, fmt.Sprintf("%d.bloom", first))
+}
+
+// saveGeneration persists a generation into the journal directory.
+func (r *Retainer) saveGeneration(gen *generation, current bool) error {
+	if err := os.MkdirAll(r.journal, 0755); err != nil {
+		return err
+	}
+	name := generationFile(r.journal, gen.first, current)
+	return gen.bloom.Commit(name, name+".tmp")
+}
+
+// loadGenerations loads the generations persisted by a previous run from the
+// journal directory. Nothing is loaded if the previous run wasn't shut down
+// cleanly, since the entries written by its last generation are lost.
+func (r *Retainer) loadGenerations() error {
+	files, err := ioutil.ReadDir(r.journal)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var (
+		firsts  []uint64
+		current = -1
+	)
+	for _, file := range files {
+		name := file.Name()
+		ext := filepath.Ext(name)
+		if ext != ".bloom" && ext != ".current" {
+			os.Remove(filepath.Join(r.journal, name)) // Leftover temporary file
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid generation file %s", name)
+		}
+		if ext == ".current" {
+			if current >= 0 {
+				return fmt.Errorf("duplicate current generation %s", name)
+			}
+			current = len(firsts)
+		}
+		firsts = append(firsts, first)
+	}
+	if len(firsts) == 0 {
+		return nil
+	}
+	if current < 0 {
+		return fmt.Errorf("unclean shutdown, %d generations without a current one", len(firsts))
+	}
+	for i, first := range firsts {
+		bloom, err := newStateBloomFromDisk(generationFile(r.journal, first, i == current))
+		if err != nil {
+			return err
+		}
+		if i == current {
+			r.current = &generation{first: first, bloom: bloom}
+		} else {
+			r.gens = append(r.gens, &generation{first: first, bloom: bloom})
+		}
+	}
+	sort.Slice(r.gens, func(i, j int) bool { return r.gens[i].first < r.gens[j].first })
+	if n := len(r.gens); n > 0 && r.gens[n-1].first >= r.current.first {
+		return fmt.Errorf("generation %d not older than the current one %d", r.gens[n-1].first, r.current.first)
+	}
+	// The current generation is only persisted again on a clean shutdown
+	if err := os.Remove(generationFile(r.journal, r.current.first, true)); err != nil {
+		return err
+	}
+	log.Info("Loaded state retention generations", "count", len(r.gens)+1, "first", firsts[0])
+	return nil
+}
+
+// wipeJournal deletes all the persisted generations.
+func (r *Retainer) wipeJournal() {
+	files, _ := ioutil.ReadDir(r.journal)
+	for _, file := range files {
+		os.Remove(filepath.Join(r.journal, file.Name()))
+	}
+}
+
+// recorded reports whether a state entry was written by any live generation.
+// The caller must hold the lock.
+func (r *Retainer) recorded(key []byte) bool {
+	if r.current.bloom.Contain(key) {
+		return true
+	}
+	for _, gen := range r.gens {
+		if gen.bloom.Contain(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// prune marks the state of the given block along with the genesis state, and
+// deletes every other state entry not recorded by a live generation. Only the
+// entries in one of the key ranges are iterated over, rotating with the target.
+func (r *Retainer) prune(target uint64) {
+	defer r.wg.Done()
+	defer func() {
+		r.lock.Lock()
+		r.pruning = false
+		r.lock.Unlock()
+	}()
+
+	header := rawdb.ReadHeader(r.db, rawdb.ReadCanonicalHash(r.db, target), target)
+	if header == nil {
+		log.Warn("Skipping historical state pruning, missing header", "number", target)
+		return
+	}
+	genesis, err := genesisRoot(r.db)
+	if err != nil {
+		log.Warn("Skipping historical state pruning", "err", err)
+		return
+	}
+	// Pick the key range to sweep, entries are keyed by their uniformly
+	// distributed hashes
+	var (
+		index = (target / r.genBlocks) % r.pruneRanges
+		first = []byte{byte(index * 256 / r.pruneRanges)}
+		limit = (index + 1) * 256 / r.pruneRanges // Exclusive, 256 for the last range
+	)
+	log.Info("Pruning historical state", "number", target, "root", header.Root, "range", fmt.Sprintf("%d/%d", index+1, r.pruneRanges))
+	start := time.Now()
+
+	bloom, err := newStateBloomWithSize(r.bloomSize)
+	if err != nil {
+		log.Error("Failed to create state bloom", "err", err)
+		return
+	}
+	for _, root := range []common.Hash{header.Root, genesis} {
+		if err := commitState(r.db, root, bloom, r.quit); err != nil {
+			if err != errPruningAborted {
+				log.Warn("Failed to mark retained state", "root", root, "err", err)
+			}
+			return
+		}
+	}
+	// Iterate over all the state entries in the database and delete the ones not
+	// marked and not written since the oldest retained state.
+	var (
+		count  int
+		stale  [][]byte
+		logged = time.Now()
+		iter   = r.db.NewIteratorWithStart(first)
+	)
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) > 0 && uint64(key[0]) >= limit {
+			break
+		}
+		if len(key) != common.HashLength || bloom.Contain(key) {
+			continue
+		}
+		stale = append(stale, common.CopyBytes(key))
+		if len(stale) < retentionDeleteBatch {
+			continue
+		}
+		deleted, err := r.delete(stale)
+		if err != nil {
+			iter.Release()
+			log.Error("Failed to delete stale state", "err", err)
+			return
+		}
+		count += deleted
+
+		// Recreate the iterator after every batch commit in order
+		// to allow the underlying compactor to delete the entries.
+		iter.Release()
+		iter = r.db.NewIteratorWithStart(stale[len(stale)-1])
+		stale = stale[:0]
+
+		select {
+		case <-r.quit:
+			iter.Release()
+			return
+		default:
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Pruning historical state", "number", target, "deleted", count, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		log.Error("Failed to iterate state entries", "err", err)
+		return
+	}
+	deleted, err := r.delete(stale)
+	if err != nil {
+		log.Error("Failed to delete stale state", "err", err)
+		return
+	}
+	count += deleted
+	log.Info("Pruned historical state", "number", target, "range", fmt.Sprintf("%d/%d", index+1, r.pruneRanges), "deleted", count, "elapsed", common.PrettyDuration(time.Since(start)))
+}
+
+// delete removes all the given state entries from the database which were not
+// written by a live generation. The deletion is done while holding the lock, so
+// no state commit can resurrect an entry that is about to be deleted.
+func (r *Retainer) delete(keys [][]byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var (
+		count int
+		batch = r.db.NewBatch()
+	)
+	for _, key := range keys {
+		if r.recorded(key) {
+			continue
+		}
+		if err := batch.Delete(key); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, batch.Write()
+}
@@ -0,0 +1,200 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Tests that the retainer keeps the state of all the blocks in the retention
+// window along with the genesis state, while pruning the older ones.
+func TestRetainer(t *testing.T) {
+	var (
+		db    = rawdb.NewMemoryDatabase()
+		sdb   = state.NewDatabase(db)
+		roots []common.Hash
+		root  common.Hash
+	)
+	retainer := NewRetainer(db, 8, "")
+	retainer.bloomSize = 1
+	retainer.pruneRanges = 1
+	defer retainer.Stop()
+
+	for i := 0; i < 40; i++ {
+		statedb, _ := state.New(root, sdb, nil)
+		for j := 0; j < 10; j++ {
+			addr := common.BytesToAddress([]byte{byte(j)})
+			statedb.SetBalance(addr, big.NewInt(int64(i*100+j)))
+			statedb.SetState(addr, common.Hash{byte(i)}, common.Hash{byte(j + 1)})
+			if i == 0 {
+				statedb.SetCode(addr, []byte{byte(j), 0xff})
+			}
+		}
+		root, _ = statedb.Commit(false)
+		roots = append(roots, root)
+
+		header := &types.Header{Number: big.NewInt(int64(i)), Root: root}
+		rawdb.WriteHeader(db, header)
+		rawdb.WriteCanonicalHash(db, header.Hash(), uint64(i))
+
+		if i == 0 {
+			if err := sdb.TrieDB().Commit(root, false, nil); err != nil {
+				t.Fatalf("failed to commit genesis state: %v", err)
+			}
+			continue
+		}
+		if err := retainer.Commit(sdb.TrieDB(), root, uint64(i)); err != nil {
+			t.Fatalf("failed to commit state %d: %v", i, err)
+		}
+		retainer.wg.Wait()
+	}
+	// The genesis and all the states in the retention window must be available
+	if err := checkState(db, roots[0]); err != nil {
+		t.Errorf("genesis state incomplete: %v", err)
+	}
+	for i := len(roots) - 1 - 8; i < len(roots); i++ {
+		if err := checkState(db, roots[i]); err != nil {
+			t.Errorf("retained state %d incomplete: %v", i, err)
+		}
+	}
+	// The states before the last pruning target must be gone
+	for i := 1; i < len(roots)-1-2*8; i++ {
+		if ok, _ := db.Has(roots[i].Bytes()); ok {
+			t.Errorf("stale state %d retained", i)
+		}
+	}
+}
+
+// commitRetainerBlock creates and commits the state of a new block on top of the
+// given parent state, returning its root.
+func commitRetainerBlock(t *testing.T, retainer *Retainer, sdb state.Database, parent common.Hash, number int) common.Hash {
+	statedb, _ := state.New(parent, sdb, nil)
+	statedb.SetBalance(common.Address{byte(number)}, big.NewInt(int64(number)))
+	root, _ := statedb.Commit(false)
+
+	if err := retainer.Commit(sdb.TrieDB(), root, uint64(number)); err != nil {
+		t.Fatalf("failed to commit state %d: %v", number, err)
+	}
+	return root
+}
+
+// Tests that the generations are persisted across restarts, but discarded if the
+// retainer wasn't stopped cleanly.
+func TestRetainerJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retention-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		db    = rawdb.NewMemoryDatabase()
+		sdb   = state.NewDatabase(db)
+		roots []common.Hash
+		root  common.Hash
+	)
+	retainer := NewRetainer(db, 8, dir)
+	for i := 1; i <= 6; i++ {
+		root = commitRetainerBlock(t, retainer, sdb, root, i)
+		roots = append(roots, root)
+	}
+	retainer.Stop()
+
+	// Reopen the retainer and ensure all the generations are restored
+	retainer = NewRetainer(db, 8, dir)
+	if retainer.current == nil || retainer.current.first != 7 {
+		t.Fatalf("current generation not restored: %+v", retainer.current)
+	}
+	if len(retainer.gens) != 2 || retainer.gens[0].first != 1 || retainer.gens[1].first != 4 {
+		t.Fatalf("completed generations not restored: %+v", retainer.gens)
+	}
+	for i, root := range roots {
+		if !retainer.recorded(root.Bytes()) {
+			t.Errorf("state %d not recorded after restart", i+1)
+		}
+	}
+	if _, err := os.Stat(generationFile(dir, 7, true)); !os.IsNotExist(err) {
+		t.Fatalf("current generation file not consumed: %v", err)
+	}
+	// Simulate a crash and ensure the generations are discarded
+	commitRetainerBlock(t, retainer, sdb, root, 7)
+
+	retainer = NewRetainer(db, 8, dir)
+	if retainer.current != nil || len(retainer.gens) != 0 {
+		t.Fatalf("generations restored after unclean shutdown: %+v, %+v", retainer.current, retainer.gens)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("stale generation files left: %d", len(files))
+	}
+	retainer.Stop()
+}
+
+// Tests that a pruning cycle only sweeps over a single key range.
+func TestRetainerPruneRange(t *testing.T) {
+	var (
+		db  = rawdb.NewMemoryDatabase()
+		sdb = state.NewDatabase(db)
+	)
+	retainer := NewRetainer(db, 8, "")
+	retainer.bloomSize = 1
+	defer retainer.Stop()
+
+	// Create a genesis state and a pruning target sharing it
+	root := commitRetainerBlock(t, retainer, sdb, common.Hash{}, 1)
+	if err := sdb.TrieDB().Commit(root, false, nil); err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	for _, number := range []int64{0, 6} {
+		header := &types.Header{Number: big.NewInt(number), Root: root}
+		rawdb.WriteHeader(db, header)
+		rawdb.WriteCanonicalHash(db, header.Hash(), uint64(number))
+	}
+	// Write stale entries all over the key space and prune the block whose key
+	// range is [0x30, 0x40)
+	gen, err := retainer.newGeneration(7)
+	if err != nil {
+		t.Fatalf("failed to create generation: %v", err)
+	}
+	retainer.current, retainer.gens = gen, nil
+	var stale [][]byte
+	for i := 0; i < 256; i++ {
+		key := bytes.Repeat([]byte{byte(i)}, common.HashLength)
+		db.Put(key, []byte{0x01})
+		stale = append(stale, key)
+	}
+	retainer.wg.Add(1)
+	retainer.prune(6)
+
+	for i, key := range stale {
+		ok, _ := db.Has(key)
+		if inRange := i >= 0x30 && i < 0x40; ok == inRange {
+			t.Errorf("stale entry %#x: retained %v, in pruned range %v", i, ok, inRange)
+		}
+	}
+	if err := checkState(db, root); err != nil {
+		t.Errorf("retained state incomplete: %v", err)
+	}
+}
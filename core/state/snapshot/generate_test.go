@@ -0,0 +1,158 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// makeTestState creates a small state trie with a few accounts, one of them
+// having a couple of storage slots, and persists it into a fresh database.
+func makeTestState(t *testing.T) (*memorydb.Database, *trie.Database, common.Hash) {
+	var (
+		diskdb = memorydb.New()
+		triedb = trie.NewDatabase(diskdb)
+	)
+	stTrie, _ := trie.NewSecure(common.Hash{}, triedb)
+	stTrie.Update([]byte("key-1"), []byte("val-1"))
+	stTrie.Update([]byte("key-2"), []byte("val-2"))
+	stTrie.Update([]byte("key-3"), []byte("val-3"))
+	stRoot, _ := stTrie.Commit(nil)
+
+	accTrie, _ := trie.NewSecure(common.Hash{}, triedb)
+	acc := &Account{Balance: big.NewInt(1), Root: stRoot.Bytes(), CodeHash: emptyCode.Bytes()}
+	val, _ := rlp.EncodeToBytes(acc)
+	accTrie.Update([]byte("acc-1"), val)
+
+	acc = &Account{Balance: big.NewInt(2), Root: emptyRoot.Bytes(), CodeHash: emptyCode.Bytes()}
+	val, _ = rlp.EncodeToBytes(acc)
+	accTrie.Update([]byte("acc-2"), val)
+
+	root, _ := accTrie.Commit(func(leaf []byte, parent common.Hash) error {
+		var acc Account
+		if err := rlp.DecodeBytes(leaf, &acc); err != nil {
+			return err
+		}
+		triedb.Reference(common.BytesToHash(acc.Root), parent)
+		return nil
+	})
+	if err := triedb.Commit(root, false, nil); err != nil {
+		t.Fatalf("failed to commit test state: %v", err)
+	}
+	return diskdb, triedb, root
+}
+
+// Tests that snapshot generation from an existing trie produces the expected
+// flat account and storage entries.
+func TestGeneration(t *testing.T) {
+	diskdb, triedb, root := makeTestState(t)
+
+	snaps := New(diskdb, triedb, 1, root, false)
+	if snap := snaps.Snapshot(root); snap == nil {
+		t.Fatalf("generated snapshot missing")
+	}
+	if have := rawdb.ReadSnapshotRoot(diskdb); have != root {
+		t.Errorf("snapshot root mismatch: have %#x, want %#x", have, root)
+	}
+	// Ensure the accounts were persisted in the slim format
+	for i, name := range []string{"acc-1", "acc-2"} {
+		blob := rawdb.ReadAccountSnapshot(diskdb, crypto.Keccak256Hash([]byte(name)))
+		if len(blob) == 0 {
+			t.Fatalf("account %d: missing from snapshot", i)
+		}
+		acc := new(Account)
+		if err := rlp.DecodeBytes(blob, acc); err != nil {
+			t.Fatalf("account %d: failed to decode: %v", i, err)
+		}
+		if acc.Balance.Uint64() != uint64(i+1) {
+			t.Errorf("account %d: balance mismatch: have %v, want %v", i, acc.Balance, i+1)
+		}
+		if len(acc.CodeHash) != 0 {
+			t.Errorf("account %d: empty code hash not slimmed: %x", i, acc.CodeHash)
+		}
+	}
+	// Ensure the storage slots were persisted for the contract account
+	accHash := crypto.Keccak256Hash([]byte("acc-1"))
+	for _, slot := range []string{"key-1", "key-2", "key-3"} {
+		blob := rawdb.ReadStorageSnapshot(diskdb, accHash, crypto.Keccak256Hash([]byte(slot)))
+		if want := "val-" + slot[4:]; string(blob) != want {
+			t.Errorf("slot %s: value mismatch: have %q, want %q", slot, blob, want)
+		}
+	}
+	// Reads through the snapshot must return the same data
+	snap := snaps.Snapshot(root)
+	if blob, err := snap.Storage(accHash, crypto.Keccak256Hash([]byte("key-2"))); err != nil || !bytes.Equal(blob, []byte("val-2")) {
+		t.Errorf("snapshot storage read mismatch: have %q (err: %v), want %q", blob, err, "val-2")
+	}
+}
+
+// Tests that a journalled snapshot tree can be reloaded with all its diff
+// layers intact.
+func TestJournal(t *testing.T) {
+	diskdb, triedb, root := makeTestState(t)
+
+	snaps := New(diskdb, triedb, 1, root, false)
+
+	// Stack a couple of diff layers on top of the generated disk layer
+	var (
+		child1   = common.HexToHash("0x02")
+		child2   = common.HexToHash("0x03")
+		accHash  = crypto.Keccak256Hash([]byte("acc-3"))
+		accounts = map[common.Hash][]byte{accHash: randomAccount()}
+		storage  = map[common.Hash]map[common.Hash][]byte{accHash: {common.HexToHash("0x01"): []byte{0x01}}}
+		destruct = map[common.Hash]struct{}{crypto.Keccak256Hash([]byte("acc-2")): {}}
+	)
+	if err := snaps.Update(child1, root, nil, accounts, storage); err != nil {
+		t.Fatalf("failed to create diff layer: %v", err)
+	}
+	if err := snaps.Update(child2, child1, destruct, nil, nil); err != nil {
+		t.Fatalf("failed to create diff layer: %v", err)
+	}
+	base, err := snaps.Journal(child2)
+	if err != nil {
+		t.Fatalf("failed to journal snapshot: %v", err)
+	}
+	if base != root {
+		t.Errorf("journal base mismatch: have %#x, want %#x", base, root)
+	}
+	// Reload the snapshot tree and ensure all layers and data are present
+	snaps = New(diskdb, triedb, 1, child2, false)
+	for _, root := range []common.Hash{root, child1, child2} {
+		if snaps.Snapshot(root) == nil {
+			t.Errorf("layer %#x missing after reload", root)
+		}
+	}
+	snap := snaps.Snapshot(child2)
+	if blob, err := snap.AccountRLP(accHash); err != nil || !bytes.Equal(blob, accounts[accHash]) {
+		t.Errorf("reloaded account mismatch: have %x (err: %v), want %x", blob, err, accounts[accHash])
+	}
+	if blob, err := snap.Storage(accHash, common.HexToHash("0x01")); err != nil || !bytes.Equal(blob, []byte{0x01}) {
+		t.Errorf("reloaded storage mismatch: have %x (err: %v), want %x", blob, err, []byte{0x01})
+	}
+	if blob, err := snap.AccountRLP(crypto.Keccak256Hash([]byte("acc-2"))); err != nil || len(blob) != 0 {
+		t.Errorf("destructed account returned: %x (err: %v)", blob, err)
+	}
+}
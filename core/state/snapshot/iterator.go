@@ -0,0 +1,293 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// Iterator is an iterator to step over all the accounts or the specific
+// storage in a snapshot which may or may not be composed of multiple layers.
+type Iterator interface {
+	// Next steps the iterator forward one element, returning false if exhausted,
+	// or an error if iteration failed for some reason (e.g. root being iterated
+	// becomes stale and garbage collected).
+	Next() bool
+
+	// Error returns any failure that occurred during iteration, which might have
+	// caused a premature iteration exit (e.g. snapshot stack becoming stale).
+	Error() error
+
+	// Hash returns the hash of the account or storage slot the iterator is
+	// currently at.
+	Hash() common.Hash
+
+	// Release releases associated resources. Release should always succeed and
+	// can be called multiple times without causing error.
+	Release()
+}
+
+// AccountIterator is an iterator to step over all the accounts in a snapshot,
+// which may or may not be composed of multiple layers.
+type AccountIterator interface {
+	Iterator
+
+	// Account returns the RLP encoded slim account the iterator is currently at.
+	Account() []byte
+}
+
+// StorageIterator is an iterator to step over the specific storage in a snapshot,
+// which may or may not be composed of multiple layers.
+type StorageIterator interface {
+	Iterator
+
+	// Slot returns the storage slot the iterator is currently at.
+	Slot() []byte
+}
+
+// layeredIterator is an iterator over the flat data of a snapshot, merging the
+// sorted keys modified in the in-memory diff layers with the persistent entries
+// of the disk layer below them. Values are always resolved through the topmost
+// layer, so that deletions and overrides of the diffs are respected.
+type layeredIterator struct {
+	dirty []common.Hash // Sorted keys modified in the diff layers, at or after the seek position
+
+	disk    ethdb.Iterator // Iterator over the disk layer entries (nil if shadowed or exhausted)
+	prefix  []byte         // Database key prefix of the iterated disk entries
+	diskKey common.Hash    // Disk key the disk iterator is currently at
+	diskOk  bool           // Whether the disk iterator is positioned at a valid entry
+
+	resolve func(hash common.Hash) ([]byte, error) // Retrieves the value of a key through the layers
+
+	hash  common.Hash // Hash of the current entry
+	value []byte      // Value of the current entry
+	fail  error       // Any failure encountered during iteration
+}
+
+// newLayeredIterator creates an iterator merging the dirty keys with the disk
+// entries residing under the given database prefix, starting at seek.
+func newLayeredIterator(dirty map[common.Hash]struct{}, diskdb ethdb.Iteratee, prefix []byte, seek common.Hash, resolve func(hash common.Hash) ([]byte, error)) *layeredIterator {
+	it := &layeredIterator{
+		dirty:   make([]common.Hash, 0, len(dirty)),
+		prefix:  prefix,
+		resolve: resolve,
+	}
+	for hash := range dirty {
+		it.dirty = append(it.dirty, hash)
+	}
+	sort.Sort(hashes(it.dirty))
+
+	if diskdb != nil {
+		it.disk = diskdb.NewIteratorWithStart(append(common.CopyBytes(prefix), seek[:]...))
+		it.advanceDisk()
+	}
+	return it
+}
+
+// advanceDisk moves the disk iterator to the next valid snapshot entry.
+func (it *layeredIterator) advanceDisk() {
+	it.diskOk = false
+	if it.disk == nil {
+		return
+	}
+	for it.disk.Next() {
+		key := it.disk.Key()
+		if !bytes.HasPrefix(key, it.prefix) {
+			break
+		}
+		// Skip any unrelated entries sharing the prefix (e.g. trie nodes)
+		if len(key) != len(it.prefix)+common.HashLength {
+			continue
+		}
+		copy(it.diskKey[:], key[len(it.prefix):])
+		it.diskOk = true
+		return
+	}
+	if err := it.disk.Error(); err != nil {
+		it.fail = err
+	}
+	it.disk.Release()
+	it.disk = nil
+}
+
+// Next steps the iterator forward one element, returning false if exhausted.
+func (it *layeredIterator) Next() bool {
+	for it.fail == nil {
+		// Pick the smaller of the next dirty and disk keys, deduplicating
+		var hash common.Hash
+		switch {
+		case len(it.dirty) == 0 && !it.diskOk:
+			return false
+
+		case len(it.dirty) > 0 && (!it.diskOk || bytes.Compare(it.dirty[0][:], it.diskKey[:]) <= 0):
+			hash, it.dirty = it.dirty[0], it.dirty[1:]
+			if it.diskOk && hash == it.diskKey {
+				it.advanceDisk()
+			}
+		default:
+			hash = it.diskKey
+			it.advanceDisk()
+		}
+		// Resolve the value through the layers, skipping deleted entries
+		value, err := it.resolve(hash)
+		if err != nil {
+			it.fail = err
+			return false
+		}
+		if len(value) == 0 {
+			continue
+		}
+		it.hash, it.value = hash, value
+		return true
+	}
+	return false
+}
+
+// Error returns any failure that occurred during iteration.
+func (it *layeredIterator) Error() error {
+	return it.fail
+}
+
+// Hash returns the hash of the entry the iterator is currently at.
+func (it *layeredIterator) Hash() common.Hash {
+	return it.hash
+}
+
+// Account returns the slim account the iterator is currently at.
+func (it *layeredIterator) Account() []byte {
+	return it.value
+}
+
+// Slot returns the storage slot the iterator is currently at.
+func (it *layeredIterator) Slot() []byte {
+	return it.value
+}
+
+// Release releases the database snapshot held during iteration.
+func (it *layeredIterator) Release() {
+	if it.disk != nil {
+		it.disk.Release()
+		it.disk = nil
+	}
+	it.dirty = nil
+}
+
+// hashes is a helper to implement sort.Interface.
+type hashes []common.Hash
+
+func (hs hashes) Len() int           { return len(hs) }
+func (hs hashes) Less(i, j int) bool { return bytes.Compare(hs[i][:], hs[j][:]) < 0 }
+func (hs hashes) Swap(i, j int)      { hs[i], hs[j] = hs[j], hs[i] }
+
+// layersOf retrieves the snapshot layer belonging to the given root and its disk
+// layer, failing if the snapshot is unknown or not yet fully generated.
+func (t *Tree) layersOf(root common.Hash) (snapshot, *diskLayer, error) {
+	t.lock.RLock()
+	snap := t.layers[root]
+	t.lock.RUnlock()
+
+	if snap == nil {
+		return nil, nil, fmt.Errorf("unknown snapshot: %x", root)
+	}
+	var disk *diskLayer
+	for layer := snap; disk == nil; layer = layer.Parent() {
+		disk, _ = layer.(*diskLayer)
+	}
+	disk.lock.RLock()
+	generating := disk.genMarker != nil
+	disk.lock.RUnlock()
+
+	if generating {
+		return nil, nil, ErrNotCoveredYet
+	}
+	return snap, disk, nil
+}
+
+// AccountIterator creates a new account iterator for the specified root hash and
+// seeks to a starting account hash. The accounts are returned in the slim format.
+func (t *Tree) AccountIterator(root common.Hash, seek common.Hash) (AccountIterator, error) {
+	snap, disk, err := t.layersOf(root)
+	if err != nil {
+		return nil, err
+	}
+	dirty := make(map[common.Hash]struct{})
+	for layer := snap; layer != snapshot(disk); layer = layer.Parent() {
+		layer.(*diffLayer).accountKeys(seek, dirty)
+	}
+	return newLayeredIterator(dirty, disk.diskdb, rawdb.SnapshotAccountPrefix, seek, snap.AccountRLP), nil
+}
+
+// StorageIterator creates a new storage iterator for the specified root hash and
+// account. The iterator will be moved to the specific start position.
+func (t *Tree) StorageIterator(root common.Hash, account common.Hash, seek common.Hash) (StorageIterator, error) {
+	snap, disk, err := t.layersOf(root)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		dirty  = make(map[common.Hash]struct{})
+		diskdb = ethdb.Iteratee(disk.diskdb)
+	)
+	for layer := snap; layer != snapshot(disk); layer = layer.Parent() {
+		// If the account was deleted in this layer, anything below is shadowed
+		if destructed := layer.(*diffLayer).storageKeys(account, seek, dirty); destructed {
+			diskdb = nil
+			break
+		}
+	}
+	prefix := append(common.CopyBytes(rawdb.SnapshotStoragePrefix), account[:]...)
+	resolve := func(hash common.Hash) ([]byte, error) {
+		return snap.Storage(account, hash)
+	}
+	return newLayeredIterator(dirty, diskdb, prefix, seek, resolve), nil
+}
+
+// accountKeys gathers all the account hashes modified in this diff layer which
+// are positioned at or after the seek hash.
+func (dl *diffLayer) accountKeys(seek common.Hash, keys map[common.Hash]struct{}) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	for hash := range dl.accountData {
+		if bytes.Compare(hash[:], seek[:]) >= 0 {
+			keys[hash] = struct{}{}
+		}
+	}
+}
+
+// storageKeys gathers all the storage slot hashes of an account modified in this
+// diff layer which are positioned at or after the seek hash. The returned flag
+// reports whether the account was destructed in this layer, shadowing all the
+// storage slots in the lower layers.
+func (dl *diffLayer) storageKeys(account common.Hash, seek common.Hash, keys map[common.Hash]struct{}) bool {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	for hash := range dl.storageData[account] {
+		if bytes.Compare(hash[:], seek[:]) >= 0 {
+			keys[hash] = struct{}{}
+		}
+	}
+	_, destructed := dl.destructSet[account]
+	return destructed
+}
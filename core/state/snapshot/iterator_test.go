@@ -0,0 +1,170 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// collectIterator drains an iterator, returning the iterated hashes and values.
+func collectIterator(t *testing.T, it Iterator, value func() []byte) ([]common.Hash, [][]byte) {
+	defer it.Release()
+
+	var (
+		keys []common.Hash
+		vals [][]byte
+	)
+	for it.Next() {
+		keys = append(keys, it.Hash())
+		vals = append(vals, value())
+	}
+	if err := it.Error(); err != nil {
+		t.Fatalf("iteration failed: %v", err)
+	}
+	return keys, vals
+}
+
+// Tests that the account iterator merges the disk layer and the diff layers on
+// top, respecting overrides and deletions, in sorted order.
+func TestAccountIteratorTraversal(t *testing.T) {
+	// Create a disk layer with a few accounts and a snapshot tree out of it
+	base := newTestDiskLayer(common.HexToHash("0x01"))
+	for hash, blob := range randomAccountSet("0xaa", "0xbb", "0xcc", "0xdd") {
+		rawdb.WriteAccountSnapshot(base.diskdb, hash, blob)
+	}
+	snaps := &Tree{
+		layers: map[common.Hash]snapshot{
+			base.root: base,
+		},
+	}
+	// Stack a few diff layers modifying, creating and deleting accounts
+	accounts := randomAccountSet("0xbb", "0xee")
+	if err := snaps.Update(common.HexToHash("0x02"), common.HexToHash("0x01"), nil, accounts, nil); err != nil {
+		t.Fatalf("failed to create a diff layer: %v", err)
+	}
+	destructs := map[common.Hash]struct{}{common.HexToHash("0xcc"): {}}
+	if err := snaps.Update(common.HexToHash("0x03"), common.HexToHash("0x02"), destructs, randomAccountSet("0x11"), nil); err != nil {
+		t.Fatalf("failed to create a diff layer: %v", err)
+	}
+	// Iterate the topmost layer and check the merged results
+	it, err := snaps.AccountIterator(common.HexToHash("0x03"), common.Hash{})
+	if err != nil {
+		t.Fatalf("failed to create iterator: %v", err)
+	}
+	keys, vals := collectIterator(t, it, it.Account)
+
+	want := []common.Hash{
+		common.HexToHash("0x11"), common.HexToHash("0xaa"), common.HexToHash("0xbb"),
+		common.HexToHash("0xdd"), common.HexToHash("0xee"),
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("account count mismatch: have %d, want %d", len(keys), len(want))
+	}
+	top := snaps.Snapshot(common.HexToHash("0x03"))
+	for i, key := range keys {
+		if key != want[i] {
+			t.Errorf("account %d: hash mismatch: have %x, want %x", i, key, want[i])
+		}
+		blob, _ := top.AccountRLP(key)
+		if !bytes.Equal(vals[i], blob) {
+			t.Errorf("account %d: data mismatch: have %x, want %x", i, vals[i], blob)
+		}
+	}
+	// Iterate from a seek position in the middle
+	it, err = snaps.AccountIterator(common.HexToHash("0x03"), common.HexToHash("0xbc"))
+	if err != nil {
+		t.Fatalf("failed to create iterator: %v", err)
+	}
+	if keys, _ = collectIterator(t, it, it.Account); len(keys) != 2 || keys[0] != common.HexToHash("0xdd") {
+		t.Fatalf("seeked iteration mismatch: have %x", keys)
+	}
+}
+
+// Tests that the storage iterator hides the slots of accounts destructed in a
+// higher layer.
+func TestStorageIteratorTraversal(t *testing.T) {
+	var (
+		account = common.HexToHash("0xaa")
+		slot1   = common.HexToHash("0x01")
+		slot2   = common.HexToHash("0x02")
+		slot3   = common.HexToHash("0x03")
+	)
+	base := newTestDiskLayer(common.HexToHash("0x01"))
+	rawdb.WriteStorageSnapshot(base.diskdb, account, slot1, []byte{0x01})
+	rawdb.WriteStorageSnapshot(base.diskdb, account, slot2, []byte{0x02})
+	rawdb.WriteStorageSnapshot(base.diskdb, common.HexToHash("0xbb"), slot1, []byte{0x03})
+
+	snaps := &Tree{
+		layers: map[common.Hash]snapshot{
+			base.root: base,
+		},
+	}
+	storage := map[common.Hash]map[common.Hash][]byte{
+		account: {slot2: nil, slot3: {0x04}},
+	}
+	if err := snaps.Update(common.HexToHash("0x02"), common.HexToHash("0x01"), nil, randomAccountSet("0xaa"), storage); err != nil {
+		t.Fatalf("failed to create a diff layer: %v", err)
+	}
+	it, err := snaps.StorageIterator(common.HexToHash("0x02"), account, common.Hash{})
+	if err != nil {
+		t.Fatalf("failed to create iterator: %v", err)
+	}
+	keys, vals := collectIterator(t, it, it.Slot)
+	if len(keys) != 2 || keys[0] != slot1 || keys[1] != slot3 {
+		t.Fatalf("storage mismatch: have %x", keys)
+	}
+	if !bytes.Equal(vals[0], []byte{0x01}) || !bytes.Equal(vals[1], []byte{0x04}) {
+		t.Fatalf("storage data mismatch: have %x", vals)
+	}
+	// Destruct and recreate the account, old slots must be gone
+	destructs := map[common.Hash]struct{}{account: {}}
+	storage = map[common.Hash]map[common.Hash][]byte{
+		account: {slot2: {0x05}},
+	}
+	if err := snaps.Update(common.HexToHash("0x03"), common.HexToHash("0x02"), destructs, randomAccountSet("0xaa"), storage); err != nil {
+		t.Fatalf("failed to create a diff layer: %v", err)
+	}
+	it, err = snaps.StorageIterator(common.HexToHash("0x03"), account, common.Hash{})
+	if err != nil {
+		t.Fatalf("failed to create iterator: %v", err)
+	}
+	if keys, _ = collectIterator(t, it, it.Slot); len(keys) != 1 || keys[0] != slot2 {
+		t.Fatalf("recreated storage mismatch: have %x", keys)
+	}
+}
+
+// Tests that iterators cannot be created while the snapshot is being generated.
+func TestIteratorGenerating(t *testing.T) {
+	base := newTestDiskLayer(common.HexToHash("0x01"))
+	base.genMarker = []byte{0x80}
+
+	snaps := &Tree{
+		layers: map[common.Hash]snapshot{
+			base.root: base,
+		},
+	}
+	if _, err := snaps.AccountIterator(base.root, common.Hash{}); err != ErrNotCoveredYet {
+		t.Fatalf("iterator error mismatch: have %v, want %v", err, ErrNotCoveredYet)
+	}
+	if _, err := snaps.AccountIterator(common.HexToHash("0x02"), common.Hash{}); err == nil {
+		t.Fatalf("iterator created for unknown root")
+	}
+}
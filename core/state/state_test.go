@@ -18,10 +18,13 @@ package state
 
 import (
 	"bytes"
+	"encoding/json"
 	"math/big"
+	"strings"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
@@ -52,7 +55,7 @@ func (s *StateSuite) TestDump(c *checker.C) {
 	s.state.Commit(false)
 
 	// check that dump contains the state objects that are in trie
-	got := string(s.state.Dump())
+	got := string(s.state.Dump(nil))
 	want := `{
     "root": "71edff0130dd2385947095001c73d9e28d862fc286fca2b922ca6f6f3cddfdd2",
     "accounts": {
@@ -87,9 +90,121 @@ func (s *StateSuite) TestDump(c *checker.C) {
 	}
 }
 
+func (s *StateSuite) TestIterativeDump(c *checker.C) {
+	// generate a few entries, one of them with storage
+	s.state.SetBalance(toAddr([]byte{0x01}), big.NewInt(22))
+	s.state.SetBalance(toAddr([]byte{0x02}), big.NewInt(44))
+	s.state.SetState(toAddr([]byte{0x02}), common.Hash{0x01}, common.Hash{0x02})
+	s.state.SetBalance(toAddr([]byte{0x03}), big.NewInt(66))
+	s.state.Commit(false)
+
+	// dump everything line by line and check the root and account count
+	var buf bytes.Buffer
+	if next := s.state.IterativeDump(&DumpConfig{SkipCode: true}, json.NewEncoder(&buf)); next != nil {
+		c.Errorf("unexpected continuation key for full dump: %x", next)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		c.Fatalf("line count mismatch: have %d, want %d", len(lines), 4)
+	}
+	var root struct {
+		Root common.Hash `json:"root"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &root); err != nil || root.Root != s.state.trie.Hash() {
+		c.Errorf("root mismatch: have %x, want %x (err: %v)", root.Root, s.state.trie.Hash(), err)
+	}
+	for _, line := range lines[1:] {
+		var account DumpEntry
+		if err := json.Unmarshal([]byte(line), &account); err != nil {
+			c.Fatalf("failed to decode account line %q: %v", line, err)
+		}
+		if account.Address == nil {
+			c.Errorf("account without address: %s", line)
+		}
+		if *account.Address == toAddr([]byte{0x02}) && len(account.Storage) != 1 {
+			c.Errorf("storage mismatch: have %d slots, want %d", len(account.Storage), 1)
+		}
+	}
+	// page through the state and ensure every account is visited exactly once
+	seen := make(map[string]bool)
+	opts := &DumpConfig{SkipCode: true, SkipStorage: true, Max: 2}
+	for pages := 0; ; pages++ {
+		if pages > 2 {
+			c.Fatalf("too many pages")
+		}
+		dump := s.state.IteratorDump(opts)
+		for addr, account := range dump.Accounts {
+			if seen[addr] {
+				c.Errorf("account %s dumped twice", addr)
+			}
+			if account.Storage != nil {
+				c.Errorf("storage included despite being skipped")
+			}
+			seen[addr] = true
+		}
+		if dump.Next == nil {
+			break
+		}
+		opts.Start = dump.Next
+	}
+	if len(seen) != 3 {
+		c.Errorf("account count mismatch: have %d, want %d", len(seen), 3)
+	}
+}
+
+func (s *StateSuite) TestDumpMissingPreimages(c *checker.C) {
+	// create the zero address and two accounts which will lose their preimage,
+	// one of them with storage in the zero slot and a slot that loses its preimage
+	var (
+		zero  = common.Address{}
+		addr1 = toAddr([]byte{0x01})
+		addr2 = toAddr([]byte{0x02})
+		slot  = common.Hash{0x01}
+	)
+	s.state.SetBalance(zero, big.NewInt(11))
+	s.state.SetBalance(addr1, big.NewInt(22))
+	s.state.SetState(addr1, common.Hash{}, common.Hash{0x03})
+	s.state.SetState(addr1, slot, common.Hash{0x04})
+	s.state.SetBalance(addr2, big.NewInt(33))
+	root, _ := s.state.Commit(false)
+	s.state.Database().TrieDB().Commit(root, false, nil)
+
+	for _, preimage := range [][]byte{addr1[:], addr2[:], slot[:]} {
+		s.db.Delete(append([]byte("secure-key-"), crypto.Keccak256(preimage)...))
+	}
+	state, err := New(root, NewDatabase(s.db), nil)
+	if err != nil {
+		c.Fatalf("failed to open state: %v", err)
+	}
+	// entries without preimage must neither merge with each other nor with the zero address
+	dump := state.RawDump(nil)
+	if len(dump.Accounts) != 3 {
+		c.Errorf("legacy account count mismatch: have %d, want %d", len(dump.Accounts), 3)
+	}
+	if account, ok := dump.Accounts[common.Bytes2Hex(zero[:])]; !ok || account.Balance != "11" {
+		c.Errorf("zero address missing or overwritten: %+v", account)
+	}
+	iterator := state.IteratorDump(nil)
+	if len(iterator.Accounts) != 3 {
+		c.Errorf("account count mismatch: have %d, want %d", len(iterator.Accounts), 3)
+	}
+	if account, ok := iterator.Accounts[hexutil.Encode(zero[:])]; !ok || account.Balance != "11" {
+		c.Errorf("zero address missing or overwritten: %+v", account)
+	}
+	for key, account := range iterator.Accounts {
+		if account.Balance == "22" && len(account.Storage) != 2 {
+			c.Errorf("storage of account %s merged: %v", key, account.Storage)
+		}
+	}
+	// only the zero address remains if incomplete entries are skipped
+	if dump := state.IteratorDump(&DumpConfig{OnlyWithAddresses: true}); len(dump.Accounts) != 1 {
+		c.Errorf("account count mismatch: have %d, want %d", len(dump.Accounts), 1)
+	}
+}
+
 func (s *StateSuite) SetUpTest(c *checker.C) {
 	s.db = rawdb.NewMemoryDatabase()
-	s.state, _ = New(common.Hash{}, NewDatabase(s.db))
+	s.state, _ = New(common.Hash{}, NewDatabase(s.db), nil)
 }
 
 func (s *StateSuite) TestNull(c *checker.C) {
@@ -142,7 +257,7 @@ func (s *StateSuite) TestSnapshotEmpty(c *checker.C) {
 // use testing instead of checker because checker does not support
 // printing/logging in tests (-check.vv does not work)
 func TestSnapshot2(t *testing.T) {
-	state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()))
+	state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
 
 	stateobjaddr0 := toAddr([]byte("so0"))
 	stateobjaddr1 := toAddr([]byte("so1"))
@@ -32,7 +32,9 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 // Tests that updating a state trie does not leak any database writes prior to
@@ -40,7 +42,7 @@ import (
 func TestUpdateLeaks(t *testing.T) {
 	// Create an empty state database
 	db := rawdb.NewMemoryDatabase()
-	state, _ := New(common.Hash{}, NewDatabase(db))
+	state, _ := New(common.Hash{}, NewDatabase(db), nil)
 
 	// Update it with some accounts
 	for i := byte(0); i < 255; i++ {
@@ -69,8 +71,8 @@ func TestIntermediateLeaks(t *testing.T) {
 	// Create two state databases, one transitioning to the final state, the other final from the beginning
 	transDb := rawdb.NewMemoryDatabase()
 	finalDb := rawdb.NewMemoryDatabase()
-	transState, _ := New(common.Hash{}, NewDatabase(transDb))
-	finalState, _ := New(common.Hash{}, NewDatabase(finalDb))
+	transState, _ := New(common.Hash{}, NewDatabase(transDb), nil)
+	finalState, _ := New(common.Hash{}, NewDatabase(finalDb), nil)
 
 	modify := func(state *StateDB, addr common.Address, i, tweak byte) {
 		state.SetBalance(addr, big.NewInt(int64(11*i)+int64(tweak)))
@@ -127,7 +129,7 @@ func TestIntermediateLeaks(t *testing.T) {
 // https://github.com/ethereum/go-ethereum/pull/15549.
 func TestCopy(t *testing.T) {
 	// Create a random state test to copy and modify "independently"
-	orig, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()))
+	orig, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
 
 	for i := byte(0); i < 255; i++ {
 		obj := orig.GetOrNewStateObject(common.BytesToAddress([]byte{i}))
@@ -347,7 +349,7 @@ func (test *snapshotTest) String() string {
 func (test *snapshotTest) run() bool {
 	// Run all actions and create snapshots.
 	var (
-		state, _     = New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()))
+		state, _     = New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
 		snapshotRevs = make([]int, len(test.snapshots))
 		sindex       = 0
 	)
@@ -361,7 +363,7 @@ func (test *snapshotTest) run() bool {
 	// Revert all snapshots in reverse order. Each revert must yield a state
 	// that is equivalent to fresh state with all actions up the snapshot applied.
 	for sindex--; sindex >= 0; sindex-- {
-		checkstate, _ := New(common.Hash{}, state.Database())
+		checkstate, _ := New(common.Hash{}, state.Database(), nil)
 		for _, action := range test.actions[:test.snapshots[sindex]] {
 			action.fn(action, checkstate)
 		}
@@ -438,7 +440,7 @@ func (s *StateSuite) TestTouchDelete(c *check.C) {
 // TestCopyOfCopy tests that modified objects are carried over to the copy, and the copy of the copy.
 // See https://github.com/ethereum/go-ethereum/pull/15225#issuecomment-380191512
 func TestCopyOfCopy(t *testing.T) {
-	sdb, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()))
+	sdb, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
 	addr := common.HexToAddress("aaaa")
 	sdb.SetBalance(addr, big.NewInt(42))
 
@@ -449,3 +451,138 @@ func TestCopyOfCopy(t *testing.T) {
 		t.Fatalf("2nd copy fail, expected 42, got %v", got)
 	}
 }
+
+// Tests that state reads are served through the flat snapshot when one is
+// available, and that committing a state on top of it creates a new layer.
+func TestSnapshotBackedReads(t *testing.T) {
+	var (
+		diskdb = rawdb.NewMemoryDatabase()
+		sdb    = NewDatabase(diskdb)
+		addr   = common.HexToAddress("aaaa")
+		key    = common.HexToHash("01")
+	)
+	state, _ := New(common.Hash{}, sdb, nil)
+	state.SetBalance(addr, big.NewInt(42))
+	state.SetState(addr, key, common.HexToHash("02"))
+	root, _ := state.Commit(false)
+	if err := sdb.TrieDB().Commit(root, false, nil); err != nil {
+		t.Fatalf("failed to commit state trie: %v", err)
+	}
+	snaps := snapshot.New(diskdb, sdb.TrieDB(), 1, root, false)
+
+	// Read the state back through the snapshot and modify it
+	state, _ = New(root, sdb, snaps)
+	if balance := state.GetBalance(addr); balance.Uint64() != 42 {
+		t.Fatalf("balance mismatch: have %v, want %v", balance, 42)
+	}
+	if value := state.GetState(addr, key); value != common.HexToHash("02") {
+		t.Fatalf("storage mismatch: have %x, want %x", value, common.HexToHash("02"))
+	}
+	state.SetBalance(addr, big.NewInt(43))
+	state.SetState(addr, key, common.Hash{})
+	root, _ = state.Commit(false)
+
+	// Ensure the new layer is available and reflects the changes
+	snap := snaps.Snapshot(root)
+	if snap == nil {
+		t.Fatalf("snapshot layer missing for committed root")
+	}
+	acc, err := snap.Account(crypto.Keccak256Hash(addr.Bytes()))
+	if err != nil || acc == nil || acc.Balance.Uint64() != 43 {
+		t.Fatalf("snapshot account mismatch: have %v (err: %v)", acc, err)
+	}
+	if blob, err := snap.Storage(crypto.Keccak256Hash(addr.Bytes()), crypto.Keccak256Hash(key.Bytes())); err != nil || len(blob) != 0 {
+		t.Fatalf("deleted slot still present: %x (err: %v)", blob, err)
+	}
+	state, _ = New(root, sdb, snaps)
+	if value := state.GetState(addr, key); value != (common.Hash{}) {
+		t.Fatalf("storage mismatch: have %x, want empty", value)
+	}
+}
+
+// Tests that replacing the storage of an account hides all of its original slots,
+// keeps serving writes and reverts, and that the original storage is never touched.
+func TestSetStorage(t *testing.T) {
+	var (
+		sdb  = NewDatabase(rawdb.NewMemoryDatabase())
+		addr = common.HexToAddress("aaaa")
+	)
+	state, _ := New(common.Hash{}, sdb, nil)
+	state.SetState(addr, common.HexToHash("01"), common.HexToHash("01"))
+	state.SetState(addr, common.HexToHash("02"), common.HexToHash("02"))
+	root, _ := state.Commit(false)
+
+	state, _ = New(root, sdb, nil)
+	state.SetStorage(addr, map[common.Hash]common.Hash{common.HexToHash("02"): common.HexToHash("22")})
+
+	if value := state.GetState(addr, common.HexToHash("01")); value != (common.Hash{}) {
+		t.Errorf("replaced slot still visible: have %x", value)
+	}
+	if value := state.GetState(addr, common.HexToHash("02")); value != common.HexToHash("22") {
+		t.Errorf("fake slot mismatch: have %x, want %x", value, common.HexToHash("22"))
+	}
+	snapshot := state.Snapshot()
+	state.SetState(addr, common.HexToHash("03"), common.HexToHash("33"))
+	if value := state.Copy().GetState(addr, common.HexToHash("03")); value != common.HexToHash("33") {
+		t.Errorf("fake slot write lost in copy: have %x, want %x", value, common.HexToHash("33"))
+	}
+	state.RevertToSnapshot(snapshot)
+	if value := state.GetState(addr, common.HexToHash("03")); value != (common.Hash{}) {
+		t.Errorf("fake slot write not reverted: have %x", value)
+	}
+	// The original storage must remain intact
+	if state, _ = New(root, sdb, nil); state.GetState(addr, common.HexToHash("01")) != common.HexToHash("01") {
+		t.Errorf("original storage modified")
+	}
+}
+
+// Tests that additions to the access list are journalled and reverted along with
+// the rest of the state, and that copies of the state are independent.
+func TestStateDBAccessList(t *testing.T) {
+	state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
+
+	var (
+		sender = common.Address{0x01}
+		dst    = common.Address{0x02}
+		other  = common.Address{0x03}
+		slot   = common.Hash{0x11}
+	)
+	verify := func(addr common.Address, slot common.Hash, addrWant, slotWant bool) {
+		t.Helper()
+		if have := state.AddressInAccessList(addr); have != addrWant {
+			t.Fatalf("address %x presence mismatch: have %v, want %v", addr, have, addrWant)
+		}
+		addrHave, slotHave := state.SlotInAccessList(addr, slot)
+		if addrHave != addrWant || slotHave != slotWant {
+			t.Fatalf("slot %x/%x presence mismatch: have %v/%v, want %v/%v", addr, slot, addrHave, slotHave, addrWant, slotWant)
+		}
+	}
+	state.PrepareAccessList(sender, &dst, nil, types.AccessList{{Address: other, StorageKeys: []common.Hash{slot}}})
+	verify(sender, slot, true, false)
+	verify(dst, slot, true, false)
+	verify(other, slot, true, true)
+
+	// Additions after a snapshot should be undone when reverting to it
+	snap := state.Snapshot()
+
+	extra := common.Address{0x04}
+	state.AddSlotToAccessList(extra, slot)
+	state.AddSlotToAccessList(dst, common.Hash{0x22})
+	verify(extra, slot, true, true)
+	verify(dst, common.Hash{0x22}, true, true)
+
+	cpy := state.Copy()
+	state.RevertToSnapshot(snap)
+
+	verify(extra, slot, false, false)
+	verify(dst, common.Hash{0x22}, true, false)
+	verify(other, slot, true, true)
+
+	if !cpy.AddressInAccessList(extra) {
+		t.Fatalf("copied state lost access list entry")
+	}
+	// Preparing for a new transaction resets the list
+	state.PrepareAccessList(extra, nil, nil, nil)
+	verify(extra, slot, true, false)
+	verify(sender, slot, false, false)
+}
@@ -64,6 +64,37 @@ func TestBlockEncoding(t *testing.T) {
 	}
 }
 
+// Tests that the EIP-1559 base fee is appended to the header encoding only when
+// set, leaving legacy headers untouched.
+func TestHeaderBaseFeeEncoding(t *testing.T) {
+	legacy := &Header{Difficulty: big.NewInt(1), Number: big.NewInt(1), GasLimit: 8000000, Extra: []byte("legacy")}
+	blob, err := rlp.EncodeToBytes(legacy)
+	if err != nil {
+		t.Fatal("encode error: ", err)
+	}
+	var dec Header
+	if err := rlp.DecodeBytes(blob, &dec); err != nil {
+		t.Fatal("decode error: ", err)
+	}
+	if dec.BaseFee != nil || dec.Hash() != legacy.Hash() {
+		t.Fatalf("legacy header round trip mismatch: base fee %v, hash %x != %x", dec.BaseFee, dec.Hash(), legacy.Hash())
+	}
+	header := CopyHeader(legacy)
+	header.BaseFee = big.NewInt(1000000000)
+	if header.Hash() == legacy.Hash() {
+		t.Fatal("base fee not included in the header hash")
+	}
+	if blob, err = rlp.EncodeToBytes(header); err != nil {
+		t.Fatal("encode error: ", err)
+	}
+	if err := rlp.DecodeBytes(blob, &dec); err != nil {
+		t.Fatal("decode error: ", err)
+	}
+	if dec.BaseFee == nil || dec.BaseFee.Cmp(header.BaseFee) != 0 || dec.Hash() != header.Hash() {
+		t.Fatalf("header round trip mismatch: base fee %v, hash %x != %x", dec.BaseFee, dec.Hash(), header.Hash())
+	}
+}
+
 func TestUncleHash(t *testing.T) {
 	uncles := make([]*Header, 0)
 	h := CalcUncleHash(uncles)
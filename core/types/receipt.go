@@ -49,6 +49,7 @@ const (
 // Receipt represents the results of a transaction.
 type Receipt struct {
 	// Consensus fields: These fields are defined by the Yellow Paper
+	Type              uint8  `json:"type,omitempty"`
 	PostState         []byte `json:"root"`
 	Status            uint64 `json:"status"`
 	CumulativeGasUsed uint64 `json:"cumulativeGasUsed" gencodec:"required"`
@@ -69,6 +70,7 @@ type Receipt struct {
 }
 
 type receiptMarshaling struct {
+	Type              hexutil.Uint64
 	PostState         hexutil.Bytes
 	Status            hexutil.Uint64
 	CumulativeGasUsed hexutil.Uint64
@@ -92,6 +94,16 @@ type storedReceiptRLP struct {
 	Logs              []*LogForStorage
 }
 
+// typedStoredReceiptRLP is the storage encoding of a receipt of a typed transaction.
+// The type is not part of the legacy storage encoding, but it is needed to derive
+// the consensus encoding of the receipt without having the transaction at hand.
+type typedStoredReceiptRLP struct {
+	Type              uint8
+	PostStateOrStatus []byte
+	CumulativeGasUsed uint64
+	Logs              []*LogForStorage
+}
+
 // v4StoredReceiptRLP is the storage encoding of a receipt used in database version 4.
 type v4StoredReceiptRLP struct {
 	PostStateOrStatus []byte
@@ -126,17 +138,77 @@ func NewReceipt(root []byte, failed bool, cumulativeGasUsed uint64) *Receipt {
 
 // EncodeRLP implements rlp.Encoder, and flattens the consensus fields of a receipt
 // into an RLP stream. If no post state is present, byzantium fork is assumed.
+// Receipts of typed transactions are wrapped into an RLP string as per EIP-2718.
 func (r *Receipt) EncodeRLP(w io.Writer) error {
-	return rlp.Encode(w, &receiptRLP{r.statusEncoding(), r.CumulativeGasUsed, r.Bloom, r.Logs})
+	if r.Type == LegacyTxType {
+		return rlp.Encode(w, &receiptRLP{r.statusEncoding(), r.CumulativeGasUsed, r.Bloom, r.Logs})
+	}
+	blob, err := r.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return rlp.Encode(w, blob)
 }
 
 // DecodeRLP implements rlp.Decoder, and loads the consensus fields of a receipt
 // from an RLP stream.
 func (r *Receipt) DecodeRLP(s *rlp.Stream) error {
+	kind, _, err := s.Kind()
+	if err != nil {
+		return err
+	}
+	if kind == rlp.List {
+		var dec receiptRLP
+		if err := s.Decode(&dec); err != nil {
+			return err
+		}
+		r.Type = LegacyTxType
+		return r.setFromRLP(dec)
+	}
+	blob, err := s.Bytes()
+	if err != nil {
+		return err
+	}
+	return r.UnmarshalBinary(blob)
+}
+
+// MarshalBinary returns the canonical consensus encoding of the receipt: the RLP
+// list for receipts of legacy transactions and the transaction type followed by
+// the RLP list for typed ones. This is the format used within the receipt trie.
+func (r *Receipt) MarshalBinary() ([]byte, error) {
+	enc, err := rlp.EncodeToBytes(&receiptRLP{r.statusEncoding(), r.CumulativeGasUsed, r.Bloom, r.Logs})
+	if err != nil || r.Type == LegacyTxType {
+		return enc, err
+	}
+	return append([]byte{r.Type}, enc...), nil
+}
+
+// UnmarshalBinary decodes the canonical consensus encoding of a receipt, as
+// produced by MarshalBinary.
+func (r *Receipt) UnmarshalBinary(b []byte) error {
+	if len(b) > 0 && b[0] > 0x7f {
+		var dec receiptRLP
+		if err := rlp.DecodeBytes(b, &dec); err != nil {
+			return err
+		}
+		r.Type = LegacyTxType
+		return r.setFromRLP(dec)
+	}
+	if len(b) == 0 {
+		return errors.New("typed receipt too short")
+	}
+	if b[0] != AccessListTxType && b[0] != DynamicFeeTxType {
+		return ErrTxTypeNotSupported
+	}
 	var dec receiptRLP
-	if err := s.Decode(&dec); err != nil {
+	if err := rlp.DecodeBytes(b[1:], &dec); err != nil {
 		return err
 	}
+	r.Type = b[0]
+	return r.setFromRLP(dec)
+}
+
+func (r *Receipt) setFromRLP(dec receiptRLP) error {
 	if err := r.setStatus(dec.PostStateOrStatus); err != nil {
 		return err
 	}
@@ -185,17 +257,16 @@ func (r *Receipt) Size() common.StorageSize {
 type ReceiptForStorage Receipt
 
 // EncodeRLP implements rlp.Encoder, and flattens all content fields of a receipt
-// into an RLP stream.
+// into an RLP stream. Receipts of typed transactions are prefixed with their type.
 func (r *ReceiptForStorage) EncodeRLP(w io.Writer) error {
-	enc := &storedReceiptRLP{
-		PostStateOrStatus: (*Receipt)(r).statusEncoding(),
-		CumulativeGasUsed: r.CumulativeGasUsed,
-		Logs:              make([]*LogForStorage, len(r.Logs)),
-	}
+	logs := make([]*LogForStorage, len(r.Logs))
 	for i, log := range r.Logs {
-		enc.Logs[i] = (*LogForStorage)(log)
+		logs[i] = (*LogForStorage)(log)
+	}
+	if r.Type == LegacyTxType {
+		return rlp.Encode(w, &storedReceiptRLP{(*Receipt)(r).statusEncoding(), r.CumulativeGasUsed, logs})
 	}
-	return rlp.Encode(w, enc)
+	return rlp.Encode(w, &typedStoredReceiptRLP{r.Type, (*Receipt)(r).statusEncoding(), r.CumulativeGasUsed, logs})
 }
 
 // DecodeRLP implements rlp.Decoder, and loads both consensus and implementation
@@ -212,6 +283,9 @@ func (r *ReceiptForStorage) DecodeRLP(s *rlp.Stream) error {
 	if err := decodeStoredReceiptRLP(r, blob); err == nil {
 		return nil
 	}
+	if err := decodeTypedStoredReceiptRLP(r, blob); err == nil {
+		return nil
+	}
 	if err := decodeV3StoredReceiptRLP(r, blob); err == nil {
 		return nil
 	}
@@ -236,6 +310,28 @@ func decodeStoredReceiptRLP(r *ReceiptForStorage, blob []byte) error {
 	return nil
 }
 
+func decodeTypedStoredReceiptRLP(r *ReceiptForStorage, blob []byte) error {
+	var stored typedStoredReceiptRLP
+	if err := rlp.DecodeBytes(blob, &stored); err != nil {
+		return err
+	}
+	if stored.Type != AccessListTxType && stored.Type != DynamicFeeTxType {
+		return ErrTxTypeNotSupported
+	}
+	if err := (*Receipt)(r).setStatus(stored.PostStateOrStatus); err != nil {
+		return err
+	}
+	r.Type = stored.Type
+	r.CumulativeGasUsed = stored.CumulativeGasUsed
+	r.Logs = make([]*Log, len(stored.Logs))
+	for i, log := range stored.Logs {
+		r.Logs[i] = (*Log)(log)
+	}
+	r.Bloom = CreateBloom(Receipts{(*Receipt)(r)})
+
+	return nil
+}
+
 func decodeV4StoredReceiptRLP(r *ReceiptForStorage, blob []byte) error {
 	var stored v4StoredReceiptRLP
 	if err := rlp.DecodeBytes(blob, &stored); err != nil {
@@ -283,9 +379,10 @@ type Receipts []*Receipt
 // Len returns the number of receipts in this list.
 func (r Receipts) Len() int { return len(r) }
 
-// GetRlp returns the RLP encoding of one receipt from the list.
+// GetRlp returns the canonical encoding of one receipt from the list, which is
+// the RLP encoding for receipts of legacy transactions.
 func (r Receipts) GetRlp(i int) []byte {
-	bytes, err := rlp.EncodeToBytes(r[i])
+	bytes, err := r[i].MarshalBinary()
 	if err != nil {
 		panic(err)
 	}
@@ -302,7 +399,8 @@ func (r Receipts) DeriveFields(config *params.ChainConfig, hash common.Hash, num
 		return errors.New("transaction and receipt count mismatch")
 	}
 	for i := 0; i < len(r); i++ {
-		// The transaction hash can be retrieved from the transaction itself
+		// The transaction type and hash can be retrieved from the transaction itself
+		r[i].Type = txs[i].Type()
 		r[i].TxHash = txs[i].Hash()
 
 		// block location fields
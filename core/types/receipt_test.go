@@ -281,3 +281,97 @@ func clearComputedFieldsOnLog(t *testing.T, log *Log) {
 	log.TxIndex = math.MaxUint32
 	log.Index = math.MaxUint32
 }
+
+// Tests that receipts of typed transactions are encoded with their type prefix,
+// both standalone and within RLP lists, while legacy ones are left untouched.
+func TestTypedReceiptEncoding(t *testing.T) {
+	receipt := &Receipt{
+		Type:              AccessListTxType,
+		Status:            ReceiptStatusSuccessful,
+		CumulativeGasUsed: 1,
+		Logs: []*Log{
+			{Address: common.BytesToAddress([]byte{0x11}), Topics: []common.Hash{common.HexToHash("dead")}, Data: []byte{0x01, 0x00, 0xff}},
+		},
+	}
+	receipt.Bloom = CreateBloom(Receipts{receipt})
+
+	blob, err := receipt.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to encode receipt: %v", err)
+	}
+	if blob[0] != AccessListTxType {
+		t.Fatalf("envelope type mismatch: have %d, want %d", blob[0], AccessListTxType)
+	}
+	dec := new(Receipt)
+	if err := dec.UnmarshalBinary(blob); err != nil {
+		t.Fatalf("failed to decode receipt: %v", err)
+	}
+	if dec.Type != receipt.Type || dec.Status != receipt.Status || dec.CumulativeGasUsed != receipt.CumulativeGasUsed || dec.Bloom != receipt.Bloom || len(dec.Logs) != 1 {
+		t.Errorf("binary round trip mismatch: have %+v, want %+v", dec, receipt)
+	}
+	legacy := &Receipt{Status: ReceiptStatusFailed, CumulativeGasUsed: 2}
+	enc, err := rlp.EncodeToBytes(Receipts{receipt, legacy})
+	if err != nil {
+		t.Fatalf("failed to encode receipt list: %v", err)
+	}
+	var receipts Receipts
+	if err := rlp.DecodeBytes(enc, &receipts); err != nil {
+		t.Fatalf("failed to decode receipt list: %v", err)
+	}
+	if len(receipts) != 2 || receipts[0].Type != AccessListTxType || receipts[1].Type != LegacyTxType || receipts[1].CumulativeGasUsed != 2 {
+		t.Errorf("RLP round trip mismatch: have %+v", receipts)
+	}
+	if have, want := (Receipts{legacy}).GetRlp(0), mustEncodeRLP(t, legacy); !bytes.Equal(have, want) {
+		t.Errorf("legacy receipt encoding mismatch: have %x, want %x", have, want)
+	}
+}
+
+// Tests that receipts of all supported transaction types survive a round trip
+// through their consensus encoding.
+func TestTypedReceiptRoundTrip(t *testing.T) {
+	for _, typ := range []uint8{LegacyTxType, AccessListTxType, DynamicFeeTxType} {
+		receipt := &Receipt{
+			Type:              typ,
+			Status:            ReceiptStatusSuccessful,
+			CumulativeGasUsed: 1,
+			Logs: []*Log{
+				{Address: common.BytesToAddress([]byte{0x11}), Topics: []common.Hash{common.HexToHash("dead")}, Data: []byte{0x01, 0x00, 0xff}},
+			},
+		}
+		receipt.Bloom = CreateBloom(Receipts{receipt})
+
+		blob, err := receipt.MarshalBinary()
+		if err != nil {
+			t.Fatalf("type %d: failed to encode receipt: %v", typ, err)
+		}
+		dec := new(Receipt)
+		if err := dec.UnmarshalBinary(blob); err != nil {
+			t.Fatalf("type %d: failed to decode receipt: %v", typ, err)
+		}
+		if dec.Type != typ || dec.Status != receipt.Status || dec.CumulativeGasUsed != receipt.CumulativeGasUsed || dec.Bloom != receipt.Bloom || len(dec.Logs) != 1 {
+			t.Errorf("type %d: binary round trip mismatch: have %+v, want %+v", typ, dec, receipt)
+		}
+		enc, err := rlp.EncodeToBytes(receipt)
+		if err != nil {
+			t.Fatalf("type %d: failed to RLP encode receipt: %v", typ, err)
+		}
+		dec = new(Receipt)
+		if err := rlp.DecodeBytes(enc, dec); err != nil {
+			t.Fatalf("type %d: failed to RLP decode receipt: %v", typ, err)
+		}
+		if dec.Type != typ || dec.CumulativeGasUsed != receipt.CumulativeGasUsed {
+			t.Errorf("type %d: RLP round trip mismatch: have %+v, want %+v", typ, dec, receipt)
+		}
+	}
+	if err := new(Receipt).UnmarshalBinary([]byte{0x03, 0xc0}); err != ErrTxTypeNotSupported {
+		t.Errorf("unknown type error mismatch: have %v, want %v", err, ErrTxTypeNotSupported)
+	}
+}
+
+func mustEncodeRLP(t *testing.T, val interface{}) []byte {
+	enc, err := rlp.EncodeToBytes(val)
+	if err != nil {
+		t.Fatalf("failed to encode %v: %v", val, err)
+	}
+	return enc
+}
@@ -21,6 +21,7 @@ import (
 	"crypto/ecdsa"
 	"encoding/json"
 	"math/big"
+	"reflect"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -144,7 +145,7 @@ func TestTransactionPriceNonceSort(t *testing.T) {
 		}
 	}
 	// Sort the transactions and cross check the nonce ordering
-	txset := NewTransactionsByPriceAndNonce(signer, groups)
+	txset := NewTransactionsByPriceAndNonce(signer, groups, nil)
 
 	txs := Transactions{}
 	for tx := txset.Peek(); tx != nil; tx = txset.Peek() {
@@ -224,3 +225,192 @@ func TestTransactionJSON(t *testing.T) {
 		}
 	}
 }
+
+// Tests that access list transactions round-trip through their binary, RLP and
+// JSON encodings, and that only the EIP-2930 signer accepts them.
+func TestAccessListTransactionEncoding(t *testing.T) {
+	key, addr := defaultTestKey()
+	signer := NewEIP2930Signer(big.NewInt(18))
+
+	to := common.HexToAddress("095e7baea6a6c7c4c2dfeb977efac326af552d87")
+	accesses := AccessList{
+		{Address: to, StorageKeys: []common.Hash{{0x01}, {0x02}}},
+		{Address: common.Address{0xaa}, StorageKeys: []common.Hash{}},
+	}
+	tx, err := SignTx(NewAccessListTransaction(big.NewInt(18), 3, &to, big.NewInt(10), 50000, big.NewInt(1), []byte{0x55, 0x44}, accesses), signer, key)
+	if err != nil {
+		t.Fatalf("could not sign transaction: %v", err)
+	}
+	if tx.Type() != AccessListTxType {
+		t.Fatalf("transaction type mismatch: have %d, want %d", tx.Type(), AccessListTxType)
+	}
+	if from, err := Sender(signer, tx); err != nil || from != addr {
+		t.Fatalf("sender mismatch: have %x (%v), want %x", from, err, addr)
+	}
+	if _, err := Sender(NewEIP155Signer(big.NewInt(18)), tx); err != ErrTxTypeNotSupported {
+		t.Fatalf("legacy signer error mismatch: have %v, want %v", err, ErrTxTypeNotSupported)
+	}
+	if _, err := Sender(NewEIP2930Signer(big.NewInt(1)), tx); err != ErrInvalidChainId {
+		t.Fatalf("foreign chain signer error mismatch: have %v, want %v", err, ErrInvalidChainId)
+	}
+	// Check the canonical binary encoding
+	blob, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to encode transaction: %v", err)
+	}
+	if blob[0] != AccessListTxType {
+		t.Fatalf("envelope type mismatch: have %d, want %d", blob[0], AccessListTxType)
+	}
+	parsed := new(Transaction)
+	if err := parsed.UnmarshalBinary(blob); err != nil {
+		t.Fatalf("failed to decode transaction: %v", err)
+	}
+	if parsed.Hash() != tx.Hash() {
+		t.Errorf("binary round trip hash mismatch: have %x, want %x", parsed.Hash(), tx.Hash())
+	}
+	if !reflect.DeepEqual(parsed.AccessList(), accesses) {
+		t.Errorf("binary round trip access list mismatch: have %v, want %v", parsed.AccessList(), accesses)
+	}
+	// Check the encoding within RLP lists (e.g. block bodies)
+	enc, err := rlp.EncodeToBytes(Transactions{tx, rightvrsTx})
+	if err != nil {
+		t.Fatalf("failed to encode transaction list: %v", err)
+	}
+	var txs Transactions
+	if err := rlp.DecodeBytes(enc, &txs); err != nil {
+		t.Fatalf("failed to decode transaction list: %v", err)
+	}
+	if len(txs) != 2 || txs[0].Hash() != tx.Hash() || txs[1].Hash() != rightvrsTx.Hash() {
+		t.Errorf("RLP round trip mismatch: have %v", txs)
+	}
+	// Check the JSON encoding
+	data, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	parsed = new(Transaction)
+	if err := json.Unmarshal(data, parsed); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if parsed.Hash() != tx.Hash() {
+		t.Errorf("JSON round trip hash mismatch: have %x, want %x", parsed.Hash(), tx.Hash())
+	}
+	if parsed.ChainId().Cmp(tx.ChainId()) != 0 {
+		t.Errorf("JSON round trip chain id mismatch: have %v, want %v", parsed.ChainId(), tx.ChainId())
+	}
+	// Legacy transactions must keep their plain RLP encoding
+	if blob, _ := rightvrsTx.MarshalBinary(); !bytes.Equal(blob, common.FromHex("f86103018207d094b94f5374fce5edbc8e2a8697c15331677e6ebf0b0a8255441ca098ff921201554726367d2be8c804a7ff89ccf285ebc57dff8ae4c44b9c19ac4aa08887321be575c8095f789dd4c743dfe42c1820f9231f98a962b210e3ac2452a3")) {
+		t.Errorf("legacy binary encoding mismatch, got %x", blob)
+	}
+}
+
+func TestDynamicFeeTransactionEncoding(t *testing.T) {
+	key, addr := defaultTestKey()
+	signer := NewEIP1559Signer(big.NewInt(18))
+
+	to := common.HexToAddress("095e7baea6a6c7c4c2dfeb977efac326af552d87")
+	accesses := AccessList{{Address: to, StorageKeys: []common.Hash{{0x01}}}}
+	tx, err := SignTx(NewDynamicFeeTransaction(big.NewInt(18), 3, &to, big.NewInt(10), 50000, big.NewInt(2), big.NewInt(10), []byte{0x55, 0x44}, accesses), signer, key)
+	if err != nil {
+		t.Fatalf("could not sign transaction: %v", err)
+	}
+	if tx.Type() != DynamicFeeTxType {
+		t.Fatalf("transaction type mismatch: have %d, want %d", tx.Type(), DynamicFeeTxType)
+	}
+	if from, err := Sender(signer, tx); err != nil || from != addr {
+		t.Fatalf("sender mismatch: have %x (%v), want %x", from, err, addr)
+	}
+	if _, err := Sender(NewEIP2930Signer(big.NewInt(18)), tx); err != ErrTxTypeNotSupported {
+		t.Fatalf("access list signer error mismatch: have %v, want %v", err, ErrTxTypeNotSupported)
+	}
+	// Check the fee accessors and the effective tip at various base fees
+	if tx.GasTipCap().Cmp(big.NewInt(2)) != 0 || tx.GasFeeCap().Cmp(big.NewInt(10)) != 0 {
+		t.Fatalf("fee caps mismatch: have tip %v, cap %v", tx.GasTipCap(), tx.GasFeeCap())
+	}
+	for i, test := range []struct {
+		baseFee *big.Int
+		tip     *big.Int
+		price   *big.Int
+		err     error
+	}{
+		{nil, big.NewInt(2), big.NewInt(10), nil},
+		{big.NewInt(5), big.NewInt(2), big.NewInt(7), nil},
+		{big.NewInt(9), big.NewInt(1), big.NewInt(10), nil},
+		{big.NewInt(11), nil, big.NewInt(10), ErrGasFeeCapTooLow},
+	} {
+		tip, err := tx.EffectiveGasTip(test.baseFee)
+		if err != test.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, test.err)
+		}
+		if test.tip != nil && tip.Cmp(test.tip) != 0 {
+			t.Errorf("test %d: tip mismatch: have %v, want %v", i, tip, test.tip)
+		}
+		if price := tx.EffectiveGasPrice(test.baseFee); price.Cmp(test.price) != 0 {
+			t.Errorf("test %d: price mismatch: have %v, want %v", i, price, test.price)
+		}
+	}
+	// Check the binary and JSON round trips
+	blob, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to encode transaction: %v", err)
+	}
+	if blob[0] != DynamicFeeTxType {
+		t.Fatalf("envelope type mismatch: have %d, want %d", blob[0], DynamicFeeTxType)
+	}
+	parsed := new(Transaction)
+	if err := parsed.UnmarshalBinary(blob); err != nil {
+		t.Fatalf("failed to decode transaction: %v", err)
+	}
+	if parsed.Hash() != tx.Hash() {
+		t.Errorf("binary round trip hash mismatch: have %x, want %x", parsed.Hash(), tx.Hash())
+	}
+	data, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	parsed = new(Transaction)
+	if err := json.Unmarshal(data, parsed); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if parsed.Hash() != tx.Hash() {
+		t.Errorf("JSON round trip hash mismatch: have %x, want %x", parsed.Hash(), tx.Hash())
+	}
+	if parsed.GasTipCap().Cmp(tx.GasTipCap()) != 0 {
+		t.Errorf("JSON round trip tip mismatch: have %v, want %v", parsed.GasTipCap(), tx.GasTipCap())
+	}
+}
+
+// Tests that with a base fee, transactions are ordered by the tip they pay the
+// miner, and accounts unable to pay the base fee are skipped.
+func TestTransactionPriceNonceSortBaseFee(t *testing.T) {
+	signer := NewEIP1559Signer(big.NewInt(1))
+
+	keys := make([]*ecdsa.PrivateKey, 3)
+	for i := 0; i < len(keys); i++ {
+		keys[i], _ = crypto.GenerateKey()
+	}
+	// Account 0 pays a high fee cap but a small tip, account 1 a lower fee cap
+	// with a higher effective tip, account 2 can't afford the base fee
+	baseFee := big.NewInt(10)
+	caps := []struct{ tip, feeCap int64 }{{1, 100}, {5, 14}, {50, 9}}
+
+	groups := map[common.Address]Transactions{}
+	for i, key := range keys {
+		tx := NewDynamicFeeTransaction(big.NewInt(1), 0, &common.Address{}, big.NewInt(100), 100, big.NewInt(caps[i].tip), big.NewInt(caps[i].feeCap), nil, nil)
+		tx, _ = SignTx(tx, signer, key)
+		groups[crypto.PubkeyToAddress(key.PublicKey)] = Transactions{tx}
+	}
+	txset := NewTransactionsByPriceAndNonce(signer, groups, baseFee)
+
+	var txs Transactions
+	for tx := txset.Peek(); tx != nil; tx = txset.Peek() {
+		txs = append(txs, tx)
+		txset.Shift()
+	}
+	if len(txs) != 2 {
+		t.Fatalf("expected 2 transactions, found %d", len(txs))
+	}
+	if txs[0].GasTipCap().Int64() != 5 || txs[1].GasTipCap().Int64() != 1 {
+		t.Errorf("transaction order mismatch: have tips %v, %v", txs[0].GasTipCap(), txs[1].GasTipCap())
+	}
+}
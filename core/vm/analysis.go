@@ -16,6 +16,32 @@
 
 package vm
 
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// analysisCacheSize is the number of analysed contract codes to retain per
+// instruction set.
+const analysisCacheSize = 1024
+
+// Caches of analysed code per instruction set, keyed by code hash. The results
+// of the analysis depend on the static gas costs and stack requirements of the
+// opcodes, so code cannot be shared between the instruction sets.
+var (
+	frontierAnalyses       = newAnalysisCache()
+	homesteadAnalyses      = newAnalysisCache()
+	byzantiumAnalyses      = newAnalysisCache()
+	constantinopleAnalyses = newAnalysisCache()
+)
+
+// newAnalysisCache creates an LRU cache for analysed code.
+func newAnalysisCache() *lru.Cache {
+	cache, _ := lru.New(analysisCacheSize)
+	return cache
+}
+
 // bitvec is a bit vector which maps bytes in a program.
 // An unset bit means the byte is an opcode, a set bit means
 // it's data (i.e. argument of PUSHxx).
@@ -60,3 +86,108 @@ func codeBitmap(code []byte) bitvec {
 	}
 	return bits
 }
+
+// basicBlock is a straight sequence of operations that, once entered, is always
+// executed to its end unless the last operation fails. Since none of the inner
+// operations can fail nor observe the remaining gas, the stack requirements and
+// the static gas of the entire block can be validated and charged up front.
+type basicBlock struct {
+	start    uint64 // Program counter of the first operation
+	ops      int    // Number of operations in the block
+	gas      uint64 // Sum of the static gas costs of the operations
+	minStack int    // Minimum stack size required on entry
+	maxStack int    // Maximum stack size allowed on entry
+}
+
+// codeAnalysis is the result of analysing a piece of code for a given
+// instruction set. It is immutable and can be shared between executions.
+type codeAnalysis struct {
+	jumpdests bitvec       // Data locations in the code, see codeBitmap
+	blocks    []basicBlock // Basic blocks of the code, ordered by start position
+}
+
+// analyseCode collects the data locations and basic blocks of the code.
+func analyseCode(code []byte, jt *[256]operation) *codeAnalysis {
+	analysis := &codeAnalysis{jumpdests: codeBitmap(code)}
+
+	var (
+		block  *basicBlock // Block being assembled, nil if none is open
+		height int         // Stack height relative to the entry of the block
+	)
+	for pc := uint64(0); pc < uint64(len(code)); {
+		op := OpCode(code[pc])
+		operation := &jt[op]
+
+		// Jump destinations always start a new block, whereas invalid opcodes are
+		// left out, their error being reported by the regular per operation checks
+		if op == JUMPDEST || !operation.valid {
+			block = nil
+		}
+		if !operation.valid {
+			pc++
+			continue
+		}
+		if block == nil {
+			analysis.blocks = append(analysis.blocks, basicBlock{start: pc, maxStack: int(params.StackLimit)})
+			block, height = &analysis.blocks[len(analysis.blocks)-1], 0
+		}
+		block.ops++
+		block.gas += operation.constantGas
+		if need := operation.minStack - height; need > block.minStack {
+			block.minStack = need
+		}
+		if room := operation.maxStack - height; room < block.maxStack {
+			block.maxStack = room
+		}
+		height += int(params.StackLimit) - operation.maxStack
+
+		// Close the block after any operation that may fail, alter the control
+		// flow or depend on the remaining gas
+		if operation.jumps || operation.halts || operation.reverts || operation.writes || operation.returns ||
+			operation.dynamicGas != nil || operation.memorySize != nil || op == GAS {
+			block = nil
+		}
+		if op >= PUSH1 && op <= PUSH32 {
+			pc += uint64(op-PUSH1) + 2
+		} else {
+			pc++
+		}
+	}
+	return analysis
+}
+
+// blockAt returns the index of the basic block starting at the given position,
+// or -1 if there is none. The hint is checked first, being the most likely one.
+func (c *codeAnalysis) blockAt(pc uint64, hint int) int {
+	if hint >= 0 && hint < len(c.blocks) && c.blocks[hint].start == pc {
+		return hint
+	}
+	lo, hi := 0, len(c.blocks)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if c.blocks[mid].start < pc {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo < len(c.blocks) && c.blocks[lo].start == pc {
+		return lo
+	}
+	return -1
+}
+
+// analysedCode returns the analysis of the given code, reusing the one cached for
+// the code hash if available. Code without a hash, most likely a piece of
+// initcode not already in the state trie, is analysed without caching.
+func analysedCode(cache *lru.Cache, hash common.Hash, code []byte, jt *[256]operation) *codeAnalysis {
+	if hash == (common.Hash{}) || cache == nil {
+		return analyseCode(code, jt)
+	}
+	if analysis, ok := cache.Get(hash); ok {
+		return analysis.(*codeAnalysis)
+	}
+	analysis := analyseCode(code, jt)
+	cache.Add(hash, analysis)
+	return analysis
+}
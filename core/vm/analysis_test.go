@@ -17,6 +17,7 @@
 package vm
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/crypto"
@@ -55,6 +56,43 @@ func TestJumpDestAnalysis(t *testing.T) {
 	}
 }
 
+func TestBasicBlockAnalysis(t *testing.T) {
+	code := []byte{
+		byte(PUSH1), 0x01, // 0: block 0
+		byte(PUSH2), byte(JUMPDEST), 0x00, // 2: jumpdest in push data
+		byte(ADD),         // 5
+		byte(GAS),         // 6: observes gas, closes block 0
+		byte(POP),         // 7: block 1
+		byte(POP),         // 8
+		byte(JUMPDEST),    // 9: block 2
+		byte(DUP1),        // 10
+		byte(PUSH1), 0x09, // 11
+		byte(JUMPI),  // 13: jumps, closes block 2
+		byte(0xfe),   // 14: invalid, left out
+		byte(MSTORE), // 15: block 3, memory expansion closes it
+		byte(STOP),   // 16: block 4
+	}
+	want := []basicBlock{
+		{start: 0, ops: 4, gas: 3*GasFastestStep + GasQuickStep, minStack: 0, maxStack: 1022},
+		{start: 7, ops: 2, gas: 2 * GasQuickStep, minStack: 2, maxStack: 1024},
+		{start: 9, ops: 4, gas: 1 + 2*GasFastestStep + GasSlowStep, minStack: 1, maxStack: 1022},
+		{start: 15, ops: 1, gas: 0, minStack: 2, maxStack: 1024},
+		{start: 16, ops: 1, gas: 0, minStack: 0, maxStack: 1024},
+	}
+	analysis := analyseCode(code, &constantinopleInstructionSet)
+	if !reflect.DeepEqual(analysis.blocks, want) {
+		t.Fatalf("basic block mismatch:\nhave %+v\nwant %+v", analysis.blocks, want)
+	}
+	for i, block := range want {
+		if have := analysis.blockAt(block.start, 0); have != i {
+			t.Errorf("block %d lookup mismatch: have %d", i, have)
+		}
+	}
+	if have := analysis.blockAt(3, 0); have != -1 {
+		t.Errorf("push data lookup mismatch: have %d, want -1", have)
+	}
+}
+
 func BenchmarkJumpdestAnalysis_1200k(bench *testing.B) {
 	// 1.4 ms
 	code := make([]byte, 1200000)
@@ -53,6 +53,36 @@ func memoryGasCost(mem *Memory, newMemSize uint64) (uint64, error) {
 	return 0, nil
 }
 
+// accessAddress adds the address to the access list of the current transaction,
+// returning whether it was cold (i.e. not accessed before). Prior to EIP-2929
+// the access list is not maintained and all accesses are considered warm.
+func accessAddress(evm *EVM, addr common.Address) bool {
+	if !evm.chainRules.IsEIP2930 || evm.StateDB.AddressInAccessList(addr) {
+		return false
+	}
+	evm.StateDB.AddAddressToAccessList(addr)
+	return true
+}
+
+// accessSlot adds the storage slot of the address to the access list of the
+// current transaction, returning whether it was cold (i.e. not accessed before).
+// Prior to EIP-2929 the access list is not maintained and all accesses are
+// considered warm.
+func accessSlot(evm *EVM, addr common.Address, slot common.Hash) bool {
+	if !evm.chainRules.IsEIP2930 {
+		return false
+	}
+	if _, ok := evm.StateDB.SlotInAccessList(addr, slot); ok {
+		return false
+	}
+	evm.StateDB.AddSlotToAccessList(addr, slot)
+	return true
+}
+
+// coldAccountSurcharge is the extra cost of accessing a cold account on top of
+// the warm access cost charged by the gas table.
+const coldAccountSurcharge = params.ColdAccountAccessCostEIP2929 - params.WarmStorageReadCostEIP2929
+
 func gasCallDataCopy(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
 	gas, err := memoryGasCost(mem, memorySize)
 	if err != nil {
@@ -110,6 +140,30 @@ func gasSStore(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, m
 		y, x    = stack.Back(1), stack.Back(0)
 		current = evm.StateDB.GetState(contract.Address(), common.BigToHash(x))
 	)
+	// Accessing a cold storage slot incurs an additional cost (EIP-2929). Since
+	// the read is charged separately, it's excluded from the cost of modifying an
+	// existing slot, and the writes only touching a warm slot cost a warm read.
+	var cold uint64
+	if accessSlot(evm, contract.Address(), common.BigToHash(x)) {
+		cold = params.ColdSloadCostEIP2929
+	}
+	var (
+		reset = params.SstoreResetGas    // Modifying an existing slot (legacy)
+		clean = params.NetSstoreCleanGas // Modifying an unchanged existing slot (net)
+		noop  = params.NetSstoreNoopGas  // Writing the current value (net)
+		dirty = params.NetSstoreDirtyGas // Modifying an already changed slot (net)
+
+		resetRefund      = params.NetSstoreResetRefund      // Restoring an existing slot (net)
+		resetClearRefund = params.NetSstoreResetClearRefund // Restoring an inexistent slot (net)
+	)
+	if evm.chainRules.IsEIP2930 {
+		reset -= params.ColdSloadCostEIP2929
+		clean -= params.ColdSloadCostEIP2929
+		noop, dirty = params.WarmStorageReadCostEIP2929, params.WarmStorageReadCostEIP2929
+
+		// Restoring a slot refunds its write price minus the dirty write price
+		resetRefund, resetClearRefund = clean-dirty, params.NetSstoreInitGas-dirty
+	}
 	// The legacy gas metering only takes into consideration the current state
 	// Legacy rules should be applied if we are in Petersburg (removal of EIP-1283)
 	// OR Constantinople is not active
@@ -121,12 +175,12 @@ func gasSStore(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, m
 		// 3. From a non-zero to a non-zero                         (CHANGE)
 		switch {
 		case current == (common.Hash{}) && y.Sign() != 0: // 0 => non 0
-			return params.SstoreSetGas, nil
+			return cold + params.SstoreSetGas, nil
 		case current != (common.Hash{}) && y.Sign() == 0: // non 0 => 0
 			evm.StateDB.AddRefund(params.SstoreRefundGas)
-			return params.SstoreClearGas, nil
+			return cold + reset, nil
 		default: // non 0 => non 0 (or 0 => 0)
-			return params.SstoreResetGas, nil
+			return cold + reset, nil
 		}
 	}
 	// The new gas metering is based on net gas costs (EIP-1283):
@@ -145,17 +199,17 @@ func gasSStore(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, m
 	// 	     2.2.2.2. Otherwise, add 4800 gas to refund counter.
 	value := common.BigToHash(y)
 	if current == value { // noop (1)
-		return params.NetSstoreNoopGas, nil
+		return cold + noop, nil
 	}
 	original := evm.StateDB.GetCommittedState(contract.Address(), common.BigToHash(x))
 	if original == current {
 		if original == (common.Hash{}) { // create slot (2.1.1)
-			return params.NetSstoreInitGas, nil
+			return cold + params.NetSstoreInitGas, nil
 		}
 		if value == (common.Hash{}) { // delete slot (2.1.2b)
 			evm.StateDB.AddRefund(params.NetSstoreClearRefund)
 		}
-		return params.NetSstoreCleanGas, nil // write existing slot (2.1.2)
+		return cold + clean, nil // write existing slot (2.1.2)
 	}
 	if original != (common.Hash{}) {
 		if current == (common.Hash{}) { // recreate slot (2.2.1.1)
@@ -166,12 +220,12 @@ func gasSStore(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, m
 	}
 	if original == value {
 		if original == (common.Hash{}) { // reset to original inexistent slot (2.2.2.1)
-			evm.StateDB.AddRefund(params.NetSstoreResetClearRefund)
+			evm.StateDB.AddRefund(resetClearRefund)
 		} else { // reset to original existing slot (2.2.2.2)
-			evm.StateDB.AddRefund(params.NetSstoreResetRefund)
+			evm.StateDB.AddRefund(resetRefund)
 		}
 	}
-	return params.NetSstoreDirtyGas, nil
+	return cold + dirty, nil
 }
 
 func makeGasLog(n uint64) gasFunc {
@@ -262,6 +316,11 @@ func gasExtCodeCopy(gt params.GasTable, evm *EVM, contract *Contract, stack *Sta
 	if gas, overflow = math.SafeAdd(gas, gt.ExtcodeCopy); overflow {
 		return 0, errGasUintOverflow
 	}
+	if accessAddress(evm, common.BigToAddress(stack.Back(0))) {
+		if gas, overflow = math.SafeAdd(gas, coldAccountSurcharge); overflow {
+			return 0, errGasUintOverflow
+		}
+	}
 
 	wordGas, overflow := bigUint64(stack.Back(3))
 	if overflow {
@@ -279,6 +338,9 @@ func gasExtCodeCopy(gt params.GasTable, evm *EVM, contract *Contract, stack *Sta
 }
 
 func gasExtCodeHash(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	if accessAddress(evm, common.BigToAddress(stack.Back(0))) {
+		return gt.ExtcodeHash + coldAccountSurcharge, nil
+	}
 	return gt.ExtcodeHash, nil
 }
 
@@ -354,14 +416,23 @@ func gasCreate2(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack,
 }
 
 func gasBalance(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	if accessAddress(evm, common.BigToAddress(stack.Back(0))) {
+		return gt.Balance + coldAccountSurcharge, nil
+	}
 	return gt.Balance, nil
 }
 
 func gasExtCodeSize(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	if accessAddress(evm, common.BigToAddress(stack.Back(0))) {
+		return gt.ExtcodeSize + coldAccountSurcharge, nil
+	}
 	return gt.ExtcodeSize, nil
 }
 
 func gasSLoad(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	if accessSlot(evm, contract.Address(), common.BigToHash(stack.Back(0))) {
+		return gt.SLoad + params.ColdSloadCostEIP2929 - params.WarmStorageReadCostEIP2929, nil
+	}
 	return gt.SLoad, nil
 }
 
@@ -385,6 +456,9 @@ func gasCall(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem
 		address        = common.BigToAddress(stack.Back(1))
 		eip158         = evm.ChainConfig().IsEIP158(evm.BlockNumber)
 	)
+	if accessAddress(evm, address) {
+		gas += coldAccountSurcharge
+	}
 	if eip158 {
 		if transfersValue && evm.StateDB.Empty(address) {
 			gas += params.CallNewAccountGas
@@ -416,6 +490,9 @@ func gasCall(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem
 
 func gasCallCode(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
 	gas := gt.Calls
+	if accessAddress(evm, common.BigToAddress(stack.Back(1))) {
+		gas += coldAccountSurcharge
+	}
 	if stack.Back(2).Sign() != 0 {
 		gas += params.CallValueTransferGas
 	}
@@ -455,6 +532,9 @@ func gasSuicide(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack,
 			address = common.BigToAddress(stack.Back(0))
 			eip158  = evm.ChainConfig().IsEIP158(evm.BlockNumber)
 		)
+		if accessAddress(evm, address) {
+			gas += params.ColdAccountAccessCostEIP2929
+		}
 
 		if eip158 {
 			// if empty and transfers value
@@ -481,6 +561,11 @@ func gasDelegateCall(gt params.GasTable, evm *EVM, contract *Contract, stack *St
 	if gas, overflow = math.SafeAdd(gas, gt.Calls); overflow {
 		return 0, errGasUintOverflow
 	}
+	if accessAddress(evm, common.BigToAddress(stack.Back(1))) {
+		if gas, overflow = math.SafeAdd(gas, coldAccountSurcharge); overflow {
+			return 0, errGasUintOverflow
+		}
+	}
 
 	evm.callGasTemp, err = callGas(gt, contract.Gas, gas, stack.Back(0))
 	if err != nil {
@@ -501,6 +586,11 @@ func gasStaticCall(gt params.GasTable, evm *EVM, contract *Contract, stack *Stac
 	if gas, overflow = math.SafeAdd(gas, gt.Calls); overflow {
 		return 0, errGasUintOverflow
 	}
+	if accessAddress(evm, common.BigToAddress(stack.Back(1))) {
+		if gas, overflow = math.SafeAdd(gas, coldAccountSurcharge); overflow {
+			return 0, errGasUintOverflow
+		}
+	}
 
 	evm.callGasTemp, err = callGas(gt, contract.Gas, gas, stack.Back(0))
 	if err != nil {
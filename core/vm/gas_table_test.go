@@ -16,7 +16,15 @@
 
 package vm
 
-import "testing"
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/params"
+)
 
 func TestMemoryGasCost(t *testing.T) {
 	tests := []struct {
@@ -37,3 +45,120 @@ func TestMemoryGasCost(t *testing.T) {
 		}
 	}
 }
+
+var eip2929Tests = []struct {
+	code string
+	gas  uint64
+}{
+	{"0x60bb315060bb315000", 3 + 2600 + 2 + 3 + 100 + 2},           // BALANCE of a cold, then warm account
+	{"0x30315000", 2 + 100 + 2},                                    // BALANCE of the contract itself
+	{"0x60013b5000", 3 + 100 + 2},                                  // EXTCODESIZE of a precompile
+	{"0x600054506000545000", 3 + 2100 + 2 + 3 + 100 + 2},           // SLOAD of a cold, then warm slot
+	{"0x6001600055", 3 + 3 + 2100 + 20000},                         // SSTORE into a cold slot
+	{"0x600054506001600055", 3 + 2100 + 2 + 3 + 3 + 20000},         // SSTORE into a warm slot
+	{"0x6000600060006000600060bb6000f15000", 7*3 + 100 + 2500 + 2}, // CALL to a cold account
+}
+
+// Tests the warm/cold state access gas accounting of EIP-2929.
+func TestEIP2929(t *testing.T) {
+	config := *params.AllEthashProtocolChanges
+	config.EIP2930Block = big.NewInt(0)
+
+	for i, tt := range eip2929Tests {
+		address := common.BytesToAddress([]byte("contract"))
+		caller := common.BytesToAddress([]byte("caller"))
+
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		statedb.CreateAccount(address)
+		statedb.SetCode(address, common.FromHex(tt.code))
+		statedb.Finalise(true)
+
+		vmctx := Context{
+			CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+			Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+			BlockNumber: big.NewInt(0),
+		}
+		vmenv := NewEVM(vmctx, statedb, &config, Config{})
+		statedb.PrepareAccessList(caller, &address, ActivePrecompiles(vmenv.chainRules), nil)
+
+		_, gas, err := vmenv.Call(AccountRef(caller), address, nil, 100000, new(big.Int))
+		if err != nil {
+			t.Errorf("test %d: execution failed: %v", i, err)
+		}
+		if used := 100000 - gas; used != tt.gas {
+			t.Errorf("test %d: gas used mismatch: have %v, want %v", i, used, tt.gas)
+		}
+	}
+}
+
+var eip2929SStoreTests = []struct {
+	petersburg bool   // Whether the legacy or the net gas metering applies
+	original   byte   // Value of the slot before the execution
+	warm       bool   // Whether the slot is in the access list before the execution
+	code       string // Code storing into slot 0
+	gas        uint64
+	refund     uint64
+}{
+	// Legacy gas metering
+	{true, 0, false, "0x6001600055", 3 + 3 + 2100 + 20000, 0},              // Create cold slot
+	{true, 0, true, "0x6001600055", 3 + 3 + 20000, 0},                      // Create warm slot
+	{true, 1, false, "0x6002600055", 3 + 3 + 2100 + 2900, 0},               // Modify cold slot
+	{true, 1, true, "0x6002600055", 3 + 3 + 2900, 0},                       // Modify warm slot
+	{true, 1, false, "0x6000600055", 3 + 3 + 2100 + 2900, 15000},           // Delete cold slot
+	{true, 0, true, "0x6000600055", 3 + 3 + 2900, 0},                       // Zero to zero
+	{true, 1, false, "0x60026000556003600055", 2*(3+3) + 2100 + 2*2900, 0}, // Modify twice
+
+	// Net gas metering
+	{false, 1, false, "0x6001600055", 3 + 3 + 2100 + 100, 0},                                   // Noop in cold slot
+	{false, 1, true, "0x6001600055", 3 + 3 + 100, 0},                                           // Noop in warm slot
+	{false, 0, false, "0x6001600055", 3 + 3 + 2100 + 20000, 0},                                 // Create cold slot
+	{false, 0, true, "0x6001600055", 3 + 3 + 20000, 0},                                         // Create warm slot
+	{false, 1, false, "0x6002600055", 3 + 3 + 2100 + 2900, 0},                                  // Modify clean cold slot
+	{false, 1, true, "0x6002600055", 3 + 3 + 2900, 0},                                          // Modify clean warm slot
+	{false, 1, false, "0x6000600055", 3 + 3 + 2100 + 2900, 15000},                              // Delete clean cold slot
+	{false, 1, false, "0x60026000556003600055", 2*(3+3) + 2100 + 2900 + 100, 0},                // Modify dirty slot
+	{false, 1, false, "0x60026000556001600055", 2*(3+3) + 2100 + 2900 + 100, 2800},             // Restore existing slot
+	{false, 0, true, "0x60016000556000600055", 2*(3+3) + 20000 + 100, 19900},                   // Restore inexistent slot
+	{false, 1, false, "0x600060005560006000556001600055", 3*(3+3) + 2100 + 2900 + 2*100, 2800}, // Delete, noop, restore
+}
+
+// Tests the EIP-2929 pricing of SSTORE into cold and warm slots, under both the
+// legacy and the net gas metering.
+func TestEIP2929SStore(t *testing.T) {
+	for i, tt := range eip2929SStoreTests {
+		config := *params.AllEthashProtocolChanges
+		config.EIP2930Block = big.NewInt(0)
+		if !tt.petersburg {
+			config.PetersburgBlock = big.NewInt(1)
+		}
+		address := common.BytesToAddress([]byte("contract"))
+		caller := common.BytesToAddress([]byte("caller"))
+
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		statedb.CreateAccount(address)
+		statedb.SetCode(address, common.FromHex(tt.code))
+		statedb.SetState(address, common.Hash{}, common.BytesToHash([]byte{tt.original}))
+		statedb.Finalise(true)
+
+		vmctx := Context{
+			CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+			Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+			BlockNumber: big.NewInt(0),
+		}
+		vmenv := NewEVM(vmctx, statedb, &config, Config{})
+		statedb.PrepareAccessList(caller, &address, ActivePrecompiles(vmenv.chainRules), nil)
+		if tt.warm {
+			statedb.AddSlotToAccessList(address, common.Hash{})
+		}
+		_, gas, err := vmenv.Call(AccountRef(caller), address, nil, 100000, new(big.Int))
+		if err != nil {
+			t.Errorf("test %d: execution failed: %v", i, err)
+		}
+		if used := 100000 - gas; used != tt.gas {
+			t.Errorf("test %d: gas used mismatch: have %v, want %v", i, used, tt.gas)
+		}
+		if refund := statedb.GetRefund(); refund != tt.refund {
+			t.Errorf("test %d: gas refund mismatch: have %v, want %v", i, refund, tt.refund)
+		}
+	}
+}
@@ -64,6 +64,22 @@ type StateDB interface {
 	AddPreimage(common.Hash, []byte)
 
 	ForEachStorage(common.Address, func(common.Hash, common.Hash) bool) error
+
+	// PrepareAccessList resets the access list for a new transaction and warms
+	// up the sender, destination, precompiles and the transaction's access list.
+	PrepareAccessList(sender common.Address, dst *common.Address, precompiles []common.Address, list types.AccessList)
+	// AddressInAccessList reports whether the address was already accessed by
+	// the current transaction (EIP-2929).
+	AddressInAccessList(addr common.Address) bool
+	// SlotInAccessList reports whether the address and the storage slot were
+	// already accessed by the current transaction (EIP-2929).
+	SlotInAccessList(addr common.Address, slot common.Hash) (addressOk bool, slotOk bool)
+	// AddAddressToAccessList adds the given address to the access list. This
+	// operation is journalled and reverted along with the rest of the state.
+	AddAddressToAccessList(addr common.Address)
+	// AddSlotToAccessList adds the given (address,slot) to the access list. This
+	// operation is journalled and reverted along with the rest of the state.
+	AddSlotToAccessList(addr common.Address, slot common.Hash)
 }
 
 // CallContext provides a basic interface for the EVM calling conventions. The EVM
@@ -24,6 +24,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/params"
+	lru "github.com/hashicorp/golang-lru"
 )
 
 // Config are the configuration options for the Interpreter
@@ -32,6 +33,9 @@ type Config struct {
 	Tracer                  Tracer // Opcode logger
 	NoRecursion             bool   // Disables call, callcode, delegate call and create
 	EnablePreimageRecording bool   // Enables recording of SHA3/keccak preimages
+	NoBaseFee               bool   // Allows zero fee messages below the base fee (calls, gas estimation)
+
+	Profiler *Profiler // Per opcode and per contract execution statistics collector
 
 	JumpTable [256]operation // EVM instruction table, automatically populated if unset
 
@@ -75,37 +79,46 @@ type EVMInterpreter struct {
 	cfg      Config
 	gasTable params.GasTable
 
-	intPool *intPool
+	intPool  *intPool
+	analyses *lru.Cache // Analysed code for the instruction set in use, keyed by code hash
 
 	hasher    keccakState // Keccak256 hasher instance shared across opcodes
 	hasherBuf common.Hash // Keccak256 hasher result array shared aross opcodes
 
 	readOnly   bool   // Whether to throw on stateful modifications
 	returnData []byte // Last CALL's return data for subsequent reuse
+
+	profNested profileNested // Usage of the finished profiled call frames, see profileFrame
 }
 
 // NewEVMInterpreter returns a new instance of the Interpreter.
 func NewEVMInterpreter(evm *EVM, cfg Config) *EVMInterpreter {
 	// We use the STOP instruction whether to see
 	// the jump table was initialised. If it was not
-	// we'll set the default jump table.
+	// we'll set the default jump table, along with the
+	// analysed code cache shared by all its interpreters.
+	// Custom jump tables get a private cache.
+	var analyses *lru.Cache
 	if !cfg.JumpTable[STOP].valid {
 		switch {
 		case evm.ChainConfig().IsConstantinople(evm.BlockNumber):
-			cfg.JumpTable = constantinopleInstructionSet
+			cfg.JumpTable, analyses = constantinopleInstructionSet, constantinopleAnalyses
 		case evm.ChainConfig().IsByzantium(evm.BlockNumber):
-			cfg.JumpTable = byzantiumInstructionSet
+			cfg.JumpTable, analyses = byzantiumInstructionSet, byzantiumAnalyses
 		case evm.ChainConfig().IsHomestead(evm.BlockNumber):
-			cfg.JumpTable = homesteadInstructionSet
+			cfg.JumpTable, analyses = homesteadInstructionSet, homesteadAnalyses
 		default:
-			cfg.JumpTable = frontierInstructionSet
+			cfg.JumpTable, analyses = frontierInstructionSet, frontierAnalyses
 		}
+	} else {
+		analyses = newAnalysisCache()
 	}
 
 	return &EVMInterpreter{
 		evm:      evm,
 		cfg:      cfg,
 		gasTable: evm.ChainConfig().GasTable(evm.BlockNumber),
+		analyses: analyses,
 	}
 }
 
@@ -114,7 +127,7 @@ func NewEVMInterpreter(evm *EVM, cfg Config) *EVMInterpreter {
 //
 // It's important to note that any errors returned by the interpreter should be
 // considered a revert-and-consume-all-gas operation except for
-// errExecutionReverted which means revert-and-keep-gas-left.
+// ErrExecutionReverted which means revert-and-keep-gas-left.
 func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (ret []byte, err error) {
 	if in.intPool == nil {
 		in.intPool = poolOfIntPools.get()
@@ -158,12 +171,26 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		gasCopy uint64 // for Tracer to log gas remaining before execution
 		logged  bool   // deferred Tracer should ignore already logged steps
 		res     []byte // result of the opcode execution function
+		// basic block execution, see basicBlock
+		code      = analysedCode(in.analyses, contract.CodeHash, contract.Code, &in.cfg.JumpTable)
+		block     = -1 // index of the last entered basic block
+		unchecked int  // number of upcoming operations already validated and charged
 	)
 	contract.Input = input
+	contract.analysis = code.jumpdests
 
 	// Reclaim the stack as an int pool when the execution stops
 	defer func() { in.intPool.put(stack.data...) }()
 
+	var profile *profileFrame
+	if in.cfg.Profiler != nil && in.cfg.Profiler.Enabled() {
+		profile = newProfileFrame(in.cfg.Profiler, in, contract)
+		defer profile.finish()
+	}
+	// Tracers and the profiler account gas per operation, so only charge it per
+	// basic block if neither is active.
+	blocks := !in.cfg.Debug && profile == nil
+
 	if in.cfg.Debug {
 		defer func() {
 			if err != nil {
@@ -184,19 +211,39 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 			// Capture pre-execution values for tracing.
 			logged, pcCopy, gasCopy = false, pc, contract.Gas
 		}
+		if profile != nil {
+			profile.beginOp()
+		}
 
 		// Get the operation from the jump table and validate the stack to ensure there are
 		// enough stack items available to perform the operation.
 		op = contract.GetOp(pc)
 		operation := in.cfg.JumpTable[op]
-		if !operation.valid {
-			return nil, fmt.Errorf("invalid opcode 0x%x", int(op))
+
+		// When entering a basic block, validate the stack and charge the static gas
+		// for all of its operations at once. If that fails, fall back to checking
+		// them one by one, so any error surfaces at the exact same operation.
+		if blocks && unchecked == 0 {
+			if block = code.blockAt(pc, block+1); block >= 0 {
+				b := &code.blocks[block]
+				if sLen := stack.len(); sLen >= b.minStack && sLen <= b.maxStack && contract.UseGas(b.gas) {
+					unchecked = b.ops
+				}
+			}
 		}
-		// Validate stack
-		if sLen := stack.len(); sLen < operation.minStack {
-			return nil, fmt.Errorf("stack underflow (%d <=> %d)", sLen, operation.minStack)
-		} else if sLen > operation.maxStack {
-			return nil, fmt.Errorf("stack limit reached %d (%d)", sLen, operation.maxStack)
+		charged := unchecked > 0
+		if charged {
+			unchecked--
+		} else {
+			if !operation.valid {
+				return nil, fmt.Errorf("invalid opcode 0x%x", int(op))
+			}
+			// Validate stack
+			if sLen := stack.len(); sLen < operation.minStack {
+				return nil, fmt.Errorf("stack underflow (%d <=> %d)", sLen, operation.minStack)
+			} else if sLen > operation.maxStack {
+				return nil, fmt.Errorf("stack limit reached %d (%d)", sLen, operation.maxStack)
+			}
 		}
 		// If the operation is valid, enforce and write restrictions
 		if in.readOnly && in.evm.chainRules.IsByzantium {
@@ -209,8 +256,8 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 				return nil, errWriteProtection
 			}
 		}
-		// Static portion of gas
-		if !contract.UseGas(operation.constantGas) {
+		// Static portion of gas, unless charged with the basic block
+		if !charged && !contract.UseGas(operation.constantGas) {
 			return nil, ErrOutOfGas
 		}
 
@@ -250,6 +297,9 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 
 		// execute the operation
 		res, err = operation.execute(&pc, in, contract, mem, stack)
+		if profile != nil {
+			profile.endOp(op)
+		}
 		// verifyPool is a build flag. Pool verification makes sure the integrity
 		// of the integer pool by comparing values to a default value.
 		if verifyPool {
@@ -265,7 +315,7 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		case err != nil:
 			return nil, err
 		case operation.reverts:
-			return res, errExecutionReverted
+			return res, ErrExecutionReverted
 		case operation.halts:
 			return res, nil
 		case !operation.jumps:
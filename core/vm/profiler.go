@@ -0,0 +1,265 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ProfileStats are the aggregated execution statistics of a set of opcodes. The
+// gas and time of call and create operations only include their own overhead,
+// not the execution of the invoked code, which is accounted to that code.
+type ProfileStats struct {
+	Count uint64        `json:"count"` // Number of executed opcodes
+	Gas   uint64        `json:"gas"`   // Gas consumed by the opcodes
+	Time  time.Duration `json:"time"`  // Wall-clock time spent executing the opcodes, in nanoseconds
+}
+
+// add accumulates the statistics of another set of opcodes into s.
+func (s *ProfileStats) add(other *ProfileStats) {
+	s.Count += other.Count
+	s.Gas += other.Gas
+	s.Time += other.Time
+}
+
+// ContractProfile is the execution profile of a single contract, identified by
+// the address it was running at and the hash of the code it was running.
+type ContractProfile struct {
+	Address  common.Address `json:"address"`
+	CodeHash common.Hash    `json:"codeHash"`
+	ProfileStats
+	Ops map[string]ProfileStats `json:"ops"`
+}
+
+// ProfileReport is a snapshot of the statistics gathered by a Profiler.
+type ProfileReport struct {
+	Running   bool                    `json:"running"`   // Whether the profiler is still collecting
+	Duration  time.Duration           `json:"duration"`  // Wall-clock time the profiler was collecting for
+	Ops       map[string]ProfileStats `json:"ops"`       // Statistics aggregated per opcode
+	Contracts []*ContractProfile      `json:"contracts"` // Statistics per contract, most time consuming first
+}
+
+// contractKey identifies the code being profiled.
+type contractKey struct {
+	address  common.Address
+	codeHash common.Hash
+}
+
+// contractStats are the statistics gathered for a single piece of code.
+type contractStats struct {
+	total ProfileStats
+	ops   map[OpCode]*ProfileStats
+}
+
+// Profiler aggregates the opcode counts, consumed gas and execution time of the
+// EVM per opcode and per contract. Unlike a Tracer, a profiler may be attached to
+// an interpreter permanently, it only starts collecting when enabled, and even
+// then gathers its statistics locally for every call frame, merging them only
+// when the frame returns.
+type Profiler struct {
+	enabled int32 // Flag whether statistics are being collected (atomic access)
+
+	ops       [256]ProfileStats
+	contracts map[contractKey]*contractStats
+	started   time.Time
+	stopped   time.Time
+	lock      sync.Mutex
+}
+
+// NewProfiler creates a new, disabled EVM profiler.
+func NewProfiler() *Profiler {
+	return &Profiler{
+		contracts: make(map[contractKey]*contractStats),
+	}
+}
+
+// Enabled returns whether the profiler is collecting statistics.
+func (p *Profiler) Enabled() bool {
+	return atomic.LoadInt32(&p.enabled) == 1
+}
+
+// Start discards any previously collected statistics and enables collection.
+// It returns false if the profiler was already running.
+func (p *Profiler) Start() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.Enabled() {
+		return false
+	}
+	p.ops = [256]ProfileStats{}
+	p.contracts = make(map[contractKey]*contractStats)
+	p.started, p.stopped = time.Now(), time.Time{}
+
+	atomic.StoreInt32(&p.enabled, 1)
+	return true
+}
+
+// Stop disables collection, retaining the statistics gathered so far. It returns
+// false if the profiler was not running.
+func (p *Profiler) Stop() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.Enabled() {
+		return false
+	}
+	atomic.StoreInt32(&p.enabled, 0)
+	p.stopped = time.Now()
+	return true
+}
+
+// Report returns the statistics gathered since the profiler was last started.
+func (p *Profiler) Report() *ProfileReport {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	report := &ProfileReport{
+		Running:   p.Enabled(),
+		Ops:       make(map[string]ProfileStats),
+		Contracts: make([]*ContractProfile, 0, len(p.contracts)),
+	}
+	switch {
+	case report.Running:
+		report.Duration = time.Since(p.started)
+	case !p.started.IsZero():
+		report.Duration = p.stopped.Sub(p.started)
+	}
+	for op, stats := range p.ops {
+		if stats.Count > 0 {
+			report.Ops[OpCode(op).String()] = stats
+		}
+	}
+	for key, stats := range p.contracts {
+		contract := &ContractProfile{
+			Address:      key.address,
+			CodeHash:     key.codeHash,
+			ProfileStats: stats.total,
+			Ops:          make(map[string]ProfileStats, len(stats.ops)),
+		}
+		for op, opStats := range stats.ops {
+			contract.Ops[op.String()] = *opStats
+		}
+		report.Contracts = append(report.Contracts, contract)
+	}
+	sort.Slice(report.Contracts, func(i, j int) bool {
+		return report.Contracts[i].Time > report.Contracts[j].Time
+	})
+	return report
+}
+
+// merge folds the statistics gathered by a finished call frame into the profile.
+func (p *Profiler) merge(key contractKey, ops *[256]ProfileStats) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	// Drop frames which raced with the profiler being stopped
+	if !p.Enabled() {
+		return
+	}
+	stats := p.contracts[key]
+	if stats == nil {
+		stats = &contractStats{ops: make(map[OpCode]*ProfileStats)}
+		p.contracts[key] = stats
+	}
+	for op := range ops {
+		if ops[op].Count == 0 {
+			continue
+		}
+		p.ops[op].add(&ops[op])
+		stats.total.add(&ops[op])
+
+		opStats := stats.ops[OpCode(op)]
+		if opStats == nil {
+			opStats = new(ProfileStats)
+			stats.ops[OpCode(op)] = opStats
+		}
+		opStats.add(&ops[op])
+	}
+}
+
+// profileFrame gathers the statistics of a single call frame of the interpreter.
+//
+// Since a call frame is suspended while the frames it invokes are executing, the
+// time and gas those consume are subtracted from the operation invoking them. To
+// do so, every finished frame reports its total usage into the interpreter, from
+// which the invoking operation can deduce the usage of its sub-calls.
+type profileFrame struct {
+	profiler *Profiler
+	in       *EVMInterpreter
+	contract *Contract
+	ops      [256]ProfileStats
+
+	start        time.Time     // Time the frame started executing
+	startGas     uint64        // Gas available to the frame when it started
+	parentNested profileNested // Nested usage reported to the parent frame before this one
+
+	opStart  time.Time     // Time the current operation started executing
+	opGas    uint64        // Gas available before the current operation
+	opNested profileNested // Nested usage reported before the current operation
+}
+
+// profileNested is the time and gas consumed by the sub-calls of an operation.
+type profileNested struct {
+	time time.Duration
+	gas  uint64
+}
+
+// newProfileFrame starts profiling a new call frame of the interpreter.
+func newProfileFrame(p *Profiler, in *EVMInterpreter, contract *Contract) *profileFrame {
+	return &profileFrame{
+		profiler:     p,
+		in:           in,
+		contract:     contract,
+		start:        time.Now(),
+		startGas:     contract.Gas,
+		parentNested: in.profNested,
+	}
+}
+
+// beginOp marks the start of the execution of an operation.
+func (f *profileFrame) beginOp() {
+	f.opStart, f.opGas, f.opNested = time.Now(), f.contract.Gas, f.in.profNested
+}
+
+// endOp accounts the execution of the given operation, excluding its sub-calls.
+func (f *profileFrame) endOp(op OpCode) {
+	var (
+		elapsed = time.Since(f.opStart)
+		gas     = f.opGas - f.contract.Gas
+		nested  = f.in.profNested
+	)
+	stats := &f.ops[op]
+	stats.Count++
+	stats.Gas += gas - (nested.gas - f.opNested.gas)
+	stats.Time += elapsed - (nested.time - f.opNested.time)
+}
+
+// finish reports the total usage of the call frame to the frame invoking it, and
+// merges the gathered statistics into the profiler.
+func (f *profileFrame) finish() {
+	f.in.profNested = profileNested{
+		time: f.parentNested.time + time.Since(f.start),
+		gas:  f.parentNested.gas + (f.startGas - f.contract.Gas),
+	}
+	f.profiler.merge(contractKey{f.contract.Address(), f.contract.CodeHash}, &f.ops)
+}
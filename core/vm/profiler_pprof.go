@@ -0,0 +1,181 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// WritePprof writes the statistics gathered since the profiler was last started
+// to w as a gzipped protobuf, in the format of pprof's profile.proto. Every sample
+// is a stack of an opcode on top of the contract it executed in, valued by the
+// opcode count, the consumed gas and the execution time.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	var (
+		report = p.Report()
+		b      = newPprofBuilder()
+	)
+	// Assemble the samples, sorted by contract and opcode for a stable output
+	for _, contract := range report.Contracts {
+		frame := b.location(fmt.Sprintf("%#x", contract.Address), fmt.Sprintf("%#x", contract.CodeHash))
+
+		ops := make([]string, 0, len(contract.Ops))
+		for op := range contract.Ops {
+			ops = append(ops, op)
+		}
+		sort.Strings(ops)
+		for _, op := range ops {
+			stats := contract.Ops[op]
+			b.sample([]uint64{b.location(op, ""), frame}, []int64{int64(stats.Count), int64(stats.Gas), int64(stats.Time)})
+		}
+	}
+	// Serialize the profile header and the collected tables
+	var out pprofBuffer
+	for i := 0; i < 3; i++ {
+		var vt pprofBuffer
+		vt.int(1, b.string([]string{"samples", "gas", "time"}[i]))
+		vt.int(2, b.string([]string{"count", "gas", "nanoseconds"}[i]))
+		out.message(1, &vt)
+	}
+	for _, sample := range b.samples {
+		out.message(2, sample)
+	}
+	for _, location := range b.locations {
+		out.message(4, location)
+	}
+	for _, function := range b.functions {
+		out.message(5, function)
+	}
+	for _, s := range b.strings {
+		out.bytes(6, []byte(s))
+	}
+	out.int(10, int64(report.Duration))
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(out); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// pprofBuilder accumulates the deduplicated tables of a pprof profile.
+type pprofBuilder struct {
+	strings   []string
+	stringIDs map[string]int64
+
+	locations   []*pprofBuffer
+	locationIDs map[[2]string]uint64
+	functions   []*pprofBuffer
+
+	samples []*pprofBuffer
+}
+
+func newPprofBuilder() *pprofBuilder {
+	return &pprofBuilder{
+		strings:     []string{""},
+		stringIDs:   map[string]int64{"": 0},
+		locationIDs: make(map[[2]string]uint64),
+	}
+}
+
+// string returns the index of s in the string table, inserting it if needed.
+func (b *pprofBuilder) string(s string) int64 {
+	if id, ok := b.stringIDs[s]; ok {
+		return id
+	}
+	id := int64(len(b.strings))
+	b.strings = append(b.strings, s)
+	b.stringIDs[s] = id
+	return id
+}
+
+// location returns the id of the location of the function with the given name
+// and file, inserting both the function and the location if needed.
+func (b *pprofBuilder) location(name, file string) uint64 {
+	key := [2]string{name, file}
+	if id, ok := b.locationIDs[key]; ok {
+		return id
+	}
+	id := uint64(len(b.locations) + 1)
+	b.locationIDs[key] = id
+
+	function := new(pprofBuffer)
+	function.uint(1, id)
+	function.int(2, b.string(name))
+	function.int(3, b.string(name))
+	function.int(4, b.string(file))
+	b.functions = append(b.functions, function)
+
+	var line pprofBuffer
+	line.uint(1, id)
+
+	location := new(pprofBuffer)
+	location.uint(1, id)
+	location.message(4, &line)
+	b.locations = append(b.locations, location)
+
+	return id
+}
+
+// sample inserts a new sample with the given stack (leaf first) and values.
+func (b *pprofBuilder) sample(stack []uint64, values []int64) {
+	var locations, vals pprofBuffer
+	for _, id := range stack {
+		locations.varint(id)
+	}
+	for _, v := range values {
+		vals.varint(uint64(v))
+	}
+	sample := new(pprofBuffer)
+	sample.bytes(1, locations)
+	sample.bytes(2, vals)
+	b.samples = append(b.samples, sample)
+}
+
+// pprofBuffer is a minimal protocol buffer encoder, supporting only the wire
+// types needed by profile.proto.
+type pprofBuffer []byte
+
+func (buf *pprofBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		*buf = append(*buf, byte(x)|0x80)
+		x >>= 7
+	}
+	*buf = append(*buf, byte(x))
+}
+
+func (buf *pprofBuffer) uint(field int, x uint64) {
+	buf.varint(uint64(field) << 3)
+	buf.varint(x)
+}
+
+func (buf *pprofBuffer) int(field int, x int64) {
+	buf.uint(field, uint64(x))
+}
+
+func (buf *pprofBuffer) bytes(field int, data []byte) {
+	buf.varint(uint64(field)<<3 | 2)
+	buf.varint(uint64(len(data)))
+	*buf = append(*buf, data...)
+}
+
+func (buf *pprofBuffer) message(field int, msg *pprofBuffer) {
+	buf.bytes(field, *msg)
+}
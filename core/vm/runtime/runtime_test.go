@@ -17,9 +17,14 @@
 package runtime
 
 import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
 	"math/big"
+	"math/rand"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
@@ -95,7 +100,7 @@ func TestExecute(t *testing.T) {
 }
 
 func TestCall(t *testing.T) {
-	state, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	state, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
 	address := common.HexToAddress("0x0a")
 	state.SetCode(address, []byte{
 		byte(vm.PUSH1), 10,
@@ -117,6 +122,168 @@ func TestCall(t *testing.T) {
 	}
 }
 
+func TestProfiler(t *testing.T) {
+	state, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	var (
+		caller = common.HexToAddress("0x0a")
+		callee = common.HexToAddress("0x0b")
+	)
+	state.SetCode(caller, append(append([]byte{
+		byte(vm.PUSH1), 0, // retSize
+		byte(vm.PUSH1), 0, // retOffset
+		byte(vm.PUSH1), 0, // inSize
+		byte(vm.PUSH1), 0, // inOffset
+		byte(vm.PUSH1), 0, // value
+		byte(vm.PUSH20)}, callee.Bytes()...),
+		byte(vm.GAS),
+		byte(vm.CALL),
+		byte(vm.POP),
+		byte(vm.STOP),
+	))
+	state.SetCode(callee, []byte{
+		byte(vm.PUSH1), 1,
+		byte(vm.PUSH1), 0,
+		byte(vm.SSTORE),
+		byte(vm.STOP),
+	})
+	profiler := vm.NewProfiler()
+	cfg := &Config{State: state, GasLimit: 1000000, EVMConfig: vm.Config{Profiler: profiler}}
+
+	// Calls made while the profiler is disabled must not be accounted
+	if _, _, err := Call(caller, nil, &Config{State: state.Copy(), EVMConfig: cfg.EVMConfig}); err != nil {
+		t.Fatalf("failed to call contract: %v", err)
+	}
+	if report := profiler.Report(); len(report.Ops) != 0 || len(report.Contracts) != 0 {
+		t.Fatalf("disabled profiler collected statistics: %v", report)
+	}
+	profiler.Start()
+	_, leftover, err := Call(caller, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to call contract: %v", err)
+	}
+	profiler.Stop()
+
+	report := profiler.Report()
+	if len(report.Contracts) != 2 {
+		t.Fatalf("contract count mismatch: have %d, want 2", len(report.Contracts))
+	}
+	// The sub-call must be accounted to the callee, so that the gas of all the
+	// opcodes sums up to the total gas used
+	var gas, count uint64
+	for _, stats := range report.Ops {
+		gas += stats.Gas
+		count += stats.Count
+	}
+	if used := cfg.GasLimit - leftover; gas != used {
+		t.Errorf("profiled gas mismatch: have %d, want %d", gas, used)
+	}
+	if count != 14 {
+		t.Errorf("profiled opcode count mismatch: have %d, want %d", count, 14)
+	}
+	for _, contract := range report.Contracts {
+		switch contract.Address {
+		case caller:
+			if stats := contract.Ops["CALL"]; stats.Count != 1 || stats.Gas != params.GasTableEIP158.Calls {
+				t.Errorf("caller CALL stats mismatch: have %+v, want gas %d", stats, params.GasTableEIP158.Calls)
+			}
+		case callee:
+			if stats := contract.Ops["SSTORE"]; stats.Count != 1 || stats.Gas != params.SstoreSetGas {
+				t.Errorf("callee SSTORE stats mismatch: have %+v, want gas %d", stats, params.SstoreSetGas)
+			}
+			if contract.CodeHash != state.GetCodeHash(callee) {
+				t.Errorf("callee code hash mismatch: have %x, want %x", contract.CodeHash, state.GetCodeHash(callee))
+			}
+		default:
+			t.Errorf("unexpected contract profiled: %x", contract.Address)
+		}
+	}
+	// The pprof output must be a valid gzip stream
+	var buf bytes.Buffer
+	if err := profiler.WritePprof(&buf); err != nil {
+		t.Fatalf("failed to write pprof profile: %v", err)
+	}
+	if _, err := gzip.NewReader(&buf); err != nil {
+		t.Fatalf("invalid pprof profile: %v", err)
+	}
+}
+
+// noopTracer is a tracer that does nothing, used to force the interpreter to
+// validate and charge every operation individually.
+type noopTracer struct{}
+
+func (noopTracer) CaptureStart(from common.Address, to common.Address, call bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+func (noopTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+func (noopTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+func (noopTracer) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	return nil
+}
+
+// randomCode generates a random program biased towards loops, jumps into push
+// data, stack underflows, memory and storage access.
+func randomCode(rnd *rand.Rand, size int) []byte {
+	ops := []vm.OpCode{
+		vm.ADD, vm.MUL, vm.SUB, vm.LT, vm.ISZERO, vm.NOT, vm.POP, vm.POP, vm.DUP1, vm.DUP2, vm.SWAP1,
+		vm.PC, vm.GAS, vm.MSIZE, vm.MLOAD, vm.MSTORE, vm.SLOAD, vm.SSTORE, vm.CALLVALUE,
+		vm.RETURNDATASIZE, vm.SHA3, vm.STOP, vm.REVERT, vm.RETURN, vm.OpCode(0xfe),
+	}
+	var (
+		code  []byte
+		dests []int
+	)
+	for len(code) < size {
+		switch n := rnd.Intn(20); {
+		case n < 6:
+			code = append(code, byte(vm.PUSH1), byte(rnd.Intn(size)))
+		case n < 8:
+			dests = append(dests, len(code))
+			code = append(code, byte(vm.JUMPDEST))
+		case n < 10:
+			// Mostly jump back to a known destination, occasionally anywhere
+			dest := rnd.Intn(size)
+			if len(dests) > 0 && rnd.Intn(4) > 0 {
+				dest = dests[rnd.Intn(len(dests))]
+			}
+			code = append(code, byte(vm.PUSH1), byte(dest), byte(vm.JUMP+vm.OpCode(rnd.Intn(2))))
+		case n < 11:
+			code = append(code, byte(vm.PUSH2), byte(vm.JUMPDEST), byte(rnd.Intn(256)))
+		default:
+			code = append(code, byte(ops[rnd.Intn(len(ops))]))
+		}
+	}
+	return code
+}
+
+// Tests that charging static gas per basic block yields the exact same results
+// as charging it per operation.
+func TestBasicBlockEquivalence(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		code := randomCode(rnd, 8+rnd.Intn(120))
+		gas := uint64(1 + rnd.Intn(30000))
+
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		address := common.BytesToAddress([]byte("contract"))
+		statedb.SetCode(address, code)
+
+		run := func(evmConfig vm.Config) (string, common.Hash) {
+			state := statedb.Copy()
+			ret, leftover, err := Call(address, nil, &Config{State: state, GasLimit: gas, EVMConfig: evmConfig})
+			return fmt.Sprintf("ret %x, gas %d, err %v", ret, leftover, err), state.IntermediateRoot(true)
+		}
+		have, haveRoot := run(vm.Config{})
+		want, wantRoot := run(vm.Config{Debug: true, Tracer: noopTracer{}})
+		if have != want || haveRoot != wantRoot {
+			t.Fatalf("code %x, gas %d: result mismatch\nhave %s, root %x\nwant %s, root %x", code, gas, have, haveRoot, want, wantRoot)
+		}
+	}
+}
+
 func BenchmarkCall(b *testing.B) {
 	var definition = `[{"constant":true,"inputs":[],"name":"seller","outputs":[{"name":"","type":"address"}],"type":"function"},{"constant":false,"inputs":[],"name":"abort","outputs":[],"type":"function"},{"constant":true,"inputs":[],"name":"value","outputs":[{"name":"","type":"uint256"}],"type":"function"},{"constant":false,"inputs":[],"name":"refund","outputs":[],"type":"function"},{"constant":true,"inputs":[],"name":"buyer","outputs":[{"name":"","type":"address"}],"type":"function"},{"constant":false,"inputs":[],"name":"confirmReceived","outputs":[],"type":"function"},{"constant":true,"inputs":[],"name":"state","outputs":[{"name":"","type":"uint8"}],"type":"function"},{"constant":false,"inputs":[],"name":"confirmPurchase","outputs":[],"type":"function"},{"inputs":[],"type":"constructor"},{"anonymous":false,"inputs":[],"name":"Aborted","type":"event"},{"anonymous":false,"inputs":[],"name":"PurchaseConfirmed","type":"event"},{"anonymous":false,"inputs":[],"name":"ItemReceived","type":"event"},{"anonymous":false,"inputs":[],"name":"Refunded","type":"event"}]`
 
@@ -151,7 +318,7 @@ func BenchmarkCall(b *testing.B) {
 }
 func benchmarkEVM_Create(bench *testing.B, code string) {
 	var (
-		statedb, _ = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+		statedb, _ = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
 		sender     = common.BytesToAddress([]byte("sender"))
 		receiver   = common.BytesToAddress([]byte("receiver"))
 	)
@@ -34,7 +34,9 @@ import (
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/ethereum/go-ethereum/trie"
@@ -261,12 +263,46 @@ func NewPublicDebugAPI(eth *Ethereum) *PublicDebugAPI {
 
 // DumpBlock retrieves the entire state of the database at a given block.
 func (api *PublicDebugAPI) DumpBlock(blockNr rpc.BlockNumber) (state.Dump, error) {
+	stateDb, err := api.stateAtBlock(blockNr)
+	if err != nil {
+		return state.Dump{}, err
+	}
+	return stateDb.RawDump(&state.DumpConfig{OnlyWithAddresses: true}), nil
+}
+
+// AccountRangeMaxResults is the maximum number of results to be returned per call
+const AccountRangeMaxResults = 256
+
+// AccountRange enumerates all accounts in the given block and start point in
+// paging request. The returned dump contains the key of the next account to
+// request, or nil if the end of the state was reached.
+func (api *PublicDebugAPI) AccountRange(blockNr rpc.BlockNumber, start hexutil.Bytes, maxResults int, nocode, nostorage, incompletes bool) (state.IteratorDump, error) {
+	stateDb, err := api.stateAtBlock(blockNr)
+	if err != nil {
+		return state.IteratorDump{}, err
+	}
+	if maxResults > AccountRangeMaxResults || maxResults <= 0 {
+		maxResults = AccountRangeMaxResults
+	}
+	opts := &state.DumpConfig{
+		SkipCode:          nocode,
+		SkipStorage:       nostorage,
+		OnlyWithAddresses: !incompletes,
+		Start:             start,
+		Max:               uint64(maxResults),
+	}
+	return stateDb.IteratorDump(opts), nil
+}
+
+// stateAtBlock retrieves the state database at a given block number, resolving
+// the pending and latest special block numbers.
+func (api *PublicDebugAPI) stateAtBlock(blockNr rpc.BlockNumber) (*state.StateDB, error) {
 	if blockNr == rpc.PendingBlockNumber {
 		// If we're dumping the pending state, we need to request
 		// both the pending block as well as the pending state from
 		// the miner and operate on those
 		_, stateDb := api.eth.miner.Pending()
-		return stateDb.RawDump(), nil
+		return stateDb, nil
 	}
 	var block *types.Block
 	if blockNr == rpc.LatestBlockNumber {
@@ -275,13 +311,9 @@ func (api *PublicDebugAPI) DumpBlock(blockNr rpc.BlockNumber) (state.Dump, error
 		block = api.eth.blockchain.GetBlockByNumber(uint64(blockNr))
 	}
 	if block == nil {
-		return state.Dump{}, fmt.Errorf("block #%d not found", blockNr)
+		return nil, fmt.Errorf("block #%d not found", blockNr)
 	}
-	stateDb, err := api.eth.BlockChain().StateAt(block.Root())
-	if err != nil {
-		return state.Dump{}, err
-	}
-	return stateDb.RawDump(), nil
+	return api.eth.BlockChain().StateAt(block.Root())
 }
 
 // PrivateDebugAPI is the collection of Ethereum full node APIs exposed over
@@ -311,6 +343,58 @@ type BadBlockArgs struct {
 	RLP   string                 `json:"rlp"`
 }
 
+// StartEVMProfile starts aggregating the opcode counts, gas and execution time of
+// all EVM executions (block processing, mining and calls) per opcode and per
+// contract, discarding any previously collected statistics.
+func (api *PrivateDebugAPI) StartEVMProfile() error {
+	profiler := api.eth.blockchain.GetVMConfig().Profiler
+	if profiler == nil {
+		return errors.New("EVM profiling not supported")
+	}
+	if !profiler.Start() {
+		return errors.New("EVM profiling already in progress")
+	}
+	log.Info("EVM profiling started")
+	return nil
+}
+
+// StopEVMProfile stops an ongoing EVM profiling, retaining the statistics for
+// later retrieval.
+func (api *PrivateDebugAPI) StopEVMProfile() error {
+	profiler := api.eth.blockchain.GetVMConfig().Profiler
+	if profiler == nil || !profiler.Stop() {
+		return errors.New("EVM profiling not in progress")
+	}
+	log.Info("EVM profiling stopped")
+	return nil
+}
+
+// EvmProfile returns the statistics collected since EVM profiling was last started.
+func (api *PrivateDebugAPI) EvmProfile() (*vm.ProfileReport, error) {
+	profiler := api.eth.blockchain.GetVMConfig().Profiler
+	if profiler == nil {
+		return nil, errors.New("EVM profiling not supported")
+	}
+	return profiler.Report(), nil
+}
+
+// WriteEVMProfile writes the statistics collected since EVM profiling was last
+// started to the given file, in pprof format.
+func (api *PrivateDebugAPI) WriteEVMProfile(file string) error {
+	profiler := api.eth.blockchain.GetVMConfig().Profiler
+	if profiler == nil {
+		return errors.New("EVM profiling not supported")
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	log.Info("Writing EVM profile", "dump", file)
+	return profiler.WritePprof(f)
+}
+
 // GetBadBlocks returns a list of the last 'bad blocks' that the client has seen on the network
 // and returns them as a JSON list of block-hashes
 func (api *PrivateDebugAPI) GetBadBlocks(ctx context.Context) ([]*BadBlockArgs, error) {
@@ -17,13 +17,24 @@
 package eth
 
 import (
+	"context"
+	"math/big"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
 var dumper = spew.ConfigState{Indent: "    "}
@@ -31,7 +42,7 @@ var dumper = spew.ConfigState{Indent: "    "}
 func TestStorageRangeAt(t *testing.T) {
 	// Create a state where account 0x010000... has a few storage entries.
 	var (
-		state, _ = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+		state, _ = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
 		addr     = common.Address{0x01}
 		keys     = []common.Hash{ // hashes of Keys of storage
 			common.HexToHash("340dd630ad21bf010b4e676dbfa9ba9a02175262d1fa356232cfde6cb5b47ef2"),
@@ -88,3 +99,291 @@ func TestStorageRangeAt(t *testing.T) {
 		}
 	}
 }
+
+// Tests that calls and gas estimations can be executed on top of a state with
+// some of its accounts overridden, without modifying the chain state.
+func TestCallWithStateOverrides(t *testing.T) {
+	var (
+		sender   = common.Address{0xaa}
+		contract = common.Address{0xbb}
+		injected = common.Address{0xcc}
+		guarded  = common.Address{0xdd}
+
+		// Contract returning the value of storage slot 0
+		getter = common.FromHex("0x60005460005260206000f3")
+		// Contract reverting unless storage slot 0 equals 2
+		guard = common.FromHex("0x600054600214600d57600080fd5b00")
+	)
+	eth := newTestTracerBackend(t, core.GenesisAlloc{
+		contract: {Balance: new(big.Int), Code: getter, Storage: map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(1)), {0x01}: common.BigToHash(big.NewInt(1))}},
+		guarded:  {Balance: new(big.Int), Code: guard},
+	}, 1, nil)
+
+	slot := func(n int64) *map[common.Hash]common.Hash {
+		return &map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(n))}
+	}
+	tests := []struct {
+		to        common.Address
+		overrides *ethapi.StateOverride
+		want      common.Hash
+		mustFail  bool
+	}{
+		// Plain call against the original state
+		{to: contract, want: common.BigToHash(big.NewInt(1))},
+		// Call with a single storage slot patched
+		{to: contract, overrides: &ethapi.StateOverride{contract: {StateDiff: slot(2)}}, want: common.BigToHash(big.NewInt(2))},
+		// Call with the entire storage replaced, hiding the original slot
+		{to: contract, overrides: &ethapi.StateOverride{contract: {State: &map[common.Hash]common.Hash{{0x01}: common.BigToHash(big.NewInt(5))}}}, want: common.Hash{}},
+		// Call into injected code with injected storage
+		{to: injected, overrides: &ethapi.StateOverride{injected: {Code: (*hexutil.Bytes)(&getter), State: slot(9)}}, want: common.BigToHash(big.NewInt(9))},
+		// Conflicting storage overrides
+		{to: contract, overrides: &ethapi.StateOverride{contract: {State: slot(2), StateDiff: slot(3)}}, mustFail: true},
+	}
+	for i, tt := range tests {
+		to := tt.to
+		res, err := ethapi.DoCall(context.Background(), eth.APIBackend, ethapi.CallArgs{From: &sender, To: &to}, rpc.LatestBlockNumber, tt.overrides, vm.Config{}, 5*time.Second, nil)
+		if tt.mustFail {
+			if err == nil {
+				t.Errorf("test %d: expected failure", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: call failed: %v", i, err)
+			continue
+		}
+		if res.Failed() {
+			t.Errorf("test %d: execution failed: %v", i, res.Err)
+			continue
+		}
+		if have := common.BytesToHash(res.Return()); have != tt.want {
+			t.Errorf("test %d: result mismatch: have %x, want %x", i, have, tt.want)
+		}
+	}
+	// Gas estimation must only succeed if the override lets the call through
+	args := ethapi.CallArgs{From: &sender, To: &guarded}
+	if _, err := ethapi.DoEstimateGas(context.Background(), eth.APIBackend, args, rpc.LatestBlockNumber, nil, nil); err == nil {
+		t.Errorf("estimation succeeded for always failing call")
+	}
+	gas, err := ethapi.DoEstimateGas(context.Background(), eth.APIBackend, args, rpc.LatestBlockNumber, &ethapi.StateOverride{guarded: {StateDiff: slot(2)}}, nil)
+	if err != nil {
+		t.Fatalf("failed to estimate gas with overrides: %v", err)
+	}
+	if gas <= hexutil.Uint64(params.TxGas) {
+		t.Errorf("estimated gas too low: have %d, want above %d", gas, params.TxGas)
+	}
+	// Ensure none of the overrides modified the chain state
+	statedb, _ := eth.blockchain.State()
+	if value := statedb.GetState(contract, common.Hash{}); value != common.BigToHash(big.NewInt(1)) {
+		t.Errorf("contract storage modified: have %x", value)
+	}
+	if code := statedb.GetCode(injected); len(code) != 0 {
+		t.Errorf("injected code persisted: have %x", code)
+	}
+}
+
+// revertCode returns the code of a contract which reverts with the given reason
+// (shorter than 32 bytes), copying the ABI encoded Error(string) from the end of
+// its own code.
+func revertCode(reason string) []byte {
+	data := append(crypto.Keccak256([]byte("Error(string)"))[:4], common.LeftPadBytes([]byte{0x20}, 32)...)
+	data = append(data, common.LeftPadBytes([]byte{byte(len(reason))}, 32)...)
+	data = append(data, common.RightPadBytes([]byte(reason), 32)...)
+	return append(common.FromHex("0x6064600c60003960646000fd"), data...)
+}
+
+func TestCallRevertReason(t *testing.T) {
+	var (
+		sender   = common.Address{0xaa}
+		reverter = common.Address{0xcc}
+	)
+	eth := newTestTracerBackend(t, core.GenesisAlloc{
+		reverter: {Balance: new(big.Int), Code: revertCode("boom")},
+	}, 1, nil)
+	api := ethapi.NewPublicBlockChainAPI(eth.APIBackend)
+	args := ethapi.CallArgs{From: &sender, To: &reverter}
+
+	want := hexutil.Encode(revertCode("boom")[12:])
+	check := func(method string, err error) {
+		if err == nil {
+			t.Fatalf("%s: expected revert error", method)
+		}
+		if have := err.Error(); have != "execution reverted: boom" {
+			t.Errorf("%s: error message mismatch: have %q, want %q", method, have, "execution reverted: boom")
+		}
+		if e, ok := err.(rpc.Error); !ok || e.ErrorCode() != 3 {
+			t.Errorf("%s: error code mismatch: have %v", method, err)
+		}
+		if e, ok := err.(rpc.DataError); !ok || e.ErrorData() != want {
+			t.Errorf("%s: error data mismatch: have %v, want %v", method, err, want)
+		}
+	}
+	_, err := api.Call(context.Background(), args, rpc.LatestBlockNumber, nil)
+	check("eth_call", err)
+
+	latest := rpc.LatestBlockNumber
+	_, err = api.EstimateGas(context.Background(), args, &latest, nil)
+	check("eth_estimateGas", err)
+}
+
+func TestCallBundle(t *testing.T) {
+	var (
+		sender   = common.Address{0xaa}
+		pauper   = common.Address{0xab}
+		counter  = common.Address{0xbb}
+		reverter = common.Address{0xcc}
+		numberer = common.Address{0xdd}
+
+		// Contract incrementing storage slot 0, logging and returning the new value
+		increment = common.FromHex("0x6000546001018060005560005260206000a060206000f3")
+		// Contract returning the current block number
+		number = common.FromHex("0x4360005260206000f3")
+	)
+	eth := newTestTracerBackend(t, core.GenesisAlloc{
+		sender:   {Balance: big.NewInt(params.Ether)},
+		counter:  {Balance: new(big.Int), Code: increment},
+		reverter: {Balance: new(big.Int), Code: revertCode("boom")},
+		numberer: {Balance: new(big.Int), Code: number},
+	}, 1, nil)
+
+	blockNumber := hexutil.Big(*big.NewInt(1000))
+	calls := []ethapi.CallArgs{
+		{From: &sender, To: &counter},
+		{From: &sender, To: &counter},
+		{From: &sender, To: &reverter},
+		{From: &pauper, To: &counter, Value: (*hexutil.Big)(big.NewInt(1))},
+		{From: &sender, To: &numberer},
+	}
+	results, err := ethapi.DoCallBundle(context.Background(), eth.APIBackend, calls, rpc.LatestBlockNumber, &ethapi.BlockOverrides{Number: &blockNumber}, 5*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute bundle: %v", err)
+	}
+	if len(results) != len(calls) {
+		t.Fatalf("result count mismatch: have %d, want %d", len(results), len(calls))
+	}
+	// The counter increments must build on top of each other
+	for i := 0; i < 2; i++ {
+		want := common.BigToHash(big.NewInt(int64(i + 1)))
+		if results[i].Error != "" {
+			t.Fatalf("call %d failed: %v", i, results[i].Error)
+		}
+		if have := common.BytesToHash(results[i].ReturnValue); have != want {
+			t.Errorf("call %d: result mismatch: have %x, want %x", i, have, want)
+		}
+		if results[i].GasUsed <= hexutil.Uint64(params.TxGas) {
+			t.Errorf("call %d: gas used too low: have %d", i, results[i].GasUsed)
+		}
+		if len(results[i].Logs) != 1 {
+			t.Fatalf("call %d: log count mismatch: have %d, want 1", i, len(results[i].Logs))
+		}
+		if log := results[i].Logs[0]; log.Address != counter || common.BytesToHash(log.Data) != want {
+			t.Errorf("call %d: log mismatch: have %x from %x", i, log.Data, log.Address)
+		}
+	}
+	// The revert must be reported along with its reason
+	if have := results[2]; have.Error != "execution reverted" || have.RevertReason != "boom" {
+		t.Errorf("revert mismatch: have %q (%q), want %q (%q)", have.Error, have.RevertReason, "execution reverted", "boom")
+	}
+	// The sender without funds must not be able to transfer value
+	if have := results[3].Error; !strings.HasPrefix(have, "insufficient funds") {
+		t.Errorf("unfunded call error mismatch: have %q", have)
+	}
+	// The block number must be overridden
+	if have := common.BytesToHash(results[4].ReturnValue).Big(); have.Cmp(blockNumber.ToInt()) != 0 {
+		t.Errorf("block number mismatch: have %v, want %v", have, blockNumber.ToInt())
+	}
+	// Ensure the bundle didn't modify the chain state
+	statedb, _ := eth.blockchain.State()
+	if value := statedb.GetState(counter, common.Hash{}); value != (common.Hash{}) {
+		t.Errorf("counter storage modified: have %x", value)
+	}
+}
+
+// Tests that the calls of a bundle share the gas of the (overridden) block.
+func TestCallBundleGasLimit(t *testing.T) {
+	var (
+		sender  = common.Address{0xaa}
+		counter = common.Address{0xbb}
+
+		// Contract incrementing storage slot 0, logging and returning the new value
+		increment = common.FromHex("0x6000546001018060005560005260206000a060206000f3")
+	)
+	eth := newTestTracerBackend(t, core.GenesisAlloc{
+		sender:  {Balance: big.NewInt(params.Ether)},
+		counter: {Balance: new(big.Int), Code: increment},
+	}, 1, nil)
+
+	gasLimit := hexutil.Uint64(60000)
+	calls := []ethapi.CallArgs{
+		{From: &sender, To: &counter},
+		{From: &sender, To: &counter},
+		{From: &sender, To: &counter, Gas: &gasLimit},
+	}
+	results, err := ethapi.DoCallBundle(context.Background(), eth.APIBackend, calls, rpc.LatestBlockNumber, &ethapi.BlockOverrides{GasLimit: &gasLimit}, 5*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute bundle: %v", err)
+	}
+	if results[0].Error != "" {
+		t.Fatalf("first call failed: %v", results[0].Error)
+	}
+	// The remaining gas doesn't suffice for another call, nor for an explicit one
+	for i := 1; i < len(results); i++ {
+		if results[i].Error == "" {
+			t.Errorf("call %d exceeding the block gas limit succeeded", i)
+		}
+	}
+}
+
+// Tests that a bundle call sent by the coinbase must be affordable without the
+// tip it gets paid back, and that it is only charged the net difference.
+func TestCallBundleCoinbaseSender(t *testing.T) {
+	var (
+		sender   = common.Address{0xaa}
+		poor     = common.Address{0xab}
+		rich     = common.Address{0xac}
+		receiver = common.Address{0xbb}
+		balancer = common.Address{0xcc}
+
+		// Contract returning the balance of the coinbase
+		code = common.FromHex("0x413160005260206000f3")
+
+		gas   = hexutil.Uint64(params.TxGas)
+		price = big.NewInt(params.GWei)
+		fee   = new(big.Int).Mul(price, big.NewInt(int64(params.TxGas)))
+	)
+	eth := newTestTracerBackend(t, core.GenesisAlloc{
+		sender:   {Balance: big.NewInt(params.Ether)},
+		poor:     {Balance: new(big.Int).Sub(fee, common.Big1)},
+		rich:     {Balance: fee},
+		balancer: {Balance: new(big.Int), Code: code},
+	}, 1, nil)
+
+	// A coinbase unable to pay the fee upfront must be rejected
+	calls := []ethapi.CallArgs{
+		{From: &poor, To: &receiver, Gas: &gas, GasPrice: (*hexutil.Big)(price)},
+	}
+	results, err := ethapi.DoCallBundle(context.Background(), eth.APIBackend, calls, rpc.LatestBlockNumber, &ethapi.BlockOverrides{Coinbase: &poor}, 5*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute bundle: %v", err)
+	}
+	if have := results[0].Error; !strings.HasPrefix(have, "insufficient funds") {
+		t.Errorf("unaffordable coinbase call error mismatch: have %q", have)
+	}
+	// A coinbase able to pay the fee must get its tip back
+	calls = []ethapi.CallArgs{
+		{From: &rich, To: &receiver, Gas: &gas, GasPrice: (*hexutil.Big)(price)},
+		{From: &sender, To: &balancer},
+	}
+	results, err = ethapi.DoCallBundle(context.Background(), eth.APIBackend, calls, rpc.LatestBlockNumber, &ethapi.BlockOverrides{Coinbase: &rich}, 5*time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to execute bundle: %v", err)
+	}
+	for i, result := range results {
+		if result.Error != "" {
+			t.Fatalf("call %d failed: %v", i, result.Error)
+		}
+	}
+	if have := common.BytesToHash(results[1].ReturnValue).Big(); have.Cmp(fee) != 0 {
+		t.Errorf("coinbase balance mismatch: have %v, want %v", have, fee)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// newTestTracerBackend creates a minimal Ethereum service with a chain of n
+// blocks generated by gen on top of the given genesis allocation, suitable for
+// running the tracing APIs against.
+func newTestTracerBackend(t *testing.T, alloc core.GenesisAlloc, n int, gen func(int, *core.BlockGen)) *Ethereum {
+	var (
+		db    = rawdb.NewMemoryDatabase()
+		gspec = &core.Genesis{Config: params.TestChainConfig, Alloc: alloc}
+	)
+	genesis := gspec.MustCommit(db)
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, n, gen)
+
+	chain, err := core.NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	eth := &Ethereum{config: &Config{}, blockchain: chain, chainDb: db}
+	eth.APIBackend = &EthAPIBackend{eth: eth}
+	return eth
+}
+
+// Tests that arbitrary calls can be traced on top of historical and current
+// states, with and without state overrides, and that nothing gets persisted.
+func TestTraceCall(t *testing.T) {
+	var (
+		sender   = common.Address{0xaa}
+		receiver = common.Address{0xbb}
+		contract = common.Address{0xcc}
+		empty    = common.Address{0xdd}
+
+		// Contract returning the value of storage slot 0
+		code = common.FromHex("0x60005460005260206000f3")
+	)
+	eth := newTestTracerBackend(t, core.GenesisAlloc{
+		testBank: {Balance: big.NewInt(params.Ether)},
+		contract: {Balance: new(big.Int), Code: code, Storage: map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(1))}},
+	}, 2, func(i int, b *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(testBank), sender, big.NewInt(1000), params.TxGas, nil, nil), types.HomesteadSigner{}, testBankKey)
+		b.AddTx(tx)
+	})
+	api := NewPrivateDebugAPI(eth)
+
+	slot := func(n int64) string { return common.BigToHash(big.NewInt(n)).Hex()[2:] }
+	tests := []struct {
+		call     ethapi.CallArgs
+		block    rpc.BlockNumberOrHash
+		config   *TraceCallConfig
+		retval   string
+		mustFail bool
+	}{
+		// Simple transfer on top of the genesis state, where the sender is not yet funded
+		{
+			call:     ethapi.CallArgs{From: &sender, To: &receiver, Value: (*hexutil.Big)(big.NewInt(1500)), GasPrice: new(hexutil.Big)},
+			block:    rpc.BlockNumberOrHashWithNumber(0),
+			mustFail: true,
+		},
+		// Simple transfer on top of the latest state, where the sender is funded enough
+		{
+			call:  ethapi.CallArgs{From: &sender, To: &receiver, Value: (*hexutil.Big)(big.NewInt(1500)), GasPrice: new(hexutil.Big)},
+			block: rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber),
+		},
+		// Simple transfer on top of a block referenced by hash
+		{
+			call:  ethapi.CallArgs{From: &sender, To: &receiver, Value: (*hexutil.Big)(big.NewInt(500)), GasPrice: new(hexutil.Big)},
+			block: rpc.BlockNumberOrHashWithHash(eth.blockchain.GetBlockByNumber(1).Hash(), true),
+		},
+		// Transfer with the sender balance overridden
+		{
+			call:  ethapi.CallArgs{From: &sender, To: &receiver, Value: (*hexutil.Big)(big.NewInt(1500)), GasPrice: new(hexutil.Big)},
+			block: rpc.BlockNumberOrHashWithNumber(0),
+			config: &TraceCallConfig{StateOverrides: &ethapi.StateOverride{
+				sender: ethapi.OverrideAccount{Balance: (*hexutil.Big)(big.NewInt(1500))},
+			}},
+		},
+		// Contract call against the original storage
+		{
+			call:   ethapi.CallArgs{To: &contract, GasPrice: new(hexutil.Big)},
+			block:  rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber),
+			retval: slot(1),
+		},
+		// Contract call with an overridden storage slot
+		{
+			call:  ethapi.CallArgs{To: &contract, GasPrice: new(hexutil.Big)},
+			block: rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber),
+			config: &TraceCallConfig{StateOverrides: &ethapi.StateOverride{
+				contract: ethapi.OverrideAccount{StateDiff: &map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(2))}},
+			}},
+			retval: slot(2),
+		},
+		// Contract call with injected code, ensuring the previous override did not stick
+		{
+			call:  ethapi.CallArgs{To: &empty, GasPrice: new(hexutil.Big)},
+			block: rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber),
+			config: &TraceCallConfig{StateOverrides: &ethapi.StateOverride{
+				empty: ethapi.OverrideAccount{Code: (*hexutil.Bytes)(&code)},
+			}},
+			retval: slot(0),
+		},
+		// Call on top of a non-existent block
+		{
+			call:     ethapi.CallArgs{To: &contract, GasPrice: new(hexutil.Big)},
+			block:    rpc.BlockNumberOrHashWithNumber(10),
+			mustFail: true,
+		},
+	}
+	for i, tt := range tests {
+		result, err := api.TraceCall(context.Background(), tt.call, tt.block, tt.config)
+		if tt.mustFail {
+			if err == nil {
+				t.Errorf("test %d: expected failure", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: failed to trace call: %v", i, err)
+			continue
+		}
+		res := result.(*ethapi.ExecutionResult)
+		if res.Failed {
+			t.Errorf("test %d: call failed", i)
+		}
+		if res.ReturnValue != tt.retval {
+			t.Errorf("test %d: return value mismatch: have %s, want %s", i, res.ReturnValue, tt.retval)
+		}
+		if tt.retval != "" && len(res.StructLogs) == 0 {
+			t.Errorf("test %d: no structured logs produced", i)
+		}
+	}
+	// Ensure none of the traces modified the chain state
+	statedb, _ := eth.blockchain.State()
+	if value := statedb.GetState(contract, common.Hash{}); value != common.BigToHash(big.NewInt(1)) {
+		t.Errorf("contract storage modified: have %x", value)
+	}
+	if code := statedb.GetCode(empty); len(code) != 0 {
+		t.Errorf("empty account code modified: have %x", code)
+	}
+}
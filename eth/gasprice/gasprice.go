@@ -39,10 +39,13 @@ type Config struct {
 
 // Oracle recommends gas prices based on the content of recent
 // blocks. Suitable for both light and full clients.
+//
+// Blocks are sampled for the lowest tip paid to the miner, which before EIP-1559
+// is simply the lowest gas price.
 type Oracle struct {
 	backend   ethapi.Backend
 	lastHead  common.Hash
-	lastPrice *big.Int
+	lastPrice *big.Int // Last suggested tip
 	cacheLock sync.RWMutex
 	fetchLock sync.Mutex
 
@@ -73,8 +76,23 @@ func NewOracle(backend ethapi.Backend, params Config) *Oracle {
 	}
 }
 
-// SuggestPrice returns the recommended gas price.
+// SuggestPrice returns the recommended gas price: the recommended tip on top of
+// the base fee of the head block, if any.
 func (gpo *Oracle) SuggestPrice(ctx context.Context) (*big.Int, error) {
+	tip, err := gpo.SuggestTipCap(ctx)
+	if err != nil {
+		return tip, err
+	}
+	head, _ := gpo.backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
+	if head == nil || head.BaseFee == nil {
+		return tip, nil
+	}
+	return new(big.Int).Add(tip, head.BaseFee), nil
+}
+
+// SuggestTipCap returns the recommended tip to pay the miner on top of the base
+// fee for a transaction to be included in a timely manner.
+func (gpo *Oracle) SuggestTipCap(ctx context.Context) (*big.Int, error) {
 	gpo.cacheLock.RLock()
 	lastHead := gpo.lastHead
 	lastPrice := gpo.lastPrice
@@ -152,14 +170,24 @@ type getBlockPricesResult struct {
 	err   error
 }
 
-type transactionsByGasPrice []*types.Transaction
+// transactionsByEffectiveTip sorts the transactions of a block by the tip they
+// paid to the miner at the block's base fee.
+type transactionsByEffectiveTip struct {
+	txs     []*types.Transaction
+	baseFee *big.Int
+}
 
-func (t transactionsByGasPrice) Len() int           { return len(t) }
-func (t transactionsByGasPrice) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
-func (t transactionsByGasPrice) Less(i, j int) bool { return t[i].GasPrice().Cmp(t[j].GasPrice()) < 0 }
+func (t transactionsByEffectiveTip) Len() int      { return len(t.txs) }
+func (t transactionsByEffectiveTip) Swap(i, j int) { t.txs[i], t.txs[j] = t.txs[j], t.txs[i] }
+func (t transactionsByEffectiveTip) Less(i, j int) bool {
+	// Transactions of a valid block always cover its base fee, ignore the error
+	tipi, _ := t.txs[i].EffectiveGasTip(t.baseFee)
+	tipj, _ := t.txs[j].EffectiveGasTip(t.baseFee)
+	return tipi.Cmp(tipj) < 0
+}
 
-// getBlockPrices calculates the lowest transaction gas price in a given block
-// and sends it to the result channel. If the block is empty, price is nil.
+// getBlockPrices calculates the lowest transaction tip in a given block and
+// sends it to the result channel. If the block is empty, price is nil.
 func (gpo *Oracle) getBlockPrices(ctx context.Context, signer types.Signer, blockNum uint64, ch chan getBlockPricesResult) {
 	block, err := gpo.backend.BlockByNumber(ctx, rpc.BlockNumber(blockNum))
 	if block == nil {
@@ -170,12 +198,16 @@ func (gpo *Oracle) getBlockPrices(ctx context.Context, signer types.Signer, bloc
 	blockTxs := block.Transactions()
 	txs := make([]*types.Transaction, len(blockTxs))
 	copy(txs, blockTxs)
-	sort.Sort(transactionsByGasPrice(txs))
+	sort.Sort(transactionsByEffectiveTip{txs, block.BaseFee()})
 
 	for _, tx := range txs {
 		sender, err := types.Sender(signer, tx)
 		if err == nil && sender != block.Coinbase() {
-			ch <- getBlockPricesResult{tx.GasPrice(), nil}
+			tip, err := tx.EffectiveGasTip(block.BaseFee())
+			if err != nil {
+				continue
+			}
+			ch <- getBlockPricesResult{tip, nil}
 			return
 		}
 	}
@@ -0,0 +1,432 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/light"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+const (
+	// softResponseLimit is the target maximum size of replies to data retrievals.
+	softResponseLimit = 2 * 1024 * 1024
+
+	// maxCodeLookups is the maximum number of bytecodes to serve. This number is
+	// there to limit the number of disk lookups.
+	maxCodeLookups = 1024
+
+	// maxTrieNodeLookups is the maximum number of state trie nodes to serve. This
+	// number is there to limit the number of disk lookups.
+	maxTrieNodeLookups = 1024
+)
+
+// Handler is a callback to invoke from an outside runner after the boilerplate
+// exchanges have passed.
+type Handler func(peer *Peer) error
+
+// Backend defines the data retrieval methods to serve remote requests and the
+// callback methods to invoke on remote deliveries.
+type Backend interface {
+	// Chain retrieves the blockchain object to serve data.
+	Chain() *core.BlockChain
+
+	// RunPeer is invoked when a peer joins on the `snap` protocol. The handler
+	// should do any peer maintenance work, handshakes and validations. If all
+	// is passed, control should be given back to the `handler` to process the
+	// inbound messages going forward.
+	RunPeer(peer *Peer, handler Handler) error
+
+	// Handle is a callback to be invoked when a data packet is received from
+	// the remote peer. Only packets not consumed by the protocol handler will
+	// be forwarded to the backend.
+	Handle(peer *Peer, packet Packet) error
+}
+
+// MakeProtocols constructs the P2P protocol definitions for `snap`.
+func MakeProtocols(backend Backend) []p2p.Protocol {
+	protocols := make([]p2p.Protocol, len(ProtocolVersions))
+	for i, version := range ProtocolVersions {
+		version := version // Closure
+
+		protocols[i] = p2p.Protocol{
+			Name:    ProtocolName,
+			Version: version,
+			Length:  protocolLengths[version],
+			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+				return backend.RunPeer(newPeer(version, p, rw), func(peer *Peer) error {
+					return handle(backend, peer)
+				})
+			},
+		}
+	}
+	return protocols
+}
+
+// handle is the callback invoked to manage the life cycle of a `snap` peer.
+// When this function terminates, the peer is disconnected.
+func handle(backend Backend, peer *Peer) error {
+	for {
+		if err := handleMessage(backend, peer); err != nil {
+			peer.Log().Debug("Message handling failed in `snap`", "err", err)
+			return err
+		}
+	}
+}
+
+// handleMessage is invoked whenever an inbound message is received from a
+// remote peer on the `snap` protocol. The remote connection is torn down upon
+// returning any error.
+func handleMessage(backend Backend, peer *Peer) error {
+	// Read the next message from the remote peer, and ensure it's fully consumed
+	msg, err := peer.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Size > maxMessageSize {
+		return fmt.Errorf("%v: %v > %v", errMsgTooLarge, msg.Size, maxMessageSize)
+	}
+	defer msg.Discard()
+
+	// Handle the message depending on its contents
+	switch msg.Code {
+	case GetAccountRangeMsg:
+		// Decode the account retrieval request
+		var req GetAccountRangePacket
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, AccountRangeMsg, serviceGetAccountRange(backend.Chain(), &req))
+
+	case AccountRangeMsg:
+		// A range of accounts arrived to one of our previous requests
+		res := new(AccountRangePacket)
+		if err := msg.Decode(res); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		return backend.Handle(peer, res)
+
+	case GetStorageRangesMsg:
+		// Decode the storage retrieval request
+		var req GetStorageRangesPacket
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, StorageRangesMsg, serviceGetStorageRanges(backend.Chain(), &req))
+
+	case StorageRangesMsg:
+		// A range of storage slots arrived to one of our previous requests
+		res := new(StorageRangesPacket)
+		if err := msg.Decode(res); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		return backend.Handle(peer, res)
+
+	case GetByteCodesMsg:
+		// Decode bytecode retrieval request
+		var req GetByteCodesPacket
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, ByteCodesMsg, serviceGetByteCodes(backend.Chain(), &req))
+
+	case ByteCodesMsg:
+		// A batch of byte codes arrived to one of our previous requests
+		res := new(ByteCodesPacket)
+		if err := msg.Decode(res); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		return backend.Handle(peer, res)
+
+	case GetTrieNodesMsg:
+		// Decode trie node retrieval request
+		var req GetTrieNodesPacket
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, TrieNodesMsg, serviceGetTrieNodes(backend.Chain(), &req))
+
+	case TrieNodesMsg:
+		// A batch of trie nodes arrived to one of our previous requests
+		res := new(TrieNodesPacket)
+		if err := msg.Decode(res); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		return backend.Handle(peer, res)
+
+	default:
+		return fmt.Errorf("%v: %v", errInvalidMsgCode, msg.Code)
+	}
+}
+
+// serviceGetAccountRange assembles the response to an account range query. The
+// accounts are served from the state snapshot, with Merkle proofs generated for
+// the origin and the last returned account. An empty response is returned if
+// the requested state is not available.
+func serviceGetAccountRange(chain *core.BlockChain, req *GetAccountRangePacket) *AccountRangePacket {
+	if req.Bytes > softResponseLimit {
+		req.Bytes = softResponseLimit
+	}
+	res := &AccountRangePacket{ID: req.ID}
+
+	// Retrieve the requested state and bail out if non existent
+	snaps := chain.Snapshots()
+	if snaps == nil {
+		return res
+	}
+	it, err := snaps.AccountIterator(req.Root, req.Origin)
+	if err != nil {
+		return res
+	}
+	defer it.Release()
+
+	// Iterate over the requested range and pile accounts up
+	var (
+		last common.Hash
+		size uint64
+	)
+	for size < req.Bytes && it.Next() {
+		hash, account := it.Hash(), common.CopyBytes(it.Account())
+
+		// Track the returned interval for the Merkle proofs
+		last = hash
+
+		// Assemble the reply item
+		size += uint64(common.HashLength + len(account))
+		res.Accounts = append(res.Accounts, &AccountData{
+			Hash: hash,
+			Body: account,
+		})
+		// If we've reached the requested limit, abort
+		if bytes.Compare(hash[:], req.Limit[:]) >= 0 {
+			break
+		}
+	}
+	if err := it.Error(); err != nil {
+		log.Debug("Failed to iterate account range", "root", req.Root, "err", err)
+		return &AccountRangePacket{ID: req.ID}
+	}
+	// Generate the Merkle proofs for the first and last account
+	tr, err := trie.New(req.Root, chain.StateCache().TrieDB())
+	if err != nil {
+		return &AccountRangePacket{ID: req.ID}
+	}
+	proof := light.NewNodeSet()
+	if err := tr.Prove(req.Origin[:], 0, proof); err != nil {
+		log.Warn("Failed to prove account range", "origin", req.Origin, "err", err)
+		return &AccountRangePacket{ID: req.ID}
+	}
+	if last != (common.Hash{}) {
+		if err := tr.Prove(last[:], 0, proof); err != nil {
+			log.Warn("Failed to prove account range", "last", last, "err", err)
+			return &AccountRangePacket{ID: req.ID}
+		}
+	}
+	for _, blob := range proof.NodeList() {
+		res.Proof = append(res.Proof, blob)
+	}
+	return res
+}
+
+// serviceGetStorageRanges assembles the response to a storage range query. The
+// storage slots are served from the state snapshot. Merkle proofs are only added
+// for the last account in the reply and only if its slot range is incomplete.
+func serviceGetStorageRanges(chain *core.BlockChain, req *GetStorageRangesPacket) *StorageRangesPacket {
+	if req.Bytes > softResponseLimit {
+		req.Bytes = softResponseLimit
+	}
+	res := &StorageRangesPacket{ID: req.ID}
+
+	// Retrieve the requested state and bail out if non existent
+	snaps := chain.Snapshots()
+	if snaps == nil {
+		return res
+	}
+	var size uint64
+	for i, account := range req.Accounts {
+		// If we've exceeded the requested data limit, abort without opening
+		// a new storage range (that we'd need to prove due to exceeded size)
+		if size >= req.Bytes {
+			break
+		}
+		// The first account might start from a different origin and the last
+		// account might end at a different limit
+		var (
+			origin common.Hash
+			limit  = common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
+		)
+		if i == 0 && len(req.Origin) > 0 {
+			origin = common.BytesToHash(req.Origin)
+		}
+		if i == len(req.Accounts)-1 && len(req.Limit) > 0 {
+			limit = common.BytesToHash(req.Limit)
+		}
+		// Retrieve the requested state and bail out if non existent
+		it, err := snaps.StorageIterator(req.Root, account, origin)
+		if err != nil {
+			return &StorageRangesPacket{ID: req.ID}
+		}
+		// Iterate over the requested range and pile slots up
+		var (
+			slots []*StorageData
+			last  common.Hash
+			abort bool
+		)
+		for it.Next() {
+			if size >= req.Bytes {
+				abort = true
+				break
+			}
+			hash, slot := it.Hash(), common.CopyBytes(it.Slot())
+
+			// Track the returned interval for the Merkle proofs
+			last = hash
+
+			// Assemble the reply item
+			size += uint64(common.HashLength + len(slot))
+			slots = append(slots, &StorageData{
+				Hash: hash,
+				Body: slot,
+			})
+			// If we've reached the requested limit, abort
+			if bytes.Compare(hash[:], limit[:]) >= 0 {
+				abort = true
+				break
+			}
+		}
+		it.Release()
+
+		res.Slots = append(res.Slots, slots)
+
+		// Generate the Merkle proofs for the first and last storage slot, but
+		// only if the response was capped. If the entire storage trie is
+		// included in the response, no need for any proofs.
+		if origin != (common.Hash{}) || abort {
+			proof, err := proveStorageRange(chain, snaps, req.Root, account, origin, last)
+			if err != nil {
+				log.Warn("Failed to prove storage range", "account", account, "origin", origin, "last", last, "err", err)
+				return &StorageRangesPacket{ID: req.ID}
+			}
+			res.Proof = proof
+
+			// Proof terminates the reply as proofs are only added if a node
+			// refuses to serve more data (exception when a contract fetch is
+			// finishing, but that's that).
+			break
+		}
+	}
+	return res
+}
+
+// proveStorageRange generates the Merkle proofs for the two edges of a storage
+// range of an account in the given state.
+func proveStorageRange(chain *core.BlockChain, snaps *snapshot.Tree, root common.Hash, account common.Hash, origin common.Hash, last common.Hash) ([][]byte, error) {
+	snap := snaps.Snapshot(root)
+	if snap == nil {
+		return nil, fmt.Errorf("missing state %x", root)
+	}
+	acc, err := snap.Account(account)
+	if err != nil {
+		return nil, err
+	}
+	if acc == nil {
+		return nil, fmt.Errorf("missing account %x", account)
+	}
+	tr, err := trie.New(common.BytesToHash(acc.Root), chain.StateCache().TrieDB())
+	if err != nil {
+		return nil, err
+	}
+	proof := light.NewNodeSet()
+	if err := tr.Prove(origin[:], 0, proof); err != nil {
+		return nil, err
+	}
+	if last != (common.Hash{}) {
+		if err := tr.Prove(last[:], 0, proof); err != nil {
+			return nil, err
+		}
+	}
+	var nodes [][]byte
+	for _, blob := range proof.NodeList() {
+		nodes = append(nodes, blob)
+	}
+	return nodes, nil
+}
+
+// serviceGetByteCodes assembles the response to a byte codes query. Unknown
+// codes are silently skipped, the requester needs to match the results up by
+// hash.
+func serviceGetByteCodes(chain *core.BlockChain, req *GetByteCodesPacket) *ByteCodesPacket {
+	if req.Bytes > softResponseLimit {
+		req.Bytes = softResponseLimit
+	}
+	if len(req.Hashes) > maxCodeLookups {
+		req.Hashes = req.Hashes[:maxCodeLookups]
+	}
+	var (
+		res  = &ByteCodesPacket{ID: req.ID}
+		size uint64
+	)
+	for _, hash := range req.Hashes {
+		if hash == emptyCode {
+			// Peers should not request the empty code, but if they do, at
+			// least send them back a correct response without db lookups
+			res.Codes = append(res.Codes, []byte{})
+			continue
+		}
+		if blob, err := chain.StateCache().ContractCode(common.Hash{}, hash); err == nil && len(blob) > 0 {
+			res.Codes = append(res.Codes, blob)
+			size += uint64(len(blob))
+		}
+		if size >= req.Bytes {
+			break
+		}
+	}
+	return res
+}
+
+// serviceGetTrieNodes assembles the response to a trie node query. Unknown nodes
+// are silently skipped, the requester needs to match the results up by hash.
+func serviceGetTrieNodes(chain *core.BlockChain, req *GetTrieNodesPacket) *TrieNodesPacket {
+	if req.Bytes > softResponseLimit {
+		req.Bytes = softResponseLimit
+	}
+	if len(req.Hashes) > maxTrieNodeLookups {
+		req.Hashes = req.Hashes[:maxTrieNodeLookups]
+	}
+	var (
+		res    = &TrieNodesPacket{ID: req.ID}
+		triedb = chain.StateCache().TrieDB()
+		size   uint64
+	)
+	for _, hash := range req.Hashes {
+		if blob, err := triedb.Node(hash); err == nil && len(blob) > 0 {
+			res.Nodes = append(res.Nodes, blob)
+			size += uint64(len(blob))
+		}
+		if size >= req.Bytes {
+			break
+		}
+	}
+	return res
+}
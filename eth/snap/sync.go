@@ -0,0 +1,1434 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/light"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+var (
+	// emptyRoot is the known root hash of an empty trie.
+	emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+	// emptyCode is the known hash of the empty EVM bytecode.
+	emptyCode = crypto.Keccak256Hash(nil)
+)
+
+const (
+	// maxRequestSize is the maximum number of bytes to request from a remote peer.
+	maxRequestSize = 512 * 1024
+
+	// maxStorageSetRequestCount is the maximum number of contracts to request the
+	// storage of in a single query. If this number is too low, we're not filling
+	// responses fully and waste round trip times. If it's too high, we're capping
+	// responses and waste bandwidth.
+	maxStorageSetRequestCount = maxRequestSize / 1024
+
+	// maxCodeRequestCount is the maximum number of bytecode blobs to request in a
+	// single query. If this number is too low, we're not filling responses fully
+	// and waste round trip times. If it's too high, we're capping responses and
+	// waste bandwidth.
+	//
+	// Deployed bytecodes are currently capped at 24KB, so the minimum request
+	// size should be maxRequestSize / 24K. Assuming that most contracts do not
+	// come close to that, requesting 4x should be a good approximation.
+	maxCodeRequestCount = maxRequestSize / (24 * 1024) * 4
+
+	// maxTrieRequestCount is the maximum number of trie node blobs to request in
+	// a single query. If this number is too low, we're not filling responses fully
+	// and waste round trip times. If it's too high, we're capping responses and
+	// waste bandwidth.
+	maxTrieRequestCount = 256
+
+	// accountConcurrency is the number of chunks to split the account trie into
+	// to allow concurrent retrievals.
+	accountConcurrency = 16
+
+	// requestTimeout is the maximum time a peer is allowed to spend on serving
+	// a single network request.
+	requestTimeout = 10 * time.Second
+)
+
+// ErrCancelled is returned if a sync is aborted by the user.
+var ErrCancelled = errors.New("sync cancelled")
+
+// SyncPeer abstracts out the methods required for a peer to be synced against
+// with the goal of allowing the construction of mock peers without the full
+// blown networking.
+type SyncPeer interface {
+	// ID retrieves the peer's unique identifier.
+	ID() string
+
+	// RequestAccountRange fetches a batch of accounts rooted in a specific account
+	// trie, starting with the origin.
+	RequestAccountRange(id uint64, root, origin, limit common.Hash, bytes uint64) error
+
+	// RequestStorageRanges fetches a batch of storage slots belonging to one or
+	// more accounts. If slots from only one account is requested, an origin marker
+	// may also be used to retrieve from there.
+	RequestStorageRanges(id uint64, root common.Hash, accounts []common.Hash, origin, limit []byte, bytes uint64) error
+
+	// RequestByteCodes fetches a batch of bytecodes by hash.
+	RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error
+
+	// RequestTrieNodes fetches a batch of account or storage trie nodes by hash.
+	RequestTrieNodes(id uint64, root common.Hash, hashes []common.Hash, bytes uint64) error
+
+	// Log retrieves the peer's own contextual logger.
+	Log() log.Logger
+}
+
+// accountRequest tracks a pending account range request to ensure responses are
+// to actual requests and to validate any security constraints.
+type accountRequest struct {
+	peer string      // Peer to which this request is assigned
+	id   uint64      // Request ID of this request
+	root common.Hash // State root the range was requested from
+
+	origin common.Hash // First account requested to allow continuation checks
+	limit  common.Hash // Last account requested to allow non-overlapping chunking
+
+	task    *accountTask    // Task which this request is filling
+	timeout *time.Timer     // Timer to track delivery timeout
+	stale   <-chan struct{} // Channel to signal the request was dropped
+}
+
+// accountResponse is an already verified remote response to an account range
+// request, containing the proven accounts and the trie nodes completely covered
+// by them.
+type accountResponse struct {
+	req *accountRequest // Original request to finalize
+
+	hashes   []common.Hash    // Account hashes in the returned range
+	accounts []*state.Account // Expanded accounts in the returned range
+
+	nodes  ethdb.KeyValueStore // Complete trie nodes proven by the range
+	cont   bool                // Whether the account range has a continuation
+	failed bool                // Whether the request failed and needs rescheduling
+}
+
+// storageRequest tracks a pending storage ranges request to ensure responses are
+// to actual requests and to validate any security constraints.
+type storageRequest struct {
+	peer string      // Peer to which this request is assigned
+	id   uint64      // Request ID of this request
+	root common.Hash // State root the ranges were requested from
+
+	origin common.Hash    // First storage slot requested (large contract mode)
+	tasks  []*storageTask // Tasks which this request is filling
+
+	timeout *time.Timer     // Timer to track delivery timeout
+	stale   <-chan struct{} // Channel to signal the request was dropped
+}
+
+// storageResponse is an already verified remote response to a storage ranges
+// request, containing the trie nodes completely covered by the returned slots.
+type storageResponse struct {
+	req *storageRequest // Original request to finalize
+
+	nodes  []ethdb.KeyValueStore // Complete trie nodes proven by each returned range
+	slots  int                   // Number of storage slots delivered
+	last   common.Hash           // Last slot delivered for the last returned range
+	cont   bool                  // Whether the last storage range has a continuation
+	failed bool                  // Whether the request failed and needs rescheduling
+}
+
+// bytecodeRequest tracks a pending bytecode request to ensure responses are to
+// actual requests and to validate any security constraints.
+type bytecodeRequest struct {
+	peer   string        // Peer to which this request is assigned
+	id     uint64        // Request ID of this request
+	hashes []common.Hash // Bytecode hashes to validate responses
+
+	timeout *time.Timer     // Timer to track delivery timeout
+	stale   <-chan struct{} // Channel to signal the request was dropped
+}
+
+// bytecodeResponse is an already verified remote response to a bytecode request.
+type bytecodeResponse struct {
+	req *bytecodeRequest // Original request to finalize
+
+	codes  map[common.Hash][]byte // Actual bytecodes to store into the database
+	failed bool                   // Whether the request failed and needs rescheduling
+}
+
+// trienodeRequest tracks a pending state trie request to ensure responses are to
+// actual requests and to validate any security constraints.
+type trienodeRequest struct {
+	peer   string        // Peer to which this request is assigned
+	id     uint64        // Request ID of this request
+	hashes []common.Hash // Trie node hashes to validate responses
+
+	timeout *time.Timer     // Timer to track delivery timeout
+	stale   <-chan struct{} // Channel to signal the request was dropped
+}
+
+// trienodeResponse is an already verified remote response to a trie node request.
+type trienodeResponse struct {
+	req *trienodeRequest // Original request to finalize
+
+	nodes  map[common.Hash][]byte // Actual trie nodes to feed to the healer
+	failed bool                   // Whether the request failed and needs rescheduling
+}
+
+// accountTask represents the sync task for a chunk of the account snapshot.
+type accountTask struct {
+	Next common.Hash // Next account to sync in this interval
+	Last common.Hash // Last account to sync in this interval
+
+	req  *accountRequest // Pending request to fill this interval
+	done bool            // Flag whether the task can be removed
+}
+
+// accountChunk is a verified range of accounts whose trie nodes are waiting for
+// the storage tries and bytecodes referenced by the accounts to be retrieved. The
+// account trie nodes are only persisted after all their dependencies, otherwise
+// healing would consider the subtries complete and never descend into them.
+type accountChunk struct {
+	nodes   ethdb.KeyValueStore // Complete trie nodes proven by the account range
+	pend    int                 // Number of storage tries and bytecodes still pending
+	tainted bool                // Flag whether a dependency could not be fully retrieved
+}
+
+// storageTask represents the sync task for a single storage trie.
+type storageTask struct {
+	root      common.Hash // Storage root hash to retrieve the trie of
+	account   common.Hash // Account hash to request the storage of
+	stateRoot common.Hash // State root the account was retrieved from
+
+	next    common.Hash // Next storage slot to retrieve (large contract mode)
+	chunked bool        // Flag whether the storage is too large for a single reply
+
+	chunks []*accountChunk // Account chunks waiting for this storage trie
+	req    *storageRequest // Pending request to fill this storage trie
+}
+
+// bytecodeTask represents the sync task for a single contract bytecode.
+type bytecodeTask struct {
+	chunks []*accountChunk  // Account chunks waiting for this bytecode
+	req    *bytecodeRequest // Pending request to fill this bytecode
+}
+
+// healTask represents the sync task for healing the state trie after the flat
+// ranges have been retrieved (and for filling in boundary nodes).
+type healTask struct {
+	scheduler *trie.Sync    // State trie sync scheduler defining the tasks
+	retry     []common.Hash // Trie nodes that need to be requested again
+}
+
+// Syncer is an Ethereum account and storage trie syncer based on snapshots and
+// the snap protocol. Its purpose is to download all the accounts and storage
+// slots from remote peers and reassemble chunks of the state trie, on top of
+// which a state sync can be run to fix any gaps / overlaps.
+//
+// Every network request has a variety of failure events:
+//   - The peer disconnects after task assignment, failing to send the request
+//   - The peer disconnects after sending the request, before delivering on it
+//   - The peer remains connected, but does not deliver a response in time
+//   - The peer delivers a stale response after a previous timeout
+//   - The peer delivers a refusal to serve the requested state
+type Syncer struct {
+	db    ethdb.KeyValueStore // Database to store the trie nodes into (and dedup)
+	bloom *trie.SyncBloom     // Bloom filter to deduplicate nodes for state fixup
+
+	root    common.Hash                   // Current state trie root being synced
+	tasks   []*accountTask                // Current account task set being synced
+	storage map[common.Hash]*storageTask  // Storage tries pending retrieval, keyed by root
+	codes   map[common.Hash]*bytecodeTask // Bytecodes pending retrieval, keyed by hash
+	healer  *healTask                     // Current state healing task being executed
+	update  chan struct{}                 // Notification channel for possible sync progression
+	quit    chan struct{}                 // Channel to signal the termination of a sync cycle
+
+	peers     map[string]SyncPeer // Currently active peers to download from
+	busy      map[string]struct{} // Peers currently serving a request
+	stateless map[string]struct{} // Peers that failed to deliver the current state
+
+	accountReqs  map[uint64]*accountRequest  // Account requests currently running
+	storageReqs  map[uint64]*storageRequest  // Storage requests currently running
+	bytecodeReqs map[uint64]*bytecodeRequest // Bytecode requests currently running
+	trienodeReqs map[uint64]*trienodeRequest // Trie node requests currently running
+
+	accountResps  chan *accountResponse  // Account sub-tasks finished or failed
+	storageResps  chan *storageResponse  // Storage sub-tasks finished or failed
+	bytecodeResps chan *bytecodeResponse // Bytecode sub-tasks finished or failed
+	trienodeResps chan *trienodeResponse // Trie node sub-tasks finished or failed
+
+	accountSynced  uint64 // Number of accounts downloaded
+	storageSynced  uint64 // Number of storage slots downloaded
+	bytecodeSynced uint64 // Number of bytecodes downloaded
+	trienodeHealed uint64 // Number of state trie nodes downloaded during healing
+	logTime        time.Time
+
+	lock sync.RWMutex // Protects fields that can change outside of sync (peers, reqs, root)
+}
+
+// NewSyncer creates a new snapshot syncer to download the Ethereum state over the
+// snap protocol. The state bloom is the same one used by the fast sync state
+// scheduler, all retrieved nodes are registered into it.
+func NewSyncer(db ethdb.KeyValueStore, bloom *trie.SyncBloom) *Syncer {
+	return &Syncer{
+		db:            db,
+		bloom:         bloom,
+		tasks:         newAccountTasks(),
+		storage:       make(map[common.Hash]*storageTask),
+		codes:         make(map[common.Hash]*bytecodeTask),
+		update:        make(chan struct{}, 1),
+		peers:         make(map[string]SyncPeer),
+		busy:          make(map[string]struct{}),
+		stateless:     make(map[string]struct{}),
+		accountReqs:   make(map[uint64]*accountRequest),
+		storageReqs:   make(map[uint64]*storageRequest),
+		bytecodeReqs:  make(map[uint64]*bytecodeRequest),
+		trienodeReqs:  make(map[uint64]*trienodeRequest),
+		accountResps:  make(chan *accountResponse),
+		storageResps:  make(chan *storageResponse),
+		bytecodeResps: make(chan *bytecodeResponse),
+		trienodeResps: make(chan *trienodeResponse),
+	}
+}
+
+// newAccountTasks splits the account hash space into equal chunks that can be
+// retrieved concurrently.
+func newAccountTasks() []*accountTask {
+	var (
+		tasks []*accountTask
+		next  common.Hash
+		step  = new(big.Int).Sub(new(big.Int).Div(new(big.Int).Exp(common.Big2, common.Big256, nil), big.NewInt(accountConcurrency)), common.Big1)
+	)
+	for i := 0; i < accountConcurrency; i++ {
+		last := common.BigToHash(new(big.Int).Add(next.Big(), step))
+		if i == accountConcurrency-1 {
+			// Make sure we don't overflow if the step is not a proper divisor
+			last = common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
+		}
+		tasks = append(tasks, &accountTask{Next: next, Last: last})
+		next = incHash(last)
+	}
+	return tasks
+}
+
+// Register injects a new data source into the syncer's peerset.
+func (s *Syncer) Register(peer SyncPeer) error {
+	// Make sure the peer is not registered yet
+	id := peer.ID()
+
+	s.lock.Lock()
+	if _, ok := s.peers[id]; ok {
+		log.Error("Snap peer already registered", "id", id)
+
+		s.lock.Unlock()
+		return errors.New("already registered")
+	}
+	s.peers[id] = peer
+	s.lock.Unlock()
+
+	// Notify any active syncs that a new peer can be assigned data
+	select {
+	case s.update <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Unregister removes a data source from the syncer's peerset, rescheduling any
+// requests that were assigned to it.
+func (s *Syncer) Unregister(id string) error {
+	// Remove all traces of the peer from the registry
+	s.lock.Lock()
+	if _, ok := s.peers[id]; !ok {
+		log.Error("Snap peer not registered", "id", id)
+
+		s.lock.Unlock()
+		return errors.New("not registered")
+	}
+	delete(s.peers, id)
+	delete(s.stateless, id)
+
+	// Collect all the requests assigned to the peer for rescheduling
+	var (
+		accountReqs  []*accountRequest
+		storageReqs  []*storageRequest
+		bytecodeReqs []*bytecodeRequest
+		trienodeReqs []*trienodeRequest
+	)
+	for _, req := range s.accountReqs {
+		if req.peer == id {
+			accountReqs = append(accountReqs, req)
+		}
+	}
+	for _, req := range s.storageReqs {
+		if req.peer == id {
+			storageReqs = append(storageReqs, req)
+		}
+	}
+	for _, req := range s.bytecodeReqs {
+		if req.peer == id {
+			bytecodeReqs = append(bytecodeReqs, req)
+		}
+	}
+	for _, req := range s.trienodeReqs {
+		if req.peer == id {
+			trienodeReqs = append(trienodeReqs, req)
+		}
+	}
+	s.lock.Unlock()
+
+	for _, req := range accountReqs {
+		s.revertAccountRequest(req)
+	}
+	for _, req := range storageReqs {
+		s.revertStorageRequest(req)
+	}
+	for _, req := range bytecodeReqs {
+		s.revertBytecodeRequest(req)
+	}
+	for _, req := range trienodeReqs {
+		s.revertTrienodeRequest(req)
+	}
+	return nil
+}
+
+// Sync starts (or resumes a previous) sync cycle to iterate over an state trie
+// with the given root and reconstruct the nodes based on the snapshot leaves.
+// Previously downloaded segments will not be redownloaded or fixed, rather any
+// errors will be healed after the leaves are fully accumulated.
+func (s *Syncer) Sync(root common.Hash, cancel chan struct{}) error {
+	// An empty state needs no downloading at all
+	if root == emptyRoot {
+		return nil
+	}
+	// Move the trie root from any previous value, and drop all the storage tasks
+	// that belong to older states, healing will take care of their accounts
+	s.lock.Lock()
+	if s.root != root {
+		s.root = root
+		s.stateless = make(map[string]struct{})
+	}
+	s.quit = make(chan struct{})
+	s.lock.Unlock()
+
+	defer s.cleanup()
+
+	if err := s.dropStaleStorage(); err != nil {
+		return err
+	}
+	log.Debug("Starting snapshot sync cycle", "root", root)
+
+	for {
+		// Remove all completed tasks and terminate sync if everything's done
+		s.cleanAccountTasks()
+		if len(s.tasks) == 0 && len(s.storage) == 0 && len(s.codes) == 0 {
+			// Snapshot ranges finished, heal the trie (including all the gaps
+			// along the range boundaries) via the fast sync scheduler
+			if s.healer == nil {
+				s.healer = &healTask{
+					scheduler: state.NewStateSync(root, s.db, s.bloom),
+				}
+			}
+			if s.healer.scheduler.Pending() == 0 {
+				log.Info("Snapshot sync complete", "accounts", s.accountSynced, "slots", s.storageSynced, "codes", s.bytecodeSynced, "healed", s.trienodeHealed)
+
+				s.tasks = newAccountTasks()
+				return nil
+			}
+		}
+		s.report()
+
+		// Assign all the data retrieval tasks to any free peers
+		s.assignAccountTasks()
+		s.assignStorageTasks()
+		s.assignBytecodeTasks()
+		s.assignTrienodeHealTasks()
+
+		// Wait for something to happen
+		var err error
+		select {
+		case <-s.update:
+			// Something happened (new peer, delivery, timeout), recheck tasks
+		case <-cancel:
+			return ErrCancelled
+
+		case res := <-s.accountResps:
+			err = s.processAccountResponse(res)
+		case res := <-s.storageResps:
+			err = s.processStorageResponse(res)
+		case res := <-s.bytecodeResps:
+			err = s.processBytecodeResponse(res)
+		case res := <-s.trienodeResps:
+			err = s.processTrienodeHealResponse(res)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// cleanup drops all the in-flight requests of a sync cycle when it terminates
+// and releases all the peers assigned to them. Late deliveries will be ignored.
+func (s *Syncer) cleanup() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for id, req := range s.accountReqs {
+		req.timeout.Stop()
+		delete(s.accountReqs, id)
+	}
+	for id, req := range s.storageReqs {
+		req.timeout.Stop()
+		delete(s.storageReqs, id)
+	}
+	for id, req := range s.bytecodeReqs {
+		req.timeout.Stop()
+		delete(s.bytecodeReqs, id)
+	}
+	for id, req := range s.trienodeReqs {
+		req.timeout.Stop()
+		delete(s.trienodeReqs, id)
+	}
+	s.busy = make(map[string]struct{})
+	close(s.quit)
+
+	// Reset all the task assignments, responses in flight will never arrive. The
+	// healer is dropped altogether since its scheduler tracks in-flight nodes,
+	// a new one will be recreated from the database contents on the next cycle.
+	for _, task := range s.tasks {
+		task.req = nil
+	}
+	for _, task := range s.storage {
+		task.req = nil
+	}
+	for _, task := range s.codes {
+		task.req = nil
+	}
+	s.healer = nil
+}
+
+// dropStaleStorage removes all the storage tasks that were scheduled for an
+// older state root. Their owning accounts will be taken care of by healing.
+func (s *Syncer) dropStaleStorage() error {
+	for root, task := range s.storage {
+		if task.stateRoot == s.root {
+			continue
+		}
+		delete(s.storage, root)
+		for _, chunk := range task.chunks {
+			if err := s.resolveChunk(chunk, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cleanAccountTasks removes account range retrieval tasks that have already been
+// completed.
+func (s *Syncer) cleanAccountTasks() {
+	for i := 0; i < len(s.tasks); i++ {
+		if s.tasks[i].done {
+			s.tasks = append(s.tasks[:i], s.tasks[i+1:]...)
+			i--
+		}
+	}
+}
+
+// report periodically prints the progress of the sync.
+func (s *Syncer) report() {
+	if time.Since(s.logTime) < 8*time.Second {
+		return
+	}
+	s.logTime = time.Now()
+
+	if s.healer == nil {
+		log.Info("State sync in progress", "accounts", s.accountSynced, "slots", s.storageSynced, "codes", s.bytecodeSynced, "ranges", len(s.tasks), "tries", len(s.storage), "pending", len(s.codes))
+	} else {
+		log.Info("State heal in progress", "nodes", s.trienodeHealed, "pending", s.healer.scheduler.Pending())
+	}
+}
+
+// idlePeer retrieves a peer that's not busy serving a request and that did not
+// refuse to serve the current state yet. The caller must hold the lock.
+func (s *Syncer) idlePeer() SyncPeer {
+	for id, peer := range s.peers {
+		if _, ok := s.busy[id]; ok {
+			continue
+		}
+		if _, ok := s.stateless[id]; ok {
+			continue
+		}
+		return peer
+	}
+	return nil
+}
+
+// nextRequestID generates a request ID that's unique across all the in-flight
+// requests. The caller must hold the lock.
+func (s *Syncer) nextRequestID() uint64 {
+	for {
+		id := rand.Uint64()
+		if _, ok := s.accountReqs[id]; ok {
+			continue
+		}
+		if _, ok := s.storageReqs[id]; ok {
+			continue
+		}
+		if _, ok := s.bytecodeReqs[id]; ok {
+			continue
+		}
+		if _, ok := s.trienodeReqs[id]; ok {
+			continue
+		}
+		return id
+	}
+}
+
+// assignAccountTasks attempts to match idle peers to pending account range
+// retrievals.
+func (s *Syncer) assignAccountTasks() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, task := range s.tasks {
+		// Skip any tasks already filling or done
+		if task.done || task.req != nil {
+			continue
+		}
+		peer := s.idlePeer()
+		if peer == nil {
+			return
+		}
+		req := &accountRequest{
+			peer:   peer.ID(),
+			id:     s.nextRequestID(),
+			root:   s.root,
+			origin: task.Next,
+			limit:  task.Last,
+			task:   task,
+			stale:  s.quit,
+		}
+		req.timeout = time.AfterFunc(requestTimeout, func() {
+			peer.Log().Debug("Account range request timed out", "reqid", req.id)
+			s.revertAccountRequest(req)
+		})
+		s.accountReqs[req.id] = req
+		s.busy[req.peer] = struct{}{}
+		task.req = req
+
+		go func() {
+			if err := peer.RequestAccountRange(req.id, req.root, req.origin, req.limit, maxRequestSize); err != nil {
+				peer.Log().Debug("Failed to request account range", "err", err)
+				s.revertAccountRequest(req)
+			}
+		}()
+	}
+}
+
+// assignStorageTasks attempts to match idle peers to pending storage range
+// retrievals. Small storage tries are requested in batches, large ones chunked
+// individually.
+func (s *Syncer) assignStorageTasks() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for {
+		// Gather a batch of storage tries to retrieve, chunked ones separately
+		var tasks []*storageTask
+		for _, task := range s.storage {
+			if task.req != nil {
+				continue
+			}
+			if task.chunked {
+				if len(tasks) == 0 {
+					tasks = append(tasks, task)
+					break
+				}
+				continue
+			}
+			tasks = append(tasks, task)
+			if len(tasks) >= maxStorageSetRequestCount {
+				break
+			}
+		}
+		if len(tasks) == 0 {
+			return
+		}
+		peer := s.idlePeer()
+		if peer == nil {
+			return
+		}
+		req := &storageRequest{
+			peer:  peer.ID(),
+			id:    s.nextRequestID(),
+			root:  s.root,
+			tasks: tasks,
+			stale: s.quit,
+		}
+		accounts := make([]common.Hash, len(tasks))
+		for i, task := range tasks {
+			accounts[i] = task.account
+			task.req = req
+		}
+		var origin []byte
+		if tasks[0].chunked {
+			req.origin = tasks[0].next
+			origin = common.CopyBytes(req.origin[:])
+		}
+		req.timeout = time.AfterFunc(requestTimeout, func() {
+			peer.Log().Debug("Storage ranges request timed out", "reqid", req.id)
+			s.revertStorageRequest(req)
+		})
+		s.storageReqs[req.id] = req
+		s.busy[req.peer] = struct{}{}
+
+		go func() {
+			if err := peer.RequestStorageRanges(req.id, req.root, accounts, origin, nil, maxRequestSize); err != nil {
+				peer.Log().Debug("Failed to request storage ranges", "err", err)
+				s.revertStorageRequest(req)
+			}
+		}()
+	}
+}
+
+// assignBytecodeTasks attempts to match idle peers to pending code retrievals.
+func (s *Syncer) assignBytecodeTasks() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for {
+		// Gather a batch of bytecodes to retrieve
+		var hashes []common.Hash
+		for hash, task := range s.codes {
+			if task.req != nil {
+				continue
+			}
+			hashes = append(hashes, hash)
+			if len(hashes) >= maxCodeRequestCount {
+				break
+			}
+		}
+		if len(hashes) == 0 {
+			return
+		}
+		peer := s.idlePeer()
+		if peer == nil {
+			return
+		}
+		req := &bytecodeRequest{
+			peer:   peer.ID(),
+			id:     s.nextRequestID(),
+			hashes: hashes,
+			stale:  s.quit,
+		}
+		for _, hash := range hashes {
+			s.codes[hash].req = req
+		}
+		req.timeout = time.AfterFunc(requestTimeout, func() {
+			peer.Log().Debug("Bytecode request timed out", "reqid", req.id)
+			s.revertBytecodeRequest(req)
+		})
+		s.bytecodeReqs[req.id] = req
+		s.busy[req.peer] = struct{}{}
+
+		go func() {
+			if err := peer.RequestByteCodes(req.id, hashes, maxRequestSize); err != nil {
+				peer.Log().Debug("Failed to request bytecodes", "err", err)
+				s.revertBytecodeRequest(req)
+			}
+		}()
+	}
+}
+
+// assignTrienodeHealTasks attempts to match idle peers to trie node requests to
+// heal any trie errors caused by the snap sync's chunked retrieval model.
+func (s *Syncer) assignTrienodeHealTasks() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.healer == nil {
+		return
+	}
+	for {
+		peer := s.idlePeer()
+		if peer == nil {
+			return
+		}
+		// Gather a batch of trie nodes to retrieve, retries first
+		var hashes []common.Hash
+		if len(s.healer.retry) > maxTrieRequestCount {
+			hashes = append(hashes, s.healer.retry[:maxTrieRequestCount]...)
+			s.healer.retry = s.healer.retry[maxTrieRequestCount:]
+		} else {
+			hashes = append(hashes, s.healer.retry...)
+			s.healer.retry = nil
+		}
+		if len(hashes) < maxTrieRequestCount {
+			hashes = append(hashes, s.healer.scheduler.Missing(maxTrieRequestCount-len(hashes))...)
+		}
+		if len(hashes) == 0 {
+			return
+		}
+		req := &trienodeRequest{
+			peer:   peer.ID(),
+			id:     s.nextRequestID(),
+			hashes: hashes,
+			stale:  s.quit,
+		}
+		req.timeout = time.AfterFunc(requestTimeout, func() {
+			peer.Log().Debug("Trienode heal request timed out", "reqid", req.id)
+			s.revertTrienodeRequest(req)
+		})
+		s.trienodeReqs[req.id] = req
+		s.busy[req.peer] = struct{}{}
+
+		root := s.root
+		go func() {
+			if err := peer.RequestTrieNodes(req.id, root, hashes, maxRequestSize); err != nil {
+				peer.Log().Debug("Failed to request trienode healers", "err", err)
+				s.revertTrienodeRequest(req)
+			}
+		}()
+	}
+}
+
+// revertAccountRequest cleans up an account range request and returns all failed
+// retrieval tasks to the scheduler for reassignment.
+func (s *Syncer) revertAccountRequest(req *accountRequest) {
+	s.lock.Lock()
+	if s.accountReqs[req.id] != req {
+		s.lock.Unlock()
+		return // already reverted or delivered
+	}
+	delete(s.accountReqs, req.id)
+	delete(s.busy, req.peer)
+	s.lock.Unlock()
+
+	req.timeout.Stop()
+	s.deliverAccounts(&accountResponse{req: req, failed: true})
+}
+
+// revertStorageRequest cleans up a storage range request and returns all failed
+// retrieval tasks to the scheduler for reassignment.
+func (s *Syncer) revertStorageRequest(req *storageRequest) {
+	s.lock.Lock()
+	if s.storageReqs[req.id] != req {
+		s.lock.Unlock()
+		return // already reverted or delivered
+	}
+	delete(s.storageReqs, req.id)
+	delete(s.busy, req.peer)
+	s.lock.Unlock()
+
+	req.timeout.Stop()
+	s.deliverStorage(&storageResponse{req: req, failed: true})
+}
+
+// revertBytecodeRequest cleans up a bytecode request and returns all failed
+// retrieval tasks to the scheduler for reassignment.
+func (s *Syncer) revertBytecodeRequest(req *bytecodeRequest) {
+	s.lock.Lock()
+	if s.bytecodeReqs[req.id] != req {
+		s.lock.Unlock()
+		return // already reverted or delivered
+	}
+	delete(s.bytecodeReqs, req.id)
+	delete(s.busy, req.peer)
+	s.lock.Unlock()
+
+	req.timeout.Stop()
+	s.deliverBytecodes(&bytecodeResponse{req: req, failed: true})
+}
+
+// revertTrienodeRequest cleans up a trie node request and returns all failed
+// retrieval tasks to the scheduler for reassignment.
+func (s *Syncer) revertTrienodeRequest(req *trienodeRequest) {
+	s.lock.Lock()
+	if s.trienodeReqs[req.id] != req {
+		s.lock.Unlock()
+		return // already reverted or delivered
+	}
+	delete(s.trienodeReqs, req.id)
+	delete(s.busy, req.peer)
+	s.lock.Unlock()
+
+	req.timeout.Stop()
+	s.deliverTrienodes(&trienodeResponse{req: req, failed: true})
+}
+
+// deliverAccounts pushes an account response into the sync loop, unless the
+// sync cycle it belongs to was already terminated.
+func (s *Syncer) deliverAccounts(res *accountResponse) {
+	select {
+	case s.accountResps <- res:
+	case <-res.req.stale:
+	}
+}
+
+// deliverStorage pushes a storage response into the sync loop, unless the sync
+// cycle it belongs to was already terminated.
+func (s *Syncer) deliverStorage(res *storageResponse) {
+	select {
+	case s.storageResps <- res:
+	case <-res.req.stale:
+	}
+}
+
+// deliverBytecodes pushes a bytecode response into the sync loop, unless the
+// sync cycle it belongs to was already terminated.
+func (s *Syncer) deliverBytecodes(res *bytecodeResponse) {
+	select {
+	case s.bytecodeResps <- res:
+	case <-res.req.stale:
+	}
+}
+
+// deliverTrienodes pushes a trie node response into the sync loop, unless the
+// sync cycle it belongs to was already terminated.
+func (s *Syncer) deliverTrienodes(res *trienodeResponse) {
+	select {
+	case s.trienodeResps <- res:
+	case <-res.req.stale:
+	}
+}
+
+// processAccountResponse integrates an already validated account range response
+// into the account tasks, scheduling the retrieval of all the storage tries and
+// bytecodes the accounts reference.
+func (s *Syncer) processAccountResponse(res *accountResponse) error {
+	task := res.req.task
+	task.req = nil
+
+	if res.failed {
+		return nil
+	}
+	s.accountSynced += uint64(len(res.accounts))
+
+	// Schedule all the dependencies of the accounts and hold back the trie nodes
+	// until they are retrieved
+	chunk := &accountChunk{nodes: res.nodes}
+	for i, account := range res.accounts {
+		if hash := common.BytesToHash(account.CodeHash); hash != emptyCode {
+			if task, ok := s.codes[hash]; ok {
+				task.chunks = append(task.chunks, chunk)
+				chunk.pend++
+			} else if !s.hasEntry(hash) {
+				s.codes[hash] = &bytecodeTask{chunks: []*accountChunk{chunk}}
+				chunk.pend++
+			}
+		}
+		if account.Root != emptyRoot {
+			if task, ok := s.storage[account.Root]; ok {
+				task.chunks = append(task.chunks, chunk)
+				chunk.pend++
+			} else if !s.hasEntry(account.Root) {
+				s.storage[account.Root] = &storageTask{
+					root:      account.Root,
+					account:   res.hashes[i],
+					stateRoot: res.req.root,
+					chunks:    []*accountChunk{chunk},
+				}
+				chunk.pend++
+			}
+		}
+	}
+	// Move the task forward, or mark it done if the range is exhausted
+	if len(res.hashes) == 0 || !res.cont {
+		task.done = true
+	} else if last := res.hashes[len(res.hashes)-1]; bytes.Compare(last[:], task.Last[:]) >= 0 {
+		task.done = true
+	} else {
+		task.Next = incHash(last)
+	}
+	if chunk.pend == 0 {
+		return s.commitNodes(chunk.nodes)
+	}
+	return nil
+}
+
+// processStorageResponse integrates an already validated storage ranges response
+// into the storage tasks, persisting the completed trie nodes and releasing any
+// account chunks waiting for the tries.
+func (s *Syncer) processStorageResponse(res *storageResponse) error {
+	for _, task := range res.req.tasks {
+		task.req = nil
+	}
+	if res.failed {
+		return nil
+	}
+	s.storageSynced += uint64(res.slots)
+
+	for i, nodes := range res.nodes {
+		task := res.req.tasks[i]
+		if err := s.commitNodes(nodes); err != nil {
+			return err
+		}
+		// If the storage trie is too large for a single reply, continue in
+		// chunked mode from the last delivered slot
+		if i == len(res.nodes)-1 && res.cont {
+			task.chunked = true
+			task.next = incHash(res.last)
+			continue
+		}
+		// Storage trie retrieved, release all accounts waiting for it. Chunked
+		// tries have gaps along the chunk boundaries, so those are left for the
+		// healer to fix up.
+		delete(s.storage, task.root)
+		for _, chunk := range task.chunks {
+			if err := s.resolveChunk(chunk, task.chunked); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// processBytecodeResponse integrates an already validated bytecode response into
+// the database, releasing any account chunks waiting for the codes.
+func (s *Syncer) processBytecodeResponse(res *bytecodeResponse) error {
+	for _, hash := range res.req.hashes {
+		s.codes[hash].req = nil
+	}
+	if res.failed {
+		return nil
+	}
+	batch := s.db.NewBatch()
+	for hash, code := range res.codes {
+		if err := batch.Put(hash[:], code); err != nil {
+			return err
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	for hash := range res.codes {
+		if s.bloom != nil {
+			s.bloom.Add(hash[:])
+		}
+		task := s.codes[hash]
+		delete(s.codes, hash)
+
+		for _, chunk := range task.chunks {
+			if err := s.resolveChunk(chunk, false); err != nil {
+				return err
+			}
+		}
+	}
+	s.bytecodeSynced += uint64(len(res.codes))
+	return nil
+}
+
+// processTrienodeHealResponse feeds an already validated trie node response into
+// the healing scheduler and persists any completed nodes.
+func (s *Syncer) processTrienodeHealResponse(res *trienodeResponse) error {
+	if s.healer == nil {
+		return nil // Healer reset since the request was made
+	}
+	if res.failed {
+		s.healer.retry = append(s.healer.retry, res.req.hashes...)
+		return nil
+	}
+	var results []trie.SyncResult
+	for _, hash := range res.req.hashes {
+		if node, ok := res.nodes[hash]; ok {
+			results = append(results, trie.SyncResult{Hash: hash, Data: node})
+		} else {
+			s.healer.retry = append(s.healer.retry, hash)
+		}
+	}
+	for _, result := range results {
+		if _, _, err := s.healer.scheduler.Process([]trie.SyncResult{result}); err != nil {
+			log.Warn("Failed to process healed trie node", "hash", result.Hash, "err", err)
+		}
+	}
+	batch := s.db.NewBatch()
+	if _, err := s.healer.scheduler.Commit(batch); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	s.trienodeHealed += uint64(len(results))
+	return nil
+}
+
+// resolveChunk marks a dependency of an account chunk as retrieved (or failed),
+// persisting the trie nodes of the chunk if it has no more pending dependencies.
+func (s *Syncer) resolveChunk(chunk *accountChunk, tainted bool) error {
+	if tainted {
+		chunk.tainted = true
+	}
+	if chunk.pend--; chunk.pend > 0 || chunk.tainted {
+		return nil
+	}
+	return s.commitNodes(chunk.nodes)
+}
+
+// commitNodes writes a set of completed trie nodes into the database, also
+// registering them in the state bloom filter.
+func (s *Syncer) commitNodes(nodes ethdb.KeyValueStore) error {
+	if nodes == nil {
+		return nil
+	}
+	batch := s.db.NewBatch()
+
+	it := nodes.NewIterator()
+	for it.Next() {
+		if err := batch.Put(it.Key(), it.Value()); err != nil {
+			it.Release()
+			return err
+		}
+	}
+	it.Release()
+
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	if s.bloom != nil {
+		it = nodes.NewIterator()
+		for it.Next() {
+			s.bloom.Add(it.Key())
+		}
+		it.Release()
+	}
+	return nil
+}
+
+// hasEntry checks whether a trie node or bytecode is already present in the
+// database. As trie nodes are only ever written if their entire subtrie is
+// present, a hit means there's nothing to retrieve.
+func (s *Syncer) hasEntry(hash common.Hash) bool {
+	ok, _ := s.db.Has(hash[:])
+	return ok
+}
+
+// OnAccounts is a callback method to invoke when a range of accounts are
+// received from a remote peer.
+func (s *Syncer) OnAccounts(peer SyncPeer, id uint64, hashes []common.Hash, accounts [][]byte, proof [][]byte) error {
+	logger := peer.Log().New("reqid", id)
+	logger.Trace("Delivering range of accounts", "hashes", len(hashes), "accounts", len(accounts), "proofs", len(proof))
+
+	// Whether or not the response is valid, we can mark the peer as idle and
+	// notify the scheduler to assign a new task. If the response is invalid,
+	// we'll drop the peer in a bit.
+	s.lock.Lock()
+	req, ok := s.accountReqs[id]
+	if !ok {
+		// Request stale, perhaps the peer timed out but came through in the end
+		logger.Warn("Unexpected account range packet")
+		s.lock.Unlock()
+		return nil
+	}
+	delete(s.accountReqs, id)
+	delete(s.busy, peer.ID())
+
+	// Clean up the request timeout timer, we'll see how to proceed further based
+	// on the actual delivered content
+	req.timeout.Stop()
+
+	// Response is valid, but check if peer is signalling that it does not have
+	// the requested data. For account range queries that means the state being
+	// retrieved was either already pruned remotely, or the peer is not yet
+	// synced to our head.
+	if len(hashes) == 0 && len(proof) == 0 {
+		logger.Debug("Peer rejected account range request", "root", req.root)
+		s.stateless[peer.ID()] = struct{}{}
+		s.lock.Unlock()
+
+		s.deliverAccounts(&accountResponse{req: req, failed: true})
+		return nil
+	}
+	s.lock.Unlock()
+
+	// Reconstruct a partial trie from the response and verify it
+	keys := make([][]byte, len(hashes))
+	for i, key := range hashes {
+		keys[i] = common.CopyBytes(key[:])
+	}
+	var end []byte
+	if len(keys) > 0 {
+		end = keys[len(keys)-1]
+	}
+	nodes, cont, err := trie.VerifyRangeProof(req.root, req.origin[:], end, keys, accounts, proofSet(proof))
+	if err != nil {
+		logger.Warn("Account range failed proof", "err", err)
+		s.deliverAccounts(&accountResponse{req: req, failed: true})
+		return err
+	}
+	accs := make([]*state.Account, len(accounts))
+	for i, account := range accounts {
+		acc := new(state.Account)
+		if err := rlp.DecodeBytes(account, acc); err != nil {
+			s.deliverAccounts(&accountResponse{req: req, failed: true})
+			return fmt.Errorf("invalid account %x: %v", account, err)
+		}
+		accs[i] = acc
+	}
+	s.deliverAccounts(&accountResponse{
+		req:      req,
+		hashes:   hashes,
+		accounts: accs,
+		nodes:    nodes,
+		cont:     cont,
+	})
+	return nil
+}
+
+// OnStorage is a callback method to invoke when ranges of storage slots
+// are received from a remote peer.
+func (s *Syncer) OnStorage(peer SyncPeer, id uint64, hashes [][]common.Hash, slots [][][]byte, proof [][]byte) error {
+	var size int
+	for _, hashset := range hashes {
+		size += len(hashset)
+	}
+	logger := peer.Log().New("reqid", id)
+	logger.Trace("Delivering ranges of storage slots", "accounts", len(hashes), "hashes", size, "proofs", len(proof))
+
+	// Whether or not the response is valid, we can mark the peer as idle and
+	// notify the scheduler to assign a new task. If the response is invalid,
+	// we'll drop the peer in a bit.
+	s.lock.Lock()
+	req, ok := s.storageReqs[id]
+	if !ok {
+		// Request stale, perhaps the peer timed out but came through in the end
+		logger.Warn("Unexpected storage ranges packet")
+		s.lock.Unlock()
+		return nil
+	}
+	delete(s.storageReqs, id)
+	delete(s.busy, peer.ID())
+
+	// Clean up the request timeout timer, we'll see how to proceed further based
+	// on the actual delivered content
+	req.timeout.Stop()
+
+	// Reject the response if the hash sets and slot sets don't match, or if the
+	// peer sent more data than requested.
+	if len(hashes) != len(slots) || len(hashes) > len(req.tasks) {
+		s.lock.Unlock()
+
+		s.deliverStorage(&storageResponse{req: req, failed: true})
+		logger.Warn("Peer sent invalid storage ranges", "accounts", len(req.tasks), "hashsets", len(hashes), "slotsets", len(slots))
+		return errBadRequest
+	}
+	// Response is valid, but check if peer is signalling that it does not have
+	// the requested data. For storage range queries that means the state being
+	// retrieved was either already pruned remotely, or the peer is not yet
+	// synced to our head.
+	if len(hashes) == 0 {
+		logger.Debug("Peer rejected storage request", "root", req.root)
+		s.stateless[peer.ID()] = struct{}{}
+		s.lock.Unlock()
+
+		s.deliverStorage(&storageResponse{req: req, failed: true})
+		return nil
+	}
+	s.lock.Unlock()
+
+	// Reconstruct the partial tries from the response and verify them
+	res := &storageResponse{
+		req:   req,
+		nodes: make([]ethdb.KeyValueStore, len(hashes)),
+		slots: size,
+	}
+	for i := 0; i < len(hashes); i++ {
+		keys := make([][]byte, len(hashes[i]))
+		for j, key := range hashes[i] {
+			keys[j] = common.CopyBytes(key[:])
+		}
+		// All but the last storage range must be complete tries, and so must
+		// be the last one if no proof was attached
+		if i < len(hashes)-1 || len(proof) == 0 {
+			nodes, _, err := trie.VerifyRangeProof(req.tasks[i].root, nil, nil, keys, slots[i], nil)
+			if err != nil {
+				logger.Warn("Storage slots failed proof", "err", err)
+				s.deliverStorage(&storageResponse{req: req, failed: true})
+				return err
+			}
+			res.nodes[i] = nodes
+			continue
+		}
+		// The last storage range is incomplete, verify it with the edge proofs
+		var end []byte
+		if len(keys) > 0 {
+			end = keys[len(keys)-1]
+			res.last = hashes[i][len(keys)-1]
+		}
+		nodes, cont, err := trie.VerifyRangeProof(req.tasks[i].root, req.origin[:], end, keys, slots[i], proofSet(proof))
+		if err != nil {
+			logger.Warn("Storage range failed proof", "err", err)
+			s.deliverStorage(&storageResponse{req: req, failed: true})
+			return err
+		}
+		res.nodes[i], res.cont = nodes, cont
+	}
+	s.deliverStorage(res)
+	return nil
+}
+
+// OnByteCodes is a callback method to invoke when a batch of contract
+// bytes codes are received from a remote peer.
+func (s *Syncer) OnByteCodes(peer SyncPeer, id uint64, codes [][]byte) error {
+	logger := peer.Log().New("reqid", id)
+	logger.Trace("Delivering set of bytecodes", "bytecodes", len(codes))
+
+	// Whether or not the response is valid, we can mark the peer as idle and
+	// notify the scheduler to assign a new task. If the response is invalid,
+	// we'll drop the peer in a bit.
+	s.lock.Lock()
+	req, ok := s.bytecodeReqs[id]
+	if !ok {
+		// Request stale, perhaps the peer timed out but came through in the end
+		logger.Warn("Unexpected bytecode packet")
+		s.lock.Unlock()
+		return nil
+	}
+	delete(s.bytecodeReqs, id)
+	delete(s.busy, peer.ID())
+
+	// Clean up the request timeout timer, we'll see how to proceed further based
+	// on the actual delivered content
+	req.timeout.Stop()
+
+	// Response is valid, but check if peer is signalling that it does not have
+	// the requested data. For bytecode range queries that means the peer is not
+	// yet synced.
+	if len(codes) == 0 {
+		logger.Debug("Peer rejected bytecode request")
+		s.stateless[peer.ID()] = struct{}{}
+		s.lock.Unlock()
+
+		s.deliverBytecodes(&bytecodeResponse{req: req, failed: true})
+		return nil
+	}
+	s.lock.Unlock()
+
+	// Cross reference the requested bytecodes with the response to find gaps
+	// that the serving node is missing
+	return s.deliverVerified(codes, req.hashes, func(blobs map[common.Hash][]byte, err error) {
+		if err != nil {
+			logger.Warn("Unexpected bytecodes", "count", len(codes))
+			s.deliverBytecodes(&bytecodeResponse{req: req, failed: true})
+			return
+		}
+		s.deliverBytecodes(&bytecodeResponse{req: req, codes: blobs})
+	})
+}
+
+// OnTrieNodes is a callback method to invoke when a batch of trie nodes
+// are received from a remote peer.
+func (s *Syncer) OnTrieNodes(peer SyncPeer, id uint64, nodes [][]byte) error {
+	logger := peer.Log().New("reqid", id)
+	logger.Trace("Delivering set of healing trienodes", "trienodes", len(nodes))
+
+	// Whether or not the response is valid, we can mark the peer as idle and
+	// notify the scheduler to assign a new task. If the response is invalid,
+	// we'll drop the peer in a bit.
+	s.lock.Lock()
+	req, ok := s.trienodeReqs[id]
+	if !ok {
+		// Request stale, perhaps the peer timed out but came through in the end
+		logger.Warn("Unexpected trienode heal packet")
+		s.lock.Unlock()
+		return nil
+	}
+	delete(s.trienodeReqs, id)
+	delete(s.busy, peer.ID())
+
+	// Clean up the request timeout timer, we'll see how to proceed further based
+	// on the actual delivered content
+	req.timeout.Stop()
+
+	// Response is valid, but check if peer is signalling that it does not have
+	// the requested data. For trie node queries that means the state being
+	// retrieved was either already pruned remotely, or the peer is not yet
+	// synced to our head.
+	if len(nodes) == 0 {
+		logger.Debug("Peer rejected trienode heal request")
+		s.stateless[peer.ID()] = struct{}{}
+		s.lock.Unlock()
+
+		s.deliverTrienodes(&trienodeResponse{req: req, failed: true})
+		return nil
+	}
+	s.lock.Unlock()
+
+	// Cross reference the requested trie nodes with the response to find gaps
+	// that the serving node is missing
+	return s.deliverVerified(nodes, req.hashes, func(blobs map[common.Hash][]byte, err error) {
+		if err != nil {
+			logger.Warn("Unexpected healing trienodes", "count", len(nodes))
+			s.deliverTrienodes(&trienodeResponse{req: req, failed: true})
+			return
+		}
+		s.deliverTrienodes(&trienodeResponse{req: req, nodes: blobs})
+	})
+}
+
+// deliverVerified matches up a set of hash addressed blobs with the requested
+// hashes, invoking the delivery callback with the verified blobs, or with an
+// error if unrequested data was received.
+func (s *Syncer) deliverVerified(blobs [][]byte, hashes []common.Hash, deliver func(map[common.Hash][]byte, error)) error {
+	requested := make(map[common.Hash]struct{}, len(hashes))
+	for _, hash := range hashes {
+		requested[hash] = struct{}{}
+	}
+	verified := make(map[common.Hash][]byte, len(blobs))
+	for _, blob := range blobs {
+		hash := crypto.Keccak256Hash(blob)
+		if _, ok := requested[hash]; !ok {
+			deliver(nil, errBadRequest)
+			return errBadRequest
+		}
+		verified[hash] = blob
+	}
+	deliver(verified, nil)
+	return nil
+}
+
+// proofSet converts a list of Merkle proof nodes into a hash addressed node
+// set to verify range proofs with.
+func proofSet(proof [][]byte) *light.NodeSet {
+	nodes := make(light.NodeList, len(proof))
+	for i, node := range proof {
+		nodes[i] = node
+	}
+	return nodes.NodeSet()
+}
+
+// incHash returns the next hash, in lexicographical order (a.k.a plus one).
+func incHash(h common.Hash) common.Hash {
+	for i := len(h) - 1; i >= 0; i-- {
+		h[i]++
+		if h[i] != 0 {
+			break
+		}
+	}
+	return h
+}
@@ -0,0 +1,443 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/light"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// testPeer is a mock snap peer serving data straight out of a set of tries.
+type testPeer struct {
+	id     string
+	test   *testing.T
+	remote *Syncer
+	logger log.Logger
+
+	state     *testState // Source state to serve data from
+	limit     uint64     // Response size cap, overriding the request
+	stateless bool       // Whether the peer refuses to serve anything
+}
+
+func newTestPeer(id string, t *testing.T, remote *Syncer, src *testState) *testPeer {
+	return &testPeer{
+		id:     id,
+		test:   t,
+		remote: remote,
+		logger: log.New("id", id),
+		state:  src,
+		limit:  maxRequestSize,
+	}
+}
+
+func (p *testPeer) ID() string      { return p.id }
+func (p *testPeer) Log() log.Logger { return p.logger }
+
+func (p *testPeer) RequestAccountRange(id uint64, root, origin, limit common.Hash, bytes uint64) error {
+	go func() {
+		if p.stateless {
+			p.remote.OnAccounts(p, id, nil, nil, nil)
+			return
+		}
+		keys, vals, proof := p.serveRange(p.state.accountTrie, origin, limit, bytes, true)
+
+		hashes := make([]common.Hash, len(keys))
+		for i, key := range keys {
+			hashes[i] = common.BytesToHash(key)
+		}
+		if err := p.remote.OnAccounts(p, id, hashes, vals, proof); err != nil {
+			p.test.Errorf("remote rejected account range: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (p *testPeer) RequestStorageRanges(id uint64, root common.Hash, accounts []common.Hash, origin, limit []byte, bytes uint64) error {
+	go func() {
+		if p.stateless {
+			p.remote.OnStorage(p, id, nil, nil, nil)
+			return
+		}
+		if bytes > p.limit {
+			bytes = p.limit
+		}
+		var (
+			hashes [][]common.Hash
+			slots  [][][]byte
+			proof  [][]byte
+			size   uint64
+		)
+		for i, account := range accounts {
+			if size >= bytes {
+				break
+			}
+			var start common.Hash
+			if i == 0 && len(origin) > 0 {
+				start = common.BytesToHash(origin)
+			}
+			keys, vals, prf := p.serveRange(p.state.storageTries[account], start, common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"), bytes-size, false)
+
+			set := make([]common.Hash, len(keys))
+			for j, key := range keys {
+				set[j] = common.BytesToHash(key)
+				size += uint64(len(key) + len(vals[j]))
+			}
+			hashes = append(hashes, set)
+			slots = append(slots, vals)
+
+			if prf != nil {
+				proof = prf
+				break
+			}
+		}
+		if err := p.remote.OnStorage(p, id, hashes, slots, proof); err != nil {
+			p.test.Errorf("remote rejected storage ranges: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (p *testPeer) RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error {
+	go func() {
+		if err := p.remote.OnByteCodes(p, id, p.serveBlobs(hashes)); err != nil {
+			p.test.Errorf("remote rejected bytecodes: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (p *testPeer) RequestTrieNodes(id uint64, root common.Hash, hashes []common.Hash, bytes uint64) error {
+	go func() {
+		if err := p.remote.OnTrieNodes(p, id, p.serveBlobs(hashes)); err != nil {
+			p.test.Errorf("remote rejected trie nodes: %v", err)
+		}
+	}()
+	return nil
+}
+
+// serveRange retrieves a range of leaves from a trie, starting at origin and
+// capped by the limit key and the response size. Edge proofs are generated if
+// the range is incomplete (or if forced).
+func (p *testPeer) serveRange(tr *trie.Trie, origin common.Hash, limit common.Hash, max uint64, force bool) ([][]byte, [][]byte, [][]byte) {
+	if max > p.limit {
+		max = p.limit
+	}
+	// Tries are not thread safe, serialize the concurrent requests
+	p.state.lock.Lock()
+	defer p.state.lock.Unlock()
+
+	var (
+		keys  [][]byte
+		vals  [][]byte
+		size  uint64
+		abort bool
+	)
+	it := trie.NewIterator(tr.NodeIterator(origin[:]))
+	for it.Next() {
+		if size >= max {
+			abort = true
+			break
+		}
+		keys = append(keys, common.CopyBytes(it.Key))
+		vals = append(vals, common.CopyBytes(it.Value))
+		size += uint64(len(it.Key) + len(it.Value))
+
+		if bytes.Compare(it.Key, limit[:]) >= 0 {
+			abort = true
+			break
+		}
+	}
+	if !force && !abort && origin == (common.Hash{}) {
+		return keys, vals, nil
+	}
+	proof := light.NewNodeSet()
+	if err := tr.Prove(origin[:], 0, proof); err != nil {
+		p.test.Errorf("failed to prove origin: %v", err)
+	}
+	if len(keys) > 0 {
+		if err := tr.Prove(keys[len(keys)-1], 0, proof); err != nil {
+			p.test.Errorf("failed to prove last key: %v", err)
+		}
+	}
+	nodes := [][]byte{}
+	for _, node := range proof.NodeList() {
+		nodes = append(nodes, node)
+	}
+	return keys, vals, nodes
+}
+
+// serveBlobs retrieves a batch of hash addressed blobs from the database.
+func (p *testPeer) serveBlobs(hashes []common.Hash) [][]byte {
+	if p.stateless {
+		return nil
+	}
+	var blobs [][]byte
+	for _, hash := range hashes {
+		if blob, err := p.state.db.Get(hash[:]); err == nil {
+			blobs = append(blobs, blob)
+		}
+	}
+	return blobs
+}
+
+// testState is a source state to sync from.
+type testState struct {
+	db           ethdb.KeyValueStore
+	root         common.Hash
+	accountTrie  *trie.Trie
+	storageTries map[common.Hash]*trie.Trie
+
+	lock sync.Mutex
+}
+
+// newTestState creates a state with the given number of accounts, every n-th
+// of which having code and a storage trie of the given size.
+func newTestState(t *testing.T, accounts int, every int, slots int) *testState {
+	var (
+		db           = rawdb.NewMemoryDatabase()
+		triedb       = trie.NewDatabase(db)
+		accountTrie  = newEmptyTrie(triedb)
+		storageTries = make(map[common.Hash]*trie.Trie)
+	)
+	for i := 0; i < accounts; i++ {
+		account := &state.Account{
+			Nonce:    uint64(i),
+			Balance:  big.NewInt(int64(i)),
+			Root:     emptyRoot,
+			CodeHash: emptyCode[:],
+		}
+		index := make([]byte, 8)
+		binary.BigEndian.PutUint64(index, uint64(i))
+		hash := crypto.Keccak256Hash(index)
+		if every > 0 && i%every == 0 {
+			// Create the contract code, unique to the account
+			code := append([]byte{0x60, 0x00}, hash[:]...)
+			account.CodeHash = crypto.Keccak256(code)
+			db.Put(account.CodeHash, code)
+
+			// Create a random storage trie for the account
+			storage := newEmptyTrie(triedb)
+			for j := 0; j < slots; j++ {
+				value := make([]byte, 32)
+				rand.Read(value)
+				blob, _ := rlp.EncodeToBytes(bytes.TrimLeft(value, "\x00"))
+				storage.Update(crypto.Keccak256(value), blob)
+			}
+			root, err := storage.Commit(nil)
+			if err != nil {
+				t.Fatalf("failed to commit storage trie: %v", err)
+			}
+			account.Root = root
+			storageTries[hash] = storage
+		}
+		blob, _ := rlp.EncodeToBytes(account)
+		accountTrie.Update(hash[:], blob)
+	}
+	root, err := accountTrie.Commit(nil)
+	if err != nil {
+		t.Fatalf("failed to commit account trie: %v", err)
+	}
+	for _, storage := range storageTries {
+		if err := triedb.Commit(storage.Hash(), false, nil); err != nil {
+			t.Fatalf("failed to flush storage trie: %v", err)
+		}
+	}
+	if err := triedb.Commit(root, false, nil); err != nil {
+		t.Fatalf("failed to flush account trie: %v", err)
+	}
+	return &testState{
+		db:           db,
+		root:         root,
+		accountTrie:  accountTrie,
+		storageTries: storageTries,
+	}
+}
+
+func newEmptyTrie(db *trie.Database) *trie.Trie {
+	tr, _ := trie.New(common.Hash{}, db)
+	return tr
+}
+
+// verifyState checks that the entire state with the given root, including all
+// storage tries and codes, is present in the database.
+func verifyState(t *testing.T, db ethdb.KeyValueStore, root common.Hash, src *testState) {
+	triedb := trie.NewDatabase(db)
+
+	accTrie, err := trie.New(root, triedb)
+	if err != nil {
+		t.Fatalf("failed to open account trie: %v", err)
+	}
+	var accounts, slots int
+
+	it := trie.NewIterator(accTrie.NodeIterator(nil))
+	for it.Next() {
+		var acc state.Account
+		if err := rlp.DecodeBytes(it.Value, &acc); err != nil {
+			t.Fatalf("invalid account encoding: %v", err)
+		}
+		accounts++
+
+		if !bytes.Equal(acc.CodeHash, emptyCode[:]) {
+			if code, err := db.Get(acc.CodeHash); err != nil || !bytes.Equal(crypto.Keccak256(code), acc.CodeHash) {
+				t.Errorf("account %x: code missing", it.Key)
+			}
+		}
+		if acc.Root != emptyRoot {
+			storage, err := trie.New(acc.Root, triedb)
+			if err != nil {
+				t.Fatalf("account %x: failed to open storage trie: %v", it.Key, err)
+			}
+			sit := trie.NewIterator(storage.NodeIterator(nil))
+			for sit.Next() {
+				slots++
+			}
+			if sit.Err != nil {
+				t.Fatalf("account %x: failed to iterate storage trie: %v", it.Key, sit.Err)
+			}
+		}
+	}
+	if it.Err != nil {
+		t.Fatalf("failed to iterate account trie: %v", it.Err)
+	}
+	want := 0
+	for _, storage := range src.storageTries {
+		sit := trie.NewIterator(storage.NodeIterator(nil))
+		for sit.Next() {
+			want++
+		}
+	}
+	if slots != want {
+		t.Errorf("storage slot count mismatch: have %d, want %d", slots, want)
+	}
+	t.Logf("verified %d accounts and %d slots", accounts, slots)
+}
+
+// runSync runs a sync against the given peers, failing after a timeout.
+func runSync(t *testing.T, syncer *Syncer, root common.Hash) {
+	var (
+		done   = make(chan error)
+		cancel = make(chan struct{})
+	)
+	go func() { done <- syncer.Sync(root, cancel) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("sync failed: %v", err)
+		}
+	case <-time.After(time.Minute):
+		close(cancel)
+		t.Fatalf("sync timed out")
+	}
+}
+
+// Tests that a simple account-only state can be synced from a single peer.
+func TestSyncAccounts(t *testing.T) {
+	t.Parallel()
+
+	var (
+		src    = newTestState(t, 2000, 0, 0)
+		db     = rawdb.NewMemoryDatabase()
+		bloom  = trie.NewSyncBloom(1, db)
+		syncer = NewSyncer(db, bloom)
+	)
+	defer bloom.Close()
+
+	syncer.Register(newTestPeer("source", t, syncer, src))
+	runSync(t, syncer, src.root)
+	verifyState(t, db, src.root, src)
+}
+
+// Tests that a state with contracts can be synced from multiple peers, even if
+// the responses are capped so small that large storage tries need chunking.
+func TestSyncWithStorage(t *testing.T) {
+	t.Parallel()
+
+	var (
+		src    = newTestState(t, 500, 10, 200)
+		db     = rawdb.NewMemoryDatabase()
+		bloom  = trie.NewSyncBloom(1, db)
+		syncer = NewSyncer(db, bloom)
+	)
+	defer bloom.Close()
+
+	for i := 0; i < 3; i++ {
+		peer := newTestPeer(fmt.Sprintf("source-%d", i), t, syncer, src)
+		peer.limit = 4096
+		syncer.Register(peer)
+	}
+	runSync(t, syncer, src.root)
+	verifyState(t, db, src.root, src)
+}
+
+// Tests that peers refusing to serve the requested state are skipped and the
+// sync proceeds with the remaining ones.
+func TestSyncWithStatelessPeer(t *testing.T) {
+	t.Parallel()
+
+	var (
+		src    = newTestState(t, 500, 5, 10)
+		db     = rawdb.NewMemoryDatabase()
+		bloom  = trie.NewSyncBloom(1, db)
+		syncer = NewSyncer(db, bloom)
+	)
+	defer bloom.Close()
+
+	stateless := newTestPeer("stateless", t, syncer, src)
+	stateless.stateless = true
+	syncer.Register(stateless)
+	syncer.Register(newTestPeer("source", t, syncer, src))
+
+	runSync(t, syncer, src.root)
+	verifyState(t, db, src.root, src)
+}
+
+// Tests that a sync without peers can be cancelled.
+func TestSyncCancel(t *testing.T) {
+	var (
+		src    = newTestState(t, 10, 0, 0)
+		db     = rawdb.NewMemoryDatabase()
+		bloom  = trie.NewSyncBloom(1, db)
+		syncer = NewSyncer(db, bloom)
+		done   = make(chan error)
+		cancel = make(chan struct{})
+	)
+	defer bloom.Close()
+
+	go func() { done <- syncer.Sync(src.root, cancel) }()
+	close(cancel)
+
+	if err := <-done; err != ErrCancelled {
+		t.Fatalf("sync error mismatch: have %v, want %v", err, ErrCancelled)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// ResultTracer is a vm.Tracer which accumulates a JSON result throughout the
+// execution of a transaction and which may be interrupted midway. Both the
+// JavaScript and the native Go tracers implement it.
+type ResultTracer interface {
+	vm.Tracer
+
+	// GetResult returns the result of the trace, or any accumulated error.
+	GetResult() (json.RawMessage, error)
+
+	// Stop terminates execution of the tracer at the first opportune moment.
+	Stop(err error)
+}
+
+// NativeConstructor creates a fresh instance of a native tracer. The config is
+// an optional, tracer specific JSON blob and may be nil.
+type NativeConstructor func(config json.RawMessage) (ResultTracer, error)
+
+var (
+	nativeLock sync.RWMutex
+	natives    = make(map[string]NativeConstructor) // Native tracers by name
+)
+
+// RegisterNative makes a native tracer available by the provided name. Native
+// tracers take precedence over JavaScript ones of the same name. If RegisterNative
+// is called twice with the same name or if the constructor is nil, it panics.
+func RegisterNative(name string, ctor NativeConstructor) {
+	nativeLock.Lock()
+	defer nativeLock.Unlock()
+
+	if ctor == nil {
+		panic("tracers: nil native tracer constructor for " + name)
+	}
+	if _, dup := natives[name]; dup {
+		panic("tracers: native tracer registered twice: " + name)
+	}
+	natives[name] = ctor
+}
+
+// native retrieves a specific native tracer constructor by name.
+func native(name string) (NativeConstructor, bool) {
+	nativeLock.RLock()
+	defer nativeLock.RUnlock()
+
+	ctor, ok := natives[name]
+	return ctor, ok
+}
+
+// NewTracer creates a tracer from its name or code. Registered native tracers
+// are looked up first, after which the code is interpreted as the name of a
+// built in JavaScript tracer or as a JavaScript snippet. The config is only
+// used by native tracers.
+func NewTracer(code string, config json.RawMessage) (ResultTracer, error) {
+	if ctor, ok := native(code); ok {
+		tracer, err := ctor(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create native tracer %q: %v", code, err)
+		}
+		return tracer, nil
+	}
+	return New(code)
+}
@@ -0,0 +1,292 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func init() {
+	RegisterNative("prestateTracer", newPrestateTracer)
+}
+
+// prestateAccount is the state of an account before the execution of the traced
+// transaction, restricted to the storage slots it accessed.
+type prestateAccount struct {
+	Balance *hexutil.Big                `json:"balance"`
+	Nonce   uint64                      `json:"nonce"`
+	Code    hexutil.Bytes               `json:"code"`
+	Storage map[common.Hash]common.Hash `json:"storage"`
+}
+
+// poststateAccount is the set of fields of an account modified by the traced
+// transaction.
+type poststateAccount struct {
+	Balance *hexutil.Big                `json:"balance,omitempty"`
+	Nonce   *uint64                     `json:"nonce,omitempty"`
+	Code    *hexutil.Bytes              `json:"code,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+}
+
+// prestateTracerConfig is the configuration accepted by the prestate tracer.
+type prestateTracerConfig struct {
+	DiffMode bool `json:"diffMode"` // Report the state changes instead of the plain prestate
+}
+
+// prestateTracer is a native Go implementation of the JavaScript prestateTracer.
+// It outputs sufficient information to create a local execution of the transaction
+// from a custom assembled genesis block. Unlike the JavaScript version, it also
+// tracks the accounts accessed via EXTCODEHASH and SELFDESTRUCT.
+//
+// In diff mode, the tracer reports both the pre- and post-state of every account
+// modified by the transaction, restricted to the modified fields. The changes are
+// the ones made by the EVM execution itself: both states are taken after the gas
+// was purchased, and the post-state before the unused gas is refunded and the
+// miner is paid.
+type prestateTracer struct {
+	config   prestateTracerConfig
+	prestate map[common.Address]*prestateAccount // Genesis that we're building
+	created  map[common.Address]bool             // Accounts not existing before the transaction
+	db       vm.StateDB                          // State database to pull the accounts from
+
+	create bool           // Whether the outer call is a contract creation
+	from   common.Address // Sender of the outer call
+	to     common.Address // Recipient of the outer call
+	value  *big.Int       // Value transferred by the outer call
+
+	result interface{} // Changes made by the execution, assembled when it ends in diff mode
+
+	interrupt uint32 // Atomic flag to signal execution interruption
+	reason    error  // Textual reason for the interruption
+}
+
+// newPrestateTracer creates a native prestate tracer, optionally in diff mode.
+func newPrestateTracer(config json.RawMessage) (ResultTracer, error) {
+	t := &prestateTracer{
+		created: make(map[common.Address]bool),
+	}
+	if len(config) > 0 {
+		if err := json.Unmarshal(config, &t.config); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// lookupAccount injects the specified account into the prestate object.
+func (t *prestateTracer) lookupAccount(addr common.Address) {
+	if _, ok := t.prestate[addr]; ok {
+		return
+	}
+	if !t.db.Exist(addr) {
+		t.created[addr] = true
+	}
+	t.prestate[addr] = &prestateAccount{
+		Balance: (*hexutil.Big)(new(big.Int).Set(t.db.GetBalance(addr))),
+		Nonce:   t.db.GetNonce(addr),
+		Code:    t.db.GetCode(addr),
+		Storage: make(map[common.Hash]common.Hash),
+	}
+}
+
+// lookupStorage injects the specified storage entry of the given account into
+// the prestate object.
+func (t *prestateTracer) lookupStorage(addr common.Address, key common.Hash) {
+	t.lookupAccount(addr)
+	if _, ok := t.prestate[addr].Storage[key]; !ok {
+		t.prestate[addr].Storage[key] = t.db.GetState(addr, key)
+	}
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *prestateTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.create = create
+	t.from, t.to = from, to
+	t.value = new(big.Int).Set(value)
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *prestateTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	// If tracing was interrupted, abort
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		return nil
+	}
+	// Add the current account if we just started tracing. Balance will potentially
+	// be wrong here, since this will include the value sent along with the message.
+	// We fix that in GetResult.
+	if t.prestate == nil {
+		t.prestate = make(map[common.Address]*prestateAccount)
+		t.db = env.StateDB
+		t.lookupAccount(contract.Address())
+
+		// In diff mode, the outer call's accounts must be captured before a revert
+		// could undo the value transfer, since that's unconditionally fixed up
+		if t.config.DiffMode {
+			t.lookupAccount(t.from)
+			t.lookupAccount(t.to)
+		}
+	}
+	// Whenever new state is accessed, add it to the prestate
+	switch op {
+	case vm.EXTCODECOPY, vm.EXTCODESIZE, vm.EXTCODEHASH, vm.BALANCE:
+		t.lookupAccount(common.BigToAddress(stackPeek(stack, 0)))
+
+	case vm.CREATE:
+		from := contract.Address()
+		t.lookupAccount(crypto.CreateAddress(from, env.StateDB.GetNonce(from)))
+
+	case vm.CREATE2:
+		offset := stackPeek(stack, 1).Uint64()
+		code := memorySlice(memory, offset, offset+stackPeek(stack, 2).Uint64())
+		salt := common.BigToHash(stackPeek(stack, 3))
+
+		t.lookupAccount(crypto.CreateAddress2(contract.Address(), salt, crypto.Keccak256(*code)))
+
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL, vm.SELFDESTRUCT:
+		var target common.Address
+		if op == vm.SELFDESTRUCT {
+			target = common.BigToAddress(stackPeek(stack, 0))
+		} else {
+			target = common.BigToAddress(stackPeek(stack, 1))
+		}
+		t.lookupAccount(target)
+
+	case vm.SSTORE, vm.SLOAD:
+		t.lookupStorage(contract.Address(), common.BigToHash(stackPeek(stack, 0)))
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t *prestateTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing. In diff
+// mode, the changes are assembled here, before the state transition refunds the
+// unused gas and pays the miner.
+func (t *prestateTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	if t.config.DiffMode && t.db != nil && atomic.LoadUint32(&t.interrupt) == 0 {
+		t.finalize()
+		t.result = t.diff()
+	}
+	return nil
+}
+
+// GetResult returns the assembled prestate, or in diff mode the pre- and post-
+// state of all the modified accounts.
+func (t *prestateTracer) GetResult() (json.RawMessage, error) {
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		return nil, t.reason
+	}
+	// If no opcode was executed, there's no state to pull the accounts from
+	if t.db == nil {
+		return json.Marshal(map[common.Address]*prestateAccount{})
+	}
+	if t.config.DiffMode {
+		return json.Marshal(t.result)
+	}
+	t.finalize()
+	return json.Marshal(t.prestate)
+}
+
+// finalize adds the outer call's accounts to the prestate and reverts the changes
+// made to them before the execution started.
+func (t *prestateTracer) finalize() {
+	// At this point, we need to deduct the 'value' from the outer transaction,
+	// and move it back to the origin
+	t.lookupAccount(t.from)
+	t.lookupAccount(t.to)
+
+	fromBal := new(big.Int).Set(t.prestate[t.from].Balance.ToInt())
+	toBal := new(big.Int).Set(t.prestate[t.to].Balance.ToInt())
+
+	t.prestate[t.to].Balance = (*hexutil.Big)(toBal.Sub(toBal, t.value))
+	t.prestate[t.from].Balance = (*hexutil.Big)(fromBal.Add(fromBal, t.value))
+
+	// Decrement the caller's nonce, and remove empty create targets. We can blindly
+	// delete the contract prestate, as any existing state would have caused the
+	// transaction to be rejected as invalid in the first place.
+	t.prestate[t.from].Nonce--
+	if t.create {
+		t.created[t.to] = true
+		if !t.config.DiffMode {
+			delete(t.prestate, t.to)
+		}
+	}
+}
+
+// diff compares the assembled prestate with the current state of each account,
+// retaining only the modified ones.
+func (t *prestateTracer) diff() interface{} {
+	var (
+		pre  = make(map[common.Address]*prestateAccount)
+		post = make(map[common.Address]*poststateAccount)
+	)
+	for addr, prev := range t.prestate {
+		// Created accounts had no prior state to compare against
+		if t.created[addr] {
+			prev = &prestateAccount{Balance: new(hexutil.Big), Storage: prev.Storage}
+			for key := range prev.Storage {
+				prev.Storage[key] = common.Hash{}
+			}
+		}
+		var (
+			modified bool
+			change   = &poststateAccount{Storage: make(map[common.Hash]common.Hash)}
+		)
+		if balance := t.db.GetBalance(addr); balance.Cmp(prev.Balance.ToInt()) != 0 {
+			change.Balance, modified = (*hexutil.Big)(balance), true
+		}
+		if nonce := t.db.GetNonce(addr); nonce != prev.Nonce {
+			change.Nonce, modified = &nonce, true
+		}
+		if code := t.db.GetCode(addr); string(code) != string(prev.Code) {
+			change.Code, modified = (*hexutil.Bytes)(&code), true
+		}
+		storage := make(map[common.Hash]common.Hash)
+		for key, val := range prev.Storage {
+			if cur := t.db.GetState(addr, key); cur != val {
+				storage[key], change.Storage[key], modified = val, cur, true
+			}
+		}
+		if !modified {
+			continue
+		}
+		post[addr] = change
+		if !t.created[addr] {
+			pre[addr] = &prestateAccount{Balance: prev.Balance, Nonce: prev.Nonce, Code: prev.Code, Storage: storage}
+		}
+	}
+	return map[string]interface{}{"pre": pre, "post": post}
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *prestateTracer) Stop(err error) {
+	t.reason = err
+	atomic.StoreUint32(&t.interrupt, 1)
+}
@@ -0,0 +1,377 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+// traceFixture runs the transaction of a call tracer test case with the given
+// tracer attached and returns the trace result.
+func traceFixture(t *testing.T, test *callTracerTest, tracer ResultTracer) json.RawMessage {
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(common.FromHex(test.Input), tx); err != nil {
+		t.Fatalf("failed to parse testcase input: %v", err)
+	}
+	signer := types.MakeSigner(test.Genesis.Config, new(big.Int).SetUint64(uint64(test.Context.Number)))
+	origin, _ := signer.Sender(tx)
+
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Origin:      origin,
+		Coinbase:    test.Context.Miner,
+		BlockNumber: new(big.Int).SetUint64(uint64(test.Context.Number)),
+		Time:        new(big.Int).SetUint64(uint64(test.Context.Time)),
+		Difficulty:  (*big.Int)(test.Context.Difficulty),
+		GasLimit:    uint64(test.Context.GasLimit),
+		GasPrice:    tx.GasPrice(),
+	}
+	statedb := tests.MakePreState(rawdb.NewMemoryDatabase(), test.Genesis.Alloc)
+	evm := vm.NewEVM(context, statedb, test.Genesis.Config, vm.Config{Debug: true, Tracer: tracer})
+
+	msg, err := tx.AsMessage(signer, nil)
+	if err != nil {
+		t.Fatalf("failed to prepare transaction for tracing: %v", err)
+	}
+	st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+	if _, err = st.TransitionDb(); err != nil {
+		t.Fatalf("failed to execute transaction: %v", err)
+	}
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve trace result: %v", err)
+	}
+	return res
+}
+
+// loadFixtures reads all the call tracer test cases from the test harness.
+func loadFixtures(t *testing.T) map[string]*callTracerTest {
+	files, err := ioutil.ReadDir("testdata")
+	if err != nil {
+		t.Fatalf("failed to retrieve tracer test suite: %v", err)
+	}
+	fixtures := make(map[string]*callTracerTest)
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), "call_tracer_") {
+			continue
+		}
+		blob, err := ioutil.ReadFile(filepath.Join("testdata", file.Name()))
+		if err != nil {
+			t.Fatalf("failed to read testcase: %v", err)
+		}
+		test := new(callTracerTest)
+		if err := json.Unmarshal(blob, test); err != nil {
+			t.Fatalf("failed to parse testcase: %v", err)
+		}
+		fixtures[camel(strings.TrimSuffix(strings.TrimPrefix(file.Name(), "call_tracer_"), ".json"))] = test
+	}
+	return fixtures
+}
+
+// Tests that the native tracers produce the exact same output as their JavaScript
+// counterparts on all the input-output datasets in the tracer test harness.
+func TestNativeTracersMatchJavaScript(t *testing.T) {
+	for name, test := range loadFixtures(t) {
+		for _, tracer := range []string{"callTracer", "prestateTracer", "4byteTracer"} {
+			native, err := NewTracer(tracer, nil)
+			if err != nil {
+				t.Fatalf("failed to create native %s: %v", tracer, err)
+			}
+			if _, ok := native.(*Tracer); ok {
+				t.Fatalf("%s resolved to a JavaScript tracer", tracer)
+			}
+			js, err := New(tracer)
+			if err != nil {
+				t.Fatalf("failed to create JavaScript %s: %v", tracer, err)
+			}
+			var have, want map[string]interface{}
+			if err := json.Unmarshal(traceFixture(t, test, native), &have); err != nil {
+				t.Fatalf("%s/%s: failed to unmarshal native trace: %v", name, tracer, err)
+			}
+			if err := json.Unmarshal(traceFixture(t, test, js), &want); err != nil {
+				t.Fatalf("%s/%s: failed to unmarshal JavaScript trace: %v", name, tracer, err)
+			}
+			// Execution times naturally differ between runs
+			delete(have, "time")
+			delete(want, "time")
+
+			if !reflect.DeepEqual(have, want) {
+				haveJSON, _ := json.MarshalIndent(have, "", "  ")
+				wantJSON, _ := json.MarshalIndent(want, "", "  ")
+				t.Errorf("%s/%s: trace mismatch:\nhave %s\nwant %s", name, tracer, haveJSON, wantJSON)
+			}
+		}
+	}
+}
+
+// Tests that the native call tracer produces the expected results on all the
+// input-output datasets in the tracer test harness.
+func TestNativeCallTracer(t *testing.T) {
+	for name, test := range loadFixtures(t) {
+		tracer, err := NewTracer("callTracer", nil)
+		if err != nil {
+			t.Fatalf("failed to create call tracer: %v", err)
+		}
+		ret := new(callTrace)
+		if err := json.Unmarshal(traceFixture(t, test, tracer), ret); err != nil {
+			t.Fatalf("%s: failed to unmarshal trace result: %v", name, err)
+		}
+		if !reflect.DeepEqual(ret, test.Result) {
+			t.Errorf("%s: trace mismatch: \nhave %+v\nwant %+v", name, ret, test.Result)
+		}
+	}
+}
+
+// Tests that the prestate tracer in diff mode reports the modified accounts,
+// restricted to their modified fields.
+func TestPrestateTracerDiffMode(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		origin   = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.HexToAddress("0x00000000000000000000000000000000deadbeef")
+		reader   = common.HexToAddress("0x00000000000000000000000000000000cafebabe")
+	)
+	// The contract stores 0x02 into slot 0, reads slot 1 and the reader's balance
+	alloc := core.GenesisAlloc{
+		contract: {
+			Code:    hexutil.MustDecode("0x6002600055600154507300000000000000000000000000000000cafebabe3150"),
+			Storage: map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(1)), common.BigToHash(big.NewInt(1)): common.BigToHash(big.NewInt(3))},
+			Balance: big.NewInt(0),
+		},
+		reader: {Balance: big.NewInt(7)},
+		origin: {Nonce: 1, Balance: big.NewInt(params.Ether)},
+	}
+	statedb := tests.MakePreState(rawdb.NewMemoryDatabase(), alloc)
+
+	tracer, err := NewTracer("prestateTracer", json.RawMessage(`{"diffMode": true}`))
+	if err != nil {
+		t.Fatalf("failed to create prestate tracer: %v", err)
+	}
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Origin:      origin,
+		BlockNumber: big.NewInt(8000000),
+		Time:        big.NewInt(5),
+		Difficulty:  big.NewInt(0x30000),
+		GasLimit:    uint64(6000000),
+		GasPrice:    big.NewInt(1),
+	}
+	evm := vm.NewEVM(context, statedb, params.MainnetChainConfig, vm.Config{Debug: true, Tracer: tracer})
+
+	signer := types.NewEIP155Signer(big.NewInt(1))
+	tx, _ := types.SignTx(types.NewTransaction(1, contract, big.NewInt(100), 100000, big.NewInt(1), nil), signer, key)
+	msg, _ := tx.AsMessage(signer, nil)
+	if _, err := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas())).TransitionDb(); err != nil {
+		t.Fatalf("failed to execute transaction: %v", err)
+	}
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve trace result: %v", err)
+	}
+	var result struct {
+		Pre  map[common.Address]*prestateAccount  `json:"pre"`
+		Post map[common.Address]*poststateAccount `json:"post"`
+	}
+	if err := json.Unmarshal(res, &result); err != nil {
+		t.Fatalf("failed to unmarshal trace result: %v", err)
+	}
+	// The untouched reader and the unmodified storage slot must be omitted
+	if _, ok := result.Pre[reader]; ok {
+		t.Errorf("unmodified account reported in prestate")
+	}
+	if _, ok := result.Post[reader]; ok {
+		t.Errorf("unmodified account reported in poststate")
+	}
+	pre, post := result.Pre[contract], result.Post[contract]
+	if pre == nil || post == nil {
+		t.Fatalf("modified contract missing: pre %v, post %v", pre, post)
+	}
+	if want := map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(1))}; !reflect.DeepEqual(pre.Storage, want) {
+		t.Errorf("contract prestate storage mismatch: have %v, want %v", pre.Storage, want)
+	}
+	if want := map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(2))}; !reflect.DeepEqual(post.Storage, want) {
+		t.Errorf("contract poststate storage mismatch: have %v, want %v", post.Storage, want)
+	}
+	if pre.Balance.ToInt().Sign() != 0 || post.Balance == nil || post.Balance.ToInt().Cmp(big.NewInt(100)) != 0 {
+		t.Errorf("contract balance mismatch: pre %v, post %v", pre.Balance, post.Balance)
+	}
+	if post.Nonce != nil || post.Code != nil {
+		t.Errorf("unmodified contract fields reported: nonce %v, code %v", post.Nonce, post.Code)
+	}
+	// The sender's nonce must be bumped and the value deducted
+	if pre, post := result.Pre[origin], result.Post[origin]; pre == nil || post == nil {
+		t.Errorf("sender missing: pre %v, post %v", pre, post)
+	} else {
+		if post.Nonce == nil || *post.Nonce != pre.Nonce+1 {
+			t.Errorf("sender nonce mismatch: pre %d, post %v", pre.Nonce, post.Nonce)
+		}
+		if diff := new(big.Int).Sub(pre.Balance.ToInt(), post.Balance.ToInt()); diff.Cmp(big.NewInt(100)) != 0 {
+			t.Errorf("sender balance change mismatch: have %v, want %v", diff, 100)
+		}
+	}
+}
+
+// Tests that the prestate tracer in diff mode excludes the gas payments from the
+// reported changes, even for the sender and the miner accessed during execution.
+func TestPrestateTracerDiffModeGas(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		origin   = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.HexToAddress("0x00000000000000000000000000000000deadbeef")
+		coinbase = common.HexToAddress("0x00000000000000000000000000000000c0ffee00")
+	)
+	// The contract reads the balance of the origin and the coinbase
+	alloc := core.GenesisAlloc{
+		contract: {Code: hexutil.MustDecode("0x323150413150"), Balance: big.NewInt(0)},
+		coinbase: {Balance: big.NewInt(5)},
+		origin:   {Nonce: 1, Balance: big.NewInt(params.Ether)},
+	}
+	for _, revert := range []bool{false, true} {
+		if revert {
+			// Make the contract revert after reading the balances
+			alloc[contract] = core.GenesisAccount{Code: hexutil.MustDecode("0x32315041315060006000fd"), Balance: big.NewInt(0)}
+		}
+		statedb := tests.MakePreState(rawdb.NewMemoryDatabase(), alloc)
+
+		tracer, err := NewTracer("prestateTracer", json.RawMessage(`{"diffMode": true}`))
+		if err != nil {
+			t.Fatalf("failed to create prestate tracer: %v", err)
+		}
+		context := vm.Context{
+			CanTransfer: core.CanTransfer,
+			Transfer:    core.Transfer,
+			Origin:      origin,
+			Coinbase:    coinbase,
+			BlockNumber: big.NewInt(8000000),
+			Time:        big.NewInt(5),
+			Difficulty:  big.NewInt(0x30000),
+			GasLimit:    uint64(6000000),
+			GasPrice:    big.NewInt(2),
+		}
+		evm := vm.NewEVM(context, statedb, params.MainnetChainConfig, vm.Config{Debug: true, Tracer: tracer})
+
+		signer := types.NewEIP155Signer(big.NewInt(1))
+		tx, _ := types.SignTx(types.NewTransaction(1, contract, big.NewInt(100), 100000, big.NewInt(2), nil), signer, key)
+		msg, _ := tx.AsMessage(signer, nil)
+		if _, err := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas())).TransitionDb(); err != nil {
+			t.Fatalf("revert %v: failed to execute transaction: %v", revert, err)
+		}
+		// Sanity check that gas was actually paid
+		if statedb.GetBalance(coinbase).Cmp(big.NewInt(5)) <= 0 {
+			t.Fatalf("revert %v: miner not paid", revert)
+		}
+		res, err := tracer.GetResult()
+		if err != nil {
+			t.Fatalf("revert %v: failed to retrieve trace result: %v", revert, err)
+		}
+		var result struct {
+			Pre  map[common.Address]*prestateAccount  `json:"pre"`
+			Post map[common.Address]*poststateAccount `json:"post"`
+		}
+		if err := json.Unmarshal(res, &result); err != nil {
+			t.Fatalf("revert %v: failed to unmarshal trace result: %v", revert, err)
+		}
+		// The miner's fee must not show up as a change
+		if _, ok := result.Pre[coinbase]; ok {
+			t.Errorf("revert %v: miner reported in prestate", revert)
+		}
+		if _, ok := result.Post[coinbase]; ok {
+			t.Errorf("revert %v: miner reported in poststate", revert)
+		}
+		// The sender's balance must only change by the transferred value
+		pre, post := result.Pre[origin], result.Post[origin]
+		if pre == nil || post == nil {
+			t.Fatalf("revert %v: sender missing: pre %v, post %v", revert, pre, post)
+		}
+		// Both balances are taken after the purchase of the gas
+		if want := new(big.Int).Sub(big.NewInt(params.Ether), big.NewInt(2*100000)); pre.Balance.ToInt().Cmp(want) != 0 {
+			t.Errorf("revert %v: sender prestate balance mismatch: have %v, want %v", revert, pre.Balance, want)
+		}
+		want := big.NewInt(100)
+		if revert {
+			want = new(big.Int)
+		}
+		if post.Balance == nil {
+			if want.Sign() != 0 {
+				t.Errorf("revert %v: sender balance change missing", revert)
+			}
+		} else if diff := new(big.Int).Sub(pre.Balance.ToInt(), post.Balance.ToInt()); diff.Cmp(want) != 0 {
+			t.Errorf("revert %v: sender balance change mismatch: have %v, want %v", revert, diff, want)
+		}
+	}
+}
+
+// noopNativeTracer is a custom tracer registered by the tests.
+type noopNativeTracer struct {
+	config json.RawMessage
+}
+
+func (t *noopNativeTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+func (t *noopNativeTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+func (t *noopNativeTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+func (t *noopNativeTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+func (t *noopNativeTracer) GetResult() (json.RawMessage, error) { return t.config, nil }
+func (t *noopNativeTracer) Stop(err error)                      {}
+
+// Tests that third party native tracers can be registered and created by name,
+// but not twice under the same name.
+func TestRegisterNative(t *testing.T) {
+	RegisterNative("noopNativeTracer", func(config json.RawMessage) (ResultTracer, error) {
+		return &noopNativeTracer{config: config}, nil
+	})
+	tracer, err := NewTracer("noopNativeTracer", json.RawMessage(`"config"`))
+	if err != nil {
+		t.Fatalf("failed to create registered tracer: %v", err)
+	}
+	if res, _ := tracer.GetResult(); string(res) != `"config"` {
+		t.Errorf("tracer config mismatch: have %s, want %s", res, `"config"`)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("duplicate registration did not panic")
+		}
+	}()
+	RegisterNative("noopNativeTracer", func(config json.RawMessage) (ResultTracer, error) { return nil, nil })
+}
@@ -27,6 +27,7 @@ import (
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
@@ -35,6 +36,7 @@ import (
 	"github.com/ethereum/go-ethereum/eth"
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
 // Verify that Client implements the ethereum interfaces.
@@ -166,6 +168,14 @@ var (
 	testKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
 	testAddr    = crypto.PubkeyToAddress(testKey.PublicKey)
 	testBalance = big.NewInt(2e10)
+
+	// Contract reverting with Error("boom"), copied from the end of its code
+	revertAddr = common.Address{0xcc}
+	revertCode = common.FromHex("0x6064600c60003960646000fd" +
+		"08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000004" +
+		"626f6f6d00000000000000000000000000000000000000000000000000000000")
 )
 
 func newTestBackend(t *testing.T) (*node.Node, []*types.Block) {
@@ -196,8 +206,11 @@ func generateTestChain() (*core.Genesis, []*types.Block) {
 	db := rawdb.NewMemoryDatabase()
 	config := params.AllEthashProtocolChanges
 	genesis := &core.Genesis{
-		Config:    config,
-		Alloc:     core.GenesisAlloc{testAddr: {Balance: testBalance}},
+		Config: config,
+		Alloc: core.GenesisAlloc{
+			testAddr:   {Balance: testBalance},
+			revertAddr: {Balance: new(big.Int), Code: revertCode},
+		},
 		ExtraData: []byte("test genesis"),
 		Timestamp: 9000,
 	}
@@ -301,3 +314,43 @@ func TestBalanceAt(t *testing.T) {
 		})
 	}
 }
+
+func TestCallContractRevert(t *testing.T) {
+	backend, _ := newTestBackend(t)
+	client, _ := backend.Attach()
+	defer backend.Stop()
+	defer client.Close()
+
+	ec := NewClient(client)
+	msg := ethereum.CallMsg{From: testAddr, To: &revertAddr}
+
+	calls := map[string]func() error{
+		"CallContract": func() error {
+			_, err := ec.CallContract(context.Background(), msg, nil)
+			return err
+		},
+		"PendingCallContract": func() error {
+			_, err := ec.PendingCallContract(context.Background(), msg)
+			return err
+		},
+		"EstimateGas": func() error {
+			_, err := ec.EstimateGas(context.Background(), msg)
+			return err
+		},
+	}
+	for name, call := range calls {
+		err := call()
+		if err == nil {
+			t.Fatalf("%s: expected revert error", name)
+		}
+		if have, want := err.Error(), "execution reverted: boom"; have != want {
+			t.Errorf("%s: error message mismatch: have %q, want %q", name, have, want)
+		}
+		if e, ok := err.(rpc.Error); !ok || e.ErrorCode() != 3 {
+			t.Errorf("%s: error code mismatch: have %v", name, err)
+		}
+		if e, ok := err.(rpc.DataError); !ok || e.ErrorData() != hexutil.Encode(revertCode[12:]) {
+			t.Errorf("%s: error data mismatch: have %v", name, err)
+		}
+	}
+}
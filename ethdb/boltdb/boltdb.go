@@ -0,0 +1,589 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js
+
+// Package boltdb implements the key-value database layer based on bbolt, a
+// pure-Go B+tree store.
+package boltdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// DatabaseFile is the name of the single file holding all the data within
+	// the database directory.
+	DatabaseFile = "bolt.db"
+
+	// minCache is the minimum amount of memory in megabytes to pre-map for the
+	// database file. Bolt relies on the OS page cache for caching, the only
+	// thing this affects is how often the memory map needs to be grown.
+	minCache = 16
+
+	// compactChunkSize is the maximum number of bytes copied into the compacted
+	// database file in a single write transaction.
+	compactChunkSize = 64 * 1024 * 1024
+
+	// compactFreeRatio is the inverse of the minimal share of free pages within
+	// the database file for compaction to be worth rewriting the file.
+	compactFreeRatio = 10
+
+	// metricsGatheringInterval specifies the interval to retrieve boltdb database
+	// transaction and freelist stats to report to the user.
+	metricsGatheringInterval = 3 * time.Second
+)
+
+var (
+	// bucketName is the single bucket all key-value pairs are stored in.
+	bucketName = []byte("ethdb")
+
+	// errNotFound is returned if a key is requested that is not found in the
+	// database.
+	errNotFound = errors.New("not found")
+
+	// errUnknownProperty is returned if an unsupported stat is requested.
+	errUnknownProperty = errors.New("unknown property")
+)
+
+// Database is a persistent key-value store backed by a B+tree. Apart from basic
+// data storage functionality it also supports batch writes and iterating over the
+// keyspace in binary-alphabetical order.
+//
+// Contrary to LSM based stores, writes are applied in place and there is no
+// background compaction. Pages freed up by deletions are reused by later writes,
+// the file itself only shrinks if it's compacted explicitly.
+type Database struct {
+	fn   string        // filename for reporting
+	db   *bolt.DB      // BoltDB instance
+	opts *bolt.Options // Options to reopen the database with after compaction
+	lock sync.RWMutex  // Mutex protecting the BoltDB instance from being swapped out
+
+	diskWriteMeter  metrics.Meter // Meter for measuring the effective amount of data written
+	writeTimeMeter  metrics.Meter // Meter for measuring the time spent writing to disk
+	freePagesGauge  metrics.Gauge // Gauge for tracking the number of free pages in the file
+	readTxOpenGauge metrics.Gauge // Gauge for tracking the number of open read transactions
+
+	quitLock sync.Mutex      // Mutex protecting the quit channel access
+	quitChan chan chan error // Quit channel to stop the metrics collection before closing the database
+
+	log log.Logger // Contextual logger tracking the database path
+}
+
+// New returns a wrapped BoltDB object. The path is the directory to create the
+// database file in. The namespace is the prefix that the metrics reporting
+// should use for surfacing internal stats.
+func New(path string, cache int, namespace string) (*Database, error) {
+	// Ensure we have some minimal memory map pre-allocated
+	if cache < minCache {
+		cache = minCache
+	}
+	logger := log.New("database", path)
+	logger.Info("Allocated memory map", "size", common.StorageSize(cache*1024*1024))
+
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+	opts := &bolt.Options{
+		Timeout:         time.Second,
+		NoFreelistSync:  true,
+		FreelistType:    bolt.FreelistMapType,
+		InitialMmapSize: cache * 1024 * 1024,
+	}
+	db, err := bolt.Open(filepath.Join(path, DatabaseFile), 0600, opts)
+	if err != nil {
+		return nil, err
+	}
+	bdb, err := newDatabase(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	bdb.fn = path
+	bdb.opts = opts
+	bdb.log = logger
+	bdb.quitChan = make(chan chan error)
+
+	bdb.diskWriteMeter = metrics.NewRegisteredMeter(namespace+"disk/write", nil)
+	bdb.writeTimeMeter = metrics.NewRegisteredMeter(namespace+"disk/writetime", nil)
+	bdb.freePagesGauge = metrics.NewRegisteredGauge(namespace+"freelist/pages", nil)
+	bdb.readTxOpenGauge = metrics.NewRegisteredGauge(namespace+"tx/open", nil)
+
+	// Start up the metrics gathering and return
+	go bdb.meter(metricsGatheringInterval)
+	return bdb, nil
+}
+
+// newDatabase wraps an already opened bolt instance, ensuring the data bucket
+// exists.
+func newDatabase(db *bolt.DB) (*Database, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Database{db: db, log: log.Root()}, nil
+}
+
+// Close stops the metrics collection, flushes any pending data to disk and closes
+// all io accesses to the underlying key-value store.
+func (db *Database) Close() error {
+	db.quitLock.Lock()
+	defer db.quitLock.Unlock()
+
+	if db.quitChan != nil {
+		errc := make(chan error)
+		db.quitChan <- errc
+		if err := <-errc; err != nil {
+			db.log.Error("Metrics collection failed", "err", err)
+		}
+		db.quitChan = nil
+	}
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	return db.db.Close()
+}
+
+// Has retrieves if a key is present in the key-value store.
+func (db *Database) Has(key []byte) (bool, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var has bool
+	err := db.db.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket(bucketName).Cursor().Seek(key)
+		has = k != nil && bytes.Equal(k, key)
+		return nil
+	})
+	return has, err
+}
+
+// Get retrieves the given key if it's present in the key-value store.
+func (db *Database) Get(key []byte) ([]byte, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var dat []byte
+	err := db.db.View(func(tx *bolt.Tx) error {
+		k, v := tx.Bucket(bucketName).Cursor().Seek(key)
+		if k == nil || !bytes.Equal(k, key) {
+			return errNotFound
+		}
+		// The value is only valid during the transaction, copy it out
+		dat = common.CopyBytes(v)
+		if dat == nil {
+			dat = []byte{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dat, nil
+}
+
+// Put inserts the given value into the key-value store.
+func (db *Database) Put(key []byte, value []byte) error {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	return db.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Put(key, value)
+	})
+}
+
+// Delete removes the key from the key-value store.
+func (db *Database) Delete(key []byte) error {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	return db.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Delete(key)
+	})
+}
+
+// NewBatch creates a write-only key-value store that buffers changes to its host
+// database until a final write is called.
+func (db *Database) NewBatch() ethdb.Batch {
+	return &batch{
+		db: db,
+	}
+}
+
+// NewIterator creates a binary-alphabetical iterator over the entire keyspace
+// contained within the boltdb database.
+func (db *Database) NewIterator() ethdb.Iterator {
+	return db.newIterator(nil, nil)
+}
+
+// NewIteratorWithStart creates a binary-alphabetical iterator over a subset of
+// database content starting at a particular initial key (or after, if it does
+// not exist).
+func (db *Database) NewIteratorWithStart(start []byte) ethdb.Iterator {
+	return db.newIterator(nil, start)
+}
+
+// NewIteratorWithPrefix creates a binary-alphabetical iterator over a subset
+// of database content with a particular key prefix.
+func (db *Database) NewIteratorWithPrefix(prefix []byte) ethdb.Iterator {
+	return db.newIterator(prefix, prefix)
+}
+
+// newIterator creates an iterator over the keyspace with the given prefix,
+// starting at the given key. The iterator reads from its own read transaction.
+func (db *Database) newIterator(prefix []byte, start []byte) *iterator {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if bytes.Compare(start, prefix) < 0 {
+		start = prefix
+	}
+	tx, err := db.db.Begin(false)
+	if err != nil {
+		return &iterator{err: err}
+	}
+	return &iterator{
+		tx:     tx,
+		cursor: tx.Bucket(bucketName).Cursor(),
+		prefix: prefix,
+		start:  start,
+	}
+}
+
+// Stat returns a particular internal stat of the database. The only supported
+// property is "boltdb.stats".
+func (db *Database) Stat(property string) (string, error) {
+	if property != "boltdb.stats" {
+		return "", errUnknownProperty
+	}
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var (
+		stats = db.db.Stats()
+		size  int64
+	)
+	err := db.db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Size: %v\nFree pages: %d (pending %d)\nFree bytes: %v\nRead txs: %d (open %d)\nPage allocs: %d (%v)\nWrites: %d (%v)\n",
+		common.StorageSize(size), stats.FreePageN, stats.PendingPageN, common.StorageSize(stats.FreeAlloc),
+		stats.TxN, stats.OpenTxN, stats.TxStats.PageCount, common.StorageSize(stats.TxStats.PageAlloc),
+		stats.TxStats.Write, stats.TxStats.WriteTime), nil
+}
+
+// Compact rewrites the database into a new file, leaving out all the pages freed
+// up by deletions, and replaces the original file with it. Bolt can only compact
+// the whole file, so the given range is ignored and the call does nothing if
+// there are only a few free pages to reclaim.
+//
+// The database can't be accessed while it's being compacted and compaction waits
+// for all open iterators to be released.
+func (db *Database) Compact(start []byte, limit []byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	var size int64
+	if err := db.db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return nil
+	}); err != nil {
+		return err
+	}
+	stats := db.db.Stats()
+	if free := int64(stats.FreePageN+stats.PendingPageN) * int64(db.db.Info().PageSize); free*compactFreeRatio < size {
+		return nil
+	}
+	var (
+		path    = db.db.Path()
+		tmppath = path + ".compact"
+	)
+	os.Remove(tmppath) // Leftover from an aborted compaction
+	dst, err := bolt.Open(tmppath, 0600, db.opts)
+	if err != nil {
+		return err
+	}
+	if err := compact(dst, db.db); err != nil {
+		dst.Close()
+		os.Remove(tmppath)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmppath)
+		return err
+	}
+	// Swap the compacted file in and reopen the database. If the file can't be
+	// replaced, the original database is reopened.
+	if err := db.db.Close(); err != nil {
+		return err
+	}
+	renameErr := os.Rename(tmppath, path)
+	bdb, err := bolt.Open(path, 0600, db.opts)
+	if err != nil {
+		return err
+	}
+	db.db = bdb
+	return renameErr
+}
+
+// compact copies all the key-value pairs from the src database into the dst one,
+// committing them in chunks to bound the size of the write transactions.
+func compact(dst, src *bolt.DB) error {
+	return src.View(func(tx *bolt.Tx) error {
+		var (
+			cursor = tx.Bucket(bucketName).Cursor()
+			k, v   = cursor.First()
+		)
+		for {
+			err := dst.Update(func(tx *bolt.Tx) error {
+				bucket, err := tx.CreateBucketIfNotExists(bucketName)
+				if err != nil {
+					return err
+				}
+				// Keys are inserted in order, so the pages can be filled up
+				bucket.FillPercent = 1.0
+
+				for size := 0; k != nil && size < compactChunkSize; k, v = cursor.Next() {
+					if err := bucket.Put(k, v); err != nil {
+						return err
+					}
+					size += len(k) + len(v)
+				}
+				return nil
+			})
+			if err != nil || k == nil {
+				return err
+			}
+		}
+	})
+}
+
+// Path returns the path to the database directory.
+func (db *Database) Path() string {
+	return db.fn
+}
+
+// meter periodically retrieves internal boltdb counters and reports them to
+// the metrics subsystem.
+func (db *Database) meter(refresh time.Duration) {
+	var (
+		prev   bolt.Stats
+		prevdb *bolt.DB
+		errc   chan error
+	)
+	for errc == nil {
+		// Retrieve the database stats and update the requested meters, starting
+		// over if the instance was swapped out by a compaction
+		db.lock.RLock()
+		if db.db != prevdb {
+			prev, prevdb = bolt.Stats{}, db.db
+		}
+		stats := db.db.Stats()
+		db.lock.RUnlock()
+
+		diff := stats.Sub(&prev)
+
+		db.diskWriteMeter.Mark(int64(diff.TxStats.PageAlloc))
+		db.writeTimeMeter.Mark(diff.TxStats.WriteTime.Nanoseconds())
+		db.freePagesGauge.Update(int64(stats.FreePageN))
+		db.readTxOpenGauge.Update(int64(stats.OpenTxN))
+
+		prev = stats
+
+		// Sleep a bit, then repeat the stats collection
+		select {
+		case errc = <-db.quitChan:
+			// Quit requesting, stop hammering the database
+		case <-time.After(refresh):
+			// Timeout, gather a new set of stats
+		}
+	}
+	errc <- nil
+}
+
+// batch is a write-only boltdb batch that commits changes to its host database
+// when Write is called. A batch cannot be used concurrently.
+type batch struct {
+	db     *Database
+	writes []keyvalue
+	size   int
+}
+
+// keyvalue is a key-value tuple tagged with a deletion field to allow creating
+// boltdb write batches.
+type keyvalue struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// Put inserts the given value into the batch for later committing.
+func (b *batch) Put(key, value []byte) error {
+	b.writes = append(b.writes, keyvalue{common.CopyBytes(key), common.CopyBytes(value), false})
+	b.size += len(value)
+	return nil
+}
+
+// Delete inserts the a key removal into the batch for later committing.
+func (b *batch) Delete(key []byte) error {
+	b.writes = append(b.writes, keyvalue{common.CopyBytes(key), nil, true})
+	b.size++
+	return nil
+}
+
+// ValueSize retrieves the amount of data queued up for writing.
+func (b *batch) ValueSize() int {
+	return b.size
+}
+
+// Write flushes any accumulated data to disk in a single transaction.
+func (b *batch) Write() error {
+	b.db.lock.RLock()
+	defer b.db.lock.RUnlock()
+
+	return b.db.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		for _, keyvalue := range b.writes {
+			var err error
+			if keyvalue.delete {
+				err = bucket.Delete(keyvalue.key)
+			} else {
+				err = bucket.Put(keyvalue.key, keyvalue.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Reset resets the batch for reuse.
+func (b *batch) Reset() {
+	b.writes = b.writes[:0]
+	b.size = 0
+}
+
+// Replay replays the batch contents.
+func (b *batch) Replay(w ethdb.KeyValueWriter) error {
+	for _, keyvalue := range b.writes {
+		if keyvalue.delete {
+			if err := w.Delete(keyvalue.key); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.Put(keyvalue.key, keyvalue.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// iterator can walk over the (potentially partial) keyspace of a boltdb key
+// value store. It reads from a single read transaction held open until the
+// iterator is released or exhausted, so it sees a point-in-time snapshot of the
+// database.
+//
+// Note, bolt can't grow its memory map while a read transaction is open, so a
+// write needing more space than mapped blocks until the iterator is released.
+// Code writing to the database while iterating over it from the same goroutine
+// should release and recreate the iterator after each larger write.
+type iterator struct {
+	tx      *bolt.Tx
+	cursor  *bolt.Cursor
+	prefix  []byte
+	start   []byte
+	started bool // Whether the cursor was already positioned
+
+	key   []byte
+	value []byte
+	err   error
+}
+
+// Next moves the iterator to the next key/value pair. It returns whether the
+// iterator is exhausted.
+func (it *iterator) Next() bool {
+	if it.tx == nil {
+		return false
+	}
+	var k, v []byte
+	if !it.started {
+		k, v = it.cursor.Seek(it.start)
+		it.started = true
+	} else {
+		k, v = it.cursor.Next()
+	}
+	if k == nil || !bytes.HasPrefix(k, it.prefix) {
+		it.Release()
+		return false
+	}
+	// The data is only valid during the transaction, copy it out
+	it.key, it.value = common.CopyBytes(k), common.CopyBytes(v)
+	if it.value == nil {
+		it.value = []byte{}
+	}
+	return true
+}
+
+// Error returns any accumulated error. Exhausting all the key/value pairs
+// is not considered to be an error.
+func (it *iterator) Error() error {
+	return it.err
+}
+
+// Key returns the key of the current key/value pair, or nil if done. The caller
+// should not modify the contents of the returned slice, and its contents may
+// change on the next call to Next.
+func (it *iterator) Key() []byte {
+	return it.key
+}
+
+// Value returns the value of the current key/value pair, or nil if done. The
+// caller should not modify the contents of the returned slice, and its contents
+// may change on the next call to Next.
+func (it *iterator) Value() []byte {
+	return it.value
+}
+
+// Release releases associated resources. Release should always succeed and can
+// be called multiple times without causing error.
+func (it *iterator) Release() {
+	if it.tx != nil {
+		it.tx.Rollback()
+		it.tx, it.cursor = nil, nil
+	}
+	it.key, it.value = nil, nil
+}
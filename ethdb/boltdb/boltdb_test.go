@@ -0,0 +1,165 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js
+
+package boltdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/dbtest"
+)
+
+// testBoltDB is a boltdb database in a temporary directory, which is removed
+// when the database is closed.
+type testBoltDB struct {
+	*Database
+	dir string
+}
+
+// Close closes the database and deletes its directory.
+func (db *testBoltDB) Close() error {
+	defer os.RemoveAll(db.dir)
+	return db.Database.Close()
+}
+
+// newTestBoltDB returns a constructor for boltdb databases in temporary
+// directories.
+func newTestBoltDB(tb testing.TB) func() ethdb.KeyValueStore {
+	return func() ethdb.KeyValueStore {
+		dir, err := ioutil.TempDir("", "boltdb-")
+		if err != nil {
+			tb.Fatalf("failed to create temporary directory: %v", err)
+		}
+		db, err := New(dir, 0, "")
+		if err != nil {
+			os.RemoveAll(dir)
+			tb.Fatalf("failed to open database: %v", err)
+		}
+		return &testBoltDB{Database: db, dir: dir}
+	}
+}
+
+func TestBoltDB(t *testing.T) {
+	t.Run("DatabaseSuite", func(t *testing.T) {
+		dbtest.TestDatabaseSuite(t, newTestBoltDB(t))
+	})
+}
+
+// Tests that an iterator doesn't see the changes made after its creation.
+func TestIteratorSnapshot(t *testing.T) {
+	db := newTestBoltDB(t)()
+	defer db.Close()
+
+	for _, key := range []string{"k1", "k2", "k3"} {
+		if err := db.Put([]byte(key), []byte("v")); err != nil {
+			t.Fatalf("failed to insert %s: %v", key, err)
+		}
+	}
+	it := db.NewIterator()
+	defer it.Release()
+
+	// Modify the database in the middle of the iteration
+	if !it.Next() || string(it.Key()) != "k1" {
+		t.Fatalf("first key mismatch: have %q, want %q", it.Key(), "k1")
+	}
+	if err := db.Delete([]byte("k2")); err != nil {
+		t.Fatalf("failed to delete key: %v", err)
+	}
+	if err := db.Put([]byte("k4"), []byte("v")); err != nil {
+		t.Fatalf("failed to insert key: %v", err)
+	}
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if err := it.Error(); err != nil {
+		t.Fatalf("iteration failed: %v", err)
+	}
+	if want := []string{"k2", "k3"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("iterated keys mismatch: have %q, want %q", keys, want)
+	}
+}
+
+// Tests that compaction shrinks the database file and retains the live data.
+func TestCompact(t *testing.T) {
+	db := newTestBoltDB(t)().(*testBoltDB)
+	defer db.Close()
+
+	value := make([]byte, 1024)
+	for i := 0; i < 4096; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%05d", i)), value); err != nil {
+			t.Fatalf("failed to insert item %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 4096; i++ {
+		if i%64 == 0 {
+			continue
+		}
+		if err := db.Delete([]byte(fmt.Sprintf("key-%05d", i))); err != nil {
+			t.Fatalf("failed to delete item %d: %v", i, err)
+		}
+	}
+	path := filepath.Join(db.dir, DatabaseFile)
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat database: %v", err)
+	}
+	if err := db.Compact(nil, nil); err != nil {
+		t.Fatalf("failed to compact database: %v", err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat database: %v", err)
+	}
+	if after.Size() >= before.Size()/4 {
+		t.Errorf("database not compacted: size before %d, after %d", before.Size(), after.Size())
+	}
+	// Compacting again has nothing left to reclaim and must leave the file alone
+	if err := db.Compact(nil, nil); err != nil {
+		t.Fatalf("failed to compact database again: %v", err)
+	}
+	if again, _ := os.Stat(path); !os.SameFile(after, again) {
+		t.Errorf("database rewritten without pages to reclaim")
+	}
+	// The retained data must survive and the database must remain writable
+	for i := 0; i < 4096; i++ {
+		has, err := db.Has([]byte(fmt.Sprintf("key-%05d", i)))
+		if err != nil {
+			t.Fatalf("failed to look up item %d: %v", i, err)
+		}
+		if has != (i%64 == 0) {
+			t.Errorf("item %d presence mismatch: have %t, want %t", i, has, i%64 == 0)
+		}
+	}
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("failed to insert item after compaction: %v", err)
+	}
+	if dat, err := db.Get([]byte("key")); err != nil || string(dat) != "value" {
+		t.Fatalf("item mismatch after compaction: have %q, %v", dat, err)
+	}
+}
+
+func BenchmarkBoltDB(b *testing.B) {
+	dbtest.BenchDatabaseSuite(b, newTestBoltDB(b))
+}
@@ -0,0 +1,463 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package dbtest contains a conformance test suite and benchmarks that every
+// ethdb.KeyValueStore implementation is expected to pass.
+package dbtest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// TestDatabaseSuite runs a suite of tests against a KeyValueStore database
+// implementation. The constructor is invoked for every subtest and must return
+// a fresh, empty database.
+func TestDatabaseSuite(t *testing.T, New func() ethdb.KeyValueStore) {
+	t.Run("Iterator", func(t *testing.T) {
+		tests := []struct {
+			content map[string]string
+			prefix  string
+			order   []string
+		}{
+			// Empty databases should be iterable
+			{map[string]string{}, "", nil},
+			{map[string]string{}, "non-existent-prefix", nil},
+
+			// Single-item databases should be iterable
+			{map[string]string{"key": "val"}, "", []string{"key"}},
+			{map[string]string{"key": "val"}, "k", []string{"key"}},
+			{map[string]string{"key": "val"}, "l", nil},
+
+			// Multi-item databases should be fully iterable
+			{
+				map[string]string{"k1": "v1", "k5": "v5", "k2": "v2", "k4": "v4", "k3": "v3"},
+				"",
+				[]string{"k1", "k2", "k3", "k4", "k5"},
+			},
+			{
+				map[string]string{"k1": "v1", "k5": "v5", "k2": "v2", "k4": "v4", "k3": "v3"},
+				"k",
+				[]string{"k1", "k2", "k3", "k4", "k5"},
+			},
+			{
+				map[string]string{"k1": "v1", "k5": "v5", "k2": "v2", "k4": "v4", "k3": "v3"},
+				"l",
+				nil,
+			},
+			// Multi-item databases should be prefix-iterable
+			{
+				map[string]string{
+					"ka1": "va1", "ka5": "va5", "ka2": "va2", "ka4": "va4", "ka3": "va3",
+					"kb1": "vb1", "kb5": "vb5", "kb2": "vb2", "kb4": "vb4", "kb3": "vb3",
+				},
+				"ka",
+				[]string{"ka1", "ka2", "ka3", "ka4", "ka5"},
+			},
+			{
+				map[string]string{
+					"ka1": "va1", "ka5": "va5", "ka2": "va2", "ka4": "va4", "ka3": "va3",
+					"kb1": "vb1", "kb5": "vb5", "kb2": "vb2", "kb4": "vb4", "kb3": "vb3",
+				},
+				"kc",
+				nil,
+			},
+		}
+		for i, tt := range tests {
+			// Create the key-value data store
+			db := New()
+			for key, val := range tt.content {
+				if err := db.Put([]byte(key), []byte(val)); err != nil {
+					t.Fatalf("test %d: failed to insert item %s:%s into database: %v", i, key, val, err)
+				}
+			}
+			// Iterate over the database with the given configs and verify the results
+			it, idx := db.NewIteratorWithPrefix([]byte(tt.prefix)), 0
+			for it.Next() {
+				if len(tt.order) <= idx {
+					t.Errorf("test %d: prefix=%q more items than expected: checking idx=%d (key %q), expecting len=%d", i, tt.prefix, idx, it.Key(), len(tt.order))
+					break
+				}
+				if !bytes.Equal(it.Key(), []byte(tt.order[idx])) {
+					t.Errorf("test %d: item %d: key mismatch: have %s, want %s", i, idx, string(it.Key()), tt.order[idx])
+				}
+				if !bytes.Equal(it.Value(), []byte(tt.content[tt.order[idx]])) {
+					t.Errorf("test %d: item %d: value mismatch: have %s, want %s", i, idx, string(it.Value()), tt.content[tt.order[idx]])
+				}
+				idx++
+			}
+			if err := it.Error(); err != nil {
+				t.Errorf("test %d: iteration failed: %v", i, err)
+			}
+			if idx != len(tt.order) {
+				t.Errorf("test %d: iteration terminated prematurely: have %d, want %d", i, idx, len(tt.order))
+			}
+			it.Release()
+			db.Close()
+		}
+	})
+
+	t.Run("IteratorWithStart", func(t *testing.T) {
+		db := New()
+		defer db.Close()
+
+		keys := []string{"1", "2", "3", "4", "6", "10", "11", "12", "20", "21", "22"}
+		sort.Strings(keys) // 1, 10, 11, etc
+
+		for _, k := range keys {
+			if err := db.Put([]byte(k), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tests := []struct {
+			start string
+			order []string
+		}{
+			{"", keys},
+			{"1", keys},
+			{"13", []string{"2", "20", "21", "22", "3", "4", "6"}},
+			{"2", []string{"2", "20", "21", "22", "3", "4", "6"}},
+			{"5", []string{"6"}},
+			{"7", nil},
+		}
+		for i, tt := range tests {
+			it := db.NewIteratorWithStart([]byte(tt.start))
+			if got := iterateKeys(it); !reflect.DeepEqual(got, tt.order) {
+				t.Errorf("test %d: start=%q: iteration mismatch: have %v, want %v", i, tt.start, got, tt.order)
+			}
+		}
+	})
+
+	t.Run("IteratorLarge", func(t *testing.T) {
+		db := New()
+		defer db.Close()
+
+		// Insert enough entries to span multiple internal pages or chunks
+		var keys []string
+		for i := 0; i < 5000; i++ {
+			key := fmt.Sprintf("%08d", i)
+			keys = append(keys, key)
+			if err := db.Put([]byte(key), bytes.Repeat([]byte{byte(i)}, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := iterateKeys(db.NewIterator()); !reflect.DeepEqual(got, keys) {
+			t.Errorf("iteration mismatch: have %d keys, want %d", len(got), len(keys))
+		}
+		// Writing while iterating must not deadlock or corrupt the iteration
+		it := db.NewIterator()
+		defer it.Release()
+
+		for i := 0; it.Next(); i++ {
+			if i%100 == 0 {
+				if err := db.Put(append([]byte("z"), it.Key()...), it.Value()); err != nil {
+					t.Fatalf("failed to write during iteration: %v", err)
+				}
+			}
+		}
+		if err := it.Error(); err != nil {
+			t.Fatalf("iteration failed: %v", err)
+		}
+	})
+
+	t.Run("KeyValueOperations", func(t *testing.T) {
+		db := New()
+		defer db.Close()
+
+		key := []byte("foo")
+
+		if got, err := db.Has(key); err != nil {
+			t.Error(err)
+		} else if got {
+			t.Errorf("wrong value: %t", got)
+		}
+		if _, err := db.Get(key); err == nil {
+			t.Errorf("missing key retrieved without error")
+		}
+		value := []byte("hello world")
+		if err := db.Put(key, value); err != nil {
+			t.Error(err)
+		}
+		if got, err := db.Has(key); err != nil {
+			t.Error(err)
+		} else if !got {
+			t.Errorf("wrong value: %t", got)
+		}
+		if got, err := db.Get(key); err != nil {
+			t.Error(err)
+		} else if !bytes.Equal(got, value) {
+			t.Errorf("wrong value: %q", got)
+		}
+		// Mutating the inserted or returned slices must not change the database
+		value[0] = 'H'
+		if got, err := db.Get(key); err != nil {
+			t.Error(err)
+		} else if !bytes.Equal(got, []byte("hello world")) {
+			t.Errorf("wrong value after mutating input: %q", got)
+		}
+		// Empty values must be distinguishable from missing ones
+		empty := []byte("empty")
+		if err := db.Put(empty, nil); err != nil {
+			t.Error(err)
+		}
+		if got, err := db.Has(empty); err != nil {
+			t.Error(err)
+		} else if !got {
+			t.Errorf("empty value not found")
+		}
+		if got, err := db.Get(empty); err != nil {
+			t.Error(err)
+		} else if len(got) != 0 {
+			t.Errorf("wrong value: %q", got)
+		}
+		if err := db.Delete(key); err != nil {
+			t.Error(err)
+		}
+		if got, err := db.Has(key); err != nil {
+			t.Error(err)
+		} else if got {
+			t.Errorf("wrong value: %t", got)
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		db := New()
+		defer db.Close()
+
+		b := db.NewBatch()
+		for _, k := range []string{"1", "2", "3", "4"} {
+			if err := b.Put([]byte(k), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if has, err := db.Has([]byte("1")); err != nil {
+			t.Fatal(err)
+		} else if has {
+			t.Error("db contains element before batch write")
+		}
+		if err := b.Write(); err != nil {
+			t.Fatal(err)
+		}
+		if got := iterateKeys(db.NewIterator()); !reflect.DeepEqual(got, []string{"1", "2", "3", "4"}) {
+			t.Errorf("got: %s; want: %s", got, []string{"1", "2", "3", "4"})
+		}
+		b.Reset()
+
+		// Mix writes and deletes in batch
+		b.Put([]byte("5"), nil)
+		b.Delete([]byte("1"))
+		b.Put([]byte("6"), nil)
+		b.Delete([]byte("3"))
+		b.Put([]byte("3"), nil)
+
+		if err := b.Write(); err != nil {
+			t.Fatal(err)
+		}
+		if got := iterateKeys(db.NewIterator()); !reflect.DeepEqual(got, []string{"2", "3", "4", "5", "6"}) {
+			t.Errorf("got: %s; want: %s", got, []string{"2", "3", "4", "5", "6"})
+		}
+	})
+
+	t.Run("BatchReplay", func(t *testing.T) {
+		db := New()
+		defer db.Close()
+
+		want := []string{"1", "2", "3", "4"}
+		b := db.NewBatch()
+		for _, k := range want {
+			if err := b.Put([]byte(k), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		b2 := db.NewBatch()
+		if err := b.Replay(b2); err != nil {
+			t.Fatal(err)
+		}
+		if err := b2.Replay(db); err != nil {
+			t.Fatal(err)
+		}
+		if got := iterateKeys(db.NewIterator()); !reflect.DeepEqual(got, want) {
+			t.Errorf("got: %s; want: %s", got, want)
+		}
+	})
+
+	t.Run("Compact", func(t *testing.T) {
+		db := New()
+		defer db.Close()
+
+		var keys []string
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("%03d", i)
+			if err := db.Put([]byte(key), []byte(key)); err != nil {
+				t.Fatal(err)
+			}
+			if i%2 == 0 {
+				keys = append(keys, key)
+			} else if err := db.Delete([]byte(key)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Compaction is optional (e.g. memory databases), but if it's supported,
+		// it must not lose or resurrect any data.
+		if err := db.Compact(nil, nil); err != nil {
+			t.Skipf("compaction unsupported: %v", err)
+		}
+		if err := db.Compact([]byte("010"), []byte("050")); err != nil {
+			t.Fatalf("failed to compact range: %v", err)
+		}
+		if got := iterateKeys(db.NewIterator()); !reflect.DeepEqual(got, keys) {
+			t.Errorf("got: %s; want: %s", got, keys)
+		}
+	})
+}
+
+// BenchDatabaseSuite runs a suite of benchmarks against a KeyValueStore database
+// implementation, allowing the different backends to be compared.
+func BenchDatabaseSuite(b *testing.B, New func() ethdb.KeyValueStore) {
+	var (
+		keys, vals   = makeDataset(1000, 32, 32, false)
+		sKeys, sVals = makeDataset(1000, 32, 32, true)
+	)
+	// Run benchmarks sequentially
+	b.Run("Write", func(b *testing.B) {
+		benchWrite := func(b *testing.B, keys, vals [][]byte) {
+			db := New()
+			defer db.Close()
+
+			b.ResetTimer()
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				db.Put(keys[i%len(keys)], vals[i%len(vals)])
+			}
+		}
+		b.Run("WriteSorted", func(b *testing.B) {
+			benchWrite(b, sKeys, sVals)
+		})
+		b.Run("WriteRandom", func(b *testing.B) {
+			benchWrite(b, keys, vals)
+		})
+	})
+	b.Run("Read", func(b *testing.B) {
+		benchRead := func(b *testing.B, keys, vals [][]byte) {
+			db := New()
+			defer db.Close()
+
+			for i := 0; i < len(keys); i++ {
+				db.Put(keys[i], vals[i])
+			}
+			b.ResetTimer()
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				db.Get(keys[i%len(keys)])
+			}
+		}
+		b.Run("ReadSorted", func(b *testing.B) {
+			benchRead(b, sKeys, sVals)
+		})
+		b.Run("ReadRandom", func(b *testing.B) {
+			benchRead(b, keys, vals)
+		})
+	})
+	b.Run("Iteration", func(b *testing.B) {
+		benchIteration := func(b *testing.B, keys, vals [][]byte) {
+			db := New()
+			defer db.Close()
+
+			for i := 0; i < len(keys); i++ {
+				db.Put(keys[i], vals[i])
+			}
+			b.ResetTimer()
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				it := db.NewIterator()
+				for it.Next() {
+				}
+				it.Release()
+			}
+		}
+		b.Run("IterationSorted", func(b *testing.B) {
+			benchIteration(b, sKeys, sVals)
+		})
+		b.Run("IterationRandom", func(b *testing.B) {
+			benchIteration(b, keys, vals)
+		})
+	})
+	b.Run("BatchWrite", func(b *testing.B) {
+		benchBatchWrite := func(b *testing.B, keys, vals [][]byte) {
+			db := New()
+			defer db.Close()
+
+			b.ResetTimer()
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				batch := db.NewBatch()
+				for j := 0; j < len(keys); j++ {
+					batch.Put(keys[j], vals[j])
+				}
+				batch.Write()
+			}
+		}
+		b.Run("BatchWriteSorted", func(b *testing.B) {
+			benchBatchWrite(b, sKeys, sVals)
+		})
+		b.Run("BatchWriteRandom", func(b *testing.B) {
+			benchBatchWrite(b, keys, vals)
+		})
+	})
+}
+
+// iterateKeys drains the iterator, returning all the keys it yielded.
+func iterateKeys(it ethdb.Iterator) []string {
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	it.Release()
+	return keys
+}
+
+// makeDataset generates a set of random key-value pairs, optionally sorted by key.
+func makeDataset(size, ksize, vsize int, order bool) ([][]byte, [][]byte) {
+	var keys [][]byte
+	var vals [][]byte
+	for i := 0; i < size; i += 1 {
+		keys = append(keys, randBytes(ksize))
+		vals = append(vals, randBytes(vsize))
+	}
+	if order {
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	}
+	return keys, vals
+}
+
+// randBytes generates a random blob of data.
+func randBytes(len int) []byte {
+	buf := make([]byte, len)
+	if n, err := rand.Read(buf); n != len || err != nil {
+		panic(err)
+	}
+	return buf
+}
@@ -19,6 +19,9 @@ package memorydb
 import (
 	"bytes"
 	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/dbtest"
 )
 
 // Tests that key-value iteration on top of a memory database works.
@@ -98,3 +101,17 @@ func TestMemoryDBIterator(t *testing.T) {
 		}
 	}
 }
+
+func TestMemoryDB(t *testing.T) {
+	t.Run("DatabaseSuite", func(t *testing.T) {
+		dbtest.TestDatabaseSuite(t, func() ethdb.KeyValueStore {
+			return New()
+		})
+	})
+}
+
+func BenchmarkMemoryDB(b *testing.B) {
+	dbtest.BenchDatabaseSuite(b, func() ethdb.KeyValueStore {
+		return New()
+	})
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package era
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// headerSize is the length of the fixed size type-length header preceding the
+// value of every e2store entry.
+const headerSize = 8
+
+// entry is a single type-length-value record of an e2store file.
+//
+// The on-disk layout of an entry is:
+//
+//   type:     2 bytes, little endian
+//   length:   4 bytes, little endian, size of the value
+//   reserved: 2 bytes, must be zero
+//   value:    length bytes
+type entry struct {
+	Type  uint16
+	Value []byte
+}
+
+// e2writer appends type-length-value entries to an underlying stream.
+type e2writer struct {
+	w io.Writer
+}
+
+// newE2Writer creates an e2store writer on top of the given stream.
+func newE2Writer(w io.Writer) *e2writer {
+	return &e2writer{w: w}
+}
+
+// Write appends a single entry of the given type to the stream, returning the
+// total number of bytes written (header included).
+func (w *e2writer) Write(typ uint16, value []byte) (int, error) {
+	if uint64(len(value)) > uint64(^uint32(0)) {
+		return 0, fmt.Errorf("entry too large: %d bytes", len(value))
+	}
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint16(header[0:], typ)
+	binary.LittleEndian.PutUint32(header[2:], uint32(len(value)))
+
+	if n, err := w.w.Write(header[:]); err != nil {
+		return n, err
+	}
+	n, err := w.w.Write(value)
+	return headerSize + n, err
+}
+
+// e2reader reads type-length-value entries from random offsets of an
+// underlying file.
+type e2reader struct {
+	r io.ReaderAt
+}
+
+// newE2Reader creates an e2store reader on top of the given random access
+// stream.
+func newE2Reader(r io.ReaderAt) *e2reader {
+	return &e2reader{r: r}
+}
+
+// ReadMetadataAt reads the header of the entry at the given offset, returning
+// its type and value length.
+func (r *e2reader) ReadMetadataAt(off int64) (uint16, uint32, error) {
+	var header [headerSize]byte
+	if _, err := r.r.ReadAt(header[:], off); err != nil {
+		return 0, 0, err
+	}
+	if header[6] != 0 || header[7] != 0 {
+		return 0, 0, errors.New("reserved bytes are non-zero")
+	}
+	return binary.LittleEndian.Uint16(header[0:]), binary.LittleEndian.Uint32(header[2:]), nil
+}
+
+// ReadAt reads the entry at the given offset, returning it along with the total
+// number of bytes it occupies (header included).
+func (r *e2reader) ReadAt(off int64) (*entry, int64, error) {
+	typ, length, err := r.ReadMetadataAt(off)
+	if err != nil {
+		return nil, 0, err
+	}
+	value := make([]byte, length)
+	if length > 0 {
+		if _, err := r.r.ReadAt(value, off+headerSize); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, 0, err
+		}
+	}
+	return &entry{Type: typ, Value: value}, headerSize + int64(length), nil
+}
@@ -0,0 +1,559 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package era implements a self-describing, checksummed archive format for
+// ranges of historical blocks.
+//
+// An archive file is a sequence of e2store type-length-value entries laid out
+// as follows:
+//
+//   Version | block-tuple* | Accumulator | BlockIndex
+//   block-tuple := CompressedHeader | CompressedBody | CompressedReceipts | TotalDifficulty
+//
+// Headers, bodies and receipts are stored in the same RLP encoding the chain
+// freezer uses, wrapped into framed snappy streams which carry a CRC checksum
+// for every chunk. The total difficulty is a 32 byte big endian integer. The
+// accumulator is the keccak256 hash of the RLP encoded list of (hash, total
+// difficulty) pairs of all the blocks in the file, and the block index holds
+// the offsets of every block tuple, allowing single blocks to be retrieved by
+// random access.
+package era
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/golang/snappy"
+)
+
+// Entry types of an archive file.
+const (
+	TypeVersion            uint16 = 0x3265
+	TypeCompressedHeader   uint16 = 0x03
+	TypeCompressedBody     uint16 = 0x04
+	TypeCompressedReceipts uint16 = 0x05
+	TypeTotalDifficulty    uint16 = 0x06
+	TypeAccumulator        uint16 = 0x07
+	TypeBlockIndex         uint16 = 0x3266
+)
+
+// MaxEraSize is the maximum number of blocks a single archive file may contain.
+// Archives are aligned to multiples of this size, their epoch being the number
+// of the first contained block divided by it.
+const MaxEraSize = 8192
+
+var (
+	errEmptyArchive  = errors.New("no blocks added to archive")
+	errArchiveFull   = fmt.Errorf("archive already contains %d blocks", MaxEraSize)
+	errFinalized     = errors.New("archive already finalized")
+	errOutOfRange    = errors.New("block number out of archive range")
+	errInvalidLayout = errors.New("invalid archive layout")
+)
+
+// Filename returns the canonical name of an archive file of the given network
+// and epoch. The name embeds a short prefix of the accumulator so that files
+// of different chains are not mixed up.
+func Filename(network string, epoch int, root common.Hash) string {
+	return fmt.Sprintf("%s-%05d-%s.era1", network, epoch, root.Hex()[2:10])
+}
+
+// ReadDir reads the archive directory and returns the sorted list of archive
+// files belonging to the given network. It fails if the epochs found are not
+// contiguous.
+func ReadDir(dir, network string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %v", dir, err)
+	}
+	var (
+		epochs = make(map[int]string)
+		sorted []int
+	)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".era1" {
+			continue
+		}
+		parts := strings.Split(strings.TrimSuffix(entry.Name(), ".era1"), "-")
+		if len(parts) != 3 || parts[0] != network {
+			continue
+		}
+		var epoch int
+		if _, err := fmt.Sscanf(parts[1], "%05d", &epoch); err != nil {
+			return nil, fmt.Errorf("malformed archive filename %s: %v", entry.Name(), err)
+		}
+		if prev, ok := epochs[epoch]; ok {
+			return nil, fmt.Errorf("duplicate archives for epoch %d: %s and %s", epoch, prev, entry.Name())
+		}
+		epochs[epoch] = entry.Name()
+		sorted = append(sorted, epoch)
+	}
+	sort.Ints(sorted)
+
+	files := make([]string, 0, len(sorted))
+	for i, epoch := range sorted {
+		if i > 0 && epoch != sorted[i-1]+1 {
+			return nil, fmt.Errorf("missing archive for epoch %d", sorted[i-1]+1)
+		}
+		files = append(files, epochs[epoch])
+	}
+	return files, nil
+}
+
+// headerRecord is a single element of the accumulated block list.
+type headerRecord struct {
+	Hash            common.Hash
+	TotalDifficulty *big.Int
+}
+
+// ComputeAccumulator calculates the accumulator root of a list of block hashes
+// and total difficulties.
+func ComputeAccumulator(hashes []common.Hash, tds []*big.Int) (common.Hash, error) {
+	if len(hashes) != len(tds) {
+		return common.Hash{}, fmt.Errorf("hash and difficulty count mismatch: %d != %d", len(hashes), len(tds))
+	}
+	records := make([]headerRecord, len(hashes))
+	for i := range hashes {
+		records[i] = headerRecord{Hash: hashes[i], TotalDifficulty: tds[i]}
+	}
+	blob, err := rlp.EncodeToBytes(records)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash(blob), nil
+}
+
+// Builder is used to create an archive file. Blocks need to be added in
+// ascending, contiguous order, after which Finalize writes the trailing
+// accumulator and block index.
+type Builder struct {
+	w       *e2writer
+	written int64 // Number of bytes written so far
+
+	start   uint64        // Number of the first block added
+	offsets []int64       // Offset of every block tuple
+	hashes  []common.Hash // Hash of every block, used for the accumulator
+	tds     []*big.Int    // Total difficulty of every block, used for the accumulator
+
+	finalized bool
+}
+
+// NewBuilder creates an archive builder writing into the given stream.
+func NewBuilder(w io.Writer) *Builder {
+	return &Builder{w: newE2Writer(w)}
+}
+
+// Add appends a block along with its receipts and total difficulty to the
+// archive.
+func (b *Builder) Add(block *types.Block, receipts types.Receipts, td *big.Int) error {
+	header, err := rlp.EncodeToBytes(block.Header())
+	if err != nil {
+		return err
+	}
+	body, err := rlp.EncodeToBytes(block.Body())
+	if err != nil {
+		return err
+	}
+	storageReceipts := make([]*types.ReceiptForStorage, len(receipts))
+	for i, receipt := range receipts {
+		storageReceipts[i] = (*types.ReceiptForStorage)(receipt)
+	}
+	rs, err := rlp.EncodeToBytes(storageReceipts)
+	if err != nil {
+		return err
+	}
+	return b.AddRLP(block.NumberU64(), block.Hash(), header, body, rs, td)
+}
+
+// AddRLP appends an already RLP encoded block, as stored by the chain freezer,
+// to the archive.
+func (b *Builder) AddRLP(number uint64, hash common.Hash, header, body, receipts []byte, td *big.Int) error {
+	if b.finalized {
+		return errFinalized
+	}
+	if len(b.offsets) == 0 {
+		// First block added, write the version entry
+		if err := b.write(TypeVersion, nil); err != nil {
+			return err
+		}
+		b.start = number
+	}
+	if len(b.offsets) >= MaxEraSize {
+		return errArchiveFull
+	}
+	if want := b.start + uint64(len(b.offsets)); number != want {
+		return fmt.Errorf("non-contiguous block: have %d, want %d", number, want)
+	}
+	if td.Sign() < 0 || td.BitLen() > 256 {
+		return fmt.Errorf("invalid total difficulty %v", td)
+	}
+	b.offsets = append(b.offsets, b.written)
+	b.hashes = append(b.hashes, hash)
+	b.tds = append(b.tds, new(big.Int).Set(td))
+
+	for _, item := range []struct {
+		typ  uint16
+		data []byte
+	}{
+		{TypeCompressedHeader, header},
+		{TypeCompressedBody, body},
+		{TypeCompressedReceipts, receipts},
+	} {
+		data, err := compress(item.data)
+		if err != nil {
+			return err
+		}
+		if err := b.write(item.typ, data); err != nil {
+			return err
+		}
+	}
+	return b.write(TypeTotalDifficulty, common.BigToHash(td).Bytes())
+}
+
+// Finalize writes the accumulator and the block index to the end of the archive
+// and returns the accumulator root.
+func (b *Builder) Finalize() (common.Hash, error) {
+	if b.finalized {
+		return common.Hash{}, errFinalized
+	}
+	if len(b.offsets) == 0 {
+		return common.Hash{}, errEmptyArchive
+	}
+	root, err := ComputeAccumulator(b.hashes, b.tds)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if err := b.write(TypeAccumulator, root.Bytes()); err != nil {
+		return common.Hash{}, err
+	}
+	// Offsets in the index are relative to the start of the index entry itself
+	index := make([]byte, 16+8*len(b.offsets))
+	binary.LittleEndian.PutUint64(index, b.start)
+	for i, offset := range b.offsets {
+		binary.LittleEndian.PutUint64(index[8+8*i:], uint64(offset-b.written))
+	}
+	binary.LittleEndian.PutUint64(index[8+8*len(b.offsets):], uint64(len(b.offsets)))
+	if err := b.write(TypeBlockIndex, index); err != nil {
+		return common.Hash{}, err
+	}
+	b.finalized = true
+	return root, nil
+}
+
+// write appends an entry to the archive, tracking the number of bytes written.
+func (b *Builder) write(typ uint16, data []byte) error {
+	n, err := b.w.Write(typ, data)
+	b.written += int64(n)
+	return err
+}
+
+// ReadAtCloser is the file interface an archive is read from.
+type ReadAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// Era is a read handle to a single archive file.
+type Era struct {
+	f ReadAtCloser
+	r *e2reader
+
+	start   uint64  // Number of the first block in the archive
+	offsets []int64 // Absolute offsets of the block tuples
+	index   int64   // Offset of the block index entry
+}
+
+// Open opens the archive file at the given path.
+func Open(filename string) (*Era, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	e, err := From(f, stat.Size())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to open archive %s: %v", filename, err)
+	}
+	return e, nil
+}
+
+// From wraps an already opened archive of the given size. The archive takes
+// ownership of the file, closing it when the archive is closed.
+func From(f ReadAtCloser, size int64) (*Era, error) {
+	r := newE2Reader(f)
+
+	// Ensure the archive starts with a version entry
+	typ, length, err := r.ReadMetadataAt(0)
+	if err != nil {
+		return nil, err
+	}
+	if typ != TypeVersion || length != 0 {
+		return nil, errors.New("missing version entry")
+	}
+	// Locate the block index via the trailing block count
+	if size < headerSize+24 {
+		return nil, errInvalidLayout
+	}
+	var buf [8]byte
+	if _, err := f.ReadAt(buf[:], size-8); err != nil {
+		return nil, err
+	}
+	count := binary.LittleEndian.Uint64(buf[:])
+	if count == 0 || count > MaxEraSize {
+		return nil, fmt.Errorf("invalid block count %d", count)
+	}
+	index := size - headerSize - 16 - 8*int64(count)
+	if index < headerSize {
+		return nil, errInvalidLayout
+	}
+	entry, _, err := r.ReadAt(index)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Type != TypeBlockIndex {
+		return nil, errInvalidLayout
+	}
+	e := &Era{
+		f:       f,
+		r:       r,
+		start:   binary.LittleEndian.Uint64(entry.Value),
+		offsets: make([]int64, count),
+		index:   index,
+	}
+	for i := range e.offsets {
+		offset := index + int64(binary.LittleEndian.Uint64(entry.Value[8+8*i:]))
+		if offset < headerSize || offset >= index {
+			return nil, fmt.Errorf("invalid offset %d for block %d", offset, e.start+uint64(i))
+		}
+		e.offsets[i] = offset
+	}
+	return e, nil
+}
+
+// Close closes the underlying archive file.
+func (e *Era) Close() error {
+	return e.f.Close()
+}
+
+// Start returns the number of the first block in the archive.
+func (e *Era) Start() uint64 {
+	return e.start
+}
+
+// Count returns the number of blocks in the archive.
+func (e *Era) Count() uint64 {
+	return uint64(len(e.offsets))
+}
+
+// Accumulator returns the accumulator root stored in the archive.
+func (e *Era) Accumulator() (common.Hash, error) {
+	entry, _, err := e.r.ReadAt(e.index - headerSize - common.HashLength)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if entry.Type != TypeAccumulator || len(entry.Value) != common.HashLength {
+		return common.Hash{}, errInvalidLayout
+	}
+	return common.BytesToHash(entry.Value), nil
+}
+
+// GetRawBlockByNumber retrieves the decompressed RLP encoded header, body and
+// receipts, along with the total difficulty of the block with the given number.
+func (e *Era) GetRawBlockByNumber(number uint64) (header, body, receipts []byte, td *big.Int, err error) {
+	if number < e.start || number >= e.start+e.Count() {
+		return nil, nil, nil, nil, errOutOfRange
+	}
+	offset := e.offsets[number-e.start]
+
+	var blobs [][]byte
+	for _, typ := range []uint16{TypeCompressedHeader, TypeCompressedBody, TypeCompressedReceipts, TypeTotalDifficulty} {
+		entry, n, err := e.r.ReadAt(offset)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		if entry.Type != typ {
+			return nil, nil, nil, nil, fmt.Errorf("block %d: unexpected entry type %#x, want %#x", number, entry.Type, typ)
+		}
+		if typ == TypeTotalDifficulty {
+			if len(entry.Value) != common.HashLength {
+				return nil, nil, nil, nil, fmt.Errorf("block %d: invalid total difficulty length %d", number, len(entry.Value))
+			}
+			td = new(big.Int).SetBytes(entry.Value)
+		} else {
+			blob, err := decompress(entry.Value)
+			if err != nil {
+				return nil, nil, nil, nil, fmt.Errorf("block %d: %v", number, err)
+			}
+			blobs = append(blobs, blob)
+		}
+		offset += n
+	}
+	return blobs[0], blobs[1], blobs[2], td, nil
+}
+
+// GetBlockByNumber retrieves the block with the given number.
+func (e *Era) GetBlockByNumber(number uint64) (*types.Block, error) {
+	header, body, _, _, err := e.GetRawBlockByNumber(number)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBlock(header, body)
+}
+
+// GetReceiptsByNumber retrieves the receipts of the block with the given number.
+// Only the consensus and storage fields of the receipts are populated.
+func (e *Era) GetReceiptsByNumber(number uint64) (types.Receipts, error) {
+	_, _, receipts, _, err := e.GetRawBlockByNumber(number)
+	if err != nil {
+		return nil, err
+	}
+	return decodeReceipts(receipts)
+}
+
+// GetTotalDifficultyByNumber retrieves the total difficulty of the block with
+// the given number.
+func (e *Era) GetTotalDifficultyByNumber(number uint64) (*big.Int, error) {
+	_, _, _, td, err := e.GetRawBlockByNumber(number)
+	return td, err
+}
+
+// Verify checks the internal consistency of the archive: every block body and
+// receipt list must match the roots in its header, the blocks must form a
+// chain and the accumulator must match the contained hashes and difficulties.
+func (e *Era) Verify() error {
+	var (
+		hashes = make([]common.Hash, 0, e.Count())
+		tds    = make([]*big.Int, 0, e.Count())
+		parent *types.Header
+	)
+	for number := e.start; number < e.start+e.Count(); number++ {
+		header, body, receipts, td, err := e.GetRawBlockByNumber(number)
+		if err != nil {
+			return err
+		}
+		block, err := decodeBlock(header, body)
+		if err != nil {
+			return fmt.Errorf("block %d: %v", number, err)
+		}
+		if err := VerifyBlock(block, receipts); err != nil {
+			return err
+		}
+		if parent != nil {
+			if block.ParentHash() != parent.Hash() {
+				return fmt.Errorf("block %d: parent hash mismatch: have %x, want %x", number, block.ParentHash(), parent.Hash())
+			}
+			if want := new(big.Int).Add(tds[len(tds)-1], block.Difficulty()); td.Cmp(want) != 0 {
+				return fmt.Errorf("block %d: total difficulty mismatch: have %v, want %v", number, td, want)
+			}
+		}
+		parent = block.Header()
+		hashes = append(hashes, block.Hash())
+		tds = append(tds, td)
+	}
+	want, err := ComputeAccumulator(hashes, tds)
+	if err != nil {
+		return err
+	}
+	have, err := e.Accumulator()
+	if err != nil {
+		return err
+	}
+	if have != want {
+		return fmt.Errorf("accumulator mismatch: have %x, want %x", have, want)
+	}
+	return nil
+}
+
+// VerifyBlock checks that the given block's body and RLP encoded storage
+// receipts match the roots in its header.
+func VerifyBlock(block *types.Block, receipts []byte) error {
+	if hash := types.DeriveSha(block.Transactions()); hash != block.TxHash() {
+		return fmt.Errorf("block %d: transaction root mismatch: have %x, want %x", block.NumberU64(), hash, block.TxHash())
+	}
+	if hash := types.CalcUncleHash(block.Uncles()); hash != block.UncleHash() {
+		return fmt.Errorf("block %d: uncle root mismatch: have %x, want %x", block.NumberU64(), hash, block.UncleHash())
+	}
+	rs, err := decodeReceipts(receipts)
+	if err != nil {
+		return fmt.Errorf("block %d: %v", block.NumberU64(), err)
+	}
+	if hash := types.DeriveSha(rs); hash != block.ReceiptHash() {
+		return fmt.Errorf("block %d: receipt root mismatch: have %x, want %x", block.NumberU64(), hash, block.ReceiptHash())
+	}
+	return nil
+}
+
+// decodeBlock assembles a block from its RLP encoded header and body.
+func decodeBlock(header, body []byte) (*types.Block, error) {
+	var h types.Header
+	if err := rlp.DecodeBytes(header, &h); err != nil {
+		return nil, fmt.Errorf("invalid header: %v", err)
+	}
+	var b types.Body
+	if err := rlp.DecodeBytes(body, &b); err != nil {
+		return nil, fmt.Errorf("invalid body: %v", err)
+	}
+	return types.NewBlockWithHeader(&h).WithBody(b.Transactions, b.Uncles), nil
+}
+
+// decodeReceipts decodes a list of RLP encoded storage receipts.
+func decodeReceipts(blob []byte) (types.Receipts, error) {
+	var storageReceipts []*types.ReceiptForStorage
+	if err := rlp.DecodeBytes(blob, &storageReceipts); err != nil {
+		return nil, fmt.Errorf("invalid receipts: %v", err)
+	}
+	receipts := make(types.Receipts, len(storageReceipts))
+	for i, receipt := range storageReceipts {
+		receipts[i] = (*types.Receipt)(receipt)
+	}
+	return receipts, nil
+}
+
+// compress wraps the data into a framed snappy stream, which checksums every
+// chunk of the data.
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := snappy.NewBufferedWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress unwraps a framed snappy stream, verifying its checksums.
+func decompress(data []byte) ([]byte, error) {
+	return ioutil.ReadAll(snappy.NewReader(bytes.NewReader(data)))
+}
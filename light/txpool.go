@@ -68,6 +68,8 @@ type TxPool struct {
 	clearIdx     uint64                               // earliest block nr that can contain mined tx info
 
 	homestead bool
+	eip2930   bool
+	eip1559   bool
 }
 
 // TxRelayBackend provides an interface to the mechanism that forwards transacions
@@ -310,6 +312,8 @@ func (pool *TxPool) setNewHead(head *types.Header) {
 	m, r := txc.getLists()
 	pool.relay.NewHead(pool.head, m, r)
 	pool.homestead = pool.config.IsHomestead(head.Number)
+	pool.eip2930 = pool.config.IsEIP2930(head.Number)
+	pool.eip1559 = pool.config.IsEIP1559(head.Number)
 	pool.signer = types.MakeSigner(pool.config, head.Number)
 }
 
@@ -340,6 +344,17 @@ func (pool *TxPool) Stats() (pending int) {
 
 // validateTx checks whether a transaction is valid according to the consensus rules.
 func (pool *TxPool) validateTx(ctx context.Context, tx *types.Transaction) error {
+	// Reject typed transactions until the fork introducing them is active
+	if !pool.eip2930 && tx.Type() != types.LegacyTxType {
+		return types.ErrTxTypeNotSupported
+	}
+	if !pool.eip1559 && tx.Type() == types.DynamicFeeTxType {
+		return types.ErrTxTypeNotSupported
+	}
+	// Ensure the tip doesn't exceed the fee cap
+	if tx.GasFeeCap().Cmp(tx.GasTipCap()) < 0 {
+		return core.ErrTipAboveFeeCap
+	}
 	// Validate sender
 	var (
 		from common.Address
@@ -378,7 +393,7 @@ func (pool *TxPool) validateTx(ctx context.Context, tx *types.Transaction) error
 	}
 
 	// Should supply enough intrinsic gas
-	gas, err := core.IntrinsicGas(tx.Data(), tx.To() == nil, pool.homestead)
+	gas, err := core.IntrinsicGas(tx.Data(), tx.AccessList(), tx.To() == nil, pool.homestead)
 	if err != nil {
 		return err
 	}
@@ -95,6 +95,11 @@ type Config struct {
 	// NoUSB disables hardware wallet monitoring and connectivity.
 	NoUSB bool `toml:",omitempty"`
 
+	// DBEngine is the database engine to use for persistent key-value stores
+	// ("leveldb" or "boltdb"). If empty, the engine of any preexisting database
+	// is used, defaulting to leveldb for fresh ones.
+	DBEngine string `toml:",omitempty"`
+
 	// IPCPath is the requested location to place the IPC endpoint. If the path is
 	// a simple file name, it is placed inside the data directory (or on the root
 	// pipe path on Windows), whereas if it's a resolvable path name (absolute or
@@ -0,0 +1,59 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// openDatabase opens a persistent key-value database at the given directory,
+// optionally attaching a chain freezer to it. The database engine is picked
+// based on the node configuration and the data already existing on disk: an
+// explicitly configured engine must match any preexisting database, whereas if
+// no engine is configured, the existing one (or LevelDB for new databases) is
+// used. The readonly flag is forwarded to the chain freezer.
+func openDatabase(engine string, directory string, cache, handles int, freezer, namespace string, readonly bool) (ethdb.Database, error) {
+	existing := rawdb.PreexistingDatabase(directory)
+	if engine != "" && existing != "" && engine != existing {
+		return nil, fmt.Errorf("db.engine choice was %v but found pre-existing %v database in specified data directory", engine, existing)
+	}
+	if engine == "" {
+		engine = existing
+	}
+	switch engine {
+	case rawdb.DBBoltdb:
+		log.Info("Using bbolt as the backing database")
+		if freezer == "" {
+			return rawdb.NewBoltDBDatabase(directory, cache, namespace)
+		}
+		return rawdb.NewBoltDBDatabaseWithFreezer(directory, cache, freezer, namespace, readonly)
+
+	case rawdb.DBLeveldb, "":
+		log.Info("Using leveldb as the backing database")
+		if freezer == "" {
+			return rawdb.NewLevelDBDatabase(directory, cache, handles, namespace)
+		}
+		return rawdb.NewLevelDBDatabaseWithFreezer(directory, cache, handles, freezer, namespace, readonly)
+
+	default:
+		return nil, fmt.Errorf("unknown db.engine %q", engine)
+	}
+}
@@ -45,7 +45,7 @@ func (ctx *ServiceContext) OpenDatabase(name string, cache int, handles int, nam
 	if ctx.config.DataDir == "" {
 		return rawdb.NewMemoryDatabase(), nil
 	}
-	return rawdb.NewLevelDBDatabase(ctx.config.ResolvePath(name), cache, handles, namespace)
+	return openDatabase(ctx.config.DBEngine, ctx.config.ResolvePath(name), cache, handles, "", namespace, false)
 }
 
 // OpenDatabaseWithFreezer opens an existing database with the given name (or
@@ -65,7 +65,7 @@ func (ctx *ServiceContext) OpenDatabaseWithFreezer(name string, cache int, handl
 	case !filepath.IsAbs(freezer):
 		freezer = ctx.config.ResolvePath(freezer)
 	}
-	return rawdb.NewLevelDBDatabaseWithFreezer(root, cache, handles, freezer, namespace)
+	return openDatabase(ctx.config.DBEngine, root, cache, handles, freezer, namespace, false)
 }
 
 // ResolvePath resolves a user path into the data directory if that was relative
@@ -0,0 +1,189 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// lookupInterval is the minimum amount of time between random lookups
+// performed by RandomNodes iterators.
+const lookupInterval = 4 * time.Second
+
+// queryFunc requests nodes close to a lookup target from a remote node.
+type queryFunc func(*node) ([]*node, error)
+
+// lookup performs a network search for nodes close to the given target. It approaches the
+// target by querying nodes that are closer to it on each iteration. The given target does
+// not need to be an actual node identifier. The query function is used to ask remote nodes
+// and is what makes the lookup independent of the discovery protocol version.
+func (tab *Table) lookup(target enode.ID, query queryFunc) []*node {
+	var (
+		asked          = make(map[enode.ID]bool)
+		seen           = make(map[enode.ID]bool)
+		reply          = make(chan []*node, alpha)
+		pendingQueries = 0
+		result         *nodesByDistance
+	)
+	// Don't query further if we hit ourself.
+	// Unlikely to happen often in practice.
+	asked[tab.self().ID()] = true
+
+	// Generate the initial result set.
+	tab.mutex.Lock()
+	result = tab.closest(target, bucketSize, false)
+	tab.mutex.Unlock()
+
+	for {
+		// ask the alpha closest nodes that we haven't asked yet
+		for i := 0; i < len(result.entries) && pendingQueries < alpha; i++ {
+			n := result.entries[i]
+			if !asked[n.ID()] {
+				asked[n.ID()] = true
+				pendingQueries++
+				go tab.lookupWorker(n, query, reply)
+			}
+		}
+		if pendingQueries == 0 {
+			// we have asked all closest nodes, stop the search
+			break
+		}
+		select {
+		case nodes := <-reply:
+			for _, n := range nodes {
+				if n != nil && !seen[n.ID()] {
+					seen[n.ID()] = true
+					result.push(n, bucketSize)
+				}
+			}
+		case <-tab.closeReq:
+			return nil // shutdown, no need to continue.
+		}
+		pendingQueries--
+	}
+	return result.entries
+}
+
+func (tab *Table) lookupWorker(n *node, query queryFunc, reply chan<- []*node) {
+	fails := tab.db.FindFails(n.ID(), n.IP())
+	r, err := query(n)
+	if err == errClosed {
+		// Avoid recording failures on shutdown.
+		reply <- nil
+		return
+	} else if len(r) == 0 {
+		fails++
+		tab.db.UpdateFindFails(n.ID(), n.IP(), fails)
+		tab.log.Trace("Findnode failed", "id", n.ID(), "failcount", fails, "err", err)
+		if fails >= maxFindnodeFailures {
+			tab.log.Trace("Too many findnode failures, dropping", "id", n.ID(), "failcount", fails)
+			tab.delete(n)
+		}
+	} else if fails > 0 {
+		// Reset failure counter because it counts _consecutive_ failures.
+		tab.db.UpdateFindFails(n.ID(), n.IP(), 0)
+	}
+
+	// Grab as many nodes as possible. Some of them might not be alive anymore, but we'll
+	// just remove those again during revalidation.
+	for _, n := range r {
+		tab.addSeenNode(n)
+	}
+	reply <- r
+}
+
+// lookupIterator performs random lookups and iterates over their results.
+// Lookups are throttled to run at most once per lookupInterval.
+type lookupIterator struct {
+	lookupFn   func() []*enode.Node
+	transport  <-chan struct{} // closed when the transport shuts down
+	buffer     []*enode.Node
+	lastLookup time.Time
+	closing    chan struct{}
+	closeOnce  sync.Once
+}
+
+func newLookupIterator(transportClosing <-chan struct{}, lookupFn func() []*enode.Node) *lookupIterator {
+	return &lookupIterator{
+		lookupFn:  lookupFn,
+		transport: transportClosing,
+		closing:   make(chan struct{}),
+	}
+}
+
+// Next moves to the next node.
+func (it *lookupIterator) Next() bool {
+	select {
+	case <-it.closing:
+		it.buffer = nil
+		return false
+	default:
+	}
+	// Consume next node in buffer.
+	if len(it.buffer) > 0 {
+		it.buffer = it.buffer[1:]
+	}
+	// Run lookups until some nodes have been found.
+	for len(it.buffer) == 0 {
+		if !it.waitLookup() {
+			return false
+		}
+		it.buffer = it.lookupFn()
+		it.lastLookup = time.Now()
+	}
+	return true
+}
+
+// waitLookup blocks until the next lookup may be performed. It returns
+// false if the iterator or the underlying transport was closed.
+func (it *lookupIterator) waitLookup() bool {
+	timer := time.NewTimer(time.Until(it.lastLookup.Add(lookupInterval)))
+	defer timer.Stop()
+
+	select {
+	case <-it.closing:
+		return false
+	case <-it.transport:
+		return false
+	case <-timer.C:
+	}
+	// Check again, the timer may have fired at the same time.
+	select {
+	case <-it.closing:
+		return false
+	case <-it.transport:
+		return false
+	default:
+		return true
+	}
+}
+
+// Node returns the current node.
+func (it *lookupIterator) Node() *enode.Node {
+	if len(it.buffer) == 0 {
+		return nil
+	}
+	return it.buffer[0]
+}
+
+// Close ends the iterator.
+func (it *lookupIterator) Close() {
+	it.closeOnce.Do(func() { close(it.closing) })
+}
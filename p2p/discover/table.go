@@ -438,9 +438,34 @@ func (tab *Table) len() (n int) {
 	return n
 }
 
+// appendLiveNodes adds the live nodes at the given log distance to the result slice.
+func (tab *Table) appendLiveNodes(dist uint, result []*enode.Node) []*enode.Node {
+	if dist > uint(hashBits) {
+		return result
+	}
+	if dist == 0 {
+		return append(result, tab.self())
+	}
+
+	tab.mutex.Lock()
+	defer tab.mutex.Unlock()
+	self := tab.self().ID()
+	for _, n := range tab.bucketAtDistance(int(dist)).entries {
+		if n.livenessChecks >= 1 && uint(enode.LogDist(self, n.ID())) == dist {
+			node := n.Node // avoid handing out pointer to struct field
+			result = append(result, &node)
+		}
+	}
+	return result
+}
+
 // bucket returns the bucket for the given node ID hash.
 func (tab *Table) bucket(id enode.ID) *bucket {
 	d := enode.LogDist(tab.self().ID(), id)
+	return tab.bucketAtDistance(d)
+}
+
+func (tab *Table) bucketAtDistance(d int) *bucket {
 	if d <= bucketMinDistance {
 		return tab.buckets[0]
 	}
@@ -83,6 +83,46 @@ func TestUDPv4_Lookup(t *testing.T) {
 	}
 }
 
+func TestUDPv4_LookupIterator(t *testing.T) {
+	t.Parallel()
+	test := newUDPTest(t)
+
+	// Seed table with initial node.
+	fillTable(test.table, []*node{wrapNode(lookupTestnet.node(256, 0))})
+
+	// Read nodes from the iterator, which performs a random lookup.
+	it := test.udp.RandomNodes()
+	resultC := make(chan []*enode.Node, 1)
+	go func() {
+		resultC <- enode.ReadNodes(it, 10)
+		it.Close()
+		test.close()
+	}()
+
+	// Answer lookup packets.
+	for done := false; !done; {
+		done = test.waitPacketOut(func(p packetV4, to *net.UDPAddr, hash []byte) {
+			n, key := lookupTestnet.nodeByAddr(to)
+			switch p.(type) {
+			case *pingV4:
+				test.packetInFrom(nil, key, to, &pongV4{Expiration: futureExp, ReplyTok: hash})
+			case *findnodeV4:
+				dist := enode.LogDist(n.ID(), lookupTestnet.target.id())
+				nodes := lookupTestnet.nodesAtDistance(dist - 1)
+				test.packetInFrom(nil, key, to, &neighborsV4{Expiration: futureExp, Nodes: nodes})
+			}
+		})
+	}
+
+	results := <-resultC
+	if len(results) != 10 {
+		t.Errorf("wrong number of results: got %d, want %d", len(results), 10)
+	}
+	if it.Next() {
+		t.Errorf("Next returned true after Close")
+	}
+}
+
 // This is the test network for the Lookup test.
 // The nodes were obtained by running lookupTestnet.mine with a random NodeID as target.
 var lookupTestnet = &preminedTestnet{
@@ -0,0 +1,256 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"bytes"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/netutil"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	topicMaxLength     = 32               // max length of a topic in REGTOPIC/TOPICQUERY
+	topicAdLifetime    = 15 * time.Minute // how long an ad stays in its topic queue
+	topicQueueLimit    = 50               // max number of ads per topic
+	topicTableLimit    = 500              // max number of ads across all topics
+	topicRegWindow     = 10 * time.Second // how long a ticket is usable once its wait time is over
+	topicRegisterTries = 8                // max number of REGTOPIC calls in RegisterTopic
+
+	ticketMACSize = sha256.Size
+)
+
+// Errors
+var (
+	errInvalidTopic  = errors.New("invalid topic")
+	errInvalidTicket = errors.New("invalid ticket")
+	errTicketWait    = errors.New("ticket wait time too long")
+	errNotRegistered = errors.New("topic registration not confirmed")
+)
+
+// topicTable holds the topic ads placed on the local node by REGTOPIC. It is
+// accessed by the dispatch goroutine only.
+type topicTable struct {
+	clock  mclock.Clock
+	key    []byte // ticket MAC key
+	queues map[string]*topicQueue
+	total  int
+}
+
+// topicQueue holds the ads of a single topic, oldest first.
+type topicQueue struct {
+	ads []*topicAd
+}
+
+type topicAd struct {
+	node    *enode.Node
+	expires mclock.AbsTime
+}
+
+// topicTicket is the content of a ticket. Tickets are opaque to their holder and are
+// protected by a MAC, so only the issuing node can read them.
+type topicTicket struct {
+	Topic  []byte
+	ID     enode.ID
+	IP     net.IP
+	Issued uint64 // mclock.AbsTime of issuing node
+	Wait   uint64 // time.Duration
+}
+
+func newTopicTable(clock mclock.Clock) *topicTable {
+	key := make([]byte, 32)
+	crand.Read(key)
+	return &topicTable{
+		clock:  clock,
+		key:    key,
+		queues: make(map[string]*topicQueue),
+	}
+}
+
+// register places an ad for n in the queue of the given topic. If the queue is full,
+// or ticket is not yet usable, it returns a new ticket and the time to wait before
+// using it. A nil ticket return means the ad was placed.
+func (tt *topicTable) register(n *enode.Node, topic []byte, ticket []byte, ip net.IP) ([]byte, time.Duration, error) {
+	now := tt.clock.Now()
+	tt.expire(now)
+
+	var valid bool
+	if len(ticket) > 0 {
+		tk, err := tt.decodeTicket(ticket)
+		if err != nil {
+			return nil, 0, err
+		}
+		if tk.ID != n.ID() || !bytes.Equal(tk.Topic, topic) || !tk.IP.Equal(ip) {
+			return nil, 0, errInvalidTicket
+		}
+		start := mclock.AbsTime(tk.Issued).Add(time.Duration(tk.Wait))
+		if now < start {
+			// The holder came back too early. Let it wait for the rest of the
+			// time on the same ticket.
+			return ticket, time.Duration(start - now), nil
+		}
+		// Tickets used after the registration window are treated like
+		// registrations without a ticket.
+		valid = now <= start.Add(topicRegWindow)
+	}
+
+	q := tt.queues[string(topic)]
+	if q == nil {
+		q = new(topicQueue)
+		tt.queues[string(topic)] = q
+	}
+	if i := q.find(n.ID()); i >= 0 {
+		// Node is already registered, renew the ad.
+		q.remove(i)
+		tt.total--
+	}
+	if valid {
+		// The holder has waited for its turn. Make room if the slot it waited
+		// for was taken by a registration without a ticket.
+		for len(q.ads) >= topicQueueLimit {
+			q.remove(0)
+			tt.total--
+		}
+		for tt.total >= topicTableLimit {
+			tt.removeOldest()
+		}
+	}
+	if wait := tt.waitTime(q, now); wait > 0 {
+		wait = (wait + time.Second - 1) / time.Second * time.Second
+		tk := topicTicket{Topic: topic, ID: n.ID(), IP: ip, Issued: uint64(now), Wait: uint64(wait)}
+		return tt.encodeTicket(&tk), wait, nil
+	}
+	q.ads = append(q.ads, &topicAd{node: n, expires: now.Add(topicAdLifetime)})
+	tt.total++
+	return nil, 0, nil
+}
+
+// removeOldest removes the oldest ad across all topics.
+func (tt *topicTable) removeOldest() {
+	var oldest *topicQueue
+	for _, q := range tt.queues {
+		if len(q.ads) > 0 && (oldest == nil || q.ads[0].expires < oldest.ads[0].expires) {
+			oldest = q
+		}
+	}
+	if oldest != nil {
+		oldest.remove(0)
+		tt.total--
+	}
+}
+
+// waitTime returns how long it takes until an ad can be placed in q.
+func (tt *topicTable) waitTime(q *topicQueue, now mclock.AbsTime) time.Duration {
+	var wait time.Duration
+	if len(q.ads) >= topicQueueLimit {
+		wait = time.Duration(q.ads[0].expires - now)
+	}
+	if tt.total >= topicTableLimit {
+		var oldest mclock.AbsTime
+		for _, q := range tt.queues {
+			if len(q.ads) > 0 && (oldest == 0 || q.ads[0].expires < oldest) {
+				oldest = q.ads[0].expires
+			}
+		}
+		if d := time.Duration(oldest - now); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// query returns up to limit nodes advertised under the given topic, newest first.
+// Nodes which can't be relayed to rip are skipped.
+func (tt *topicTable) query(topic []byte, rip net.IP, limit int) []*enode.Node {
+	tt.expire(tt.clock.Now())
+
+	var nodes []*enode.Node
+	q := tt.queues[string(topic)]
+	if q == nil {
+		return nil
+	}
+	for i := len(q.ads) - 1; i >= 0 && len(nodes) < limit; i-- {
+		n := q.ads[i].node
+		if netutil.CheckRelayIP(rip, n.IP()) != nil {
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+// expire removes all ads which have expired at the given time.
+func (tt *topicTable) expire(now mclock.AbsTime) {
+	for topic, q := range tt.queues {
+		i := 0
+		for i < len(q.ads) && q.ads[i].expires <= now {
+			i++
+		}
+		q.ads = q.ads[i:]
+		tt.total -= i
+		if len(q.ads) == 0 {
+			delete(tt.queues, topic)
+		}
+	}
+}
+
+func (tt *topicTable) encodeTicket(tk *topicTicket) []byte {
+	enc, _ := rlp.EncodeToBytes(tk)
+	mac := hmac.New(sha256.New, tt.key)
+	mac.Write(enc)
+	return mac.Sum(enc)
+}
+
+func (tt *topicTable) decodeTicket(ticket []byte) (*topicTicket, error) {
+	if len(ticket) <= ticketMACSize {
+		return nil, errInvalidTicket
+	}
+	enc, sum := ticket[:len(ticket)-ticketMACSize], ticket[len(ticket)-ticketMACSize:]
+	mac := hmac.New(sha256.New, tt.key)
+	mac.Write(enc)
+	if !hmac.Equal(mac.Sum(nil), sum) {
+		return nil, errInvalidTicket
+	}
+	tk := new(topicTicket)
+	if err := rlp.DecodeBytes(enc, tk); err != nil {
+		return nil, errInvalidTicket
+	}
+	return tk, nil
+}
+
+func (q *topicQueue) find(id enode.ID) int {
+	for i, ad := range q.ads {
+		if ad.node.ID() == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *topicQueue) remove(i int) {
+	copy(q.ads[i:], q.ads[i+1:])
+	q.ads[len(q.ads)-1] = nil
+	q.ads = q.ads[:len(q.ads)-1]
+}
@@ -0,0 +1,195 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+var (
+	testTopic   = []byte("topic")
+	testAdIP    = net.IP{10, 0, 2, 1}
+	testQueryIP = net.IP{10, 0, 3, 1}
+)
+
+func TestTopicTableRegister(t *testing.T) {
+	var (
+		clock = new(mclock.Simulated)
+		tt    = newTopicTable(clock)
+		nodes = nodesAtDistance(enode.ID{}, 256, topicQueueLimit+2)
+	)
+	// Fill the queue. The first ad is placed a minute before the others.
+	mustRegister(t, tt, nodes[0], nil)
+	clock.Run(time.Minute)
+	for _, n := range nodes[1:topicQueueLimit] {
+		mustRegister(t, tt, n, nil)
+	}
+	if got := tt.query(testTopic, testQueryIP, topicQueueLimit+1); len(got) != topicQueueLimit {
+		t.Fatalf("query returned %d nodes, want %d", len(got), topicQueueLimit)
+	}
+	// Renewing an ad works on a full queue.
+	mustRegister(t, tt, nodes[1], nil)
+
+	// The next node gets a ticket which is due when the first ad expires.
+	holder := nodes[topicQueueLimit]
+	ticket, wait, err := tt.register(holder, testTopic, nil, testAdIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket == nil || wait != topicAdLifetime-time.Minute {
+		t.Fatalf("got ticket %x, wait %v; want ticket with wait %v", ticket, wait, topicAdLifetime-time.Minute)
+	}
+	// Using it too early returns the same ticket.
+	clock.Run(time.Minute)
+	again, wait, err := tt.register(holder, testTopic, ticket, testAdIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(ticket) || wait != topicAdLifetime-2*time.Minute {
+		t.Fatalf("early use returned wait %v, want same ticket with wait %v", wait, topicAdLifetime-2*time.Minute)
+	}
+
+	// When the ticket is due, a node without ticket takes the free slot.
+	clock.Run(wait)
+	mustRegister(t, tt, nodes[topicQueueLimit+1], nil)
+	// The ticket holder still gets in by evicting the oldest ad.
+	mustRegister(t, tt, holder, ticket)
+	if len(tt.queues[string(testTopic)].ads) != topicQueueLimit || tt.total != topicQueueLimit {
+		t.Fatalf("wrong ad count %d, total %d", len(tt.queues[string(testTopic)].ads), tt.total)
+	}
+	for _, n := range tt.query(testTopic, testQueryIP, topicQueueLimit) {
+		if n.ID() == nodes[2].ID() {
+			t.Fatal("oldest ad not evicted")
+		}
+	}
+
+	// All ads expire after their lifetime.
+	clock.Run(topicAdLifetime)
+	if got := tt.query(testTopic, testQueryIP, topicQueueLimit); len(got) != 0 {
+		t.Fatalf("query returned %d nodes after expiry", len(got))
+	}
+	if len(tt.queues) != 0 || tt.total != 0 {
+		t.Fatalf("table not empty after expiry: %d queues, total %d", len(tt.queues), tt.total)
+	}
+}
+
+func TestTopicTableRegWindow(t *testing.T) {
+	var (
+		clock = new(mclock.Simulated)
+		tt    = newTopicTable(clock)
+		nodes = nodesAtDistance(enode.ID{}, 256, topicQueueLimit+1)
+	)
+	for _, n := range nodes[:topicQueueLimit] {
+		mustRegister(t, tt, n, nil)
+	}
+	holder := nodes[topicQueueLimit]
+	ticket, wait, _ := tt.register(holder, testTopic, nil, testAdIP)
+
+	// Refill the freed queue, then use the ticket after the registration window.
+	clock.Run(wait)
+	for _, n := range nodes[:topicQueueLimit] {
+		mustRegister(t, tt, n, nil)
+	}
+	clock.Run(topicRegWindow + time.Second)
+	newTicket, wait, err := tt.register(holder, testTopic, ticket, testAdIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newTicket == nil || string(newTicket) == string(ticket) {
+		t.Fatal("expired ticket did not yield a new ticket")
+	}
+	if want := topicAdLifetime - topicRegWindow - time.Second; wait != want {
+		t.Fatalf("wrong wait time %v, want %v", wait, want)
+	}
+}
+
+func TestTopicTableLimit(t *testing.T) {
+	var (
+		clock  = new(mclock.Simulated)
+		tt     = newTopicTable(clock)
+		nodes  = nodesAtDistance(enode.ID{}, 256, topicTableLimit/topicQueueLimit+1)
+		topics = make([][]byte, topicQueueLimit)
+	)
+	for i := range topics {
+		topics[i] = []byte{byte(i)}
+	}
+	for _, n := range nodes[:len(nodes)-1] {
+		for _, topic := range topics {
+			if ticket, _, err := tt.register(n, topic, nil, testAdIP); err != nil || ticket != nil {
+				t.Fatalf("registration failed: ticket %x, err %v", ticket, err)
+			}
+		}
+		clock.Run(time.Minute)
+	}
+	if tt.total != topicTableLimit {
+		t.Fatalf("wrong total %d", tt.total)
+	}
+	// A new topic is subject to the table limit.
+	ticket, wait, _ := tt.register(nodes[len(nodes)-1], []byte("new"), nil, testAdIP)
+	if want := topicAdLifetime - time.Duration(len(nodes)-1)*time.Minute; ticket == nil || wait != want {
+		t.Fatalf("got ticket %x, wait %v; want ticket with wait %v", ticket, wait, want)
+	}
+}
+
+func TestTopicTableInvalidTicket(t *testing.T) {
+	var (
+		clock = new(mclock.Simulated)
+		tt    = newTopicTable(clock)
+		nodes = nodesAtDistance(enode.ID{}, 256, topicQueueLimit+2)
+	)
+	for _, n := range nodes[:topicQueueLimit] {
+		mustRegister(t, tt, n, nil)
+	}
+	holder := nodes[topicQueueLimit]
+	ticket, _, _ := tt.register(holder, testTopic, nil, testAdIP)
+
+	tests := []struct {
+		name   string
+		node   *enode.Node
+		topic  []byte
+		ticket []byte
+		ip     net.IP
+	}{
+		{"other node", nodes[topicQueueLimit+1], testTopic, ticket, testAdIP},
+		{"other topic", holder, []byte("other"), ticket, testAdIP},
+		{"other IP", holder, testTopic, ticket, testQueryIP},
+		{"modified", holder, testTopic, append(ticket[:len(ticket)-1:len(ticket)-1], ^ticket[len(ticket)-1]), testAdIP},
+		{"short", holder, testTopic, ticket[:ticketMACSize], testAdIP},
+		{"other issuer", holder, testTopic, newTopicTable(clock).encodeTicket(&topicTicket{Topic: testTopic, ID: holder.ID(), IP: testAdIP}), testAdIP},
+	}
+	for _, test := range tests {
+		if _, _, err := tt.register(test.node, test.topic, test.ticket, test.ip); err != errInvalidTicket {
+			t.Errorf("%s: got error %v, want %v", test.name, err, errInvalidTicket)
+		}
+	}
+}
+
+func mustRegister(t *testing.T, tt *topicTable, n *enode.Node, ticket []byte) {
+	t.Helper()
+	newTicket, wait, err := tt.register(n, testTopic, ticket, testAdIP)
+	if err != nil {
+		t.Fatalf("registration of %v failed: %v", n.ID(), err)
+	}
+	if newTicket != nil {
+		t.Fatalf("registration of %v not confirmed, wait %v", n.ID(), wait)
+	}
+}
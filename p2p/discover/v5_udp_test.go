@@ -0,0 +1,1115 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/internal/testlog"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/discover/v5wire"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// This test checks that incoming PING calls are handled correctly.
+func TestUDPv5_pingHandling(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	test.packetIn(&v5wire.Ping{ReqID: []byte("foo")})
+	test.waitPacketOut(func(p *v5wire.Pong, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if !bytes.Equal(p.ReqID, []byte("foo")) {
+			t.Error("wrong request ID in response:", p.ReqID)
+		}
+		if p.ENRSeq != test.table.self().Seq() {
+			t.Error("wrong ENR sequence number in response:", p.ENRSeq)
+		}
+		if !p.ToIP.Equal(test.remoteaddr.IP) || int(p.ToPort) != test.remoteaddr.Port {
+			t.Errorf("wrong endpoint in response: %v:%d", p.ToIP, p.ToPort)
+		}
+	})
+}
+
+// This test checks that incoming 'unknown' packets trigger the handshake.
+func TestUDPv5_unknownPacket(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	nonce := v5wire.Nonce{1, 2, 3}
+	check := func(p *v5wire.Whoareyou, wantSeq uint64) {
+		t.Helper()
+		if p.Nonce != nonce {
+			t.Error("wrong nonce in WHOAREYOU:", p.Nonce, nonce)
+		}
+		if p.IDNonce == ([16]byte{}) {
+			t.Error("all zero ID nonce")
+		}
+		if p.RecordSeq != wantSeq {
+			t.Errorf("wrong record seq %d in WHOAREYOU, want %d", p.RecordSeq, wantSeq)
+		}
+	}
+
+	// Unknown packet from unknown node.
+	test.packetIn(&v5wire.Unknown{Nonce: nonce})
+	test.waitPacketOut(func(p *v5wire.Whoareyou, addr *net.UDPAddr, _ v5wire.Nonce) {
+		check(p, 0)
+	})
+
+	// Make node known.
+	n := test.getNode(test.remotekey, test.remoteaddr).Node()
+	test.table.addSeenNode(wrapNode(n))
+
+	test.packetIn(&v5wire.Unknown{Nonce: nonce})
+	test.waitPacketOut(func(p *v5wire.Whoareyou, addr *net.UDPAddr, _ v5wire.Nonce) {
+		check(p, n.Seq())
+	})
+}
+
+// This test checks that incoming FINDNODE calls are handled correctly.
+func TestUDPv5_findnodeHandling(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	// Create test nodes and insert them into the table.
+	nodes253 := nodesAtDistance(test.table.self().ID(), 253, 10)
+	nodes249 := nodesAtDistance(test.table.self().ID(), 249, 4)
+	nodes248 := nodesAtDistance(test.table.self().ID(), 248, 10)
+	fillTableLive(test.table, nodes253)
+	fillTableLive(test.table, nodes249)
+	fillTableLive(test.table, nodes248)
+
+	// Requesting with distance zero should return the node's own record.
+	test.packetIn(&v5wire.Findnode{ReqID: []byte{0}, Distances: []uint{0}})
+	test.expectNodes([]byte{0}, 1, []*enode.Node{test.udp.Self()})
+
+	// Requesting with distance > 256 shouldn't crash.
+	test.packetIn(&v5wire.Findnode{ReqID: []byte{1}, Distances: []uint{4234098}})
+	test.expectNodes([]byte{1}, 1, nil)
+
+	// Requesting with empty distance list shouldn't crash either.
+	test.packetIn(&v5wire.Findnode{ReqID: []byte{2}, Distances: []uint{}})
+	test.expectNodes([]byte{2}, 1, nil)
+
+	// This request gets no nodes because the corresponding bucket is empty.
+	test.packetIn(&v5wire.Findnode{ReqID: []byte{3}, Distances: []uint{254}})
+	test.expectNodes([]byte{3}, 1, nil)
+
+	// This request gets all the distance-253 nodes.
+	test.packetIn(&v5wire.Findnode{ReqID: []byte{4}, Distances: []uint{253}})
+	test.expectNodes([]byte{4}, 4, nodes253)
+
+	// This request gets all the distance-249 nodes and some more at 248 because
+	// the bucket at 249 is not full.
+	test.packetIn(&v5wire.Findnode{ReqID: []byte{5}, Distances: []uint{249, 248}})
+	var nodes []*enode.Node
+	nodes = append(nodes, nodes249...)
+	nodes = append(nodes, nodes248...)
+	test.expectNodes([]byte{5}, 5, nodes)
+}
+
+func (test *udpV5Test) expectNodes(wantReqID []byte, wantTotal uint8, wantNodes []*enode.Node) {
+	test.t.Helper()
+
+	nodeSet := make(map[enode.ID]*enr.Record)
+	for _, n := range wantNodes {
+		nodeSet[n.ID()] = n.Record()
+	}
+
+	for {
+		test.waitPacketOut(func(p *v5wire.Nodes, addr *net.UDPAddr, _ v5wire.Nonce) {
+			if !bytes.Equal(p.ReqID, wantReqID) {
+				test.t.Fatalf("wrong request ID %v in response, want %v", p.ReqID, wantReqID)
+			}
+			if len(p.Nodes) > 3 {
+				test.t.Fatalf("too many nodes in response")
+			}
+			if p.Total != wantTotal {
+				test.t.Fatalf("wrong total response count %d, want %d", p.Total, wantTotal)
+			}
+			for _, record := range p.Nodes {
+				n, _ := enode.New(enode.ValidSchemesForTesting, record)
+				want := nodeSet[n.ID()]
+				if want == nil {
+					test.t.Fatalf("unexpected node in response: %v", n)
+				}
+				if !reflect.DeepEqual(record, want) {
+					test.t.Fatalf("wrong record in response: %v", n)
+				}
+				delete(nodeSet, n.ID())
+			}
+		})
+		if len(nodeSet) == 0 {
+			return
+		}
+	}
+}
+
+// This test checks that outgoing PING calls work.
+func TestUDPv5_pingCall(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	remote := test.getNode(test.remotekey, test.remoteaddr).Node()
+	done := make(chan error, 1)
+
+	// This ping times out.
+	go func() {
+		_, err := test.udp.ping(remote)
+		done <- err
+	}()
+	test.waitPacketOut(func(p *v5wire.Ping, addr *net.UDPAddr, _ v5wire.Nonce) {})
+	if err := <-done; err != errTimeout {
+		t.Fatalf("want errTimeout, got %q", err)
+	}
+
+	// This ping works.
+	go func() {
+		_, err := test.udp.ping(remote)
+		done <- err
+	}()
+	test.waitPacketOut(func(p *v5wire.Ping, addr *net.UDPAddr, _ v5wire.Nonce) {
+		test.packetInFrom(test.remotekey, test.remoteaddr, &v5wire.Pong{ReqID: p.ReqID})
+	})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// This ping gets a reply from the wrong endpoint.
+	go func() {
+		_, err := test.udp.ping(remote)
+		done <- err
+	}()
+	test.waitPacketOut(func(p *v5wire.Ping, addr *net.UDPAddr, _ v5wire.Nonce) {
+		wrongAddr := &net.UDPAddr{IP: net.IP{33, 44, 55, 22}, Port: 10101}
+		test.packetInFrom(test.remotekey, wrongAddr, &v5wire.Pong{ReqID: p.ReqID})
+	})
+	if err := <-done; err != errTimeout {
+		t.Fatalf("want errTimeout for reply from wrong IP, got %q", err)
+	}
+}
+
+// This test checks that outgoing FINDNODE calls work and multiple NODES
+// replies are aggregated.
+func TestUDPv5_findnodeCall(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	// Launch the request:
+	var (
+		distances = []uint{230}
+		remote    = test.getNode(test.remotekey, test.remoteaddr).Node()
+		nodes     = nodesAtDistance(remote.ID(), int(distances[0]), 8)
+		done      = make(chan error, 1)
+		response  []*enode.Node
+	)
+	go func() {
+		var err error
+		response, err = test.udp.findnode(remote, distances)
+		done <- err
+	}()
+
+	// Serve the responses:
+	test.waitPacketOut(func(p *v5wire.Findnode, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if !reflect.DeepEqual(p.Distances, distances) {
+			t.Fatalf("wrong distances in request: %v", p.Distances)
+		}
+		test.packetIn(&v5wire.Nodes{
+			ReqID: p.ReqID,
+			Total: 2,
+			Nodes: nodesToRecords(nodes[:4]),
+		})
+		test.packetIn(&v5wire.Nodes{
+			ReqID: p.ReqID,
+			Total: 2,
+			Nodes: nodesToRecords(nodes[4:]),
+		})
+	})
+
+	// Check results:
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(response, nodes) {
+		t.Fatalf("wrong nodes in response")
+	}
+}
+
+// This test checks that pending calls are re-sent when a handshake happens.
+func TestUDPv5_callResend(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	remote := test.getNode(test.remotekey, test.remoteaddr).Node()
+	done := make(chan error, 2)
+	go func() {
+		_, err := test.udp.ping(remote)
+		done <- err
+	}()
+	go func() {
+		_, err := test.udp.ping(remote)
+		done <- err
+	}()
+
+	// Ping answered by WHOAREYOU.
+	test.waitPacketOut(func(p *v5wire.Ping, addr *net.UDPAddr, nonce v5wire.Nonce) {
+		test.packetIn(&v5wire.Whoareyou{Nonce: nonce})
+	})
+	// Ping should be re-sent.
+	test.waitPacketOut(func(p *v5wire.Ping, addr *net.UDPAddr, _ v5wire.Nonce) {
+		test.packetIn(&v5wire.Pong{ReqID: p.ReqID})
+	})
+	// Answer the other ping.
+	test.waitPacketOut(func(p *v5wire.Ping, addr *net.UDPAddr, _ v5wire.Nonce) {
+		test.packetIn(&v5wire.Pong{ReqID: p.ReqID})
+	})
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected ping error: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected ping error: %v", err)
+	}
+}
+
+// This test ensures we don't allow multiple rounds of WHOAREYOU for a single call.
+func TestUDPv5_multipleHandshakeRounds(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	remote := test.getNode(test.remotekey, test.remoteaddr).Node()
+	done := make(chan error, 1)
+	go func() {
+		_, err := test.udp.ping(remote)
+		done <- err
+	}()
+
+	// Ping answered by WHOAREYOU.
+	test.waitPacketOut(func(p *v5wire.Ping, addr *net.UDPAddr, nonce v5wire.Nonce) {
+		test.packetIn(&v5wire.Whoareyou{Nonce: nonce})
+	})
+	// Ping answered by WHOAREYOU again.
+	test.waitPacketOut(func(p *v5wire.Ping, addr *net.UDPAddr, nonce v5wire.Nonce) {
+		test.packetIn(&v5wire.Whoareyou{Nonce: nonce})
+	})
+	if err := <-done; err != errTimeout {
+		t.Fatalf("unexpected ping error: %q", err)
+	}
+}
+
+// This test checks that calls with n replies may take up to n * respTimeout.
+func TestUDPv5_callTimeoutReset(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	// Launch the request:
+	var (
+		distance = uint(230)
+		remote   = test.getNode(test.remotekey, test.remoteaddr).Node()
+		nodes    = nodesAtDistance(remote.ID(), int(distance), 8)
+		done     = make(chan error, 1)
+	)
+	go func() {
+		_, err := test.udp.findnode(remote, []uint{distance})
+		done <- err
+	}()
+
+	// Serve two responses, slowly.
+	test.waitPacketOut(func(p *v5wire.Findnode, addr *net.UDPAddr, _ v5wire.Nonce) {
+		time.Sleep(respTimeoutV5 - 50*time.Millisecond)
+		test.packetIn(&v5wire.Nodes{
+			ReqID: p.ReqID,
+			Total: 2,
+			Nodes: nodesToRecords(nodes[:4]),
+		})
+
+		time.Sleep(respTimeoutV5 - 50*time.Millisecond)
+		test.packetIn(&v5wire.Nodes{
+			ReqID: p.ReqID,
+			Total: 2,
+			Nodes: nodesToRecords(nodes[4:]),
+		})
+	})
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %q", err)
+	}
+}
+
+// This test checks that TALKREQ calls the registered handler function.
+func TestUDPv5_talkHandling(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	var recvMessage []byte
+	test.udp.RegisterTalkHandler("test", func(id enode.ID, addr *net.UDPAddr, message []byte) []byte {
+		recvMessage = message
+		return []byte("test response")
+	})
+
+	// Successful case:
+	test.packetIn(&v5wire.TalkRequest{
+		ReqID:    []byte("foo"),
+		Protocol: "test",
+		Message:  []byte("test request"),
+	})
+	test.waitPacketOut(func(p *v5wire.TalkResponse, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if !bytes.Equal(p.ReqID, []byte("foo")) {
+			t.Error("wrong request ID in response:", p.ReqID)
+		}
+		if string(p.Message) != "test response" {
+			t.Errorf("wrong talk response message: %q", p.Message)
+		}
+		if string(recvMessage) != "test request" {
+			t.Errorf("wrong message received in handler: %q", recvMessage)
+		}
+	})
+
+	// Check that empty response is returned for unregistered protocols.
+	recvMessage = nil
+	test.packetIn(&v5wire.TalkRequest{
+		ReqID:    []byte("2"),
+		Protocol: "wrong",
+		Message:  []byte("test request"),
+	})
+	test.waitPacketOut(func(p *v5wire.TalkResponse, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if !bytes.Equal(p.ReqID, []byte("2")) {
+			t.Error("wrong request ID in response:", p.ReqID)
+		}
+		if string(p.Message) != "" {
+			t.Errorf("wrong talk response message: %q", p.Message)
+		}
+		if recvMessage != nil {
+			t.Errorf("handler was called for wrong protocol")
+		}
+	})
+}
+
+// This test checks that outgoing TALKREQ calls work.
+func TestUDPv5_talkRequest(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	remote := test.getNode(test.remotekey, test.remoteaddr).Node()
+	done := make(chan error, 1)
+
+	// This request times out.
+	go func() {
+		_, err := test.udp.TalkRequest(remote, "test", []byte("test request"))
+		done <- err
+	}()
+	test.waitPacketOut(func(p *v5wire.TalkRequest, addr *net.UDPAddr, _ v5wire.Nonce) {})
+	if err := <-done; err != errTimeout {
+		t.Fatalf("want errTimeout, got %q", err)
+	}
+
+	// This request works.
+	go func() {
+		_, err := test.udp.TalkRequest(remote, "test", []byte("test request"))
+		done <- err
+	}()
+	test.waitPacketOut(func(p *v5wire.TalkRequest, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if p.Protocol != "test" {
+			t.Errorf("wrong protocol ID in talk request: %q", p.Protocol)
+		}
+		if string(p.Message) != "test request" {
+			t.Errorf("wrong message talk request: %q", p.Message)
+		}
+		test.packetInFrom(test.remotekey, test.remoteaddr, &v5wire.TalkResponse{
+			ReqID:   p.ReqID,
+			Message: []byte("test response"),
+		})
+	})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// This test checks that incoming REGTOPIC and TOPICQUERY calls are handled correctly.
+func TestUDPv5_topicHandling(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	topic := []byte("topic")
+	remote := test.getNode(test.remotekey, test.remoteaddr).Node()
+
+	// Registration on an empty queue is confirmed immediately.
+	test.packetIn(&v5wire.Regtopic{ReqID: []byte("1"), Topic: topic, ENR: remote.Record()})
+	test.waitPacketOut(func(p *v5wire.Regconfirmation, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if !bytes.Equal(p.ReqID, []byte("1")) {
+			t.Error("wrong request ID in response:", p.ReqID)
+		}
+		if !bytes.Equal(p.Topic, topic) {
+			t.Errorf("wrong topic in response: %q", p.Topic)
+		}
+	})
+
+	// The registered node is returned by TOPICQUERY.
+	test.packetIn(&v5wire.TopicQuery{ReqID: []byte("2"), Topic: topic})
+	test.expectNodes([]byte("2"), 1, []*enode.Node{remote})
+	test.packetIn(&v5wire.TopicQuery{ReqID: []byte("3"), Topic: []byte("other")})
+	test.expectNodes([]byte("3"), 1, nil)
+
+	// Fill the queue. The next registration gets a ticket.
+	for i := 1; i < topicQueueLimit; i++ {
+		key := newkey()
+		addr := &net.UDPAddr{IP: net.IP{10, 0, 2, byte(i)}, Port: 30303}
+		n := test.getNode(key, addr).Node()
+		test.packetInFrom(key, addr, &v5wire.Regtopic{ReqID: []byte("4"), Topic: topic, ENR: n.Record()})
+		test.waitPacketOut(func(p *v5wire.Regconfirmation, addr *net.UDPAddr, _ v5wire.Nonce) {})
+	}
+	var (
+		key    = newkey()
+		addr   = &net.UDPAddr{IP: net.IP{10, 0, 3, 1}, Port: 30303}
+		n      = test.getNode(key, addr).Node()
+		ticket []byte
+	)
+	test.packetInFrom(key, addr, &v5wire.Regtopic{ReqID: []byte("5"), Topic: topic, ENR: n.Record()})
+	test.waitPacketOut(func(p *v5wire.Ticket, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if !bytes.Equal(p.ReqID, []byte("5")) {
+			t.Error("wrong request ID in response:", p.ReqID)
+		}
+		if time.Duration(p.WaitTime)*time.Second != topicAdLifetime {
+			t.Errorf("wrong wait time %d", p.WaitTime)
+		}
+		ticket = p.Ticket
+	})
+
+	// Using the ticket when it's due places the ad.
+	test.clock.Run(topicAdLifetime)
+	test.packetInFrom(key, addr, &v5wire.Regtopic{ReqID: []byte("6"), Topic: topic, ENR: n.Record(), Ticket: ticket})
+	test.waitPacketOut(func(p *v5wire.Regconfirmation, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if !bytes.Equal(p.ReqID, []byte("6")) {
+			t.Error("wrong request ID in response:", p.ReqID)
+		}
+	})
+	test.packetIn(&v5wire.TopicQuery{ReqID: []byte("7"), Topic: topic})
+	test.expectNodes([]byte("7"), 1, []*enode.Node{n})
+
+	// Registrations with a foreign record are ignored.
+	test.packetIn(&v5wire.Regtopic{ReqID: []byte("8"), Topic: topic, ENR: n.Record()})
+}
+
+// This test checks that outgoing REGTOPIC calls wait for their ticket.
+func TestUDPv5_registerTopic(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	var (
+		topic  = []byte("topic")
+		ticket = []byte("ticket")
+		remote = test.getNode(test.remotekey, test.remoteaddr).Node()
+		done   = make(chan error, 1)
+	)
+	go func() {
+		done <- test.udp.RegisterTopic(remote, topic)
+	}()
+	test.waitPacketOut(func(p *v5wire.Regtopic, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if !bytes.Equal(p.Topic, topic) {
+			t.Errorf("wrong topic in request: %q", p.Topic)
+		}
+		if p.ENR.Seq() != test.udp.Self().Seq() || len(p.Ticket) != 0 {
+			t.Errorf("wrong record or ticket in request")
+		}
+		test.packetIn(&v5wire.Ticket{ReqID: p.ReqID, Ticket: ticket, WaitTime: 5})
+	})
+	test.clock.WaitForTimers(1)
+	test.clock.Run(5 * time.Second)
+	test.waitPacketOut(func(p *v5wire.Regtopic, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if !bytes.Equal(p.Ticket, ticket) {
+			t.Errorf("wrong ticket in request: %q", p.Ticket)
+		}
+		test.packetIn(&v5wire.Regconfirmation{ReqID: p.ReqID, Topic: topic})
+	})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// This test checks that lookup works.
+func TestUDPv5_lookup(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+
+	// Lookup on empty table returns no nodes.
+	if results := test.udp.Lookup(lookupTestnet.target.id()); len(results) > 0 {
+		t.Fatalf("lookup on empty table returned %d results: %#v", len(results), results)
+	}
+
+	// Ensure the tester knows all nodes in lookupTestnet by IP.
+	for d, nn := range lookupTestnet.dists {
+		for i, key := range nn {
+			n := lookupTestnet.node(d, i)
+			test.getNode(key, &net.UDPAddr{IP: n.IP(), Port: n.UDP()})
+		}
+	}
+
+	// Seed table with initial node.
+	initialNode := lookupTestnet.node(256, 0)
+	fillTable(test.table, []*node{wrapNode(initialNode)})
+
+	// Start the lookup.
+	resultC := make(chan []*enode.Node, 1)
+	go func() {
+		resultC <- test.udp.Lookup(lookupTestnet.target.id())
+		test.close()
+	}()
+
+	// Answer lookup packets.
+	asked := make(map[enode.ID]bool)
+	for done := false; !done; {
+		done = test.waitPacketOut(func(p v5wire.Packet, to *net.UDPAddr, _ v5wire.Nonce) {
+			recipient, key := lookupTestnet.nodeByAddr(to)
+			switch p := p.(type) {
+			case *v5wire.Ping:
+				test.packetInFrom(key, to, &v5wire.Pong{ReqID: p.ReqID})
+			case *v5wire.Findnode:
+				if asked[recipient.ID()] {
+					t.Error("Asked node", recipient.ID(), "twice")
+				}
+				asked[recipient.ID()] = true
+				nodes := test.neighborsAtDistances(recipient, p.Distances, 16)
+				t.Logf("Got FINDNODE for %v, returning %d nodes", p.Distances, len(nodes))
+				for _, resp := range packNodes(p.ReqID, nodes) {
+					test.packetInFrom(key, to, resp)
+				}
+			}
+		})
+	}
+
+	// Verify result nodes.
+	results := <-resultC
+	want := lookupTestnet.closest(bucketSize)
+	if len(results) != len(want) {
+		t.Fatalf("wrong number of results: got %d, want %d", len(results), len(want))
+	}
+	for i := range results {
+		if results[i].ID() != want[i].ID() {
+			t.Errorf("result %d mismatch: got %v, want %v", i, results[i].ID(), want[i].ID())
+		}
+	}
+}
+
+// This test checks the local node can be utilised to set key-values.
+func TestUDPv5_LocalNode(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	localNd := test.udp.LocalNode()
+	localNd.Set(enr.WithEntry("testing", uint64(1)))
+	var val uint64
+	if err := test.udp.Self().Load(enr.WithEntry("testing", &val)); err != nil {
+		t.Fatalf("could not load node entry: %v", err)
+	}
+	if val != 1 {
+		t.Errorf("wrong value for entry: got %d, want 1", val)
+	}
+}
+
+func TestUDPv5_lookupDistances(t *testing.T) {
+	var (
+		lnID  = enode.ID{}
+		tests = []struct {
+			dist uint
+			want []uint
+		}{
+			{1, []uint{1, 2, 3}},
+			{255, []uint{255, 256, 254}},
+			{256, []uint{256, 255, 254}},
+		}
+	)
+	for _, test := range tests {
+		target := idAtDistance(lnID, int(test.dist))
+		if have := lookupDistances(target, lnID); !reflect.DeepEqual(have, test.want) {
+			t.Errorf("wrong distances for %d: have %v, want %v", test.dist, have, test.want)
+		}
+	}
+}
+
+// This test checks that two nodes complete the handshake and can talk to each other
+// when connected through an in-memory network.
+func TestUDPv5_handshakeTwoNodes(t *testing.T) {
+	t.Parallel()
+	mn := newMemNet()
+	nodeA := startMemV5(t, mn, net.IP{10, 0, 0, 1})
+	defer nodeA.Close()
+	nodeB := startMemV5(t, mn, net.IP{10, 0, 0, 2})
+	defer nodeB.Close()
+
+	nodeB.RegisterTalkHandler("echo", func(id enode.ID, addr *net.UDPAddr, msg []byte) []byte {
+		return msg
+	})
+
+	// The first call performs the handshake.
+	if err := nodeA.Ping(nodeB.Self()); err != nil {
+		t.Fatal("ping failed:", err)
+	}
+	// B should have learned about A during the handshake.
+	if n := nodeB.tab.getNode(nodeA.Self().ID()); n == nil {
+		t.Error("node A not in table of node B after handshake")
+	}
+	// Further calls use the established session.
+	resp, err := nodeA.TalkRequest(nodeB.Self(), "echo", []byte("hello"))
+	if err != nil {
+		t.Fatal("talk request failed:", err)
+	}
+	if string(resp) != "hello" {
+		t.Errorf("wrong talk response %q", resp)
+	}
+	// B can use the same session in the other direction.
+	n, err := nodeB.RequestENR(nodeA.Self())
+	if err != nil {
+		t.Fatal("ENR request failed:", err)
+	}
+	if n.ID() != nodeA.Self().ID() || n.Seq() != nodeA.Self().Seq() {
+		t.Errorf("wrong record in response: %v", n)
+	}
+}
+
+// This test checks that topic ads can be placed and found through a third node.
+func TestUDPv5_topicTwoNodes(t *testing.T) {
+	t.Parallel()
+	mn := newMemNet()
+	nodeA := startMemV5(t, mn, net.IP{10, 0, 0, 1})
+	defer nodeA.Close()
+	nodeB := startMemV5(t, mn, net.IP{10, 0, 0, 2})
+	defer nodeB.Close()
+	nodeC := startMemV5(t, mn, net.IP{10, 0, 0, 3})
+	defer nodeC.Close()
+
+	topic := []byte("topic")
+	if err := nodeA.RegisterTopic(nodeB.Self(), topic); err != nil {
+		t.Fatal("topic registration failed:", err)
+	}
+	nodes, err := nodeC.TopicQuery(nodeB.Self(), topic)
+	if err != nil {
+		t.Fatal("topic query failed:", err)
+	}
+	if len(nodes) != 1 || nodes[0].ID() != nodeA.Self().ID() {
+		t.Fatalf("wrong topic query result %v", nodes)
+	}
+}
+
+// This test checks that discv4 and discv5 can share a single socket. Packets
+// that discv4 can't handle are passed on to discv5.
+func TestUDPv5_sharedSocket(t *testing.T) {
+	t.Parallel()
+	mn := newMemNet()
+	nodeA4, nodeA5 := startMemShared(t, mn, net.IP{10, 0, 0, 1})
+	defer nodeA4.Close()
+	defer nodeA5.Close()
+	nodeB4, nodeB5 := startMemShared(t, mn, net.IP{10, 0, 0, 2})
+	defer nodeB4.Close()
+	defer nodeB5.Close()
+
+	if _, err := nodeA4.ping(nodeB4.Self()); err != nil {
+		t.Fatal("v4 ping failed:", err)
+	}
+	if err := nodeA5.Ping(nodeB5.Self()); err != nil {
+		t.Fatal("v5 ping failed:", err)
+	}
+	if _, err := nodeB4.ping(nodeA4.Self()); err != nil {
+		t.Fatal("v4 ping failed:", err)
+	}
+}
+
+// udpV5Test is the framework for all tests above.
+// It runs the UDPv5 transport on a virtual socket and allows testing outgoing packets.
+type udpV5Test struct {
+	t                   *testing.T
+	pipe                *dgramPipe
+	table               *Table
+	db                  *enode.DB
+	udp                 *UDPv5
+	clock               *mclock.Simulated
+	localkey, remotekey *ecdsa.PrivateKey
+	remoteaddr          *net.UDPAddr
+	nodesByID           map[enode.ID]*enode.LocalNode
+	nodesByIP           map[string]*enode.LocalNode
+}
+
+// testCodec is the packet encoding used by protocol tests. This codec does not perform encryption.
+type testCodec struct {
+	test *udpV5Test
+	id   enode.ID
+	ctr  uint64
+}
+
+type testCodecFrame struct {
+	NodeID  enode.ID
+	AuthTag v5wire.Nonce
+	Ptype   byte
+	Packet  rlp.RawValue
+}
+
+func (c *testCodec) Encode(toID enode.ID, addr string, p v5wire.Packet, _ *v5wire.Whoareyou) ([]byte, v5wire.Nonce, error) {
+	c.ctr++
+	var authTag v5wire.Nonce
+	binary.BigEndian.PutUint64(authTag[:], c.ctr)
+
+	penc, _ := rlp.EncodeToBytes(p)
+	frame, err := rlp.EncodeToBytes(testCodecFrame{c.id, authTag, p.Kind(), penc})
+	return frame, authTag, err
+}
+
+func (c *testCodec) Decode(input []byte, addr string) (enode.ID, *enode.Node, v5wire.Packet, error) {
+	frame, p, err := c.decodeFrame(input)
+	if err != nil {
+		return enode.ID{}, nil, nil, err
+	}
+	return frame.NodeID, nil, p, nil
+}
+
+func (c *testCodec) decodeFrame(input []byte) (frame testCodecFrame, p v5wire.Packet, err error) {
+	if err = rlp.DecodeBytes(input, &frame); err != nil {
+		return frame, nil, fmt.Errorf("invalid frame: %v", err)
+	}
+	switch frame.Ptype {
+	case v5wire.UnknownPacket:
+		dec := new(v5wire.Unknown)
+		err = rlp.DecodeBytes(frame.Packet, &dec)
+		p = dec
+	case v5wire.WhoareyouPacket:
+		dec := new(v5wire.Whoareyou)
+		err = rlp.DecodeBytes(frame.Packet, &dec)
+		p = dec
+	default:
+		p, err = v5wire.DecodeMessage(frame.Ptype, frame.Packet)
+	}
+	return frame, p, err
+}
+
+func newUDPV5Test(t *testing.T) *udpV5Test {
+	test := &udpV5Test{
+		t:          t,
+		pipe:       newpipe(),
+		localkey:   newkey(),
+		remotekey:  newkey(),
+		remoteaddr: &net.UDPAddr{IP: net.IP{10, 0, 1, 99}, Port: 30303},
+		clock:      new(mclock.Simulated),
+		nodesByID:  make(map[enode.ID]*enode.LocalNode),
+		nodesByIP:  make(map[string]*enode.LocalNode),
+	}
+	test.db, _ = enode.OpenDB("")
+	ln := enode.NewLocalNode(test.db, test.localkey)
+	ln.SetStaticIP(net.IP{10, 0, 0, 1})
+	ln.SetFallbackUDP(30303)
+	test.udp, _ = newUDPv5(test.pipe, ln, Config{
+		PrivateKey:   test.localkey,
+		Log:          testlog.Logger(t, log.LvlTrace),
+		ValidSchemes: enode.ValidSchemesForTesting,
+		Clock:        test.clock,
+	})
+	test.udp.codec = &testCodec{test: test, id: ln.ID()}
+	test.table = test.udp.tab
+	test.nodesByID[ln.ID()] = ln
+	test.udp.start()
+	// Wait for initial refresh so the table doesn't send unexpected findnode.
+	<-test.table.initDone
+	return test
+}
+
+// handles a packet as if it had been sent to the transport.
+func (test *udpV5Test) packetIn(packet v5wire.Packet) {
+	test.t.Helper()
+	test.packetInFrom(test.remotekey, test.remoteaddr, packet)
+}
+
+// handles a packet as if it had been sent to the transport by the key/endpoint.
+func (test *udpV5Test) packetInFrom(key *ecdsa.PrivateKey, addr *net.UDPAddr, packet v5wire.Packet) {
+	test.t.Helper()
+
+	ln := test.getNode(key, addr)
+	codec := &testCodec{test: test, id: ln.ID()}
+	enc, _, err := codec.Encode(test.udp.Self().ID(), addr.String(), packet, nil)
+	if err != nil {
+		test.t.Errorf("%s encode error: %v", packet.Name(), err)
+	}
+	if test.udp.dispatchReadPacket(addr, enc) {
+		<-test.udp.readNextCh // unblock UDPv5.dispatch
+	}
+}
+
+// getNode ensures the test knows about a node at the given endpoint.
+func (test *udpV5Test) getNode(key *ecdsa.PrivateKey, addr *net.UDPAddr) *enode.LocalNode {
+	id := encodePubkey(&key.PublicKey).id()
+	ln := test.nodesByID[id]
+	if ln == nil {
+		db, _ := enode.OpenDB("")
+		ln = enode.NewLocalNode(db, key)
+		ln.SetStaticIP(addr.IP)
+		ln.Set(enr.UDP(addr.Port))
+		test.nodesByID[id] = ln
+	}
+	test.nodesByIP[string(addr.IP)] = ln
+	return ln
+}
+
+// neighborsAtDistances returns signed records of lookupTestnet nodes at the
+// given distances from base.
+func (test *udpV5Test) neighborsAtDistances(base *enode.Node, distances []uint, max int) []*enode.Node {
+	var result []*enode.Node
+	for d := range lookupTestnet.dists {
+		for i := range lookupTestnet.dists[d] {
+			n := lookupTestnet.node(d, i)
+			if !containsUint(uint(enode.LogDist(base.ID(), n.ID())), distances) {
+				continue
+			}
+			result = append(result, test.nodesByIP[string(n.IP())].Node())
+			if len(result) >= max {
+				return result
+			}
+		}
+	}
+	return result
+}
+
+// waitPacketOut waits for the next output packet and handles it using the given 'validate'
+// function. The function must be of type func (X, *net.UDPAddr, v5wire.Nonce) where X is
+// assignable to v5wire.Packet.
+func (test *udpV5Test) waitPacketOut(validate interface{}) (closed bool) {
+	test.t.Helper()
+
+	fn := reflect.ValueOf(validate)
+	exptype := fn.Type().In(0)
+
+	dgram, ok := test.pipe.receive()
+	if !ok {
+		return true
+	}
+	ln := test.nodesByIP[string(dgram.to.IP)]
+	if ln == nil {
+		test.t.Fatalf("attempt to send to non-existing node %v", &dgram.to)
+		return false
+	}
+	codec := &testCodec{test: test, id: ln.ID()}
+	frame, p, err := codec.decodeFrame(dgram.data)
+	if err != nil {
+		test.t.Errorf("sent packet decode error: %v", err)
+		return false
+	}
+	if !reflect.TypeOf(p).AssignableTo(exptype) {
+		test.t.Errorf("sent packet type mismatch, got: %v, want: %v", reflect.TypeOf(p), exptype)
+		return false
+	}
+	fn.Call([]reflect.Value{reflect.ValueOf(p), reflect.ValueOf(&dgram.to), reflect.ValueOf(frame.AuthTag)})
+	return false
+}
+
+func (test *udpV5Test) close() {
+	test.t.Helper()
+
+	test.udp.Close()
+	test.db.Close()
+	for id, n := range test.nodesByID {
+		if id != test.udp.Self().ID() {
+			n.Database().Close()
+		}
+	}
+	if len(test.pipe.queue) != 0 {
+		test.t.Fatalf("%d unmatched UDP packets in queue", len(test.pipe.queue))
+	}
+}
+
+// nodesAtDistance creates n nodes for which enode.LogDist(base, node.ID()) == ld.
+func nodesAtDistance(base enode.ID, ld int, n int) []*enode.Node {
+	results := make([]*enode.Node, n)
+	for i := range results {
+		var r enr.Record
+		r.Set(enr.IP(intIP(i + 1)))
+		r.Set(enr.UDP(30303))
+		results[i] = enode.SignNull(&r, idAtDistance(base, ld))
+	}
+	return results
+}
+
+// fillTableLive adds the given nodes to the table and marks them as live.
+func fillTableLive(tab *Table, nodes []*enode.Node) {
+	for _, n := range nodes {
+		wn := wrapNode(n)
+		wn.livenessChecks = 1
+		tab.addSeenNode(wn)
+	}
+}
+
+func nodesToRecords(nodes []*enode.Node) []*enr.Record {
+	records := make([]*enr.Record, len(nodes))
+	for i := range nodes {
+		records[i] = nodes[i].Record()
+	}
+	return records
+}
+
+// startMemV5 starts a UDPv5 transport on the in-memory network.
+func startMemV5(t *testing.T, mn *memNet, ip net.IP) *UDPv5 {
+	key := newkey()
+	ln := newMemLocalNode(key, ip)
+	udp, err := ListenV5(mn.listen(&net.UDPAddr{IP: ip, Port: 30303}), ln, Config{
+		PrivateKey: key,
+		Log:        testlog.Logger(t, log.LvlTrace).New("node", ln.ID().TerminalString()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return udp
+}
+
+// startMemShared starts discv4 and discv5 on a single socket of the in-memory network.
+func startMemShared(t *testing.T, mn *memNet, ip net.IP) (*UDPv4, *UDPv5) {
+	var (
+		key       = newkey()
+		ln        = newMemLocalNode(key, ip)
+		logger    = testlog.Logger(t, log.LvlTrace).New("node", ln.ID().TerminalString())
+		conn      = mn.listen(&net.UDPAddr{IP: ip, Port: 30303})
+		unhandled = make(chan ReadPacket, 10)
+	)
+	udp4, err := ListenV4(conn, ln, Config{PrivateKey: key, Unhandled: unhandled, Log: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := &sharedConn{memConn: conn, unhandled: unhandled, closing: make(chan struct{})}
+	udp5, err := ListenV5(shared, ln, Config{PrivateKey: key, Log: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return udp4, udp5
+}
+
+func newMemLocalNode(key *ecdsa.PrivateKey, ip net.IP) *enode.LocalNode {
+	db, _ := enode.OpenDB("")
+	ln := enode.NewLocalNode(db, key)
+	ln.SetStaticIP(ip)
+	ln.SetFallbackUDP(30303)
+	return ln
+}
+
+// memNet is an in-memory network of UDP sockets.
+type memNet struct {
+	mu    sync.Mutex
+	conns map[string]*memConn
+}
+
+// memConn is a UDP socket on a memNet.
+type memConn struct {
+	net       *memNet
+	addr      *net.UDPAddr
+	in        chan ReadPacket
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+func newMemNet() *memNet {
+	return &memNet{conns: make(map[string]*memConn)}
+}
+
+// listen creates a socket with the given address.
+func (mn *memNet) listen(addr *net.UDPAddr) *memConn {
+	c := &memConn{
+		net:     mn,
+		addr:    addr,
+		in:      make(chan ReadPacket, 100),
+		closing: make(chan struct{}),
+	}
+	mn.mu.Lock()
+	mn.conns[addr.String()] = c
+	mn.mu.Unlock()
+	return c
+}
+
+// WriteToUDP delivers a datagram to the socket listening on 'to'.
+// Datagrams to unknown addresses are dropped, just like on a real network.
+func (c *memConn) WriteToUDP(b []byte, to *net.UDPAddr) (int, error) {
+	c.net.mu.Lock()
+	dst := c.net.conns[to.String()]
+	c.net.mu.Unlock()
+	if dst == nil {
+		return len(b), nil
+	}
+	data := make([]byte, len(b))
+	copy(data, b)
+	select {
+	case dst.in <- ReadPacket{data, c.addr}:
+	default:
+	}
+	return len(b), nil
+}
+
+func (c *memConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	select {
+	case p := <-c.in:
+		return copy(b, p.Data), p.Addr, nil
+	case <-c.closing:
+		return 0, nil, io.EOF
+	}
+}
+
+func (c *memConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closing) })
+	return nil
+}
+
+func (c *memConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+// sharedConn reads the packets which discv4 didn't handle and writes to
+// the underlying socket.
+type sharedConn struct {
+	*memConn
+	unhandled <-chan ReadPacket
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *sharedConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	select {
+	case p, ok := <-s.unhandled:
+		if !ok {
+			return 0, nil, io.EOF
+		}
+		return copy(b, p.Data), p.Addr, nil
+	case <-s.closing:
+		return 0, nil, io.EOF
+	}
+}
+
+func (s *sharedConn) Close() error {
+	s.closeOnce.Do(func() { close(s.closing) })
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package discv5
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// lookupInterval is the minimum amount of time between random lookups
+// performed by RandomNodes iterators.
+const lookupInterval = 4 * time.Second
+
+// RandomNodes returns an iterator which finds random nodes in the network.
+// Nodes are found by performing lookups for random targets and are returned
+// in enode format.
+func (net *Network) RandomNodes() enode.Iterator {
+	return &lookupIterator{net: net, closing: make(chan struct{})}
+}
+
+// lookupIterator performs random lookups and iterates over their results.
+type lookupIterator struct {
+	net        *Network
+	buffer     []*enode.Node
+	lastLookup time.Time
+	closing    chan struct{}
+	closeOnce  sync.Once
+}
+
+// Next moves to the next node.
+func (it *lookupIterator) Next() bool {
+	select {
+	case <-it.closing:
+		it.buffer = nil
+		return false
+	default:
+	}
+	if len(it.buffer) > 0 {
+		it.buffer = it.buffer[1:]
+	}
+	for len(it.buffer) == 0 {
+		if !it.waitLookup() {
+			return false
+		}
+		var target NodeID
+		rand.Read(target[:])
+		it.buffer = convertNodes(it.net.Lookup(target))
+		it.lastLookup = time.Now()
+	}
+	return true
+}
+
+// waitLookup blocks until the next lookup may be performed. It returns
+// false if the iterator or the network was closed.
+func (it *lookupIterator) waitLookup() bool {
+	timer := time.NewTimer(time.Until(it.lastLookup.Add(lookupInterval)))
+	defer timer.Stop()
+
+	select {
+	case <-it.closing:
+		return false
+	case <-it.net.closed:
+		return false
+	case <-timer.C:
+	}
+	select {
+	case <-it.closing:
+		return false
+	case <-it.net.closed:
+		return false
+	default:
+		return true
+	}
+}
+
+// Node returns the current node.
+func (it *lookupIterator) Node() *enode.Node {
+	if len(it.buffer) == 0 {
+		return nil
+	}
+	return it.buffer[0]
+}
+
+// Close ends the iterator.
+func (it *lookupIterator) Close() {
+	it.closeOnce.Do(func() { close(it.closing) })
+}
+
+// convertNodes converts lookup results to enode.Node, skipping nodes
+// without an endpoint or with an invalid public key.
+func convertNodes(nodes []*Node) []*enode.Node {
+	result := make([]*enode.Node, 0, len(nodes))
+	for _, n := range nodes {
+		if n.Incomplete() {
+			continue
+		}
+		key, err := n.ID.Pubkey()
+		if err != nil {
+			continue
+		}
+		result = append(result, enode.NewV4(key, n.IP, int(n.TCP), int(n.UDP)))
+	}
+	return result
+}
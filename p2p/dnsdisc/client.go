@@ -0,0 +1,335 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package dnsdisc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// Client discovers nodes by querying DNS servers.
+type Client struct {
+	cfg     Config
+	clock   mclock.Clock
+	entries *lru.Cache
+}
+
+// Config holds configuration options for the client.
+type Config struct {
+	Timeout         time.Duration      // timeout used for DNS lookups (default 5s)
+	RecheckInterval time.Duration      // time between tree root update checks (default 30min)
+	RetryDelay      time.Duration      // delay before a failing tree is queried again (default 10s)
+	CacheLimit      int                // maximum number of cached records (default 1000)
+	ValidSchemes    enr.IdentityScheme // acceptable ENR identity schemes (default enode.ValidSchemes)
+	Resolver        Resolver           // the DNS resolver to use (defaults to system DNS)
+	Logger          log.Logger         // destination of client log messages (defaults to root logger)
+}
+
+// Resolver is a DNS resolver that can query TXT records.
+type Resolver interface {
+	LookupTXT(ctx context.Context, domain string) ([]string, error)
+}
+
+func (cfg Config) withDefaults() Config {
+	const (
+		defaultTimeout = 5 * time.Second
+		defaultRecheck = 30 * time.Minute
+		defaultRetry   = 10 * time.Second
+		defaultCache   = 1000
+	)
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.RecheckInterval == 0 {
+		cfg.RecheckInterval = defaultRecheck
+	}
+	if cfg.RetryDelay == 0 {
+		cfg.RetryDelay = defaultRetry
+	}
+	if cfg.CacheLimit == 0 {
+		cfg.CacheLimit = defaultCache
+	}
+	if cfg.ValidSchemes == nil {
+		cfg.ValidSchemes = enode.ValidSchemes
+	}
+	if cfg.Resolver == nil {
+		cfg.Resolver = new(net.Resolver)
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.Root()
+	}
+	return cfg
+}
+
+// NewClient creates a client.
+func NewClient(cfg Config) *Client {
+	cfg = cfg.withDefaults()
+	cache, err := lru.New(cfg.CacheLimit)
+	if err != nil {
+		panic(err)
+	}
+	return &Client{cfg: cfg, entries: cache, clock: mclock.System{}}
+}
+
+// SyncTree downloads the entire node tree at the given URL.
+func (c *Client) SyncTree(url string) (*Tree, error) {
+	le, err := parseLink(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid enrtree URL: %v", err)
+	}
+	ct := newClientTree(c, le)
+	t := &Tree{entries: make(map[string]entry)}
+	if err := ct.syncAll(t.entries); err != nil {
+		return nil, err
+	}
+	t.root = ct.root
+	return t, nil
+}
+
+// NewIterator creates an iterator that visits random nodes of all the trees
+// referenced by the given enrtree:// URLs. Trees linked from those lists are
+// visited as well.
+func (c *Client) NewIterator(urls ...string) (*RandomIterator, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	it := &RandomIterator{
+		c:        c,
+		ctx:      ctx,
+		cancelFn: cancel,
+		trees:    make(map[string]*clientTree),
+	}
+	for _, url := range urls {
+		if err := it.addTree(url); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	return it, nil
+}
+
+// resolveRoot retrieves a root entry via DNS.
+func (c *Client) resolveRoot(ctx context.Context, loc *linkEntry) (rootEntry, error) {
+	txts, err := c.cfg.Resolver.LookupTXT(ctx, loc.domain)
+	c.cfg.Logger.Trace("Updating DNS discovery root", "tree", loc.domain, "err", err)
+	if err != nil {
+		return rootEntry{}, err
+	}
+	for _, txt := range txts {
+		if strings.HasPrefix(txt, rootPrefix) {
+			return parseAndVerifyRoot(txt, loc)
+		}
+	}
+	return rootEntry{}, nameError{loc.domain, errNoRoot}
+}
+
+func parseAndVerifyRoot(txt string, loc *linkEntry) (rootEntry, error) {
+	e, err := parseRoot(txt)
+	if err != nil {
+		return e, err
+	}
+	if !e.verifySignature(loc.pubkey) {
+		return e, entryError{typ: "root", err: errInvalidSig}
+	}
+	return e, nil
+}
+
+// resolveEntry retrieves an entry from the cache or fetches it from the network
+// if it isn't cached.
+func (c *Client) resolveEntry(ctx context.Context, domain, hash string) (entry, error) {
+	cacheKey := truncateHash(hash)
+	if e, ok := c.entries.Get(cacheKey); ok {
+		return e.(entry), nil
+	}
+	e, err := c.doResolveEntry(ctx, domain, hash)
+	if err != nil {
+		return nil, err
+	}
+	c.entries.Add(cacheKey, e)
+	return e, nil
+}
+
+// doResolveEntry fetches an entry via DNS.
+func (c *Client) doResolveEntry(ctx context.Context, domain, hash string) (entry, error) {
+	wantHash, err := b32format.DecodeString(hash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base32 hash")
+	}
+	name := hash + "." + domain
+	txts, err := c.cfg.Resolver.LookupTXT(ctx, name)
+	c.cfg.Logger.Trace("DNS discovery lookup", "name", name, "err", err)
+	if err != nil {
+		return nil, err
+	}
+	for _, txt := range txts {
+		e, err := parseEntry(txt, c.cfg.ValidSchemes)
+		if err == errUnknownEntry {
+			continue
+		}
+		if !bytes.HasPrefix(crypto.Keccak256([]byte(txt)), wantHash) {
+			err = nameError{name, errHashMismatch}
+		} else if err != nil {
+			err = nameError{name, err}
+		}
+		return e, err
+	}
+	return nil, nameError{name, errNoEntry}
+}
+
+// RandomIterator traverses a set of trees and returns nodes found in them.
+// Nodes are returned in random order, each tree is picked with equal
+// probability.
+type RandomIterator struct {
+	cur      *enode.Node
+	ctx      context.Context
+	cancelFn context.CancelFunc
+	c        *Client
+
+	mu    sync.Mutex
+	trees map[string]*clientTree // all trees, keyed by link URL
+}
+
+// Node returns the current node.
+func (it *RandomIterator) Node() *enode.Node {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	return it.cur
+}
+
+// Close closes the iterator. Calling Close unblocks any pending call to Next.
+func (it *RandomIterator) Close() {
+	it.cancelFn()
+
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	it.trees = nil
+}
+
+// Next moves the iterator to the next node. It blocks until a node is found or
+// the iterator is closed, in which case it returns false.
+func (it *RandomIterator) Next() bool {
+	n := it.nextNode()
+
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	it.cur = n
+	return n != nil
+}
+
+// addTree adds an enrtree:// URL to the iterator.
+func (it *RandomIterator) addTree(url string) error {
+	le, err := parseLink(url)
+	if err != nil {
+		return fmt.Errorf("invalid enrtree URL: %v", err)
+	}
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if it.trees != nil {
+		it.trees[le.String()] = newClientTree(it.c, le)
+	}
+	return nil
+}
+
+// nextNode syncs random tree entries until it finds a node.
+func (it *RandomIterator) nextNode() *enode.Node {
+	for {
+		ct, wait := it.pickTree()
+		if ct == nil {
+			if wait == 0 {
+				return nil // closed
+			}
+			select {
+			case <-it.c.clock.After(wait):
+				continue
+			case <-it.ctx.Done():
+				return nil
+			}
+		}
+		n, err := ct.syncRandom(it.ctx)
+		if err != nil {
+			if it.ctx.Err() != nil {
+				return nil
+			}
+			it.c.cfg.Logger.Debug("Error in DNS random node sync", "tree", ct.loc.domain, "err", err)
+			ct.retryAt = it.c.clock.Now().Add(it.c.cfg.RetryDelay)
+			continue
+		}
+		for _, le := range ct.takeLinks() {
+			it.addLinkedTree(le)
+		}
+		if n != nil {
+			return n
+		}
+	}
+}
+
+// addLinkedTree adds a tree that was found through a link entry.
+func (it *RandomIterator) addLinkedTree(le *linkEntry) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if it.trees == nil {
+		return
+	}
+	if _, ok := it.trees[le.String()]; !ok {
+		it.trees[le.String()] = newClientTree(it.c, le)
+	}
+}
+
+// pickTree returns a random tree that can be synced right now. If no such tree
+// exists, it returns the time until one of the trees becomes syncable again. A
+// zero wait time means the iterator has been closed.
+func (it *RandomIterator) pickTree() (*clientTree, time.Duration) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	if it.trees == nil || it.ctx.Err() != nil {
+		return nil, 0
+	}
+	var (
+		now      = it.c.clock.Now()
+		syncable []*clientTree
+		next     mclock.AbsTime
+	)
+	for _, ct := range it.trees {
+		if ct.canSyncRandom(now) {
+			syncable = append(syncable, ct)
+			continue
+		}
+		if at := ct.nextSyncTime(); next == 0 || at < next {
+			next = at
+		}
+	}
+	if len(syncable) > 0 {
+		return syncable[rand.Intn(len(syncable))], 0
+	}
+	if wait := time.Duration(next - now); wait > 0 {
+		return nil, wait
+	}
+	// No trees at all, wait for a full recheck interval.
+	return nil, it.c.cfg.RecheckInterval
+}
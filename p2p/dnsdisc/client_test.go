@@ -0,0 +1,310 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package dnsdisc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/ethereum/go-ethereum/common/mclock"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/internal/testlog"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+)
+
+const (
+	signingKeySeed = 0x111111
+	nodesSeed1     = 0x2945237
+	nodesSeed2     = 0x4567299
+)
+
+func TestClientSyncTree(t *testing.T) {
+	nodes := testNodes(nodesSeed1, 30)
+	tree, url := makeTestTree("n", nodes, nil)
+	r := mapResolver(tree.ToTXT("n"))
+
+	c := NewClient(Config{Resolver: r, Logger: testlog.Logger(t, log.LvlTrace)})
+	stree, err := c.SyncTree(url)
+	if err != nil {
+		t.Fatal("sync error:", err)
+	}
+	if !reflect.DeepEqual(sortByID(stree.Nodes()), sortByID(tree.Nodes())) {
+		t.Errorf("wrong nodes in synced tree:\nhave %v\nwant %v", spew.Sdump(stree.Nodes()), spew.Sdump(tree.Nodes()))
+	}
+	if !reflect.DeepEqual(stree.Links(), tree.Links()) {
+		t.Errorf("wrong links in synced tree: %v", stree.Links())
+	}
+	if stree.Seq() != tree.Seq() {
+		t.Errorf("synced tree has wrong seq: %d", stree.Seq())
+	}
+	if stree.Signature() != tree.Signature() {
+		t.Errorf("synced tree has wrong signature")
+	}
+}
+
+// In this test, syncing the tree fails because it contains an invalid ENR entry.
+func TestClientSyncTreeBadNode(t *testing.T) {
+	tree, url := makeTestTree("n", testNodes(nodesSeed1, 1), nil)
+	r := mapResolver(tree.ToTXT("n"))
+
+	// Replace the node record with garbage. The subdomain is the hash
+	// of the original record, so the client must notice the mismatch.
+	name := tree.root.eroot + ".n"
+	r[name] = "enr:-----"
+
+	c := NewClient(Config{Resolver: r, Logger: testlog.Logger(t, log.LvlTrace)})
+	_, err := c.SyncTree(url)
+	wantErr := nameError{name: name, err: errHashMismatch}
+	if err != wantErr {
+		t.Fatalf("expected sync error %q, got %q", wantErr, err)
+	}
+}
+
+// In this test, the iterator visits all nodes of a tree and the tree it links to.
+func TestIteratorLinks(t *testing.T) {
+	nodes := testNodes(nodesSeed1, 40)
+	tree1, url1 := makeTestTree("t1", nodes[:10], nil)
+	tree2, url2 := makeTestTree("t2", nodes[10:], []string{url1})
+	r := mapResolver(tree1.ToTXT("t1"))
+	for name, txt := range tree2.ToTXT("t2") {
+		r[name] = txt
+	}
+
+	c := NewClient(Config{Resolver: r, Logger: testlog.Logger(t, log.LvlTrace)})
+	it, err := c.NewIterator(url2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	checkIterator(t, it, nodes)
+}
+
+// In this test, the iterator picks up changes to the tree after the root
+// recheck interval has passed.
+func TestIteratorNodeUpdates(t *testing.T) {
+	var (
+		clock    = new(mclock.Simulated)
+		nodes    = testNodes(nodesSeed1, 30)
+		resolver = newMapResolver()
+		c        = NewClient(Config{
+			Resolver:        resolver,
+			Logger:          testlog.Logger(t, log.LvlTrace),
+			RecheckInterval: 20 * time.Minute,
+		})
+	)
+	c.clock = clock
+	tree1, url := makeTestTree("n", nodes[:25], nil)
+	it, err := c.NewIterator(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	// Sync the original tree.
+	resolver.add(tree1.ToTXT("n"))
+	checkIterator(t, it, nodes[:25])
+
+	// Update some nodes and ensure the iterator picks up the new version after
+	// the recheck interval.
+	keys := testKeys(nodesSeed1, len(nodes))
+	updateSomeNodes(keys, nodes)
+	tree2, _ := makeTestTree("n", nodes, nil)
+	resolver.clear()
+	resolver.add(tree2.ToTXT("n"))
+
+	clock.Run(c.cfg.RecheckInterval + 1*time.Second)
+	checkIterator(t, it, nodes)
+}
+
+// In this test, Close unblocks a call to Next on an iterator that can't find
+// any nodes.
+func TestIteratorClose(t *testing.T) {
+	tree, url := makeTestTree("n", nil, nil)
+	c := NewClient(Config{Resolver: mapResolver(tree.ToTXT("n"))})
+	it, err := c.NewIterator(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan bool)
+	go func() {
+		done <- it.Next()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	it.Close()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatal("Next returned true for empty tree")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Next did not return after Close")
+	}
+}
+
+func checkIterator(t *testing.T, it *RandomIterator, wantNodes []*enode.Node) {
+	t.Helper()
+
+	var (
+		want     = make(map[enode.ID]*enode.Node)
+		maxCalls = len(wantNodes) * 20
+		calls    = 0
+	)
+	for _, n := range wantNodes {
+		want[n.ID()] = n
+	}
+	for ; len(want) > 0 && calls < maxCalls; calls++ {
+		if !nextWithTimeout(it, 5*time.Second) {
+			t.Fatalf("Next returned false or timed out (call %d)", calls)
+		}
+		n := it.Node()
+		if w := want[n.ID()]; w != nil && w.Seq() == n.Seq() {
+			delete(want, n.ID())
+		}
+	}
+	t.Logf("checkIterator called Next %d times to find %d nodes", calls, len(wantNodes))
+	for _, n := range want {
+		t.Errorf("iterator didn't discover node %v", n.ID())
+	}
+}
+
+// nextWithTimeout calls Next on the iterator, giving up after the given time. The
+// iterator may otherwise block forever waiting for a simulated clock to advance.
+func nextWithTimeout(it *RandomIterator, timeout time.Duration) bool {
+	done := make(chan bool, 1)
+	go func() {
+		done <- it.Next()
+	}()
+	select {
+	case ok := <-done:
+		return ok
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func makeTestTree(domain string, nodes []*enode.Node, links []string) (*Tree, string) {
+	tree, err := MakeTree(1, nodes, links)
+	if err != nil {
+		panic(err)
+	}
+	url, err := tree.Sign(testKey(signingKeySeed), domain)
+	if err != nil {
+		panic(err)
+	}
+	return tree, url
+}
+
+// testKeys creates deterministic private keys for testing. The keys are derived
+// by hashing the seed and the key index, as key generation from a seeded random
+// source is not guaranteed to be reproducible.
+func testKeys(seed int64, n int) []*ecdsa.PrivateKey {
+	keys := make([]*ecdsa.PrivateKey, n)
+	for i := 0; i < n; i++ {
+		var enc [16]byte
+		binary.BigEndian.PutUint64(enc[:8], uint64(seed))
+		binary.BigEndian.PutUint64(enc[8:], uint64(i))
+		key, err := crypto.ToECDSA(crypto.Keccak256(enc[:]))
+		if err != nil {
+			panic("can't generate key: " + err.Error())
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+func testKey(seed int64) *ecdsa.PrivateKey {
+	return testKeys(seed, 1)[0]
+}
+
+func testNodes(seed int64, n int) []*enode.Node {
+	keys := testKeys(seed, n)
+	nodes := make([]*enode.Node, n)
+	for i, key := range keys {
+		record := new(enr.Record)
+		record.SetSeq(uint64(i))
+		enode.SignV4(record, key)
+		n, err := enode.New(enode.ValidSchemes, record)
+		if err != nil {
+			panic(err)
+		}
+		nodes[i] = n
+	}
+	return nodes
+}
+
+func testNode(seed int64) *enode.Node {
+	return testNodes(seed, 1)[0]
+}
+
+// updateSomeNodes re-signs the records of the first half of nodes with a
+// higher sequence number.
+func updateSomeNodes(keys []*ecdsa.PrivateKey, nodes []*enode.Node) {
+	for i, n := range nodes[:len(nodes)/2] {
+		r := n.Record()
+		r.SetSeq(n.Seq() + 1)
+		enode.SignV4(r, keys[i])
+		n2, err := enode.New(enode.ValidSchemes, r)
+		if err != nil {
+			panic(err)
+		}
+		nodes[i] = n2
+	}
+}
+
+func encodePubkey(key *ecdsa.PublicKey) string {
+	return b32format.EncodeToString(crypto.CompressPubkey(key))
+}
+
+// mapResolver is a resolver that serves TXT records from a map.
+type mapResolver map[string]string
+
+func newMapResolver(maps ...map[string]string) mapResolver {
+	mr := make(mapResolver)
+	for _, m := range maps {
+		mr.add(m)
+	}
+	return mr
+}
+
+func (mr mapResolver) clear() {
+	for k := range mr {
+		delete(mr, k)
+	}
+}
+
+func (mr mapResolver) add(m map[string]string) {
+	for k, v := range m {
+		mr[k] = v
+	}
+}
+
+func (mr mapResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if record, ok := mr[name]; ok {
+		return []string{record}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,29 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package dnsdisc implements node discovery via DNS (EIP-1459).
+//
+// Node lists are published as a merkle tree of TXT records. The root of the tree
+// lives at the apex of the domain and is signed by the list operator. Branch
+// entries reference their children by truncated hash, and leaves are either node
+// records or links to other trees. A list is referenced by an enrtree:// URL, which
+// contains the operator's public key and the domain name of the tree root:
+//
+//	enrtree://AM5FCQLWIZX2QFPNJAP7VUERCCRNGRHWZG3YYHIUV7BVDQ5FDPRT2@nodes.example.org
+//
+// The Client type in this package resolves trees on demand, verifying the root
+// signature and the hash of every entry it retrieves.
+package dnsdisc
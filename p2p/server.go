@@ -35,6 +35,7 @@ import (
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/p2p/discv5"
+	"github.com/ethereum/go-ethereum/p2p/dnsdisc"
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/p2p/enr"
 	"github.com/ethereum/go-ethereum/p2p/nat"
@@ -49,6 +50,8 @@ const (
 	maxActiveDialTasks     = 16
 	defaultMaxPendingPeers = 50
 	defaultDialRatio       = 3
+	dialCandidateBuffer    = 10 // dial candidates buffered for the dialer
+	discmixTimeout         = 5 * time.Second
 
 	// Maximum time allowed for reading a complete message.
 	// This is effectively the amount of time a connection can be idle.
@@ -84,9 +87,14 @@ type Config struct {
 	NoDiscovery bool
 
 	// DiscoveryV5 specifies whether the new topic-discovery based V5 discovery
-	// protocol should be started or not.
+	// protocol should be started or not. Nodes found by it are also used as
+	// dial candidates if the server dials dynamically.
 	DiscoveryV5 bool `toml:",omitempty"`
 
+	// DiscoveryV51 specifies whether the discovery v5.1 protocol should be
+	// started. It shares the UDP socket with discovery v4.
+	DiscoveryV51 bool `toml:",omitempty"`
+
 	// Name sets the node name of this server.
 	// Use common.MakeName to create a name that follows existing conventions.
 	Name string `toml:"-"`
@@ -100,6 +108,15 @@ type Config struct {
 	// protocol.
 	BootstrapNodesV5 []*discv5.Node `toml:",omitempty"`
 
+	// BootstrapNodesV51 are used to establish connectivity
+	// with the rest of the network using the V5.1 discovery
+	// protocol.
+	BootstrapNodesV51 []*enode.Node `toml:",omitempty"`
+
+	// DiscoveryDNS contains enrtree:// URLs of DNS node lists. Nodes
+	// found in these lists are used as dial candidates.
+	DiscoveryDNS []string `toml:",omitempty"`
+
 	// Static nodes are used as pre-configured connections which are always
 	// maintained and re-connected on disconnects.
 	StaticNodes []*enode.Node
@@ -168,8 +185,10 @@ type Server struct {
 	ntab         discoverTable
 	listener     net.Listener
 	ourHandshake *protoHandshake
-	lastLookup   time.Time
 	DiscV5       *discv5.Network
+	discV51      *discover.UDPv5
+	discmix      *enode.FairMix
+	dialbuf      *enode.Buffer
 
 	// These are for Peers, PeerCount (and nothing else).
 	peerOp     chan peerOpFunc
@@ -361,6 +380,11 @@ func (srv *Server) Self() *enode.Node {
 	return ln.Node()
 }
 
+// LocalNode returns the local node record.
+func (srv *Server) LocalNode() *enode.LocalNode {
+	return srv.localnode
+}
+
 // Stop terminates the server and all active peer connections.
 // It blocks until all active connections have been closed.
 func (srv *Server) Stop() {
@@ -379,6 +403,10 @@ func (srv *Server) Stop() {
 	srv.loopWG.Wait()
 }
 
+// discv5VersionPrefix is the prefix of all packets of the legacy discovery v5
+// protocol. It must match versionPrefix in package p2p/discv5.
+var discv5VersionPrefix = []byte("temporary discovery v5")
+
 // sharedUDPConn implements a shared connection. Write sends messages to the underlying connection while read returns
 // messages that were found unprocessable and sent to the unhandled channel by the primary listener.
 type sharedUDPConn struct {
@@ -455,8 +483,12 @@ func (srv *Server) Start() (err error) {
 		return err
 	}
 
+	var candidates <-chan *enode.Node
+	if srv.dialbuf != nil {
+		candidates = srv.dialbuf.Chan()
+	}
 	dynPeers := srv.maxDialedConns()
-	dialer := newDialState(srv.localnode.ID(), srv.StaticNodes, srv.BootstrapNodes, srv.ntab, dynPeers, srv.NetRestrict)
+	dialer := newDialState(srv.localnode.ID(), srv.StaticNodes, srv.BootstrapNodes, candidates, dynPeers, srv.NetRestrict)
 	srv.loopWG.Add(1)
 	go srv.run(dialer)
 	return nil
@@ -508,9 +540,26 @@ func (srv *Server) setupLocalNode() error {
 }
 
 func (srv *Server) setupDiscovery() error {
-	if srv.NoDiscovery && !srv.DiscoveryV5 {
+	// Dynamic dial candidates are gathered from all discovery mechanisms
+	// and protocols which provide them.
+	if srv.maxDialedConns() > 0 {
+		srv.discmix = enode.NewFairMix(discmixTimeout)
+		srv.dialbuf = enode.NewBuffer(srv.discmix, dialCandidateBuffer)
+		for _, p := range srv.Protocols {
+			if p.DialCandidates != nil {
+				srv.discmix.AddSource(p.DialCandidates)
+			}
+		}
+		if err := srv.setupDNSDiscovery(); err != nil {
+			return err
+		}
+	}
+	if srv.NoDiscovery && !srv.DiscoveryV5 && !srv.DiscoveryV51 {
 		return nil
 	}
+	if srv.NoDiscovery && srv.DiscoveryV5 && srv.DiscoveryV51 {
+		return errors.New("discovery v5 and v5.1 can't share the UDP socket without discovery v4")
+	}
 
 	addr, err := net.ResolveUDPAddr("udp", srv.ListenAddr)
 	if err != nil {
@@ -531,11 +580,22 @@ func (srv *Server) setupDiscovery() error {
 
 	// Discovery V4
 	var unhandled chan discover.ReadPacket
-	var sconn *sharedUDPConn
+	var sconn, sconnV51 *sharedUDPConn
 	if !srv.NoDiscovery {
-		if srv.DiscoveryV5 {
+		switch {
+		case srv.DiscoveryV5 && srv.DiscoveryV51:
+			unhandled = make(chan discover.ReadPacket, 100)
+			v5ch := make(chan discover.ReadPacket, 100)
+			v51ch := make(chan discover.ReadPacket, 100)
+			sconn = &sharedUDPConn{conn, v5ch}
+			sconnV51 = &sharedUDPConn{conn, v51ch}
+			go demuxUnhandled(unhandled, v5ch, v51ch)
+		case srv.DiscoveryV5:
 			unhandled = make(chan discover.ReadPacket, 100)
 			sconn = &sharedUDPConn{conn, unhandled}
+		case srv.DiscoveryV51:
+			unhandled = make(chan discover.ReadPacket, 100)
+			sconnV51 = &sharedUDPConn{conn, unhandled}
 		}
 		cfg := discover.Config{
 			PrivateKey:  srv.PrivateKey,
@@ -548,6 +608,9 @@ func (srv *Server) setupDiscovery() error {
 			return err
 		}
 		srv.ntab = ntab
+		if srv.discmix != nil {
+			srv.discmix.AddSource(ntab.RandomNodes())
+		}
 	}
 	// Discovery V5
 	if srv.DiscoveryV5 {
@@ -565,10 +628,69 @@ func (srv *Server) setupDiscovery() error {
 			return err
 		}
 		srv.DiscV5 = ntab
+		if srv.discmix != nil {
+			srv.discmix.AddSource(ntab.RandomNodes())
+		}
+	}
+	// Discovery V5.1
+	if srv.DiscoveryV51 {
+		cfg := discover.Config{
+			PrivateKey:  srv.PrivateKey,
+			NetRestrict: srv.NetRestrict,
+			Bootnodes:   srv.BootstrapNodesV51,
+			Log:         srv.log,
+		}
+		var ntab *discover.UDPv5
+		var err error
+		if sconnV51 != nil {
+			ntab, err = discover.ListenV5(sconnV51, srv.localnode, cfg)
+		} else {
+			ntab, err = discover.ListenV5(conn, srv.localnode, cfg)
+		}
+		if err != nil {
+			return err
+		}
+		srv.discV51 = ntab
+		if srv.discmix != nil {
+			srv.discmix.AddSource(ntab.RandomNodes())
+		}
 	}
 	return nil
 }
 
+// demuxUnhandled distributes the packets which discovery v4 couldn't handle
+// among the v5 protocols. Packets of the legacy discovery v5 protocol are
+// recognized by their prefix, everything else is passed to discovery v5.1.
+// The output channels are closed when discovery v4 shuts down.
+func demuxUnhandled(unhandled <-chan discover.ReadPacket, v5, v51 chan<- discover.ReadPacket) {
+	defer close(v5)
+	defer close(v51)
+
+	for p := range unhandled {
+		out := v51
+		if bytes.HasPrefix(p.Data, discv5VersionPrefix) {
+			out = v5
+		}
+		select {
+		case out <- p:
+		default:
+		}
+	}
+}
+
+func (srv *Server) setupDNSDiscovery() error {
+	if len(srv.DiscoveryDNS) == 0 {
+		return nil
+	}
+	client := dnsdisc.NewClient(dnsdisc.Config{Logger: srv.log})
+	it, err := client.NewIterator(srv.DiscoveryDNS...)
+	if err != nil {
+		return err
+	}
+	srv.discmix.AddSource(it)
+	return nil
+}
+
 func (srv *Server) setupListening() error {
 	// Launch the TCP listener.
 	listener, err := net.Listen("tcp", srv.ListenAddr)
@@ -765,6 +887,12 @@ running:
 	if srv.DiscV5 != nil {
 		srv.DiscV5.Close()
 	}
+	if srv.discV51 != nil {
+		srv.discV51.Close()
+	}
+	if srv.dialbuf != nil {
+		srv.dialbuf.Close()
+	}
 	// Disconnect all peers.
 	for _, p := range peers {
 		p.Disconnect(DiscQuitting)
@@ -808,7 +936,7 @@ func (srv *Server) maxInboundConns() int {
 	return srv.MaxPeers - srv.maxDialedConns()
 }
 func (srv *Server) maxDialedConns() int {
-	if srv.NoDiscovery || srv.NoDial {
+	if srv.NoDial || (srv.NoDiscovery && !srv.DiscoveryV51 && len(srv.DiscoveryDNS) == 0 && !srv.hasDialCandidates()) {
 		return 0
 	}
 	r := srv.DialRatio
@@ -818,6 +946,16 @@ func (srv *Server) maxDialedConns() int {
 	return srv.MaxPeers / r
 }
 
+// hasDialCandidates reports whether any protocol provides dial candidates.
+func (srv *Server) hasDialCandidates() bool {
+	for _, p := range srv.Protocols {
+		if p.DialCandidates != nil {
+			return true
+		}
+	}
+	return false
+}
+
 // listenLoop runs in its own goroutine and accepts
 // inbound connections.
 func (srv *Server) listenLoop() {
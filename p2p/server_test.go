@@ -17,6 +17,7 @@
 package p2p
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"errors"
 	"math/rand"
@@ -26,7 +27,9 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/internal/testlog"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/p2p/enr"
 	"golang.org/x/crypto/sha3"
@@ -231,7 +234,6 @@ func TestServerTaskScheduling(t *testing.T) {
 		localnode: enode.NewLocalNode(db, newkey()),
 		nodedb:    db,
 		quit:      make(chan struct{}),
-		ntab:      fakeTable{},
 		running:   true,
 		log:       log.New(),
 	}
@@ -279,7 +281,6 @@ func TestServerManyTasks(t *testing.T) {
 			quit:      make(chan struct{}),
 			localnode: enode.NewLocalNode(db, newkey()),
 			nodedb:    db,
-			ntab:      fakeTable{},
 			running:   true,
 			log:       log.New(),
 		}
@@ -567,6 +568,77 @@ func TestServerSetupConn(t *testing.T) {
 	}
 }
 
+// This test checks that discovery v5.1 runs on the discovery v4 socket,
+// with and without the legacy discovery v5 protocol sharing it.
+func TestServerDiscoveryV51(t *testing.T) {
+	for _, legacyV5 := range []bool{false, true} {
+		srv := &Server{Config: Config{
+			Name:         "test",
+			MaxPeers:     10,
+			ListenAddr:   "127.0.0.1:0",
+			PrivateKey:   newkey(),
+			NoDial:       true,
+			DiscoveryV5:  legacyV5,
+			DiscoveryV51: true,
+			Logger:       testlog.Logger(t, log.LvlTrace),
+		}}
+		if err := srv.Start(); err != nil {
+			t.Fatalf("could not start server (legacy v5: %t): %v", legacyV5, err)
+		}
+		if srv.discV51 == nil {
+			t.Fatalf("discovery v5.1 not running (legacy v5: %t)", legacyV5)
+		}
+		if legacyV5 && srv.DiscV5 == nil {
+			t.Fatal("legacy discovery v5 not running")
+		}
+
+		// Ping the server from a standalone v5.1 node.
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, _ := enode.OpenDB("")
+		key := newkey()
+		client, err := discover.ListenV5(conn, enode.NewLocalNode(db, key), discover.Config{PrivateKey: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Ping(srv.Self()); err != nil {
+			t.Errorf("v5.1 ping failed (legacy v5: %t): %v", legacyV5, err)
+		}
+		client.Close()
+		db.Close()
+		srv.Stop()
+	}
+}
+
+func TestDemuxUnhandled(t *testing.T) {
+	var (
+		unhandled = make(chan discover.ReadPacket, 2)
+		v5        = make(chan discover.ReadPacket, 2)
+		v51       = make(chan discover.ReadPacket, 2)
+		legacy    = append(append([]byte{}, discv5VersionPrefix...), 1, 2, 3)
+		other     = []byte{1, 2, 3}
+	)
+	unhandled <- discover.ReadPacket{Data: legacy}
+	unhandled <- discover.ReadPacket{Data: other}
+	close(unhandled)
+	demuxUnhandled(unhandled, v5, v51)
+
+	if p, ok := <-v5; !ok || !bytes.Equal(p.Data, legacy) {
+		t.Errorf("wrong packet on v5 channel: %x", p.Data)
+	}
+	if p, ok := <-v51; !ok || !bytes.Equal(p.Data, other) {
+		t.Errorf("wrong packet on v5.1 channel: %x", p.Data)
+	}
+	if _, ok := <-v5; ok {
+		t.Error("v5 channel not closed")
+	}
+	if _, ok := <-v51; ok {
+		t.Error("v5.1 channel not closed")
+	}
+}
+
 type setupTransport struct {
 	pubkey            *ecdsa.PublicKey
 	encHandshakeErr   error
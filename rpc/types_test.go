@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/math"
 )
 
@@ -64,3 +65,59 @@ func TestBlockNumberJSONUnmarshal(t *testing.T) {
 		}
 	}
 }
+
+func TestBlockNumberOrHashJSONUnmarshal(t *testing.T) {
+	hash := common.HexToHash("0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+	tests := []struct {
+		input    string
+		mustFail bool
+		expected BlockNumberOrHash
+	}{
+		0:  {`"0x"`, true, BlockNumberOrHash{}},
+		1:  {`"0x0"`, false, BlockNumberOrHashWithNumber(0)},
+		2:  {`"0x12"`, false, BlockNumberOrHashWithNumber(18)},
+		3:  {`"0x8000000000000000"`, true, BlockNumberOrHash{}},
+		4:  {`"pending"`, false, BlockNumberOrHashWithNumber(PendingBlockNumber)},
+		5:  {`"latest"`, false, BlockNumberOrHashWithNumber(LatestBlockNumber)},
+		6:  {`"earliest"`, false, BlockNumberOrHashWithNumber(EarliestBlockNumber)},
+		7:  {`"` + hash.Hex() + `"`, false, BlockNumberOrHashWithHash(hash, false)},
+		8:  {`"` + hash.Hex()[:65] + `"`, true, BlockNumberOrHash{}},
+		9:  {`{"blockNumber":"0x12"}`, false, BlockNumberOrHashWithNumber(18)},
+		10: {`{"blockNumber":"latest"}`, false, BlockNumberOrHashWithNumber(LatestBlockNumber)},
+		11: {`{"blockHash":"` + hash.Hex() + `"}`, false, BlockNumberOrHashWithHash(hash, false)},
+		12: {`{"blockHash":"` + hash.Hex() + `","requireCanonical":true}`, false, BlockNumberOrHashWithHash(hash, true)},
+		13: {`{"blockNumber":"0x12","blockHash":"` + hash.Hex() + `"}`, true, BlockNumberOrHash{}},
+		14: {`{}`, true, BlockNumberOrHash{}},
+		15: {`someString`, true, BlockNumberOrHash{}},
+		16: {``, true, BlockNumberOrHash{}},
+	}
+
+	for i, test := range tests {
+		var bnh BlockNumberOrHash
+		err := json.Unmarshal([]byte(test.input), &bnh)
+		if test.mustFail && err == nil {
+			t.Errorf("Test %d should fail", i)
+			continue
+		}
+		if !test.mustFail && err != nil {
+			t.Errorf("Test %d should pass but got err: %v", i, err)
+			continue
+		}
+		if test.mustFail {
+			continue
+		}
+		haveNum, haveNumOk := bnh.Number()
+		wantNum, wantNumOk := test.expected.Number()
+		if haveNum != wantNum || haveNumOk != wantNumOk {
+			t.Errorf("Test %d got unexpected number, want %d (%v), got %d (%v)", i, wantNum, wantNumOk, haveNum, haveNumOk)
+		}
+		haveHash, haveHashOk := bnh.Hash()
+		wantHash, wantHashOk := test.expected.Hash()
+		if haveHash != wantHash || haveHashOk != wantHashOk {
+			t.Errorf("Test %d got unexpected hash, want %x (%v), got %x (%v)", i, wantHash, wantHashOk, haveHash, haveHashOk)
+		}
+		if bnh.RequireCanonical != test.expected.RequireCanonical {
+			t.Errorf("Test %d got unexpected canonical flag, want %v, got %v", i, test.expected.RequireCanonical, bnh.RequireCanonical)
+		}
+	}
+}
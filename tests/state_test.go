@@ -21,16 +21,66 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"math/big"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/vm"
 )
 
 func TestState(t *testing.T) {
 	t.Parallel()
 
+	st := newStateTestMatcher()
+	st.walk(t, stateTestDir, func(t *testing.T, name string, test *StateTest) {
+		for _, subtest := range test.Subtests() {
+			subtest := subtest
+			key := fmt.Sprintf("%s/%d", subtest.Fork, subtest.Index)
+			name := name + "/" + key
+			t.Run(key, func(t *testing.T) {
+				withTrace(t, test.gasLimit(subtest), func(vmconfig vm.Config) error {
+					_, err := test.Run(subtest, vmconfig)
+					return st.checkFailure(t, name, err)
+				})
+			})
+		}
+	})
+}
+
+// Tests that charging the static gas of whole basic blocks at once leads to the
+// exact same post state and logs as validating every operation individually,
+// which the interpreter does when tracing.
+func TestStateBasicBlocks(t *testing.T) {
+	t.Parallel()
+
+	st := newStateTestMatcher()
+	st.walk(t, stateTestDir, func(t *testing.T, name string, test *StateTest) {
+		for _, subtest := range test.Subtests() {
+			subtest := subtest
+			t.Run(fmt.Sprintf("%s/%d", subtest.Fork, subtest.Index), func(t *testing.T) {
+				have, err := test.Run(subtest, testVMConfig)
+				if have == nil {
+					t.Skip(err)
+				}
+				want, _ := test.Run(subtest, vm.Config{Debug: true, Tracer: noopTracer{}})
+
+				if haveRoot, wantRoot := have.IntermediateRoot(false), want.IntermediateRoot(false); haveRoot != wantRoot {
+					t.Errorf("post state root mismatch: have %x, want %x", haveRoot, wantRoot)
+				}
+				if haveLogs, wantLogs := rlpHash(have.Logs()), rlpHash(want.Logs()); haveLogs != wantLogs {
+					t.Errorf("post state logs hash mismatch: have %x, want %x", haveLogs, wantLogs)
+				}
+			})
+		}
+	})
+}
+
+// newStateTestMatcher creates a test matcher with the known slow, broken and
+// failing state tests.
+func newStateTestMatcher() *testMatcher {
 	st := new(testMatcher)
 	// Long tests:
 	st.slow(`^stAttackTest/ContractCreationSpam`)
@@ -52,20 +102,24 @@ func TestState(t *testing.T) {
 	st.fails(`^stRevertTest/RevertPrecompiledTouch(_storage)?\.json/Constantinople/3`, "bug in test")
 	st.fails(`^stRevertTest/RevertPrecompiledTouch(_storage)?\.json/ConstantinopleFix/0`, "bug in test")
 	st.fails(`^stRevertTest/RevertPrecompiledTouch(_storage)?\.json/ConstantinopleFix/3`, "bug in test")
+	return st
+}
 
-	st.walk(t, stateTestDir, func(t *testing.T, name string, test *StateTest) {
-		for _, subtest := range test.Subtests() {
-			subtest := subtest
-			key := fmt.Sprintf("%s/%d", subtest.Fork, subtest.Index)
-			name := name + "/" + key
-			t.Run(key, func(t *testing.T) {
-				withTrace(t, test.gasLimit(subtest), func(vmconfig vm.Config) error {
-					_, err := test.Run(subtest, vmconfig)
-					return st.checkFailure(t, name, err)
-				})
-			})
-		}
-	})
+// noopTracer is a tracer that does nothing, used to run the interpreter in its
+// per operation validation mode.
+type noopTracer struct{}
+
+func (noopTracer) CaptureStart(from common.Address, to common.Address, call bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+func (noopTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+func (noopTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+func (noopTracer) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	return nil
 }
 
 // Transactions with gasLimit above this value will not get a VM trace on failure.
@@ -0,0 +1,238 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// leafChanSize is the size of the leafCh. It's a pretty arbitrary number, to allow
+// some parallelism but not incur too much memory overhead.
+const leafChanSize = 200
+
+// leaf represents a trie node ready to be inserted into the trie database.
+type leaf struct {
+	size int         // Estimated size of the RLP encoded node
+	hash common.Hash // Hash of the RLP encoded node
+	node node        // Collapsed node to insert into the database
+}
+
+// committer is a type used for the trie Commit operation. It walks the dirty
+// nodes of an already hashed trie, inserting them into the trie database.
+//
+// If a leaf callback is set, the insertions and callbacks are done on a separate
+// goroutine fed through the leaf channel, pipelining them with the trie walk.
+// The callback is still invoked sequentially, in the order of the insertions.
+type committer struct {
+	onleaf LeafCallback
+	leafCh chan *leaf
+}
+
+// committers live in a global sync.Pool
+var committerPool = sync.Pool{
+	New: func() interface{} {
+		return new(committer)
+	},
+}
+
+// newCommitter creates a new committer or picks one from the pool.
+func newCommitter() *committer {
+	return committerPool.Get().(*committer)
+}
+
+func returnCommitterToPool(c *committer) {
+	c.onleaf = nil
+	c.leafCh = nil
+	committerPool.Put(c)
+}
+
+// Commit collapses a hashed node down into a hash node and inserts all of its
+// dirty subtrie into the database.
+func (c *committer) Commit(n node, db *Database) (hashNode, error) {
+	if db == nil {
+		return nil, errors.New("no db provided")
+	}
+	h, err := c.commit(n, db)
+	if err != nil {
+		return nil, err
+	}
+	return h.(hashNode), nil
+}
+
+// commit collapses a node down into a hash node and inserts it into the database.
+// Nodes smaller than a hash are returned collapsed, to be embedded in the parent.
+func (c *committer) commit(n node, db *Database) (node, error) {
+	// If this path is clean, use available cached data
+	hash, dirty := n.cache()
+	if hash != nil && !dirty {
+		return hash, nil
+	}
+	// Commit children, then parent
+	switch cn := n.(type) {
+	case *shortNode:
+		collapsed := cn.copy()
+
+		// If the child is a full node, recursively commit. Otherwise it can
+		// only be a hash node or a value node.
+		if _, ok := cn.Val.(*fullNode); ok {
+			child, err := c.commit(cn.Val, db)
+			if err != nil {
+				return nil, err
+			}
+			collapsed.Val = child
+		}
+		// The key needs to be copied, since we're delivering it to database
+		collapsed.Key = hexToCompact(cn.Key)
+		if hn, ok := c.store(collapsed, db).(hashNode); ok {
+			return hn, nil
+		}
+		return collapsed, nil
+
+	case *fullNode:
+		children, err := c.commitChildren(cn, db)
+		if err != nil {
+			return nil, err
+		}
+		collapsed := cn.copy()
+		collapsed.Children = children
+
+		if hn, ok := c.store(collapsed, db).(hashNode); ok {
+			return hn, nil
+		}
+		return collapsed, nil
+
+	case hashNode:
+		return cn, nil
+
+	default:
+		// Value nodes are never committed on their own
+		panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+	}
+}
+
+// commitChildren commits the children of the given full node, returning them
+// collapsed. The 17th child can only be a value node, which is kept as is.
+func (c *committer) commitChildren(n *fullNode, db *Database) ([17]node, error) {
+	var children [17]node
+	for i := 0; i < 16; i++ {
+		child := n.Children[i]
+		if child == nil {
+			continue
+		}
+		// Hashed children are already persisted, use the hash directly
+		if hn, ok := child.(hashNode); ok {
+			children[i] = hn
+			continue
+		}
+		hashed, err := c.commit(child, db)
+		if err != nil {
+			return children, err
+		}
+		children[i] = hashed
+	}
+	children[16] = n.Children[16]
+	return children, nil
+}
+
+// store inserts the collapsed node into the database if it has a hash of its
+// own, returning the hash. Nodes without a hash were smaller than 32 bytes when
+// hashed and are returned as is, to be embedded in their parent.
+func (c *committer) store(n node, db *Database) node {
+	hash, _ := n.cache()
+	if hash == nil {
+		return n
+	}
+	// The size is used for memory tracking only, it doesn't need to be exact
+	size := estimateSize(n)
+
+	// If there's a leaf callback, hand the node over to the commit loop,
+	// otherwise insert it directly.
+	if c.leafCh != nil {
+		c.leafCh <- &leaf{
+			size: size,
+			hash: common.BytesToHash(hash),
+			node: n,
+		}
+	} else {
+		db.lock.Lock()
+		db.insert(common.BytesToHash(hash), size, n)
+		db.lock.Unlock()
+	}
+	return hash
+}
+
+// commitLoop inserts the nodes fed through the leaf channel into the database
+// and invokes the leaf callback for any value children, until the channel is
+// closed.
+func (c *committer) commitLoop(db *Database) {
+	for item := range c.leafCh {
+		// We are pooling the trie nodes into an intermediate memory cache
+		db.lock.Lock()
+		db.insert(item.hash, item.size, item.node)
+		db.lock.Unlock()
+
+		// Track external references from account->storage trie
+		switch n := item.node.(type) {
+		case *shortNode:
+			if child, ok := n.Val.(valueNode); ok {
+				c.onleaf(child, item.hash)
+			}
+		case *fullNode:
+			for i := 0; i < 16; i++ {
+				if child, ok := n.Children[i].(valueNode); ok {
+					c.onleaf(child, item.hash)
+				}
+			}
+		}
+	}
+}
+
+// estimateSize estimates the size of an RLP encoded node, without actually
+// encoding it. It slightly overestimates for small short nodes.
+func estimateSize(n node) int {
+	switch n := n.(type) {
+	case *shortNode:
+		// A short node contains a compacted key and a value
+		return 3 + len(n.Key) + estimateSize(n.Val)
+	case *fullNode:
+		// A full node contains up to 16 hashes (some nils) and a value
+		s := 3
+		for i := 0; i < 16; i++ {
+			if child := n.Children[i]; child != nil {
+				s += estimateSize(child)
+			} else {
+				s++
+			}
+		}
+		if n.Children[16] != nil {
+			s += estimateSize(n.Children[16])
+		} else {
+			s++
+		}
+		return s
+	case valueNode:
+		return 1 + len(n)
+	case hashNode:
+		return 1 + len(n)
+	default:
+		panic(fmt.Sprintf("node type %T", n))
+	}
+}
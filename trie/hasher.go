@@ -25,10 +25,12 @@ import (
 	"golang.org/x/crypto/sha3"
 )
 
+// hasher is a type used for the trie Hash operation. A hasher has some
+// internal preallocated temp space.
 type hasher struct {
-	tmp    sliceBuffer
-	sha    keccakState
-	onleaf LeafCallback
+	sha      keccakState
+	tmp      sliceBuffer
+	parallel bool // Whether to use parallel threads when hashing
 }
 
 // keccakState wraps sha3.state. In addition to the usual hash methods, it also supports
@@ -60,9 +62,9 @@ var hasherPool = sync.Pool{
 	},
 }
 
-func newHasher(onleaf LeafCallback) *hasher {
+func newHasher(parallel bool) *hasher {
 	h := hasherPool.Get().(*hasher)
-	h.onleaf = onleaf
+	h.parallel = parallel
 	return h
 }
 
@@ -72,144 +74,142 @@ func returnHasherToPool(h *hasher) {
 
 // hash collapses a node down into a hash node, also returning a copy of the
 // original node initialized with the computed hash to replace the original one.
-func (h *hasher) hash(n node, db *Database, force bool) (node, node, error) {
-	// If we're not storing the node, just hashing, use available cached data
-	if hash, dirty := n.cache(); hash != nil {
-		if db == nil {
-			return hash, n, nil
-		}
-		if !dirty {
-			switch n.(type) {
-			case *fullNode, *shortNode:
-				return hash, hash, nil
-			default:
-				return hash, n, nil
-			}
-		}
+// The dirty flags are left untouched, the nodes are persisted by the committer.
+func (h *hasher) hash(n node, force bool) (hashed node, cached node) {
+	// Return the cached hash if the node was already hashed
+	if hash, _ := n.cache(); hash != nil {
+		return hash, n
 	}
-	// Trie not processed yet or needs storage, walk the children
-	collapsed, cached, err := h.hashChildren(n, db)
-	if err != nil {
-		return hashNode{}, n, err
-	}
-	hashed, err := h.store(collapsed, db, force)
-	if err != nil {
-		return hashNode{}, n, err
-	}
-	// Cache the hash of the node for later reuse and remove
-	// the dirty flag in commit mode. It's fine to assign these values directly
-	// without copying the node first because hashChildren copies it.
-	cachedHash, _ := hashed.(hashNode)
-	switch cn := cached.(type) {
+	// Trie not processed yet, walk the children
+	switch n := n.(type) {
 	case *shortNode:
-		cn.flags.hash = cachedHash
-		if db != nil {
-			cn.flags.dirty = false
+		collapsed, cached := h.hashShortNodeChildren(n)
+		hashed := h.shortnodeToHash(collapsed, force)
+
+		// Nodes smaller than a hash are embedded into their parent, so only
+		// cache actual hashes
+		if hn, ok := hashed.(hashNode); ok {
+			cached.flags.hash = hn
+		} else {
+			cached.flags.hash = nil
 		}
+		return hashed, cached
+
 	case *fullNode:
-		cn.flags.hash = cachedHash
-		if db != nil {
-			cn.flags.dirty = false
+		collapsed, cached := h.hashFullNodeChildren(n)
+		hashed := h.fullnodeToHash(collapsed, force)
+
+		if hn, ok := hashed.(hashNode); ok {
+			cached.flags.hash = hn
+		} else {
+			cached.flags.hash = nil
 		}
+		return hashed, cached
+
+	default:
+		// Value and hash nodes don't have children so they're left as were
+		return n, n
 	}
-	return hashed, cached, nil
 }
 
-// hashChildren replaces the children of a node with their hashes if the encoded
-// size of the child is larger than a hash, returning the collapsed node as well
-// as a replacement for the original node with the child hashes cached in.
-func (h *hasher) hashChildren(original node, db *Database) (node, node, error) {
-	var err error
-
-	switch n := original.(type) {
-	case *shortNode:
-		// Hash the short node's child, caching the newly hashed subtree
-		collapsed, cached := n.copy(), n.copy()
-		collapsed.Key = hexToCompact(n.Key)
-		cached.Key = common.CopyBytes(n.Key)
-
-		if _, ok := n.Val.(valueNode); !ok {
-			collapsed.Val, cached.Val, err = h.hash(n.Val, db, false)
-			if err != nil {
-				return original, original, err
-			}
-		}
-		return collapsed, cached, nil
+// hashShortNodeChildren hashes the child of a short node, returning the collapsed
+// node ready for encoding as well as a replacement for the original node with the
+// child hash cached in.
+func (h *hasher) hashShortNodeChildren(n *shortNode) (collapsed, cached *shortNode) {
+	collapsed, cached = n.copy(), n.copy()
+	collapsed.Key = hexToCompact(n.Key)
+	cached.Key = common.CopyBytes(n.Key)
+
+	// Unless the child is a value or hash node, hash it
+	switch n.Val.(type) {
+	case *fullNode, *shortNode:
+		collapsed.Val, cached.Val = h.hash(n.Val, false)
+	}
+	return collapsed, cached
+}
 
-	case *fullNode:
-		// Hash the full node's children, caching the newly hashed subtrees
-		collapsed, cached := n.copy(), n.copy()
+// hashFullNodeChildren hashes the children of a full node, returning the collapsed
+// node ready for encoding as well as a replacement for the original node with the
+// children hashes cached in. If the hasher is running in parallel mode, all the
+// children are hashed concurrently, each on their own sequential hasher.
+func (h *hasher) hashFullNodeChildren(n *fullNode) (collapsed, cached *fullNode) {
+	collapsed, cached = n.copy(), n.copy()
 
+	if h.parallel {
+		var wg sync.WaitGroup
+		wg.Add(16)
 		for i := 0; i < 16; i++ {
-			if n.Children[i] != nil {
-				collapsed.Children[i], cached.Children[i], err = h.hash(n.Children[i], db, false)
-				if err != nil {
-					return original, original, err
+			go func(i int) {
+				defer wg.Done()
+
+				if child := n.Children[i]; child != nil {
+					hasher := newHasher(false)
+					collapsed.Children[i], cached.Children[i] = hasher.hash(child, false)
+					returnHasherToPool(hasher)
 				}
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		for i := 0; i < 16; i++ {
+			if child := n.Children[i]; child != nil {
+				collapsed.Children[i], cached.Children[i] = h.hash(child, false)
 			}
 		}
-		cached.Children[16] = n.Children[16]
-		return collapsed, cached, nil
-
-	default:
-		// Value and hash nodes don't have children so they're left as were
-		return n, original, nil
 	}
+	return collapsed, cached
 }
 
-// store hashes the node n and if we have a storage layer specified, it writes
-// the key/value pair to it and tracks any node->child references as well as any
-// node->external trie references.
-func (h *hasher) store(n node, db *Database, force bool) (node, error) {
-	// Don't store hashes or empty nodes.
-	if _, isHash := n.(hashNode); n == nil || isHash {
-		return n, nil
-	}
-	// Generate the RLP encoding of the node
+// shortnodeToHash encodes a collapsed short node and hashes it. If the encoding
+// is smaller than 32 bytes and hashing isn't forced, the node itself is returned
+// as it's to be embedded into its parent.
+func (h *hasher) shortnodeToHash(n *shortNode, force bool) node {
 	h.tmp.Reset()
 	if err := rlp.Encode(&h.tmp, n); err != nil {
 		panic("encode error: " + err.Error())
 	}
 	if len(h.tmp) < 32 && !force {
-		return n, nil // Nodes smaller than 32 bytes are stored inside their parent
+		return n // Nodes smaller than 32 bytes are stored inside their parent
 	}
-	// Larger nodes are replaced by their hash and stored in the database.
-	hash, _ := n.cache()
-	if hash == nil {
-		hash = h.makeHashNode(h.tmp)
-	}
-
-	if db != nil {
-		// We are pooling the trie nodes into an intermediate memory cache
-		hash := common.BytesToHash(hash)
-
-		db.lock.Lock()
-		db.insert(hash, h.tmp, n)
-		db.lock.Unlock()
+	return h.hashData(h.tmp)
+}
 
-		// Track external references from account->storage trie
-		if h.onleaf != nil {
-			switch n := n.(type) {
-			case *shortNode:
-				if child, ok := n.Val.(valueNode); ok {
-					h.onleaf(child, hash)
-				}
-			case *fullNode:
-				for i := 0; i < 16; i++ {
-					if child, ok := n.Children[i].(valueNode); ok {
-						h.onleaf(child, hash)
-					}
-				}
-			}
-		}
+// fullnodeToHash encodes a collapsed full node and hashes it. If the encoding
+// is smaller than 32 bytes and hashing isn't forced, the node itself is returned
+// as it's to be embedded into its parent.
+func (h *hasher) fullnodeToHash(n *fullNode, force bool) node {
+	h.tmp.Reset()
+	if err := n.EncodeRLP(&h.tmp); err != nil {
+		panic("encode error: " + err.Error())
+	}
+	if len(h.tmp) < 32 && !force {
+		return n // Nodes smaller than 32 bytes are stored inside their parent
 	}
-	return hash, nil
+	return h.hashData(h.tmp)
 }
 
-func (h *hasher) makeHashNode(data []byte) hashNode {
-	n := make(hashNode, h.sha.Size())
+// hashData hashes the provided data.
+func (h *hasher) hashData(data []byte) hashNode {
+	n := make(hashNode, 32)
 	h.sha.Reset()
 	h.sha.Write(data)
 	h.sha.Read(n)
 	return n
 }
+
+// proofHash is used to construct trie proofs, and returns the collapsed node
+// (for later RLP encoding) as well as the hashed node, unless the node is smaller
+// than 32 bytes, in which case it's returned as is. Value and hash nodes are
+// returned unmodified.
+func (h *hasher) proofHash(original node) (collapsed, hashed node) {
+	switch n := original.(type) {
+	case *shortNode:
+		sn, _ := h.hashShortNodeChildren(n)
+		return sn, h.shortnodeToHash(sn, false)
+	case *fullNode:
+		fn, _ := h.hashFullNodeChildren(n)
+		return fn, h.fullnodeToHash(fn, false)
+	default:
+		return n, n
+	}
+}
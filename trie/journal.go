@@ -0,0 +1,160 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// cleanJournalVersion ensures that an incompatible clean cache journal is
+// detected and discarded.
+const cleanJournalVersion uint64 = 0
+
+// cleanJournalHeader is the first entry of a clean cache journal, guarding the
+// cached entries against format changes and database modifications.
+type cleanJournalHeader struct {
+	Version uint64      // Format version of the journal
+	Root    common.Hash // State root which was fully persisted when saving
+}
+
+// cleanJournalEntry is a single trie node entry of a clean cache journal.
+type cleanJournalEntry struct {
+	Hash common.Hash
+	Blob []byte
+}
+
+// SaveCache persists the content of the clean cache into the given journal file,
+// guarded by a state root which is fully available in the persistent database.
+// The journal is written to a temporary file first and moved into its final
+// location afterwards, so a crash never leaves a partial journal around.
+func (db *Database) SaveCache(file string, root common.Hash) error {
+	if db.cleans == nil {
+		return nil
+	}
+	start := time.Now()
+
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	var (
+		buf   = bufio.NewWriter(f)
+		nodes int
+		size  common.StorageSize
+	)
+	err = func() error {
+		if err := rlp.Encode(buf, cleanJournalHeader{Version: cleanJournalVersion, Root: root}); err != nil {
+			return err
+		}
+		it := db.cleans.Iterator()
+		for it.SetNext() {
+			entry, err := it.Value()
+			if err != nil {
+				continue // Entry evicted in the meantime
+			}
+			key := entry.Key()
+			if len(key) != common.HashLength {
+				continue
+			}
+			blob := entry.Value()
+			if err := rlp.Encode(buf, cleanJournalEntry{Hash: common.BytesToHash([]byte(key)), Blob: blob}); err != nil {
+				return err
+			}
+			nodes++
+			size += common.StorageSize(len(key) + len(blob))
+		}
+		if err := buf.Flush(); err != nil {
+			return err
+		}
+		return f.Sync()
+	}()
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return err
+	}
+	log.Info("Persisted the clean trie cache", "path", file, "nodes", nodes, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// LoadCache populates the clean cache from the given journal file. The journal
+// is discarded if it has an incompatible version, if it was persisted for a
+// state root other than the given one (the database was modified since), or if
+// any of its entries is corrupted. A missing journal is not an error.
+func (db *Database) LoadCache(file string, root common.Hash) error {
+	if db.cleans == nil {
+		return nil
+	}
+	start := time.Now()
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stream := rlp.NewStream(bufio.NewReader(f), 0)
+
+	var header cleanJournalHeader
+	if err := stream.Decode(&header); err != nil {
+		return fmt.Errorf("failed to decode journal header: %v", err)
+	}
+	if header.Version != cleanJournalVersion {
+		return fmt.Errorf("journal version mismatch: have %d, want %d", header.Version, cleanJournalVersion)
+	}
+	if header.Root != root {
+		return fmt.Errorf("journal root mismatch: have %x, want %x", header.Root, root)
+	}
+	var (
+		nodes int
+		size  common.StorageSize
+	)
+	for {
+		var entry cleanJournalEntry
+		if err := stream.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			db.cleans.Reset()
+			return fmt.Errorf("failed to decode journal entry: %v", err)
+		}
+		// Never let a corrupted journal poison the cache, drop everything
+		if crypto.Keccak256Hash(entry.Blob) != entry.Hash {
+			db.cleans.Reset()
+			return fmt.Errorf("corrupted journal entry %x", entry.Hash)
+		}
+		db.cleans.Set(string(entry.Hash[:]), entry.Blob)
+		nodes++
+		size += common.StorageSize(common.HashLength + len(entry.Blob))
+	}
+	log.Info("Loaded the clean trie cache", "path", file, "nodes", nodes, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Tests that the clean cache can be persisted and loaded back, and that stale or
+// corrupted journals are discarded.
+func TestCleanCacheJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "triejournal-")
+	if err != nil {
+		t.Fatalf("failed to create temporary dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "triecache")
+
+	// Create a trie and flush it to disk, populating the clean cache
+	diskdb := memorydb.New()
+	triedb := NewDatabaseWithCache(diskdb, 16)
+
+	trie, _ := New(common.Hash{}, triedb)
+	for i := byte(0); i < 100; i++ {
+		trie.Update([]byte{i, 0xaa}, common.Hash{i}.Bytes())
+	}
+	root, _ := trie.Commit(nil)
+	if err := triedb.Commit(root, false, nil); err != nil {
+		t.Fatalf("failed to commit trie: %v", err)
+	}
+	if err := triedb.SaveCache(file, root); err != nil {
+		t.Fatalf("failed to save clean cache: %v", err)
+	}
+	// Drop the trie from disk, so it can only be served from the cache
+	emptydb := memorydb.New()
+
+	// A journal saved for a different root must be discarded
+	fresh := NewDatabaseWithCache(emptydb, 16)
+	if err := fresh.LoadCache(file, common.Hash{0x01}); err == nil {
+		t.Errorf("journal with mismatching root loaded")
+	}
+	if _, err := fresh.Node(root); err == nil {
+		t.Errorf("node loaded from discarded journal")
+	}
+	// A matching journal must restore the cache
+	fresh = NewDatabaseWithCache(emptydb, 16)
+	if err := fresh.LoadCache(file, root); err != nil {
+		t.Fatalf("failed to load clean cache: %v", err)
+	}
+	if _, err := fresh.Node(root); err != nil {
+		t.Errorf("failed to retrieve node from loaded cache: %v", err)
+	}
+	// A corrupted journal must be discarded entirely
+	blob, _ := ioutil.ReadFile(file)
+	blob[len(blob)-1] ^= 0xff
+	ioutil.WriteFile(file, blob, 0644)
+
+	fresh = NewDatabaseWithCache(emptydb, 16)
+	if err := fresh.LoadCache(file, root); err == nil {
+		t.Errorf("corrupted journal loaded")
+	}
+	if _, err := fresh.Node(root); err == nil {
+		t.Errorf("node loaded from corrupted journal")
+	}
+	// A journal with an unknown version must be discarded
+	header, _ := rlp.EncodeToBytes(cleanJournalHeader{Version: cleanJournalVersion + 1, Root: root})
+	ioutil.WriteFile(file, header, 0644)
+
+	if err := NewDatabaseWithCache(emptydb, 16).LoadCache(file, root); err == nil {
+		t.Errorf("journal with unknown version loaded")
+	}
+	// A missing journal is not an error
+	if err := NewDatabaseWithCache(emptydb, 16).LoadCache(filepath.Join(dir, "missing"), root); err != nil {
+		t.Errorf("failed to skip missing journal: %v", err)
+	}
+}
@@ -20,11 +20,13 @@ import (
 	"bytes"
 	crand "crypto/rand"
 	mrand "math/rand"
+	"sort"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/ethdb/memorydb"
 )
 
@@ -149,6 +151,333 @@ func TestMissingKeyProof(t *testing.T) {
 }
 
 // mutateByte changes one byte in b.
+type entrySlice []*kv
+
+func (p entrySlice) Len() int           { return len(p) }
+func (p entrySlice) Less(i, j int) bool { return bytes.Compare(p[i].k, p[j].k) < 0 }
+func (p entrySlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// sortedRandomTrie creates a random trie and returns its entries sorted by key.
+func sortedRandomTrie(n int) (*Trie, entrySlice) {
+	trie, vals := randomTrie(n)
+
+	var entries entrySlice
+	for _, kv := range vals {
+		entries = append(entries, kv)
+	}
+	sort.Sort(entries)
+	return trie, entries
+}
+
+// rangeOf splits the keys and values of a consecutive range of entries.
+func rangeOf(entries entrySlice) ([][]byte, [][]byte) {
+	var keys, vals [][]byte
+	for _, entry := range entries {
+		keys = append(keys, entry.k)
+		vals = append(vals, entry.v)
+	}
+	return keys, vals
+}
+
+// TestRangeProof tests normal range proof with both edge proofs
+// as the existent proof. The test cases are generated randomly.
+func TestRangeProof(t *testing.T) {
+	trie, entries := sortedRandomTrie(4096)
+	for i := 0; i < 500; i++ {
+		start := mrand.Intn(len(entries))
+		end := mrand.Intn(len(entries)-start) + start + 1
+
+		proof := memorydb.New()
+		if err := trie.Prove(entries[start].k, 0, proof); err != nil {
+			t.Fatalf("Failed to prove the first node %v", err)
+		}
+		if err := trie.Prove(entries[end-1].k, 0, proof); err != nil {
+			t.Fatalf("Failed to prove the last node %v", err)
+		}
+		keys, vals := rangeOf(entries[start:end])
+		_, hasMore, err := VerifyRangeProof(trie.Hash(), keys[0], keys[len(keys)-1], keys, vals, proof)
+		if err != nil {
+			t.Fatalf("Case %d(%d->%d) expect no error, got %v", i, start, end-1, err)
+		}
+		if hasMore != (end < len(entries)) {
+			t.Fatalf("Case %d(%d->%d) continuation mismatch: have %v, want %v", i, start, end-1, hasMore, end < len(entries))
+		}
+	}
+}
+
+// TestRangeProofWithNonExistentProof tests normal range proof with both edge proofs
+// as the non-existent proof. The test cases are generated randomly.
+func TestRangeProofWithNonExistentProof(t *testing.T) {
+	trie, entries := sortedRandomTrie(4096)
+	for i := 0; i < 500; i++ {
+		start := mrand.Intn(len(entries))
+		end := mrand.Intn(len(entries)-start) + start + 1
+
+		// Short circuit if the edge keys would overflow or collide with the
+		// neighbouring entries
+		first := decreaseKey(common.CopyBytes(entries[start].k))
+		if bytes.Compare(first, entries[start].k) > 0 || (start != 0 && bytes.Equal(first, entries[start-1].k)) {
+			continue
+		}
+		last := increaseKey(common.CopyBytes(entries[end-1].k))
+		if bytes.Compare(last, entries[end-1].k) < 0 || (end != len(entries) && bytes.Equal(last, entries[end].k)) {
+			continue
+		}
+		proof := memorydb.New()
+		if err := trie.Prove(first, 0, proof); err != nil {
+			t.Fatalf("Failed to prove the first node %v", err)
+		}
+		if err := trie.Prove(last, 0, proof); err != nil {
+			t.Fatalf("Failed to prove the last node %v", err)
+		}
+		keys, vals := rangeOf(entries[start:end])
+		if _, _, err := VerifyRangeProof(trie.Hash(), first, last, keys, vals, proof); err != nil {
+			t.Fatalf("Case %d(%d->%d) expect no error, got %v", i, start, end-1, err)
+		}
+	}
+}
+
+// TestOneElementRangeProof tests the proof with only one element. The first
+// edge proof can be existent one or non-existent one.
+func TestOneElementRangeProof(t *testing.T) {
+	trie, entries := sortedRandomTrie(4096)
+
+	// One element with existent edge proof, both edge proofs point to the
+	// same key.
+	start := 1000
+	proof := memorydb.New()
+	if err := trie.Prove(entries[start].k, 0, proof); err != nil {
+		t.Fatalf("Failed to prove the first node %v", err)
+	}
+	_, hasMore, err := VerifyRangeProof(trie.Hash(), entries[start].k, entries[start].k, [][]byte{entries[start].k}, [][]byte{entries[start].v}, proof)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !hasMore {
+		t.Fatalf("Expected more elements after the proven one")
+	}
+	// One element with left non-existent edge proof
+	first := decreaseKey(common.CopyBytes(entries[start].k))
+	proof = memorydb.New()
+	if err := trie.Prove(first, 0, proof); err != nil {
+		t.Fatalf("Failed to prove the first node %v", err)
+	}
+	if err := trie.Prove(entries[start].k, 0, proof); err != nil {
+		t.Fatalf("Failed to prove the last node %v", err)
+	}
+	if _, _, err := VerifyRangeProof(trie.Hash(), first, entries[start].k, [][]byte{entries[start].k}, [][]byte{entries[start].v}, proof); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// Test the mini trie with only a single element.
+	tinyTrie := new(Trie)
+	entry := &kv{randBytes(32), randBytes(20), false}
+	tinyTrie.Update(entry.k, entry.v)
+
+	first = common.HexToHash("0x0000000000000000000000000000000000000000000000000000000000000000").Bytes()
+	last := entry.k
+	proof = memorydb.New()
+	if err := tinyTrie.Prove(first, 0, proof); err != nil {
+		t.Fatalf("Failed to prove the first node %v", err)
+	}
+	if err := tinyTrie.Prove(last, 0, proof); err != nil {
+		t.Fatalf("Failed to prove the last node %v", err)
+	}
+	if _, _, err := VerifyRangeProof(tinyTrie.Hash(), first, last, [][]byte{entry.k}, [][]byte{entry.v}, proof); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+// TestAllElementsProof tests the range proof with all elements. The edge
+// proofs can be nil.
+func TestAllElementsProof(t *testing.T) {
+	trie, entries := sortedRandomTrie(4096)
+	keys, vals := rangeOf(entries)
+
+	nodes, hasMore, err := VerifyRangeProof(trie.Hash(), nil, nil, keys, vals, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if hasMore {
+		t.Fatalf("Expected no more elements")
+	}
+	// The whole trie should have been reconstructed into the node set
+	it := NewIterator(newFullTrie(t, trie.Hash(), nodes).NodeIterator(nil))
+	count := 0
+	for it.Next() {
+		count++
+	}
+	if it.Err != nil {
+		t.Fatalf("Failed to iterate reconstructed trie: %v", it.Err)
+	}
+	if count != len(entries) {
+		t.Fatalf("Reconstructed trie leaf count mismatch: have %d, want %d", count, len(entries))
+	}
+	// With edge proofs, it should still work.
+	proof := memorydb.New()
+	if err := trie.Prove(entries[0].k, 0, proof); err != nil {
+		t.Fatalf("Failed to prove the first node %v", err)
+	}
+	if err := trie.Prove(entries[len(entries)-1].k, 0, proof); err != nil {
+		t.Fatalf("Failed to prove the last node %v", err)
+	}
+	if _, _, err := VerifyRangeProof(trie.Hash(), keys[0], keys[len(keys)-1], keys, vals, proof); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+// TestEmptyRangeProof tests the range proof with "no" element. The first
+// edge proof must be a non-existent proof.
+func TestEmptyRangeProof(t *testing.T) {
+	trie, entries := sortedRandomTrie(4096)
+
+	var cases = []struct {
+		pos int
+		err bool
+	}{
+		{len(entries) - 1, false},
+		{500, true},
+	}
+	for _, c := range cases {
+		proof := memorydb.New()
+		first := increaseKey(common.CopyBytes(entries[c.pos].k))
+		if err := trie.Prove(first, 0, proof); err != nil {
+			t.Fatalf("Failed to prove the first node %v", err)
+		}
+		_, _, err := VerifyRangeProof(trie.Hash(), first, nil, nil, nil, proof)
+		if c.err && err == nil {
+			t.Fatalf("Expected error, got nil")
+		}
+		if !c.err && err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+}
+
+// TestBadRangeProof tests a few cases which the proof is wrong.
+// The prover is expected to detect the error.
+func TestBadRangeProof(t *testing.T) {
+	trie, entries := sortedRandomTrie(4096)
+	for i := 0; i < 500; i++ {
+		start := mrand.Intn(len(entries))
+		end := mrand.Intn(len(entries)-start) + start + 1
+
+		proof := memorydb.New()
+		if err := trie.Prove(entries[start].k, 0, proof); err != nil {
+			t.Fatalf("Failed to prove the first node %v", err)
+		}
+		if err := trie.Prove(entries[end-1].k, 0, proof); err != nil {
+			t.Fatalf("Failed to prove the last node %v", err)
+		}
+		keys, vals := rangeOf(entries[start:end])
+		var first, last = keys[0], keys[len(keys)-1]
+
+		testcase := mrand.Intn(4)
+		switch testcase {
+		case 0:
+			// Modified key
+			index := mrand.Intn(end - start)
+			keys[index] = randBytes(32) // In theory it can't be same
+		case 1:
+			// Modified val
+			index := mrand.Intn(end - start)
+			vals[index] = randBytes(20) // In theory it can't be same
+		case 2:
+			// Gapped entry slice
+			index := mrand.Intn(end - start)
+			if (index == 0 && start < 100) || (index == end-start-1 && end <= 100) {
+				continue
+			}
+			keys = append(keys[:index], keys[index+1:]...)
+			vals = append(vals[:index], vals[index+1:]...)
+		case 3:
+			// Out of order
+			index1 := mrand.Intn(end - start)
+			index2 := mrand.Intn(end - start)
+			if index1 == index2 {
+				continue
+			}
+			keys[index1], keys[index2] = keys[index2], keys[index1]
+			vals[index1], vals[index2] = vals[index2], vals[index1]
+		}
+		if _, _, err := VerifyRangeProof(trie.Hash(), first, last, keys, vals, proof); err == nil {
+			t.Fatalf("%d Case %d index %d range: (%d->%d) expect error, got nil", i, testcase, start, end-1, len(keys))
+		}
+	}
+}
+
+// TestRangeProofNodes tests that the trie nodes returned for consecutive ranges
+// are all genuine nodes of the proven trie.
+func TestRangeProofNodes(t *testing.T) {
+	trie, entries := sortedRandomTrie(4096)
+	keys, vals := rangeOf(entries)
+
+	full, _, err := VerifyRangeProof(trie.Hash(), nil, nil, keys, vals, nil)
+	if err != nil {
+		t.Fatalf("Failed to verify full range: %v", err)
+	}
+	for start := 0; start < len(entries); start += 512 {
+		end := start + 512
+		if end > len(entries) {
+			end = len(entries)
+		}
+		proof := memorydb.New()
+		if err := trie.Prove(entries[start].k, 0, proof); err != nil {
+			t.Fatalf("Failed to prove the first node %v", err)
+		}
+		if err := trie.Prove(entries[end-1].k, 0, proof); err != nil {
+			t.Fatalf("Failed to prove the last node %v", err)
+		}
+		nodes, _, err := VerifyRangeProof(trie.Hash(), keys[start], keys[end-1], keys[start:end], vals[start:end], proof)
+		if err != nil {
+			t.Fatalf("Range %d->%d: failed to verify: %v", start, end-1, err)
+		}
+		if nodes == nil {
+			t.Fatalf("Range %d->%d: no nodes returned", start, end-1)
+		}
+		it := nodes.NewIterator()
+		for it.Next() {
+			want, err := full.Get(it.Key())
+			if err != nil {
+				t.Fatalf("Range %d->%d: unknown node %x", start, end-1, it.Key())
+			}
+			if !bytes.Equal(it.Value(), want) {
+				t.Fatalf("Range %d->%d: node %x mismatch: have %x, want %x", start, end-1, it.Key(), it.Value(), want)
+			}
+		}
+		it.Release()
+	}
+}
+
+// newFullTrie opens a trie from a node set, failing the test if the root is
+// missing.
+func newFullTrie(t *testing.T, root common.Hash, nodes ethdb.KeyValueStore) *Trie {
+	tr, err := New(root, NewDatabase(nodes))
+	if err != nil {
+		t.Fatalf("Failed to open trie: %v", err)
+	}
+	return tr
+}
+
+func increaseKey(key []byte) []byte {
+	for i := len(key) - 1; i >= 0; i-- {
+		key[i]++
+		if key[i] != 0x0 {
+			break
+		}
+	}
+	return key
+}
+
+func decreaseKey(key []byte) []byte {
+	for i := len(key) - 1; i >= 0; i-- {
+		key[i]--
+		if key[i] != 0xff {
+			break
+		}
+	}
+	return key
+}
+
 func mutateByte(b []byte) {
 	for r := mrand.Intn(len(b)); ; {
 		new := byte(mrand.Intn(255))
@@ -89,7 +89,7 @@ func testMissingNode(t *testing.T, memonly bool) {
 	updateString(trie, "123456", "asdfasdfasdfasdfasdfasdfasdfasdf")
 	root, _ := trie.Commit(nil)
 	if !memonly {
-		triedb.Commit(root, true)
+		triedb.Commit(root, true, nil)
 	}
 
 	trie, _ = New(root, triedb)
@@ -317,6 +317,69 @@ func TestLargeValue(t *testing.T) {
 	trie.Hash()
 }
 
+// Tests that hashing a trie in parallel yields the same result as doing it
+// sequentially.
+func TestParallelHash(t *testing.T) {
+	addresses, accounts := makeAccounts(1000)
+
+	sequential, parallel := newEmpty(), newEmpty()
+	for i := 0; i < len(addresses); i++ {
+		sequential.Update(crypto.Keccak256(addresses[i][:]), accounts[i])
+		parallel.Update(crypto.Keccak256(addresses[i][:]), accounts[i])
+	}
+	h := newHasher(false)
+	want, _ := h.hash(sequential.root, true)
+	returnHasherToPool(h)
+
+	h = newHasher(true)
+	have, _ := h.hash(parallel.root, true)
+	returnHasherToPool(h)
+
+	if !bytes.Equal(have.(hashNode), want.(hashNode)) {
+		t.Errorf("parallel hash mismatch: have %x, want %x", have, want)
+	}
+}
+
+// Tests that committing an already hashed trie persists all of its nodes, and
+// reports all of its leaves.
+func TestCommitAfterHash(t *testing.T) {
+	addresses, accounts := makeAccounts(1000)
+
+	trie := newEmpty()
+	for i := 0; i < len(addresses); i++ {
+		trie.Update(crypto.Keccak256(addresses[i][:]), accounts[i])
+	}
+	hash := trie.Hash()
+
+	leaves := 0
+	root, err := trie.Commit(func(leaf []byte, parent common.Hash) error {
+		leaves++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to commit trie: %v", err)
+	}
+	if root != hash {
+		t.Errorf("commit root mismatch: have %x, want %x", root, hash)
+	}
+	if leaves != len(addresses) {
+		t.Errorf("leaf count mismatch: have %d, want %d", leaves, len(addresses))
+	}
+	// Committing again should be a noop, and a fresh trie should be complete
+	if again, _ := trie.Commit(nil); again != hash {
+		t.Errorf("recommit root mismatch: have %x, want %x", again, hash)
+	}
+	reopened, err := New(root, trie.db)
+	if err != nil {
+		t.Fatalf("failed to reopen trie: %v", err)
+	}
+	for i := 0; i < len(addresses); i++ {
+		if val := reopened.Get(crypto.Keccak256(addresses[i][:])); !bytes.Equal(val, accounts[i]) {
+			t.Fatalf("account %d mismatch: have %x, want %x", i, val, accounts[i])
+		}
+	}
+}
+
 type countingDB struct {
 	ethdb.KeyValueStore
 	gets map[string]int
@@ -493,17 +556,32 @@ func benchUpdate(b *testing.B, e binary.ByteOrder) *Trie {
 // the first one will be NOOP. As such, we'll use b.N as the number of account to
 // insert into the trie before measuring the hashing.
 func BenchmarkHash(b *testing.B) {
+	// Create a realistic account trie to hash
+	addresses, accounts := makeAccounts(b.N)
+	trie := newEmpty()
+	for i := 0; i < len(addresses); i++ {
+		trie.Update(crypto.Keccak256(addresses[i][:]), accounts[i])
+	}
+	// Insert the accounts into the trie and hash it
+	b.ResetTimer()
+	b.ReportAllocs()
+	trie.Hash()
+}
+
+// makeAccounts generates a deterministic set of random addresses along with
+// realistic RLP encoded accounts belonging to them.
+func makeAccounts(size int) (addresses [][20]byte, accounts [][]byte) {
 	// Make the random benchmark deterministic
 	random := rand.New(rand.NewSource(0))
 
 	// Create a realistic account trie to hash
-	addresses := make([][20]byte, b.N)
+	addresses = make([][20]byte, size)
 	for i := 0; i < len(addresses); i++ {
 		for j := 0; j < len(addresses[i]); j++ {
 			addresses[i][j] = byte(random.Intn(256))
 		}
 	}
-	accounts := make([][]byte, len(addresses))
+	accounts = make([][]byte, len(addresses))
 	for i := 0; i < len(accounts); i++ {
 		var (
 			nonce   = uint64(random.Int63())
@@ -513,14 +591,61 @@ func BenchmarkHash(b *testing.B) {
 		)
 		accounts[i], _ = rlp.EncodeToBytes([]interface{}{nonce, balance, root, code})
 	}
+	return addresses, accounts
+}
+
+// BenchmarkHashFixedSize benchmarks the hashing of a trie with a fixed number of
+// freshly inserted accounts, i.e. with all the nodes dirty.
+func BenchmarkHashFixedSize(b *testing.B) {
+	for _, size := range []int{10, 100, 1000, 10000, 100000} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			b.ReportAllocs()
+			addresses, accounts := makeAccounts(size)
+			for i := 0; i < b.N; i++ {
+				benchHashFixedSize(b, addresses, accounts)
+			}
+		})
+	}
+}
+
+func benchHashFixedSize(b *testing.B, addresses [][20]byte, accounts [][]byte) {
+	b.StopTimer()
+	trie := newEmpty()
+	for i := 0; i < len(addresses); i++ {
+		trie.Update(crypto.Keccak256(addresses[i][:]), accounts[i])
+	}
 	// Insert the accounts into the trie and hash it
+	b.StartTimer()
+	trie.Hash()
+	b.StopTimer()
+}
+
+// BenchmarkCommitAfterHashFixedSize benchmarks committing a trie with a fixed
+// number of freshly inserted accounts into the trie database, after the trie was
+// already hashed (as is the case for the state trie at the end of a block).
+func BenchmarkCommitAfterHashFixedSize(b *testing.B) {
+	for _, size := range []int{10, 100, 1000, 10000, 100000} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			b.ReportAllocs()
+			addresses, accounts := makeAccounts(size)
+			for i := 0; i < b.N; i++ {
+				benchCommitAfterHashFixedSize(b, addresses, accounts)
+			}
+		})
+	}
+}
+
+func benchCommitAfterHashFixedSize(b *testing.B, addresses [][20]byte, accounts [][]byte) {
+	b.StopTimer()
 	trie := newEmpty()
 	for i := 0; i < len(addresses); i++ {
 		trie.Update(crypto.Keccak256(addresses[i][:]), accounts[i])
 	}
-	b.ResetTimer()
-	b.ReportAllocs()
+	// Insert the accounts into the trie and hash it
 	trie.Hash()
+	b.StartTimer()
+	trie.Commit(func(leaf []byte, parent common.Hash) error { return nil })
+	b.StopTimer()
 }
 
 func tempDB() (string, *Database) {